@@ -0,0 +1,640 @@
+// account_data.go
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+///////////////////////////////////////////////////////
+// ВЫГРУЗКА ДАННЫХ («скачать мои данные»)
+///////////////////////////////////////////////////////
+
+type exportProfile struct {
+	ID          uint      `json:"id"`
+	Email       string    `json:"email"`
+	FullName    string    `json:"full_name"`
+	Role        string    `json:"role"`
+	AuthSource  string    `json:"auth_source"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	Avatar      string    `json:"avatar,omitempty"` // путь внутри архива
+	CreatedAt   time.Time `json:"created_at"`
+	ExportedAt  time.Time `json:"exported_at"`
+}
+
+type exportSubmission struct {
+	ID           uint      `json:"id"`
+	BlockID      uint      `json:"block_id"`
+	Course       string    `json:"course"`
+	OriginalName string    `json:"original_name"`
+	File         string    `json:"file,omitempty"` // путь внутри архива
+	SizeBytes    int64     `json:"size_bytes"`
+	Status       string    `json:"status"`
+	Comment      string    `json:"comment,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type exportQuizAttempt struct {
+	ID        uint           `json:"id"`
+	BlockID   uint           `json:"block_id"`
+	Course    string         `json:"course"`
+	Module    string         `json:"module"`
+	Score     float64        `json:"score"`
+	Passed    bool           `json:"passed"`
+	Details   datatypes.JSON `json:"details"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type exportScormAttempt struct {
+	BlockID       uint           `json:"block_id"`
+	Course        string         `json:"course"`
+	Module        string         `json:"module"`
+	Status        string         `json:"status"`
+	SuccessStatus string         `json:"success_status"`
+	Score         *float64       `json:"score"`
+	Completed     bool           `json:"completed"`
+	TotalTime     int64          `json:"total_time_seconds"`
+	CMI           datatypes.JSON `json:"cmi"`
+	CompletedAt   *time.Time     `json:"completed_at,omitempty"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type exportFlashcard struct {
+	BlockID    uint      `json:"block_id"`
+	Course     string    `json:"course"`
+	CardID     string    `json:"card_id"`
+	Due        time.Time `json:"due"`
+	Interval   float64   `json:"interval_days"`
+	Ease       float64   `json:"ease"`
+	Stability  float64   `json:"stability,omitempty"`
+	Difficulty float64   `json:"difficulty,omitempty"`
+	Reps       int       `json:"reps"`
+	Lapses     int       `json:"lapses"`
+	Reviews    int       `json:"reviews"`
+	LastReview time.Time `json:"last_review"`
+}
+
+// ответы на неанонимные опросы; анонимные с пользователем не связаны и в выгрузку не попадают
+type exportSurveyResponse struct {
+	BlockID   uint           `json:"block_id"`
+	Course    string         `json:"course"`
+	Survey    string         `json:"survey"`
+	Answers   datatypes.JSON `json:"answers"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// просмотр видео-файлов
+type exportVideoWatch struct {
+	BlockID     uint           `json:"block_id"`
+	Course      string         `json:"course"`
+	Video       string         `json:"video"`
+	Duration    float64        `json:"duration"`
+	Intervals   datatypes.JSON `json:"intervals"`
+	Watched     float64        `json:"watched"`
+	Position    float64        `json:"position"`
+	CompletedAt *time.Time     `json:"completed_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// рецензии, написанные пользователем, и полученные им — без рецензентов, как их видит автор
+type exportPeerReview struct {
+	BlockID     uint           `json:"block_id"`
+	Course      string         `json:"course"`
+	Assignment  string         `json:"assignment"`
+	Role        string         `json:"role"` // reviewer | author
+	Scores      datatypes.JSON `json:"scores"`
+	Comment     string         `json:"comment,omitempty"`
+	SubmittedAt *time.Time     `json:"submitted_at"`
+}
+
+type exportCourseProgress struct {
+	CourseID  uint    `json:"course_id"`
+	Course    string  `json:"course"`
+	Graded    int     `json:"graded_blocks"`    // задания, тесты, SCORM, карточки и видео с порогом просмотра
+	Completed int     `json:"completed_blocks"` // тест пройден / задание сдано / SCORM завершён
+	Percent   float64 `json:"percent"`
+}
+
+type exportLogin struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// writeUserExport пишет ZIP со всеми данными пользователя: профиль, отправки (с файлами),
+// попытки тестов, состояние SCORM-пакетов, расписание карточек, ответы на опросы, прогресс по курсам
+// и историю входов.
+func writeUserExport(w io.Writer, u *User) error {
+	zw := zip.NewWriter(w)
+
+	// --- профиль ---
+	profile := exportProfile{
+		ID:          u.ID,
+		Email:       u.Email,
+		FullName:    u.FullName,
+		Role:        u.Role,
+		AuthSource:  u.AuthSource,
+		TOTPEnabled: u.TOTPEnabled,
+		CreatedAt:   u.CreatedAt,
+		ExportedAt:  time.Now(),
+	}
+	if u.AvatarPath != "" {
+		name := "avatar" + filepath.Ext(u.AvatarPath)
+		if err := zipFile(zw, name, u.AvatarPath); err == nil {
+			profile.Avatar = name
+		}
+	}
+	if err := zipJSON(zw, "profile.json", profile); err != nil {
+		return err
+	}
+
+	// --- отправки заданий ---
+	var subs []Submission
+	if err := db.Preload("Block.Module.Course").
+		Where("user_id = ?", u.ID).Order("created_at").Find(&subs).Error; err != nil {
+		return err
+	}
+	outSubs := make([]exportSubmission, 0, len(subs))
+	for _, s := range subs {
+		es := exportSubmission{
+			ID:           s.ID,
+			BlockID:      s.BlockID,
+			Course:       s.Block.Module.Course.Title,
+			OriginalName: s.OriginalName,
+			SizeBytes:    s.SizeBytes,
+			Status:       s.Status,
+			Comment:      s.Comment,
+			CreatedAt:    s.CreatedAt,
+		}
+		if s.StoredPath != "" {
+			name := "submissions/" + strconv.Itoa(int(s.ID)) + "_" + filepath.Base(s.OriginalName)
+			if err := zipFile(zw, name, s.StoredPath); err != nil {
+				log.Printf("export user %d: файл отправки %d: %v\n", u.ID, s.ID, err)
+			} else {
+				es.File = name
+			}
+		}
+		outSubs = append(outSubs, es)
+	}
+	if err := zipJSON(zw, "submissions.json", outSubs); err != nil {
+		return err
+	}
+
+	// --- попытки тестов ---
+	var attempts []QuizAttempt
+	if err := db.Preload("Block.Module.Course").
+		Where("user_id = ?", u.ID).Order("created_at").Find(&attempts).Error; err != nil {
+		return err
+	}
+	outAttempts := make([]exportQuizAttempt, 0, len(attempts))
+	for _, a := range attempts {
+		outAttempts = append(outAttempts, exportQuizAttempt{
+			ID:        a.ID,
+			BlockID:   a.BlockID,
+			Course:    a.Block.Module.Course.Title,
+			Module:    a.Block.Module.Title,
+			Score:     a.Score,
+			Passed:    a.Passed,
+			Details:   a.Details,
+			CreatedAt: a.CreatedAt,
+		})
+	}
+	if err := zipJSON(zw, "quiz_attempts.json", outAttempts); err != nil {
+		return err
+	}
+
+	// --- SCORM ---
+	var scorm []ScormAttempt
+	if err := db.Preload("Block.Module.Course").
+		Where("user_id = ?", u.ID).Order("created_at").Find(&scorm).Error; err != nil {
+		return err
+	}
+	outScorm := make([]exportScormAttempt, 0, len(scorm))
+	for _, a := range scorm {
+		outScorm = append(outScorm, exportScormAttempt{
+			BlockID:       a.BlockID,
+			Course:        a.Block.Module.Course.Title,
+			Module:        a.Block.Module.Title,
+			Status:        a.Status,
+			SuccessStatus: a.SuccessStatus,
+			Score:         a.Score,
+			Completed:     a.Completed,
+			TotalTime:     a.TotalTime,
+			CMI:           a.CMI,
+			CompletedAt:   a.CompletedAt,
+			UpdatedAt:     a.UpdatedAt,
+		})
+	}
+	if err := zipJSON(zw, "scorm_attempts.json", outScorm); err != nil {
+		return err
+	}
+
+	// --- карточки ---
+	var states []FlashcardState
+	if err := db.Preload("Block.Module.Course").
+		Where("user_id = ?", u.ID).Order("block_id, card_id").Find(&states).Error; err != nil {
+		return err
+	}
+	type reviewCount struct {
+		BlockID uint
+		CardID  string
+		N       int
+	}
+	var counts []reviewCount
+	if err := db.Model(&FlashcardReview{}).Select("block_id, card_id, count(*) AS n").
+		Where("user_id = ?", u.ID).Group("block_id, card_id").Scan(&counts).Error; err != nil {
+		return err
+	}
+	reviews := map[string]int{}
+	for _, rc := range counts {
+		reviews[strconv.FormatUint(uint64(rc.BlockID), 10)+"/"+rc.CardID] = rc.N
+	}
+	outCards := make([]exportFlashcard, 0, len(states))
+	for _, st := range states {
+		outCards = append(outCards, exportFlashcard{
+			BlockID:    st.BlockID,
+			Course:     st.Block.Module.Course.Title,
+			CardID:     st.CardID,
+			Due:        st.Due,
+			Interval:   st.Interval,
+			Ease:       st.Ease,
+			Stability:  st.Stability,
+			Difficulty: st.Difficulty,
+			Reps:       st.Reps,
+			Lapses:     st.Lapses,
+			Reviews:    reviews[strconv.FormatUint(uint64(st.BlockID), 10)+"/"+st.CardID],
+			LastReview: st.LastReview,
+		})
+	}
+	if err := zipJSON(zw, "flashcards.json", outCards); err != nil {
+		return err
+	}
+
+	// --- опросы ---
+	var responses []SurveyResponse
+	if err := db.Preload("Block.Module.Course").
+		Where("user_id = ?", u.ID).Order("created_at").Find(&responses).Error; err != nil {
+		return err
+	}
+	outResponses := make([]exportSurveyResponse, 0, len(responses))
+	for _, r := range responses {
+		outResponses = append(outResponses, exportSurveyResponse{
+			BlockID:   r.BlockID,
+			Course:    r.Block.Module.Course.Title,
+			Survey:    blockTitle(&r.Block),
+			Answers:   r.Answers,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	if err := zipJSON(zw, "survey_responses.json", outResponses); err != nil {
+		return err
+	}
+
+	// --- видео ---
+	var watches []VideoWatch
+	if err := db.Preload("Block.Module.Course").Where("user_id = ?", u.ID).Order("id").Find(&watches).Error; err != nil {
+		return err
+	}
+	outWatches := make([]exportVideoWatch, 0, len(watches))
+	for _, w := range watches {
+		outWatches = append(outWatches, exportVideoWatch{
+			BlockID:     w.BlockID,
+			Course:      w.Block.Module.Course.Title,
+			Video:       blockTitle(&w.Block),
+			Duration:    w.Duration,
+			Intervals:   w.Intervals,
+			Watched:     w.Watched,
+			Position:    w.Position,
+			CompletedAt: w.CompletedAt,
+			UpdatedAt:   w.UpdatedAt,
+		})
+	}
+	if err := zipJSON(zw, "video_watch.json", outWatches); err != nil {
+		return err
+	}
+
+	// --- взаимное рецензирование ---
+	var peerReviews []PeerReview
+	if err := db.Preload("Block.Module.Course").Preload("Submission").
+		Where("reviewer_id = ? OR submission_id IN (SELECT id FROM submissions WHERE user_id = ?)", u.ID, u.ID).
+		Order("id").Find(&peerReviews).Error; err != nil {
+		return err
+	}
+	outPeer := make([]exportPeerReview, 0, len(peerReviews))
+	for _, r := range peerReviews {
+		role := "reviewer"
+		if r.Submission.UserID == u.ID {
+			role = "author"
+		}
+		outPeer = append(outPeer, exportPeerReview{
+			BlockID:     r.BlockID,
+			Course:      r.Block.Module.Course.Title,
+			Assignment:  blockTitle(&r.Block),
+			Role:        role,
+			Scores:      r.Scores,
+			Comment:     r.Comment,
+			SubmittedAt: r.SubmittedAt,
+		})
+	}
+	if err := zipJSON(zw, "peer_reviews.json", outPeer); err != nil {
+		return err
+	}
+
+	// --- прогресс ---
+	progress, err := userCourseProgress(u.ID)
+	if err != nil {
+		return err
+	}
+	if err := zipJSON(zw, "progress.json", progress); err != nil {
+		return err
+	}
+
+	// --- история входов ---
+	var logins []LoginAttempt
+	if err := db.Where("email = ?", strings.ToLower(u.Email)).
+		Order("created_at").Find(&logins).Error; err != nil {
+		return err
+	}
+	outLogins := make([]exportLogin, 0, len(logins))
+	for _, l := range logins {
+		outLogins = append(outLogins, exportLogin{
+			IP:        l.IP,
+			UserAgent: l.UserAgent,
+			Success:   l.Success,
+			Reason:    l.Reason,
+			CreatedAt: l.CreatedAt,
+		})
+	}
+	if err := zipJSON(zw, "login_history.json", outLogins); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// userCourseIDs — курсы, которые пользователь начал: что-то сдавал или учил карточки.
+func userCourseIDs(userID uint) ([]uint, error) {
+	var courseIDs []uint
+	err := db.Raw(`
+		SELECT DISTINCT m.course_id FROM modules m
+		JOIN blocks b ON b.module_id = m.id
+		WHERE b.id IN (SELECT block_id FROM submissions WHERE user_id = ?)
+		   OR b.id IN (SELECT block_id FROM quiz_attempts WHERE user_id = ?)
+		   OR b.id IN (SELECT block_id FROM scorm_attempts WHERE user_id = ?)
+		   OR b.id IN (SELECT block_id FROM flashcard_states WHERE user_id = ?)`,
+		userID, userID, userID, userID).Scan(&courseIDs).Error
+	return courseIDs, err
+}
+
+// userCourseProgress — по каждому начатому курсу: сколько заданий/тестов закрыто.
+func userCourseProgress(userID uint) ([]exportCourseProgress, error) {
+	courseIDs, err := userCourseIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]exportCourseProgress, 0, len(courseIDs))
+	for _, cid := range courseIDs {
+		var course Course
+		if err := db.Preload("Modules.Blocks").First(&course, cid).Error; err != nil {
+			return nil, err
+		}
+		res = append(res, courseProgress(userID, &course))
+	}
+	return res, nil
+}
+
+// courseProgress — прогресс по одному курсу (Modules.Blocks должны быть загружены).
+func courseProgress(userID uint, course *Course) exportCourseProgress {
+	p := exportCourseProgress{CourseID: course.ID, Course: course.Title}
+	for _, m := range course.Modules {
+		for _, b := range m.Blocks {
+			k := blockKinds[b.Type]
+			if k == nil || !k.BlockGraded(&b) {
+				continue
+			}
+			p.Graded++
+			if k.completed(userID, b.ID) {
+				p.Completed++
+			}
+		}
+	}
+	if p.Graded > 0 {
+		p.Percent = float64(p.Completed) * 100 / float64(p.Graded)
+	}
+	return p
+}
+
+func zipJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func zipFile(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
+
+///////////////////////////////////////////////////////
+// УДАЛЕНИЕ ДАННЫХ (право на забвение)
+///////////////////////////////////////////////////////
+
+const (
+	retentionDelete    = "delete"    // запись удаляется
+	retentionAnonymize = "anonymize" // запись остаётся для статистики, без персональных данных
+)
+
+// retentionPolicy — что делать с каждым видом данных при удалении аккаунта.
+type retentionPolicy struct {
+	Submissions  string // файлы удаляются всегда; anonymize оставляет строку с оценкой
+	QuizAttempts string
+	LoginHistory string
+	PeerReviews  string // написанные рецензии: anonymize оставляет баллы (оценки авторов не меняются) без комментария
+}
+
+// loadRetentionPolicy: RETENTION_SUBMISSIONS, RETENTION_QUIZ_ATTEMPTS, RETENTION_LOGIN_HISTORY,
+// RETENTION_PEER_REVIEWS.
+func loadRetentionPolicy() retentionPolicy {
+	get := func(key, def string) string {
+		v := envOr(key, def)
+		if v != retentionDelete && v != retentionAnonymize {
+			log.Printf("%s: неизвестное значение %q, используется %q\n", key, v, def)
+			return def
+		}
+		return v
+	}
+	return retentionPolicy{
+		Submissions:  get("RETENTION_SUBMISSIONS", retentionDelete),
+		QuizAttempts: get("RETENTION_QUIZ_ATTEMPTS", retentionAnonymize),
+		LoginHistory: get("RETENTION_LOGIN_HISTORY", retentionDelete),
+		PeerReviews:  get("RETENTION_PEER_REVIEWS", retentionAnonymize),
+	}
+}
+
+// keepsUserRow — нужна ли обезличенная строка users, к которой привязаны оставшиеся записи.
+func (p retentionPolicy) keepsUserRow() bool {
+	return p.Submissions == retentionAnonymize || p.QuizAttempts == retentionAnonymize ||
+		p.PeerReviews == retentionAnonymize
+}
+
+var errLastAdmin = errors.New("нельзя удалить единственного администратора")
+
+// eraseUser удаляет или обезличивает данные пользователя согласно политике.
+// Файлы (отправки, аватар) удаляются с диска всегда.
+func eraseUser(u *User, p retentionPolicy) error {
+	if u.IsAdmin() && u.AuthSource != AuthSourceService {
+		var admins int64
+		// сервисные аккаунты не в счёт: войти в админку они не могут
+		db.Model(&User{}).Where("role = ? AND erased_at IS NULL AND auth_source <> ?", "admin", AuthSourceService).Count(&admins)
+		if admins <= 1 {
+			return errLastAdmin
+		}
+	}
+
+	var files []string
+	if u.AvatarPath != "" {
+		files = append(files, u.AvatarPath)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var subs []Submission
+		if err := tx.Where("user_id = ?", u.ID).Find(&subs).Error; err != nil {
+			return err
+		}
+		for _, s := range subs {
+			if s.StoredPath != "" {
+				files = append(files, s.StoredPath)
+			}
+		}
+
+		if p.Submissions == retentionDelete {
+			if err := tx.Where("user_id = ?", u.ID).Delete(&Submission{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&Submission{}).Where("user_id = ?", u.ID).Updates(map[string]any{
+				"original_name": "",
+				"stored_path":   "",
+				"mimetype":      "",
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if p.QuizAttempts == retentionDelete {
+			if err := tx.Where("user_id = ?", u.ID).Delete(&QuizAttempt{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", u.ID).Delete(&ScormAttempt{}).Error; err != nil {
+				return err
+			}
+		} else {
+			// итог остаётся в статистике, а сохранённые пакетом данные (cmi) — нет
+			if err := tx.Model(&ScormAttempt{}).Where("user_id = ?", u.ID).
+				Update("cmi", datatypes.JSON("{}")).Error; err != nil {
+				return err
+			}
+		}
+
+		// рецензии на чужие работы: без строки пользователя их удалил бы каскад, и оценки авторов
+		// молча поменялись бы — поэтому баллы остаются, а свободный текст стирается
+		if p.PeerReviews == retentionDelete {
+			if err := tx.Where("reviewer_id = ?", u.ID).Delete(&PeerReview{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&PeerReview{}).Where("reviewer_id = ?", u.ID).
+				Update("comment", "").Error; err != nil {
+				return err
+			}
+		}
+
+		// ответ на опрос остаётся в итогах, но становится анонимным
+		if err := tx.Model(&SurveyResponse{}).Where("user_id = ?", u.ID).Updates(map[string]any{
+			"user_id":    nil,
+			"created_at": gorm.Expr("date_trunc('day', created_at)"),
+		}).Error; err != nil {
+			return err
+		}
+
+		email := strings.ToLower(u.Email)
+		if p.LoginHistory == retentionDelete {
+			if err := tx.Where("email = ?", email).Delete(&LoginAttempt{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&LoginAttempt{}).Where("email = ?", email).Updates(map[string]any{
+				"email":      "",
+				"ip":         "",
+				"user_agent": "",
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, m := range []any{&UserSession{}, &RecoveryCode{}, &EmailChange{}, &APIToken{}, &XAPIStatement{}, &LTIUserLink{}, &LTIGrade{}, &FlashcardState{}, &FlashcardReview{}, &SurveyParticipant{}, &VideoWatch{}} {
+			if err := tx.Where("user_id = ?", u.ID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+
+		if !p.keepsUserRow() {
+			return tx.Delete(&User{}, u.ID).Error
+		}
+
+		// строка остаётся только как якорь для обезличенных записей
+		now := time.Now()
+		return tx.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]any{
+			"email":          "erased-" + strconv.Itoa(int(u.ID)) + "@erased.invalid",
+			"password_hash":  "!", // не bcrypt — войти невозможно
+			"full_name":      "",
+			"role":           "student",
+			"auth_source":    AuthSourceLocal,
+			"external_id":    "",
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+			"avatar_path":    "",
+			"erased_at":      &now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			log.Printf("erase user %d: %v\n", u.ID, err)
+		}
+	}
+	log.Printf("erase user %d: выполнено (отправки=%s, тесты=%s, входы=%s, рецензии=%s)\n",
+		u.ID, p.Submissions, p.QuizAttempts, p.LoginHistory, p.PeerReviews)
+	return nil
+}
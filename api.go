@@ -0,0 +1,173 @@
+// api.go
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON API для мобильного приложения и внутренних инструментов.
+// Контракт описан в api/openapi.json; при старте маршруты /api/v1 сверяются со спецификацией.
+
+const apiPrefix = "/api/v1"
+
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// apiErrorBody — единый конверт ошибки: {"error": {"code": "...", "message": "..."}}
+type apiErrorBody struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func apiError(c *gin.Context, status int, code, msg string) {
+	c.AbortWithStatusJSON(status, apiErrorBody{Error: apiErrorDetail{Code: code, Message: msg}})
+}
+
+// apiListMeta — пагинация в ответах-списках: {"data": [...], "meta": {...}}
+type apiListMeta struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+type apiPage struct {
+	Page    int
+	PerPage int
+}
+
+func (p apiPage) Offset() int { return (p.Page - 1) * p.PerPage }
+
+// apiPagination читает ?page= и ?per_page= (по умолчанию 1 и 20, не больше 100).
+func apiPagination(c *gin.Context) (apiPage, bool) {
+	p := apiPage{Page: 1, PerPage: 20}
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apiError(c, http.StatusBadRequest, "invalid_parameter", "page должен быть целым ≥ 1")
+			return p, false
+		}
+		p.Page = n
+	}
+	if v := c.Query("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			apiError(c, http.StatusBadRequest, "invalid_parameter", "per_page должен быть от 1 до 100")
+			return p, false
+		}
+		p.PerPage = n
+	}
+	return p, true
+}
+
+func apiList(c *gin.Context, data any, total int64, p apiPage) {
+	pages := int((total + int64(p.PerPage) - 1) / int64(p.PerPage))
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"meta": apiListMeta{Page: p.Page, PerPage: p.PerPage, Total: total, TotalPages: pages},
+	})
+}
+
+// apiParamID — числовой параметр пути; при ошибке уже ответил 400.
+func apiParamID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id < 1 {
+		apiError(c, http.StatusBadRequest, "invalid_parameter", "Некорректный "+name)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// apiQueryID — необязательный числовой параметр запроса: 0 — не задан; при ошибке уже ответил 400.
+func apiQueryID(c *gin.Context, name string) (uint, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || id < 1 {
+		apiError(c, http.StatusBadRequest, "invalid_parameter", "Некорректный "+name)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// apiRecovery — паника внутри API тоже отдаёт конверт ошибки, а не HTML/пустой ответ.
+func apiRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		log.Printf("api panic: %v\n", err)
+		apiError(c, http.StatusInternalServerError, "internal", "Внутренняя ошибка сервера")
+	})
+}
+
+// ---------- OpenAPI ----------
+
+var ginParamRe = regexp.MustCompile(`:([A-Za-z_]+)`)
+
+// checkAPISpec пишет в лог расхождения маршрутов /api/v1 с api/openapi.json. При разработке
+// их ловит api_test.go: тест падает на любом расхождении.
+func checkAPISpec(r *gin.Engine) {
+	problems, err := apiSpecProblems(r.Routes())
+	if err != nil {
+		log.Fatalf("api/openapi.json: %v", err)
+	}
+	for _, p := range problems {
+		log.Printf("openapi: %s\n", p)
+	}
+}
+
+// apiSpecProblems сверяет маршруты /api/v1 со спецификацией в обе стороны: недокументированные
+// обработчики и описанные, но не реализованные пути.
+func apiSpecProblems(routes gin.RoutesInfo) ([]string, error) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return nil, err
+	}
+
+	documented := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+apiPrefix+path] = true
+		}
+	}
+
+	implemented := map[string]bool{}
+	for _, rt := range routes {
+		if !strings.HasPrefix(rt.Path, apiPrefix+"/") {
+			continue
+		}
+		implemented[rt.Method+" "+ginParamRe.ReplaceAllString(rt.Path, "{$1}")] = true
+	}
+
+	var problems []string
+	for k := range implemented {
+		if !documented[k] {
+			problems = append(problems, "нет в спецификации: "+k)
+		}
+	}
+	for k := range documented {
+		if !implemented[k] {
+			problems = append(problems, "нет обработчика: "+k)
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
@@ -0,0 +1,56 @@
+// api_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Каждый маршрут /api/v1 описан в api/openapi.json, и каждый описанный путь реализован.
+func TestAPISpecMatchesRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAPIRoutes(r)
+
+	problems, err := apiSpecProblems(r.Routes())
+	if err != nil {
+		t.Fatalf("api/openapi.json: %v", err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+// Фильтр по ID в запросе: не число — 400 с конвертом ошибки, а не ошибка Postgres.
+func TestAPIQueryID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		query string
+		id    uint
+		ok    bool
+	}{
+		{"", 0, true},
+		{"block_id=42", 42, true},
+		{"block_id=abc", 0, false},
+		{"block_id=0", 0, false},
+		{"block_id=-1", 0, false},
+		{"block_id=1.5", 0, false},
+		{"block_id=99999999999", 0, false},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/submissions?"+tc.query, nil)
+		id, ok := apiQueryID(c, "block_id")
+		if id != tc.id || ok != tc.ok {
+			t.Errorf("%q: apiQueryID = %d, %v; ожидается %d, %v", tc.query, id, ok, tc.id, tc.ok)
+		}
+		if !tc.ok && (w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"invalid_parameter"`)) {
+			t.Errorf("%q: ответ %d %s, ожидается 400 invalid_parameter", tc.query, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// api_tokens.go
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Права токенов. Токен никогда не даёт больше, чем роль владельца: admin-права
+// выдаются только токенам администраторов и сервисных аккаунтов с ролью admin.
+const (
+	scopeCatalogRead      = "catalog:read"      // каталог и дерево курсов
+	scopeLearn            = "learn"             // свои тесты, отправки, прогресс
+	scopeSubmissionsGrade = "submissions:grade" // проверка заданий
+	scopeUsersManage      = "users:manage"      // управление пользователями
+)
+
+type apiScope struct {
+	Name      string
+	Title     string
+	AdminOnly bool
+}
+
+var apiScopes = []apiScope{
+	{scopeCatalogRead, "Чтение каталога курсов", false},
+	{scopeLearn, "Прохождение курсов: тесты, отправки, прогресс", false},
+	{scopeSubmissionsGrade, "Проверка заданий", true},
+	{scopeUsersManage, "Управление пользователями", true},
+}
+
+const (
+	apiTokenPrefix = "tb_"
+	ctxAPIToken    = "api_token"
+	ctxAPIUser     = "api_user"
+)
+
+// scopesFor — какие права может получить токен этого владельца.
+func scopesFor(u *User) []apiScope {
+	var res []apiScope
+	for _, s := range apiScopes {
+		if !s.AdminOnly || u.IsAdmin() {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func (t APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// StatusLabel — для списков в UI.
+func (t APIToken) StatusLabel() string {
+	switch {
+	case t.RevokedAt != nil:
+		return "отозван"
+	case t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt):
+		return "истёк"
+	default:
+		return "активен"
+	}
+}
+
+// issueAPIToken создаёт токен и возвращает его открытым текстом (показать один раз).
+// ttl == 0 — бессрочный.
+func issueAPIToken(owner, createdBy *User, name string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 100 {
+		return "", nil, errors.New("укажите название токена (до 100 символов)")
+	}
+
+	allowed := map[string]bool{}
+	for _, s := range scopesFor(owner) {
+		allowed[s.Name] = true
+	}
+	seen := map[string]bool{}
+	var clean []string
+	for _, s := range scopes {
+		if !allowed[s] {
+			return "", nil, errors.New("право " + s + " недоступно для этого владельца")
+		}
+		if !seen[s] {
+			seen[s] = true
+			clean = append(clean, s)
+		}
+	}
+	if len(clean) == 0 {
+		return "", nil, errors.New("выберите хотя бы одно право")
+	}
+	sort.Strings(clean)
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	plain := apiTokenPrefix + secret
+
+	tok := APIToken{
+		UserID:      owner.ID,
+		Name:        name,
+		Prefix:      plain[:len(apiTokenPrefix)+6],
+		TokenHash:   hashToken(plain),
+		Scopes:      strings.Join(clean, " "),
+		CreatedByID: createdBy.ID,
+	}
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		tok.ExpiresAt = &exp
+	}
+	if err := db.Create(&tok).Error; err != nil {
+		return "", nil, err
+	}
+	return plain, &tok, nil
+}
+
+// revokeAPIToken отзывает токен; ownerID != 0 — только свой.
+func revokeAPIToken(id, ownerID uint) error {
+	q := db.Model(&APIToken{}).Where("id = ? AND revoked_at IS NULL", id)
+	if ownerID != 0 {
+		q = q.Where("user_id = ?", ownerID)
+	}
+	res := q.Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("токен не найден или уже отозван")
+	}
+	return nil
+}
+
+// tokenTTLFromForm — срок действия из select'а expires_days ("0" — бессрочно).
+func tokenTTLFromForm(c *gin.Context) time.Duration {
+	switch c.PostForm("expires_days") {
+	case "0":
+		return 0
+	case "7":
+		return 7 * 24 * time.Hour
+	case "90":
+		return 90 * 24 * time.Hour
+	case "365":
+		return 365 * 24 * time.Hour
+	default:
+		return 30 * 24 * time.Hour
+	}
+}
+
+// ---------- middleware ----------
+
+// bearerAuthMiddleware принимает «Authorization: Bearer tb_...» в /api/v1.
+// Запрос с токеном считается аутентифицированным без cookie-сессии: getCurrentUser
+// вернёт владельца токена, CSRF не проверяется.
+func bearerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.GetHeader("Authorization")
+		if h == "" || !strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+			c.Next()
+			return
+		}
+		plain, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			apiError(c, http.StatusUnauthorized, "invalid_token", "Ожидается Authorization: Bearer <токен>")
+			return
+		}
+
+		var tok APIToken
+		if err := db.Preload("User").Where("token_hash = ?", hashToken(strings.TrimSpace(plain))).
+			First(&tok).Error; err != nil {
+			apiError(c, http.StatusUnauthorized, "invalid_token", "Неизвестный токен")
+			return
+		}
+		now := time.Now()
+		if !tok.Active(now) {
+			apiError(c, http.StatusUnauthorized, "invalid_token", "Токен "+tok.StatusLabel())
+			return
+		}
+		if tok.User.ErasedAt != nil {
+			apiError(c, http.StatusUnauthorized, "invalid_token", "Владелец токена удалён")
+			return
+		}
+
+		// last_used — не чаще раза в минуту
+		if tok.LastUsedAt == nil || now.Sub(*tok.LastUsedAt) > time.Minute {
+			if err := db.Model(&APIToken{}).Where("id = ?", tok.ID).Updates(map[string]any{
+				"last_used_at": now,
+				"last_used_ip": c.ClientIP(),
+			}).Error; err != nil {
+				log.Printf("api token %d: last_used: %v\n", tok.ID, err)
+			}
+		}
+
+		user := tok.User
+		c.Set(ctxAPIToken, &tok)
+		c.Set(ctxAPIUser, &user)
+		c.Next()
+	}
+}
+
+// currentAPIToken — токен запроса или nil, если запрос пришёл с cookie-сессией.
+func currentAPIToken(c *gin.Context) *APIToken {
+	if v, ok := c.Get(ctxAPIToken); ok {
+		return v.(*APIToken)
+	}
+	return nil
+}
+
+// requireScope — для запросов с токеном проверяет право; сессионные запросы не ограничивает.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tok := currentAPIToken(c); tok != nil && !tok.HasScope(scope) {
+			apiError(c, http.StatusForbidden, "insufficient_scope", "У токена нет права "+scope)
+			return
+		}
+		c.Next()
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
@@ -21,24 +22,23 @@ var (
 	db *gorm.DB
 
 	tmplFuncs = template.FuncMap{
-		// аналог |safe
+		// аналог |safe, но через тот же allowlist, что и Markdown (sanitize.go)
 		"safe": func(v any) template.HTML {
-			switch x := v.(type) {
-			case template.HTML:
-				return x
-			case string:
-				return template.HTML(x)
-			default:
-				return template.HTML(fmt.Sprint(x))
-			}
+			return template.HTML(sanitizeHTML(fmt.Sprint(v)))
 		},
 
-		// очень простой markdown → HTML
+		// markdown → безопасный HTML (без кеша; для блоков — Block.TextHTML)
 		"md": func(s string) template.HTML {
-			if s == "" {
-				return ""
-			}
-			return template.HTML("<p>" + template.HTMLEscapeString(s) + "</p>")
+			return renderMarkdown(s, "", nil)
+		},
+
+		// текст с формулами $…$ (вопросы и варианты тестов)
+		"math": renderMathText,
+
+		// типы блоков (blocks.go): название типа и payload.title без подстановки «Блок #N»
+		"blockTypeTitle": blockTypeTitle,
+		"payloadTitle": func(b Block) string {
+			return payloadTitle(b.Payload)
 		},
 
 		// a + b
@@ -73,6 +73,28 @@ var (
 			}
 		},
 
+		// размер файла: 512 Б, 12 КБ, 2,4 МБ (float64 — число из payload в виде map)
+		"fileSize": func(v any) string {
+			var n int64
+			switch x := v.(type) {
+			case int64:
+				n = x
+			case int:
+				n = int64(x)
+			case float64:
+				n = int64(x)
+			}
+			switch {
+			case n < 1024:
+				return fmt.Sprintf("%d Б", n)
+			case n < 1<<20:
+				return fmt.Sprintf("%d КБ", n>>10)
+			case n < 1<<30:
+				return strings.Replace(fmt.Sprintf("%.1f МБ", float64(n)/(1<<20)), ".", ",", 1)
+			}
+			return strings.Replace(fmt.Sprintf("%.1f ГБ", float64(n)/(1<<30)), ".", ",", 1)
+		},
+
 		// поиск варианта ответа по id
 		"findOption": func(q QuizQuestion, optID uint) *QuizOption {
 			for i := range q.Options {
@@ -82,6 +104,43 @@ var (
 			}
 			return nil
 		},
+
+		// заголовок блока из payload (или «Блок #id»)
+		"blockTitle": func(b Block) string {
+			return blockTitle(&b)
+		},
+
+		// секунды → Ч:ММ:СС
+		"hms": func(sec int64) string {
+			return fmt.Sprintf("%d:%02d:%02d", sec/3600, sec%3600/60, sec%60)
+		},
+
+		// секунды видео → М:СС или Ч:ММ:СС
+		"clock": func(sec float64) string {
+			n := int64(sec)
+			if n >= 3600 {
+				return fmt.Sprintf("%d:%02d:%02d", n/3600, n%3600/60, n%60)
+			}
+			return fmt.Sprintf("%d:%02d", n/60, n%60)
+		},
+
+		// оценка в процентах или «—», если её нет (взаимное рецензирование)
+		"percent": func(v *float64) string {
+			if v == nil {
+				return "—"
+			}
+			return fmt.Sprintf("%.1f%%", *v)
+		},
+
+		// RFC3339 из payload → значение для <input type="datetime-local">
+		"localDateTime": func(v any) string {
+			s, _ := v.(string)
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return ""
+			}
+			return t.Local().Format("2006-01-02T15:04")
+		},
 	}
 )
 
@@ -102,6 +161,9 @@ func initDB() *gorm.DB {
 	if err := autoMigrate(gormDB); err != nil {
 		log.Fatalf("autoMigrate error: %v", err)
 	}
+	if err := runDataMigrations(gormDB); err != nil {
+		log.Fatalf("data migration error: %v", err)
+	}
 
 	seedAdmin(gormDB)
 
@@ -118,6 +180,32 @@ func autoMigrate(gormDB *gorm.DB) error {
 		&QuizQuestion{},
 		&QuizOption{},
 		&QuizAttempt{},
+		&RecoveryCode{},
+		&AuthThrottle{},
+		&LoginAttempt{},
+		&UserSession{},
+		&EmailChange{},
+		&APIToken{},
+		&CourseCompletion{},
+		&Webhook{},
+		&WebhookDelivery{},
+		&XAPIStatement{},
+		&LTIPlatform{},
+		&LTIKey{},
+		&LTILaunchState{},
+		&LTIUserLink{},
+		&LTIResourceLink{},
+		&LTIGrade{},
+		&LTIDeepLinkRequest{},
+		&ScormAttempt{},
+		&FlashcardState{},
+		&FlashcardReview{},
+		&SurveyResponse{},
+		&SurveyParticipant{},
+		&PeerReviewRound{},
+		&PeerReview{},
+		&VideoWatch{},
+		&DataMigration{},
 	)
 }
 
@@ -151,6 +239,7 @@ func seedAdmin(gormDB *gorm.DB) {
 		Email:        email,
 		PasswordHash: string(hash),
 		Role:         "admin",
+		AuthSource:   AuthSourceLocal,
 		CreatedAt:    time.Now(),
 	}
 
@@ -185,11 +274,21 @@ func loadTemplates() *template.Template {
 	// основные страницы
 	t = mustParseFile(t, "index.html", "templates/index.html")
 	t = mustParseFile(t, "login.html", "templates/login.html")
+	t = mustParseFile(t, "login_2fa.html", "templates/login_2fa.html")
 	t = mustParseFile(t, "register.html", "templates/register.html")
 	t = mustParseFile(t, "dashboard.html", "templates/dashboard.html")
+	t = mustParseFile(t, "account_2fa.html", "templates/account_2fa.html")
+	t = mustParseFile(t, "account_sessions.html", "templates/account_sessions.html")
+	t = mustParseFile(t, "account_profile.html", "templates/account_profile.html")
+	t = mustParseFile(t, "account_tokens.html", "templates/account_tokens.html")
 	t = mustParseFile(t, "courses.html", "templates/courses.html")
 	t = mustParseFile(t, "course_player.html", "templates/course_player.html")
 	t = mustParseFile(t, "view.html", "templates/view.html")
+	t = mustParseFile(t, "lti_deep_link.html", "templates/lti_deep_link.html")
+	t = mustParseFile(t, "lti_autopost.html", "templates/lti_autopost.html")
+	t = mustParseFile(t, "scorm_player.html", "templates/scorm_player.html")
+	t = mustParseFile(t, "review.html", "templates/review.html")
+	t = mustParseFile(t, "peer_review.html", "templates/peer_review.html")
 
 	// админские и блочные шаблоны (там свои define)
 	t = template.Must(t.ParseGlob("templates/admin/*.html"))
@@ -201,28 +300,55 @@ func loadTemplates() *template.Template {
 // ---------- main ----------
 
 func main() {
+	// служебные команды: ./server course-sync <каталог> (см. course_sync.go)
+	if len(os.Args) > 1 && os.Args[1] == "course-sync" {
+		os.Exit(runCourseSyncCommand(os.Args[2:]))
+	}
+
 	db = initDB()
 
+	initAuthChain()
+	initLoginLimiter()
+	startLDAPSync()
+	startWebhookWorker()
+	startXAPIWorker()
+	startLTIWorker()
+
 	r := gin.Default()
+	// c.ClientIP() — ключ лимитов входа и регистрации, IP сессий и токенов: X-Forwarded-For
+	// принимается только от прокси из TRUSTED_PROXIES, по умолчанию — ни от кого
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
 
 	// грузим шаблоны вручную и втыкаем в Gin
 	tmpl := loadTemplates()
 	r.SetHTMLTemplate(tmpl)
+	// {{ $.CSRF }} во всех шаблонах
+	r.HTMLRender = csrfHTMLRender{r.HTMLRender}
 
 	r.Static("/static", "./static")
 
-	// сессии
-	secret := os.Getenv("SESSION_SECRET")
-	if secret == "" {
-		secret = "supersecretkey"
-	}
-	store := cookie.NewStore([]byte(secret))
-	r.Use(sessions.Sessions("trainbrain_session", store))
+	// сессии (по умолчанию — в Postgres, см. session_store.go)
+	r.Use(clientIPMiddleware())
+	r.Use(sessions.Sessions(sessionCookieName, newSessionStore()))
+	r.Use(bearerAuthMiddleware())
+	r.Use(csrfMiddleware())
 
 	// роуты
 	registerAuthRoutes(r)
+	registerTwoFactorRoutes(r)
+	registerAccountRoutes(r)
+	registerAPIRoutes(r)
+	registerLTIRoutes(r)
+	checkAPISpec(r)
 	registerCourseRoutes(r)
 	registerSubmitRoutes(r)
+	registerScormRoutes(r)
+	registerFlashcardRoutes(r)
+	registerSurveyRoutes(r)
+	registerPeerReviewRoutes(r)
+	registerVideoRoutes(r)
 	registerAdminRoutes(r)
 
 	port := os.Getenv("PORT")
@@ -253,6 +379,20 @@ func registerAuthRoutes(r *gin.Engine) {
 	})
 
 	r.POST("/register", func(c *gin.Context) {
+		if !localAuthEnabled() {
+			c.HTML(http.StatusForbidden, "register.html", gin.H{
+				"Error": "Регистрация отключена: вход через корпоративный каталог",
+			})
+			return
+		}
+
+		if wait := registerBlockedFor(c.ClientIP()); wait > 0 {
+			c.HTML(http.StatusTooManyRequests, "register.html", gin.H{
+				"Error": "Слишком много регистраций с вашего адреса. Повторите через " + humanDuration(wait),
+			})
+			return
+		}
+
 		email := c.PostForm("email")
 		password := c.PostForm("password")
 		password2 := c.PostForm("password2")
@@ -291,6 +431,7 @@ func registerAuthRoutes(r *gin.Engine) {
 			Email:        email,
 			PasswordHash: string(hash),
 			Role:         "student",
+			AuthSource:   AuthSourceLocal,
 			CreatedAt:    time.Now(),
 		}
 		if err := db.Create(&user).Error; err != nil {
@@ -299,12 +440,9 @@ func registerAuthRoutes(r *gin.Engine) {
 			})
 			return
 		}
+		emitUserRegistered(&user)
 
-		sess := sessions.Default(c)
-		sess.Set("user_id", user.ID)
-		_ = sess.Save()
-
-		c.Redirect(http.StatusFound, "/dashboard")
+		loginOrChallenge(c, &user)
 	})
 
 	r.GET("/login", func(c *gin.Context) {
@@ -317,64 +455,111 @@ func registerAuthRoutes(r *gin.Engine) {
 	r.POST("/login", func(c *gin.Context) {
 		email := c.PostForm("email")
 		password := c.PostForm("password")
+		ip := c.ClientIP()
+		ua := c.Request.UserAgent()
 
-		var user User
-		if err := db.Where("email = ?", email).First(&user).Error; err != nil {
-			c.HTML(http.StatusUnauthorized, "login.html", gin.H{
-				"Error": "Неверный email или пароль",
+		if wait := loginBlockedFor(ip, email); wait > 0 {
+			logLoginAttempt(ip, email, ua, false, "locked")
+			c.HTML(http.StatusTooManyRequests, "login.html", gin.H{
+				"Error": "Слишком много неудачных попыток. Повторите через " + humanDuration(wait),
 			})
 			return
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		user, err := authenticate(email, password)
+		if err != nil {
+			recordLoginFailure(ip, email, ua, "bad_credentials")
 			c.HTML(http.StatusUnauthorized, "login.html", gin.H{
 				"Error": "Неверный email или пароль",
 			})
 			return
 		}
+		if user.TOTPEnabled || twoFactorRequired(user) {
+			// вход ещё не завершён: счётчик аккаунта не сбрасываем (иначе повторный POST /login
+			// снимал бы блокировку за неверные коды 2FA), успех запишет второй шаг
+			logLoginAttempt(ip, email, ua, false, loginPasswordOK)
+		} else {
+			recordLoginSuccess(ip, email, ua)
+		}
 
-		sess := sessions.Default(c)
-		sess.Set("user_id", user.ID)
-		_ = sess.Save()
-
-		c.Redirect(http.StatusFound, "/dashboard")
+		loginOrChallenge(c, user)
 	})
 
-	r.GET("/logout", func(c *gin.Context) {
-		sess := sessions.Default(c)
-		sess.Clear()
-		_ = sess.Save()
+	r.POST("/logout", func(c *gin.Context) {
+		logoutSession(c)
 		c.Redirect(http.StatusFound, "/")
 	})
 
 	r.GET("/dashboard", authRequired(), func(c *gin.Context) {
 		user := getCurrentUser(c)
+		cards, err := loadFlashcardStats(user.ID, time.Now())
+		if err != nil {
+			log.Printf("dashboard: карточки пользователя %d: %v\n", user.ID, err)
+		}
 		c.HTML(http.StatusOK, "dashboard.html", gin.H{
-			"User": user,
+			"User":       user,
+			"Flashcards": cards,
 		})
 	})
 }
 
 // ---------- helpers ----------
 
-func getCurrentUser(c *gin.Context) *User {
+// loginOrChallenge — пароль уже проверен: либо сразу входим,
+// либо (2FA включена или обязательна по политике) отправляем на второй шаг.
+func loginOrChallenge(c *gin.Context, user *User) {
+	if user.TOTPEnabled || twoFactorRequired(user) {
+		challengeSecondFactor(c, user, "")
+		return
+	}
+
+	finishLogin(c, user)
+	c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// challengeSecondFactor отправляет на второй шаг входа; next — куда перейти после него
+// (путь на сайте, "" — /dashboard).
+func challengeSecondFactor(c *gin.Context, user *User, next string) {
 	sess := sessions.Default(c)
-	idVal := sess.Get("user_id")
-	if idVal == nil {
-		return nil
+	sess.Delete("user_id")
+	sess.Set("pending_user_id", user.ID)
+	sess.Set("pending_at", time.Now().Unix())
+	if next != "" {
+		sess.Set("pending_next", next)
+	} else {
+		sess.Delete("pending_next")
 	}
+	_ = sess.Save()
 
-	var id uint
-	switch v := idVal.(type) {
-	case uint:
-		id = v
-	case int:
-		id = uint(v)
-	case int64:
-		id = uint(v)
-	case float64:
-		id = uint(v)
-	default:
+	if user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login/2fa")
+	} else {
+		c.Redirect(http.StatusFound, "/login/2fa/setup")
+	}
+}
+
+// finishLogin — единственное место, где user_id попадает в сессию.
+// ID сессии при этом меняется, чтобы нельзя было подсунуть жертве заранее известную сессию.
+func finishLogin(c *gin.Context, user *User) {
+	rotateSession(c)
+	resetCSRFToken(c)
+	sess := sessions.Default(c)
+	sess.Delete("pending_user_id")
+	sess.Delete("pending_at")
+	sess.Delete("pending_next")
+	sess.Set("user_id", user.ID)
+	_ = sess.Save()
+}
+
+func getCurrentUser(c *gin.Context) *User {
+	// запрос с API-токеном (см. bearerAuthMiddleware)
+	if v, ok := c.Get(ctxAPIUser); ok {
+		return v.(*User)
+	}
+
+	sess := sessions.Default(c)
+	id, ok := sessionUint(sess.Get("user_id"))
+	if !ok {
 		return nil
 	}
 
@@ -385,9 +570,29 @@ func getCurrentUser(c *gin.Context) *User {
 	return &user
 }
 
+// sessionUint — id из сессии мог сохраниться как uint/int/int64/float64
+func sessionUint(v any) (uint, bool) {
+	switch x := v.(type) {
+	case uint:
+		return x, true
+	case int:
+		return uint(x), true
+	case int64:
+		return uint(x), true
+	case float64:
+		return uint(x), true
+	default:
+		return 0, false
+	}
+}
+
 func authRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if getCurrentUser(c) == nil {
+			if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+				apiError(c, http.StatusUnauthorized, "unauthorized", "Требуется вход или API-токен")
+				return
+			}
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
@@ -398,21 +603,74 @@ func authRequired() gin.HandlerFunc {
 
 func adminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		isAPI := strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/")
 		user := getCurrentUser(c)
 		if user == nil {
+			if isAPI {
+				apiError(c, http.StatusUnauthorized, "unauthorized", "Требуется вход или API-токен")
+				return
+			}
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
 		if !user.IsAdmin() {
+			if isAPI {
+				apiError(c, http.StatusForbidden, "forbidden", "Нужны права администратора")
+				return
+			}
 			c.String(http.StatusForbidden, "Forbidden")
 			c.Abort()
 			return
 		}
+		// политика: админам без 2FA — сначала подключить её
+		// (к токенам не относится: токен выпускался уже из сессии с 2FA)
+		if currentAPIToken(c) == nil && twoFactorRequired(user) && !user.TOTPEnabled {
+			if isAPI {
+				apiError(c, http.StatusForbidden, "2fa_required", "Подключите двухфакторную аутентификацию")
+				return
+			}
+			setFlash(c, "warning", "Для доступа к админке подключите двухфакторную аутентификацию.")
+			c.Redirect(http.StatusFound, "/account/2fa")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+// ---------- переменные окружения ----------
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// trustedProxies — TRUSTED_PROXIES: IP и подсети (CIDR) через запятую или пробел; nil — не доверять никому.
+func trustedProxies() []string {
+	list := strings.FieldsFunc(os.Getenv("TRUSTED_PROXIES"), func(r rune) bool { return r == ',' || r == ' ' })
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
 // helper для отладки
 func debugPrint(err error) {
 	if err != nil {
@@ -0,0 +1,104 @@
+// auth.go
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator — один источник проверки логина/пароля (локальная БД, LDAP, ...).
+// При успехе возвращает пользователя из нашей БД (создаёт/обновляет его при необходимости).
+type Authenticator interface {
+	Name() string
+	Authenticate(login, password string) (*User, error)
+}
+
+// errInvalidCredentials — «нормальный» отказ: пользователя нет или пароль неверный.
+// Остальные ошибки (недоступен LDAP и т.п.) логируются, но цепочка идёт дальше.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+var authChain []Authenticator
+
+// initAuthChain собирает цепочку из AUTH_BACKENDS (по умолчанию "local").
+// Пример: AUTH_BACKENDS=ldap,local — сначала AD/LDAP, затем локальные пароли.
+func initAuthChain() {
+	backends := os.Getenv("AUTH_BACKENDS")
+	if backends == "" {
+		backends = "local"
+	}
+
+	authChain = nil
+	for _, name := range strings.Split(backends, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "local":
+			authChain = append(authChain, localAuthenticator{})
+		case "ldap":
+			cfg, err := loadLDAPConfig()
+			if err != nil {
+				log.Fatalf("ldap auth: %v", err)
+			}
+			authChain = append(authChain, &ldapAuthenticator{cfg: cfg})
+		default:
+			log.Fatalf("unknown auth backend %q", name)
+		}
+	}
+
+	if len(authChain) == 0 {
+		log.Fatalf("AUTH_BACKENDS: не задано ни одного источника аутентификации")
+	}
+}
+
+// authenticate проходит по цепочке и возвращает первого успешно проверенного пользователя.
+func authenticate(login, password string) (*User, error) {
+	login = strings.TrimSpace(login)
+	if login == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	for _, a := range authChain {
+		user, err := a.Authenticate(login, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errInvalidCredentials) {
+			log.Printf("auth %s: %v\n", a.Name(), err)
+		}
+	}
+	return nil, errInvalidCredentials
+}
+
+// localAuthEnabled — можно ли заводить локальные аккаунты (регистрация, пароль в профиле).
+func localAuthEnabled() bool {
+	for _, a := range authChain {
+		if _, ok := a.(localAuthenticator); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------- локальные пароли (bcrypt) ----------
+
+type localAuthenticator struct{}
+
+func (localAuthenticator) Name() string { return "local" }
+
+func (localAuthenticator) Authenticate(login, password string) (*User, error) {
+	var user User
+	if err := db.Where("email = ? AND auth_source = ?", login, AuthSourceLocal).First(&user).Error; err != nil {
+		return nil, errInvalidCredentials
+	}
+	if user.PasswordHash == "" {
+		return nil, errInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, errInvalidCredentials
+	}
+	return &user, nil
+}
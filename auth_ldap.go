@@ -0,0 +1,370 @@
+// auth_ldap.go
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ---------- конфигурация ----------
+
+type ldapRoleMapping struct {
+	Role    string
+	GroupDN string
+}
+
+type ldapConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserFilter string // {login} заменяется на экранированный логин
+	AttrEmail  string
+	AttrName   string
+
+	GroupBaseDN string // если пусто — роли считаются только по memberOf
+	GroupFilter string // {dn} заменяется на экранированный DN пользователя
+
+	RoleMap     []ldapRoleMapping // порядок = приоритет
+	DefaultRole string
+
+	SyncInterval time.Duration
+}
+
+func loadLDAPConfig() (ldapConfig, error) {
+	cfg := ldapConfig{
+		URL:                os.Getenv("LDAP_URL"),
+		StartTLS:           os.Getenv("LDAP_STARTTLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         envOr("LDAP_USER_FILTER", "(|(mail={login})(uid={login})(sAMAccountName={login}))"),
+		AttrEmail:          envOr("LDAP_ATTR_EMAIL", "mail"),
+		AttrName:           envOr("LDAP_ATTR_NAME", "displayName"),
+		GroupBaseDN:        os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:        envOr("LDAP_GROUP_FILTER", "(|(member={dn})(uniqueMember={dn}))"),
+		DefaultRole:        envOr("LDAP_DEFAULT_ROLE", "student"),
+	}
+
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return cfg, errors.New("LDAP_URL и LDAP_BASE_DN обязательны")
+	}
+
+	// LDAP_ROLE_MAP="admin=cn=tb-admins,ou=groups,dc=example,dc=org;staff=cn=tb-staff,ou=groups,dc=example,dc=org"
+	for _, part := range strings.Split(os.Getenv("LDAP_ROLE_MAP"), ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		role, dn, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(role) == "" || strings.TrimSpace(dn) == "" {
+			return cfg, fmt.Errorf("LDAP_ROLE_MAP: некорректный элемент %q", part)
+		}
+		cfg.RoleMap = append(cfg.RoleMap, ldapRoleMapping{
+			Role:    strings.TrimSpace(role),
+			GroupDN: strings.TrimSpace(dn),
+		})
+	}
+
+	interval := envOr("LDAP_SYNC_INTERVAL", "1h")
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return cfg, fmt.Errorf("LDAP_SYNC_INTERVAL: %v", err)
+	}
+	cfg.SyncInterval = d
+
+	return cfg, nil
+}
+
+// ---------- аутентификатор ----------
+
+type ldapAuthenticator struct {
+	cfg ldapConfig
+}
+
+// ldapEntry — то, что мы забираем из каталога про пользователя.
+type ldapEntry struct {
+	DN       string
+	Email    string
+	FullName string
+	Groups   []string
+}
+
+func (a *ldapAuthenticator) Name() string { return "ldap" }
+
+func (a *ldapAuthenticator) dial() (*ldap.Conn, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithTLSConfig(tlsCfg))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(10 * time.Second)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// bindService — bind под сервисной учёткой (или анонимно, если LDAP_BIND_DN не задан).
+func (a *ldapAuthenticator) bindService(conn *ldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(a.cfg.BindDN, a.cfg.BindPassword)
+}
+
+func (a *ldapAuthenticator) search(conn *ldap.Conn, baseDN string, scope int, filter string) (*ldapEntry, error) {
+	req := ldap.NewSearchRequest(
+		baseDN, scope, ldap.NeverDerefAliases, 2, 10, false,
+		filter,
+		[]string{a.cfg.AttrEmail, a.cfg.AttrName, "cn", "memberOf"},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, errInvalidCredentials
+		}
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		// 0 — нет такого пользователя, >1 — фильтр неоднозначный, лучше отказать
+		return nil, errInvalidCredentials
+	}
+
+	e := res.Entries[0]
+	entry := &ldapEntry{
+		DN:       e.DN,
+		Email:    strings.TrimSpace(e.GetAttributeValue(a.cfg.AttrEmail)),
+		FullName: strings.TrimSpace(e.GetAttributeValue(a.cfg.AttrName)),
+		Groups:   e.GetAttributeValues("memberOf"),
+	}
+	if entry.FullName == "" {
+		entry.FullName = strings.TrimSpace(e.GetAttributeValue("cn"))
+	}
+
+	if a.cfg.GroupBaseDN != "" {
+		groups, err := a.searchGroups(conn, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		entry.Groups = append(entry.Groups, groups...)
+	}
+	return entry, nil
+}
+
+// searchGroups — для OpenLDAP без overlay memberOf ищем группы по member/uniqueMember.
+func (a *ldapAuthenticator) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	filter := strings.ReplaceAll(a.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(userDN))
+	req := ldap.NewSearchRequest(
+		a.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 10, false,
+		filter, []string{"dn"}, nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+func (a *ldapAuthenticator) roleFor(groups []string) string {
+	for _, m := range a.cfg.RoleMap {
+		for _, g := range groups {
+			if strings.EqualFold(normalizeDN(g), normalizeDN(m.GroupDN)) {
+				return m.Role
+			}
+		}
+	}
+	return a.cfg.DefaultRole
+}
+
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.TrimSpace(dn)
+	}
+	parts := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, at := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(at.Type)+"="+at.Value)
+		}
+		parts = append(parts, strings.Join(attrs, "+"))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (a *ldapAuthenticator) Authenticate(login, password string) (*User, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := a.bindService(conn); err != nil {
+		return nil, fmt.Errorf("service bind: %w", err)
+	}
+
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "{login}", ldap.EscapeFilter(login))
+	entry, err := a.search(conn, a.cfg.BaseDN, ldap.ScopeWholeSubtree, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// проверяем пароль bind'ом от имени пользователя
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, err
+	}
+
+	return a.upsertUser(entry)
+}
+
+// upsertUser создаёт или обновляет локальную запись для пользователя каталога.
+func (a *ldapAuthenticator) upsertUser(entry *ldapEntry) (*User, error) {
+	if entry.Email == "" {
+		return nil, fmt.Errorf("у %s не заполнен атрибут %s", entry.DN, a.cfg.AttrEmail)
+	}
+	role := a.roleFor(entry.Groups)
+
+	var user User
+	err := db.Where("auth_source = ? AND external_id = ?", AuthSourceLDAP, entry.DN).First(&user).Error
+	if err != nil {
+		var cnt int64
+		db.Model(&User{}).Where("email = ?", entry.Email).Count(&cnt)
+		if cnt > 0 {
+			return nil, fmt.Errorf("email %s уже занят другой учётной записью", entry.Email)
+		}
+
+		user = User{
+			Email:      entry.Email,
+			FullName:   entry.FullName,
+			Role:       role,
+			AuthSource: AuthSourceLDAP,
+			ExternalID: entry.DN,
+			CreatedAt:  time.Now(),
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return nil, err
+		}
+		log.Printf("ldap: создан пользователь %s (%s, роль %s)\n", user.Email, entry.DN, role)
+		emitUserRegistered(&user)
+		return &user, nil
+	}
+
+	roleChanged := user.Role != role
+	user.Email = entry.Email
+	user.FullName = entry.FullName
+	user.Role = role
+	if err := db.Save(&user).Error; err != nil {
+		return nil, err
+	}
+	if roleChanged {
+		// права изменились — старые сессии пусть войдут заново
+		if _, err := revokeUserSessions(user.ID, ""); err != nil {
+			log.Printf("ldap: отзыв сессий %s: %v\n", user.Email, err)
+		}
+	}
+	return &user, nil
+}
+
+// ---------- периодическая синхронизация ----------
+
+// syncUsers подтягивает FullName/Email/роль для всех LDAP-пользователей.
+func (a *ldapAuthenticator) syncUsers() error {
+	var users []User
+	if err := db.Where("auth_source = ?", AuthSourceLDAP).Find(&users).Error; err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := a.bindService(conn); err != nil {
+		return fmt.Errorf("service bind: %w", err)
+	}
+
+	updated := 0
+	for _, u := range users {
+		entry, err := a.search(conn, u.ExternalID, ldap.ScopeBaseObject, "(objectClass=*)")
+		if errors.Is(err, errInvalidCredentials) {
+			log.Printf("ldap sync: %s не найден в каталоге\n", u.ExternalID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		role := a.roleFor(entry.Groups)
+		if entry.Email == u.Email && entry.FullName == u.FullName && role == u.Role {
+			continue
+		}
+		if entry.Email != "" {
+			u.Email = entry.Email
+		}
+		u.FullName = entry.FullName
+		roleChanged := u.Role != role
+		u.Role = role
+		if err := db.Save(&u).Error; err != nil {
+			log.Printf("ldap sync: ошибка сохранения %s: %v\n", u.ExternalID, err)
+			continue
+		}
+		if roleChanged {
+			if _, err := revokeUserSessions(u.ID, ""); err != nil {
+				log.Printf("ldap sync: отзыв сессий %s: %v\n", u.Email, err)
+			}
+		}
+		updated++
+	}
+
+	log.Printf("ldap sync: проверено %d, обновлено %d\n", len(users), updated)
+	return nil
+}
+
+// startLDAPSync запускает фоновую синхронизацию, если в цепочке есть LDAP.
+func startLDAPSync() {
+	for _, a := range authChain {
+		la, ok := a.(*ldapAuthenticator)
+		if !ok || la.cfg.SyncInterval <= 0 {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(la.cfg.SyncInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := la.syncUsers(); err != nil {
+					log.Printf("ldap sync: %v\n", err)
+				}
+			}
+		}()
+	}
+}
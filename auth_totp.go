@@ -0,0 +1,166 @@
+// auth_totp.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod        = 30
+	totpSkew          = 1 // допускаем ±1 шаг (часы телефона могут спешить)
+	recoveryCodeCount = 10
+)
+
+func totpIssuer() string {
+	return envOr("TOTP_ISSUER", "TrainBrain")
+}
+
+// twoFactorRequired — политика TWOFA_REQUIRED_ROLES (например "admin" или "admin,staff").
+func twoFactorRequired(u *User) bool {
+	for _, role := range strings.Split(os.Getenv("TWOFA_REQUIRED_ROLES"), ",") {
+		if strings.TrimSpace(role) == u.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// newTOTPKey возвращает ключ для приложения-аутентификатора.
+// secret == "" — сгенерировать новый, иначе восстановить ключ (и QR) по уже выданному секрету.
+func newTOTPKey(u *User, secret string) (*otp.Key, error) {
+	opts := totp.GenerateOpts{
+		Issuer:      totpIssuer(),
+		AccountName: u.Email,
+		Period:      totpPeriod,
+	}
+	if secret != "" {
+		raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+		if err != nil {
+			return nil, err
+		}
+		opts.Secret = raw
+	}
+	return totp.Generate(opts)
+}
+
+// totpQRDataURI рисует otpauth:// URL ключа как PNG в data: URI (без внешних сервисов).
+func totpQRDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(220, 220)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// matchTOTPStep возвращает номер 30-секундного шага, которому соответствует код, или -1.
+func matchTOTPStep(secret, code string, now time.Time) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return -1
+	}
+	opts := totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+	for off := -totpSkew; off <= totpSkew; off++ {
+		t := now.Add(time.Duration(off*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, opts)
+		if err != nil {
+			return -1
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return t.Unix() / totpPeriod
+		}
+	}
+	return -1
+}
+
+// verifyTOTP проверяет код пользователя и запоминает шаг, чтобы один код нельзя было использовать дважды.
+func verifyTOTP(u *User, code string) bool {
+	if !u.TOTPEnabled || u.TOTPSecret == "" {
+		return false
+	}
+	step := matchTOTPStep(u.TOTPSecret, code, time.Now())
+	if step < 0 || step <= u.TOTPLastStep {
+		return false
+	}
+	res := db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", u.ID, step).
+		Update("totp_last_step", step)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false
+	}
+	u.TOTPLastStep = step
+	return true
+}
+
+// ---------- резервные коды ----------
+
+func hashRecoveryCode(code string) string {
+	norm := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(norm))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b) // 8 символов
+	return s[:4] + "-" + s[4:], nil
+}
+
+// regenerateRecoveryCodes удаляет старые коды и возвращает новые (в открытом виде — показываются один раз).
+func regenerateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		rc := RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)}
+		if err := tx.Create(&rc).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// useRecoveryCode помечает код использованным; true — если код был действителен.
+func useRecoveryCode(userID uint, code string) bool {
+	now := time.Now()
+	res := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", &now)
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+func remainingRecoveryCodes(userID uint) int64 {
+	var cnt int64
+	db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&cnt)
+	return cnt
+}
@@ -0,0 +1,1361 @@
+// blocks.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Типы блоков. Каждый тип объявляет payload — Go-структуру, по тегам которой строится
+// JSON Schema, — разбор формы админки, подгрузку данных для плеера и правило «блок пройден».
+// Остальной код спрашивает реестр и не сравнивает строки типов.
+
+// blockPayload — payload блока своего типа. validate — правила, которых нет в схеме
+// (зависимость одних полей от других).
+type blockPayload interface {
+	validate() error
+}
+
+type blockKind struct {
+	Type     string
+	Title    string     // в админке
+	XAPIType string     // тип активности xAPI
+	Scored   bool       // результат с баллом: колонка в журнале LTI
+	Schema   jsonSchema // строится по payload при старте
+
+	payload  func() blockPayload // пустой payload типа (указатель на структуру)
+	markdown []string            // поля с Markdown — их чистит санитайзер
+	urls     []string            // поля с адресами файлов и встраивания
+	legacy   func(pm map[string]any)
+
+	// parseForm собирает payload из формы; prev — сохранённый payload того же типа (или пустой).
+	// warnings — ошибки формул и диаграмм: блок с ними сохраняется.
+	parseForm func(c *gin.Context, prev blockPayload) (p blockPayload, warnings []string, err error)
+	// load подгружает для плеера то, что не лежит в payload; user может быть nil.
+	load func(blk *Block, user *User) error
+	// completed — блок пройден учеником; nil — блок не оценивается и в прогрессе не считается.
+	completed func(userID, blockID uint) bool
+	// gradedIf — оценивается ли конкретный блок, если это задаёт payload (видео с порогом просмотра);
+	// nil — оцениваются все блоки типа с completed.
+	gradedIf func(blk *Block) bool
+}
+
+// Graded — блоки типа могут входить в прогресс.
+func (k *blockKind) Graded() bool { return k.completed != nil }
+
+// BlockGraded — блок входит в прогресс ученика.
+func (k *blockKind) BlockGraded(blk *Block) bool {
+	return k.Graded() && (k.gradedIf == nil || k.gradedIf(blk))
+}
+
+var (
+	blockKindList = []*blockKind{
+		textBlock, videoBlock, assignmentBlock, quizBlock, scormBlock, fileBlock, pdfBlock, linkBlock, flashcardsBlock,
+		surveyBlock, pollBlock,
+	}
+	blockKinds = indexBlockKinds(blockKindList)
+)
+
+func indexBlockKinds(list []*blockKind) map[string]*blockKind {
+	m := make(map[string]*blockKind, len(list))
+	for _, k := range list {
+		k.Schema = schemaOf(reflect.TypeOf(k.payload()))
+		k.Schema["title"] = k.Title
+		m[k.Type] = k
+	}
+	return m
+}
+
+// blockItemIDRe — id элементов внутри payload (карточки, критерии рубрики, вопросы опроса):
+// по нему хранятся ответы, поэтому он не меняется при правке текста.
+var blockItemIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// newBlockItemID — id нового элемента payload, подходит под blockItemIDRe.
+func newBlockItemID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// blockTypeTitle — название типа для админки.
+func blockTypeTitle(typ string) string {
+	if k := blockKinds[typ]; k != nil {
+		return k.Title
+	}
+	return typ
+}
+
+// payloadTitle — payload.title; title есть у payload любого типа блока.
+func payloadTitle(raw datatypes.JSON) string {
+	var p struct {
+		Title string `json:"title"`
+	}
+	if len(raw) > 0 && json.Unmarshal(raw, &p) == nil {
+		return strings.TrimSpace(p.Title)
+	}
+	return ""
+}
+
+// decodeBlockData заполняет blk.Data. Повреждённый payload даёт пустую структуру — блок
+// покажется без содержимого, страница курса не падает.
+func decodeBlockData(blk *Block) {
+	k := blockKinds[blk.Type]
+	if k == nil {
+		blk.Data = nil
+		return
+	}
+	p := k.payload()
+	if len(blk.Payload) > 0 {
+		if err := json.Unmarshal(blk.Payload, p); err != nil {
+			log.Printf("blocks: блок #%d: payload не читается: %v\n", blk.ID, err)
+			p = k.payload()
+		}
+	}
+	blk.Data = p
+}
+
+// checkBlockPayload — payload соответствует схеме типа и его правилам.
+func checkBlockPayload(k *blockKind, raw datatypes.JSON) error {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	if problems := validateSchema(k.Schema, v, "payload"); len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	p := k.payload()
+	if err := json.Unmarshal(raw, p); err != nil {
+		return err
+	}
+	return p.validate()
+}
+
+// canonicalBlockPayload приводит payload, сохранённый старыми версиями (или пришедший из архива),
+// к каноническому виду: старые ключи переносятся, неизвестные отбрасываются (dropped).
+// problem — канонический payload всё ещё не проходит проверку; он возвращается как есть.
+func canonicalBlockPayload(k *blockKind, raw datatypes.JSON) (out datatypes.JSON, dropped []string, problem error, err error) {
+	pm := map[string]any{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &pm); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if k.legacy != nil {
+		k.legacy(pm)
+	}
+	props, _ := k.Schema["properties"].(jsonSchema)
+	for key := range pm {
+		if _, ok := props[key]; !ok {
+			dropped = append(dropped, key)
+			delete(pm, key)
+		}
+	}
+	sort.Strings(dropped)
+
+	b, err := json.Marshal(pm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := k.payload()
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, nil, nil, err
+	}
+	if b, err = json.Marshal(p); err != nil {
+		return nil, nil, nil, err
+	}
+	return datatypes.JSON(b), dropped, checkBlockPayload(k, b), nil
+}
+
+// migrateCanonicalPayloads переписывает payload блоков в канонический вид реестра.
+// Блоки, которые и после этого не проходят проверку (например, видео без адреса),
+// сохраняются как есть и попадают в лог. updated_at не меняется.
+func migrateCanonicalPayloads(tx *gorm.DB) error {
+	changed := 0
+	var blocks []Block
+	err := tx.Select("id", "type", "payload").Order("id").FindInBatches(&blocks, 200, func(tx *gorm.DB, _ int) error {
+		for _, b := range blocks {
+			k := blockKinds[b.Type]
+			if k == nil {
+				log.Printf("blocks: блок #%d: неизвестный тип %q\n", b.ID, b.Type)
+				continue
+			}
+			out, dropped, problem, err := canonicalBlockPayload(k, b.Payload)
+			if err == nil {
+				// перенесённые старые адреса проходят через санитайзер
+				out, _, err = sanitizeBlockPayload(b.Type, out)
+			}
+			if err != nil {
+				log.Printf("blocks: блок #%d: payload не приводится к схеме: %v\n", b.ID, err)
+				continue
+			}
+			if len(dropped) > 0 {
+				log.Printf("blocks: блок #%d: отброшены поля %s\n", b.ID, strings.Join(dropped, ", "))
+			}
+			if problem != nil {
+				log.Printf("blocks: блок #%d: %v\n", b.ID, problem)
+			}
+			if sameJSON(b.Payload, out) {
+				continue
+			}
+			if err := tx.Model(&Block{}).Where("id = ?", b.ID).UpdateColumn("payload", out).Error; err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("blocks: приведено к схеме payload блоков: %d\n", changed)
+	return nil
+}
+
+// loadBlocksForPlayer — payload и данные ученика для всех блоков курса.
+func loadBlocksForPlayer(course *Course, user *User) error {
+	for mi := range course.Modules {
+		for bi := range course.Modules[mi].Blocks {
+			blk := &course.Modules[mi].Blocks[bi]
+			decodeBlockData(blk)
+			k := blockKinds[blk.Type]
+			if k == nil || k.load == nil {
+				continue
+			}
+			if err := k.load(blk, user); err != nil {
+				return fmt.Errorf("блок #%d: %w", blk.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// blockCompleted — есть ли у ученика результат, которым тип блока засчитывает прохождение.
+func blockCompleted(model any, cond string, userID, blockID uint) bool {
+	var cnt int64
+	q := db.Model(model).Where("user_id = ? AND block_id = ?", userID, blockID)
+	if cond != "" {
+		q = q.Where(cond)
+	}
+	q.Count(&cnt)
+	return cnt > 0
+}
+
+// lastByUser — последняя запись ученика по блоку; nil, если её нет.
+func lastByUser[T any](userID, blockID uint) (*T, error) {
+	var v T
+	err := db.Where("user_id = ? AND block_id = ?", userID, blockID).Order("created_at desc").First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+///////////////////////////////////////////////////////
+// ТЕКСТ
+///////////////////////////////////////////////////////
+
+type textPayload struct {
+	Title    string            `json:"title,omitempty" schema:"maxlen=300"`
+	Text     string            `json:"text"`                // Markdown
+	ImageURL string            `json:"image_url,omitempty"` // картинка над текстом
+	Diagrams map[string]string `json:"diagrams,omitempty"`  // SVG по diagramKey, рисуются при сохранении
+}
+
+func (p *textPayload) validate() error { return nil }
+
+var textBlock = &blockKind{
+	Type:     "text",
+	Title:    "Текст",
+	XAPIType: xapiTypeLesson,
+	payload:  func() blockPayload { return &textPayload{} },
+	markdown: []string{"text"},
+	urls:     []string{"image_url"},
+
+	parseForm: func(c *gin.Context, prev blockPayload) (blockPayload, []string, error) {
+		p := &textPayload{
+			Title:    strings.TrimSpace(c.PostForm("payload_title")),
+			Text:     c.PostForm("payload_text"),
+			ImageURL: strings.TrimSpace(c.PostForm("payload_image_url")),
+		}
+		var warnings []string
+		p.Diagrams, warnings = textBlockExtras(p.Text, prev.(*textPayload).Diagrams)
+		if len(warnings) > 0 {
+			warnings = append(warnings, "формулы с ошибками показываются исходником, диаграммы — блоком кода")
+		}
+		return p, warnings, nil
+	},
+	load: func(blk *Block, _ *User) error {
+		p := blk.Data.(*textPayload)
+		blk.TextHTML = blockMarkdown(blk, "text", p.Text, p.Diagrams)
+		return nil
+	},
+}
+
+///////////////////////////////////////////////////////
+// ВИДЕО
+///////////////////////////////////////////////////////
+
+type videoPayload struct {
+	Title string `json:"title,omitempty" schema:"maxlen=300"`
+	Mode  string `json:"mode" schema:"required,enum=embed|file"`
+	URL   string `json:"url,omitempty"` // embed: адрес плеера для iframe
+	Src   string `json:"src,omitempty"` // file: путь к mp4
+	// file: видео пройдено, когда просмотрено не меньше этой доли, %; без порога не оценивается
+	CompletePercent int `json:"complete_percent,omitempty" schema:"min=1,max=100"`
+	// file: субтитры, главы и текст всех дорожек для поиска — его собирает сохранение блока (video_captions.go)
+	Tracks     []videoTrack   `json:"tracks,omitempty"`
+	Chapters   []videoChapter `json:"chapters,omitempty"`
+	Transcript string         `json:"transcript,omitempty"`
+}
+
+// videoTrack — дорожка субтитров: загруженный на сайт WebVTT.
+type videoTrack struct {
+	Lang    string `json:"lang" schema:"required,maxlen=35"` // BCP 47: ru, en, pt-BR
+	Label   string `json:"label" schema:"required,maxlen=100"`
+	Src     string `json:"src" schema:"required"`
+	Default bool   `json:"default,omitempty"` // включена при открытии
+}
+
+type videoChapter struct {
+	At    float64 `json:"at" schema:"min=0"` // с от начала
+	Title string  `json:"title" schema:"required,maxlen=200"`
+}
+
+func (p *videoPayload) validate() error {
+	switch {
+	case p.Mode == "embed" && p.URL == "":
+		return errors.New("payload.url: укажите адрес видео для встраивания")
+	case p.Mode == "file" && p.Src == "":
+		return errors.New("payload.src: укажите путь к видеофайлу")
+	case p.Mode != "file" && p.CompletePercent != 0:
+		return errors.New("payload.complete_percent: просмотр отслеживается только у видео-файла")
+	case p.Mode != "file" && (len(p.Tracks) > 0 || len(p.Chapters) > 0):
+		return errors.New("payload.tracks, payload.chapters: субтитры и главы бывают только у видео-файла")
+	}
+	langs := map[string]bool{}
+	defaults := 0
+	for i, t := range p.Tracks {
+		// адрес вложен в tracks — санитайзер его не видит; расшифровке нужен файл на нашем диске
+		if contentFilePath(t.Src) == "" || !strings.EqualFold(path.Ext(t.Src), ".vtt") {
+			return fmt.Errorf("payload.tracks[%d].src: субтитры — только загруженный на сайт .vtt", i)
+		}
+		if langs[t.Lang] {
+			return fmt.Errorf("payload.tracks[%d].lang: дорожка %q уже есть", i, t.Lang)
+		}
+		langs[t.Lang] = true
+		if t.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return errors.New("payload.tracks: включённой по умолчанию может быть только одна дорожка")
+	}
+	for i, ch := range p.Chapters {
+		if i > 0 && ch.At <= p.Chapters[i-1].At {
+			return fmt.Errorf("payload.chapters[%d].at: главы идут по возрастанию времени", i)
+		}
+	}
+	return nil
+}
+
+// videoOf — payload видео; nil для блока другого типа.
+func videoOf(blk *Block) *videoPayload {
+	if p, ok := blk.Data.(*videoPayload); ok {
+		return p
+	}
+	var p videoPayload
+	if blk.Type != "video" || len(blk.Payload) == 0 || json.Unmarshal(blk.Payload, &p) != nil {
+		return nil
+	}
+	return &p
+}
+
+var videoBlock = &blockKind{
+	Type:     "video",
+	Title:    "Видео",
+	XAPIType: xapiTypeMedia,
+	payload:  func() blockPayload { return &videoPayload{} },
+	urls:     []string{"url", "src"},
+
+	// первые версии писали адрес дважды: url + video_url, src + path
+	legacy: func(pm map[string]any) {
+		for canon, old := range map[string]string{"url": "video_url", "src": "path"} {
+			if s, _ := pm[canon].(string); s == "" {
+				if v, ok := pm[old]; ok {
+					pm[canon] = v
+				}
+			}
+			delete(pm, old)
+		}
+		if m, _ := pm["mode"].(string); m == "" {
+			pm["mode"] = "embed"
+			u, _ := pm["url"].(string)
+			if s, _ := pm["src"].(string); u == "" && s != "" {
+				pm["mode"] = "file"
+			}
+		}
+	},
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &videoPayload{
+			Title: strings.TrimSpace(c.PostForm("payload_title")),
+			Mode:  strings.TrimSpace(c.PostForm("payload_mode")),
+			URL:   strings.TrimSpace(c.PostForm("payload_url")),
+			Src:   strings.TrimSpace(c.PostForm("payload_src")),
+		}
+		if p.Mode == "" {
+			p.Mode = "embed"
+		}
+		if v := strings.TrimSpace(c.PostForm("payload_complete_percent")); v != "" && p.Mode == "file" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				return nil, nil, errors.New("порог просмотра — целое число от 1 до 100 %")
+			}
+			p.CompletePercent = n
+		}
+		if p.Mode != "file" {
+			return p, nil, nil
+		}
+
+		// строки формы — параллельные списки tr_* и ch_*; пустые строки пропускаются
+		langs, labels, srcs := c.PostFormArray("tr_lang"), c.PostFormArray("tr_label"), c.PostFormArray("tr_src")
+		defaults := c.PostFormArray("tr_default")
+		at := func(list []string, i int) string {
+			if i < len(list) {
+				return strings.TrimSpace(list[i])
+			}
+			return ""
+		}
+		for i := range srcs {
+			t := videoTrack{Lang: at(langs, i), Label: at(labels, i), Src: at(srcs, i), Default: at(defaults, i) != ""}
+			if t.Src == "" && t.Lang == "" && t.Label == "" {
+				continue
+			}
+			if t.Label == "" {
+				t.Label = t.Lang
+			}
+			p.Tracks = append(p.Tracks, t)
+		}
+		times, titles := c.PostFormArray("ch_at"), c.PostFormArray("ch_title")
+		for i := range titles {
+			ch := videoChapter{Title: at(titles, i)}
+			if ch.Title == "" && at(times, i) == "" {
+				continue
+			}
+			var err error
+			if ch.At, err = parseClock(at(times, i)); err != nil {
+				return nil, nil, fmt.Errorf("глава %d: %w", i+1, err)
+			}
+			p.Chapters = append(p.Chapters, ch)
+		}
+		if err := p.validate(); err != nil {
+			return nil, nil, err
+		}
+		var err error
+		p.Transcript, err = videoTranscriptText(p.Tracks)
+		return p, nil, err
+	},
+	load: func(blk *Block, user *User) (err error) {
+		p := blk.Data.(*videoPayload)
+		if p.Mode != "file" {
+			return nil
+		}
+		blk.Transcripts = loadVideoTranscripts(blk, p.Tracks)
+		if user != nil {
+			blk.Watch, err = lastByUser[VideoWatch](user.ID, blk.ID)
+		}
+		return err
+	},
+	completed: func(userID, blockID uint) bool {
+		return blockCompleted(&VideoWatch{}, "completed_at IS NOT NULL", userID, blockID)
+	},
+	gradedIf: func(blk *Block) bool {
+		p := videoOf(blk)
+		return p != nil && p.Mode == "file" && p.CompletePercent > 0
+	},
+}
+
+///////////////////////////////////////////////////////
+// ЗАДАНИЕ
+///////////////////////////////////////////////////////
+
+type assignmentPayload struct {
+	Title      string              `json:"title,omitempty" schema:"maxlen=300"`
+	Prompt     string              `json:"prompt"`                // условие, выводится как есть с переносами строк
+	PeerReview *peerReviewSettings `json:"peer_review,omitempty"` // взаимное рецензирование (peer_review.go)
+}
+
+func (p *assignmentPayload) validate() error {
+	if p.PeerReview != nil {
+		return p.PeerReview.validate()
+	}
+	return nil
+}
+
+// peerReviewSettings — сроки сдачи и рецензирования, число рецензентов на работу и рубрика.
+// Сроки — RFC 3339 с часовым поясом сервера.
+type peerReviewSettings struct {
+	Deadline       string            `json:"deadline" schema:"required"`        // сдача до; после — распределение работ
+	ReviewDeadline string            `json:"review_deadline" schema:"required"` // рецензии до; после — отзывы видны авторам
+	Reviewers      int               `json:"reviewers" schema:"required,min=1,max=10"`
+	Rubric         []rubricCriterion `json:"rubric" schema:"required"`
+}
+
+type rubricCriterion struct {
+	ID          string `json:"id" schema:"required,maxlen=32"`
+	Title       string `json:"title" schema:"required,maxlen=300"`
+	Description string `json:"description,omitempty" schema:"maxlen=2000"`
+	Points      int    `json:"points" schema:"required,min=1,max=100"` // максимум баллов
+}
+
+func (s *peerReviewSettings) validate() error {
+	deadline, err := time.Parse(time.RFC3339, s.Deadline)
+	if err != nil {
+		return errors.New("payload.peer_review.deadline: укажите срок сдачи")
+	}
+	reviewDeadline, err := time.Parse(time.RFC3339, s.ReviewDeadline)
+	if err != nil {
+		return errors.New("payload.peer_review.review_deadline: укажите срок рецензирования")
+	}
+	if !reviewDeadline.After(deadline) {
+		return errors.New("payload.peer_review.review_deadline: срок рецензирования — позже срока сдачи")
+	}
+	if len(s.Rubric) == 0 {
+		return errors.New("payload.peer_review.rubric: добавьте хотя бы один критерий")
+	}
+	seen := map[string]bool{}
+	for i, rc := range s.Rubric {
+		switch {
+		case !blockItemIDRe.MatchString(rc.ID):
+			return fmt.Errorf("payload.peer_review.rubric[%d].id: латинские буквы, цифры, _ и -, до 32 символов", i)
+		case seen[rc.ID]:
+			return fmt.Errorf("payload.peer_review.rubric[%d].id: повторяется %q", i, rc.ID)
+		case strings.TrimSpace(rc.Title) == "":
+			return fmt.Errorf("payload.peer_review.rubric[%d].title: пустой критерий", i)
+		}
+		seen[rc.ID] = true
+	}
+	return nil
+}
+
+// DeadlineAt, ReviewDeadlineAt — сроки; payload прошёл validate, поэтому ошибок разбора нет.
+func (s *peerReviewSettings) DeadlineAt() time.Time {
+	t, _ := time.Parse(time.RFC3339, s.Deadline)
+	return t.Local()
+}
+
+func (s *peerReviewSettings) ReviewDeadlineAt() time.Time {
+	t, _ := time.Parse(time.RFC3339, s.ReviewDeadline)
+	return t.Local()
+}
+
+// MaxPoints — сумма максимумов по рубрике.
+func (s *peerReviewSettings) MaxPoints() int {
+	n := 0
+	for _, rc := range s.Rubric {
+		n += rc.Points
+	}
+	return n
+}
+
+// parsePeerReviewForm — настройки рецензирования из формы задания; nil — рецензирование выключено.
+// Критерии — параллельные списки rc_*, сроки — datetime-local в часовом поясе сервера.
+func parsePeerReviewForm(c *gin.Context) (*peerReviewSettings, error) {
+	if c.PostForm("payload_peer_enabled") != "on" {
+		return nil, nil
+	}
+	s := &peerReviewSettings{}
+	for _, f := range []struct {
+		field, title string
+		dst          *string
+	}{
+		{"payload_peer_deadline", "срок сдачи", &s.Deadline},
+		{"payload_peer_review_deadline", "срок рецензирования", &s.ReviewDeadline},
+	} {
+		t, err := time.ParseInLocation("2006-01-02T15:04", strings.TrimSpace(c.PostForm(f.field)), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("%s: укажите дату и время", f.title)
+		}
+		*f.dst = t.Format(time.RFC3339)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(c.PostForm("payload_peer_reviewers")))
+	if err != nil {
+		return nil, errors.New("рецензентов на работу — число от 1 до 10")
+	}
+	s.Reviewers = n
+
+	ids, titles, descs, points := c.PostFormArray("rc_id"), c.PostFormArray("rc_title"),
+		c.PostFormArray("rc_description"), c.PostFormArray("rc_points")
+	at := func(list []string, i int) string {
+		if i < len(list) {
+			return strings.TrimSpace(list[i])
+		}
+		return ""
+	}
+	for i := range titles {
+		rc := rubricCriterion{ID: at(ids, i), Title: at(titles, i), Description: at(descs, i)}
+		if rc.Title == "" {
+			continue
+		}
+		if rc.Points, err = strconv.Atoi(at(points, i)); err != nil {
+			return nil, fmt.Errorf("критерий «%s»: баллы — целое число от 1 до 100", rc.Title)
+		}
+		if rc.ID == "" {
+			if rc.ID, err = newBlockItemID(); err != nil {
+				return nil, err
+			}
+		}
+		s.Rubric = append(s.Rubric, rc)
+	}
+	return s, nil
+}
+
+var assignmentBlock = &blockKind{
+	Type:     "assignment",
+	Title:    "Задание",
+	XAPIType: xapiTypeAssignment,
+	payload:  func() blockPayload { return &assignmentPayload{} },
+
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		peer, err := parsePeerReviewForm(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &assignmentPayload{
+			Title:      strings.TrimSpace(c.PostForm("payload_title")),
+			Prompt:     c.PostForm("payload_prompt"),
+			PeerReview: peer,
+		}, nil, nil
+	},
+	load: func(blk *Block, user *User) (err error) {
+		if user == nil {
+			return nil
+		}
+		if blk.LastSubmission, err = lastByUser[Submission](user.ID, blk.ID); err != nil {
+			return err
+		}
+		if blk.Data.(*assignmentPayload).PeerReview != nil {
+			blk.Peer, err = loadPeerReviewView(blk, user, time.Now())
+		}
+		return err
+	},
+	completed: func(userID, blockID uint) bool {
+		return blockCompleted(&Submission{}, "", userID, blockID)
+	},
+}
+
+///////////////////////////////////////////////////////
+// ТЕСТ
+///////////////////////////////////////////////////////
+
+const quizDefaultPassScore = 60.0
+
+type quizPayload struct {
+	Title     string   `json:"title,omitempty" schema:"maxlen=300"`
+	PassScore *float64 `json:"pass_score,omitempty" schema:"min=0,max=100"` // нет — quizDefaultPassScore
+}
+
+func (p *quizPayload) validate() error { return nil }
+
+// PassPercent — проходной балл в процентах.
+func (p *quizPayload) PassPercent() float64 {
+	if p.PassScore != nil && *p.PassScore > 0 {
+		return *p.PassScore
+	}
+	return quizDefaultPassScore
+}
+
+var quizBlock = &blockKind{
+	Type:     "quiz",
+	Title:    "Тест",
+	XAPIType: xapiTypeAssessment,
+	Scored:   true,
+	payload:  func() blockPayload { return &quizPayload{} },
+
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &quizPayload{Title: strings.TrimSpace(c.PostForm("payload_title"))}
+		if s := strings.TrimSpace(c.PostForm("payload_pass_score")); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, nil, errors.New("проходной балл — число от 0 до 100")
+			}
+			p.PassScore = &v
+		}
+		return p, nil, nil
+	},
+	load: func(blk *Block, user *User) (err error) {
+		if err := db.Preload("Options").
+			Where("block_id = ?", blk.ID).
+			Order("\"order\" asc").
+			Find(&blk.QuizQuestions).Error; err != nil {
+			return err
+		}
+		if user != nil {
+			blk.LastAttempt, err = lastByUser[QuizAttempt](user.ID, blk.ID)
+		}
+		return err
+	},
+	completed: func(userID, blockID uint) bool {
+		return blockCompleted(&QuizAttempt{}, "passed", userID, blockID)
+	},
+}
+
+///////////////////////////////////////////////////////
+// SCORM
+///////////////////////////////////////////////////////
+
+func (p *scormBlockPayload) validate() error {
+	if scormPackagePath(p.Package) == "" {
+		return errors.New("payload.package: пакет SCORM не найден")
+	}
+	return nil
+}
+
+var scormBlock = &blockKind{
+	Type:     "scorm",
+	Title:    "SCORM",
+	XAPIType: xapiTypeLesson,
+	Scored:   true,
+	payload:  func() blockPayload { return &scormBlockPayload{} },
+
+	parseForm: parseScormBlockForm,
+	load: func(blk *Block, user *User) (err error) {
+		if user != nil {
+			blk.Scorm, err = loadScormAttempt(user.ID, blk.ID)
+		}
+		return err
+	},
+	completed: func(userID, blockID uint) bool {
+		return blockCompleted(&ScormAttempt{}, "completed", userID, blockID)
+	},
+}
+
+// parseScormBlockForm распаковывает новый пакет (если загружен), иначе оставляет прежний.
+func parseScormBlockForm(c *gin.Context, prev blockPayload) (blockPayload, []string, error) {
+	p := prev.(*scormBlockPayload)
+	if fh, err := c.FormFile("scorm_package"); err == nil && fh.Filename != "" {
+		id, info, err := scormImport(fh)
+		if err != nil {
+			return nil, nil, err
+		}
+		p = &scormBlockPayload{
+			Package:            id,
+			Version:            info.Version,
+			Launch:             info.Launch,
+			PackageTitle:       info.Title,
+			OriginalName:       filepath.Base(fh.Filename),
+			MasteryScore:       info.MasteryScore,
+			ScaledPassingScore: info.ScaledPassingScore,
+			CompletionThresh:   info.CompletionThresh,
+			LaunchData:         info.LaunchData,
+		}
+	}
+	if scormPackagePath(p.Package) == "" {
+		return nil, nil, errors.New("загрузите ZIP-пакет SCORM")
+	}
+
+	p.Title = strings.TrimSpace(c.PostForm("payload_title"))
+	if p.Title == "" {
+		p.Title = p.PackageTitle
+	}
+	p.PassScore = nil
+	if s := strings.TrimSpace(c.PostForm("payload_scorm_pass_score")); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > 100 {
+			return nil, nil, errors.New("проходной балл — число от 0 до 100")
+		}
+		p.PassScore = &v
+	}
+	return p, nil, nil
+}
+
+///////////////////////////////////////////////////////
+// ФАЙЛ
+///////////////////////////////////////////////////////
+
+type filePayload struct {
+	Title string `json:"title,omitempty" schema:"maxlen=300"`
+	URL   string `json:"url" schema:"required"`
+	Name  string `json:"name,omitempty" schema:"maxlen=255"` // имя при скачивании
+	Size  int64  `json:"size,omitempty" schema:"min=0"`      // байт; для загруженных на сайт
+	MIME  string `json:"mime,omitempty" schema:"maxlen=100"`
+}
+
+func (p *filePayload) validate() error {
+	if p.URL == "" {
+		return errors.New("payload.url: загрузите файл или укажите его адрес")
+	}
+	return nil
+}
+
+// DisplayName — имя файла для страницы курса.
+func (p *filePayload) DisplayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return path.Base(strings.SplitN(p.URL, "?", 2)[0])
+}
+
+// Kind — тип файла коротко: расширение (PDF, DOCX) или «Файл».
+func (p *filePayload) Kind() string {
+	if ext := strings.TrimPrefix(path.Ext(p.DisplayName()), "."); ext != "" {
+		return strings.ToUpper(ext)
+	}
+	return "Файл"
+}
+
+// Icon — значок bootstrap-icons по типу файла.
+func (p *filePayload) Icon() string {
+	switch strings.ToLower(p.Kind()) {
+	case "pdf":
+		return "bi-file-earmark-pdf"
+	case "doc", "docx", "odt", "rtf":
+		return "bi-file-earmark-word"
+	case "xls", "xlsx", "ods", "csv":
+		return "bi-file-earmark-spreadsheet"
+	case "ppt", "pptx", "odp":
+		return "bi-file-earmark-slides"
+	case "zip", "7z", "rar", "tar", "gz":
+		return "bi-file-earmark-zip"
+	case "png", "jpg", "jpeg", "gif", "webp":
+		return "bi-file-earmark-image"
+	case "mp3":
+		return "bi-file-earmark-music"
+	case "mp4":
+		return "bi-file-earmark-play"
+	case "txt", "md":
+		return "bi-file-earmark-text"
+	}
+	return "bi-file-earmark"
+}
+
+var fileBlock = &blockKind{
+	Type:     "file",
+	Title:    "Файл",
+	XAPIType: xapiTypeFile,
+	payload:  func() blockPayload { return &filePayload{} },
+	urls:     []string{"url"},
+
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &filePayload{
+			Title: strings.TrimSpace(c.PostForm("payload_title")),
+			URL:   strings.TrimSpace(c.PostForm("payload_file_url")),
+			Name:  strings.TrimSpace(c.PostForm("payload_file_name")),
+		}
+		// размер и тип — по файлу на диске, а не из формы
+		if contentFilePath(p.URL) != "" {
+			info, err := describeContent(p.URL)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.Size, p.MIME = info.Size, info.MIME
+			if p.Name == "" {
+				p.Name = uploadStampRe.ReplaceAllString(info.Name, "")
+			}
+		}
+		return p, nil, nil
+	},
+}
+
+///////////////////////////////////////////////////////
+// PDF
+///////////////////////////////////////////////////////
+
+type pdfPayload struct {
+	Title    string `json:"title,omitempty" schema:"maxlen=300"`
+	URL      string `json:"url" schema:"required"`
+	Name     string `json:"name,omitempty" schema:"maxlen=255"`
+	Size     int64  `json:"size,omitempty" schema:"min=0"`
+	Pages    int    `json:"pages,omitempty" schema:"min=0"`
+	PageFrom int    `json:"page_from,omitempty" schema:"min=1"` // показывать страницы с…
+	PageTo   int    `json:"page_to,omitempty" schema:"min=1"`   // …по (включительно)
+}
+
+func (p *pdfPayload) validate() error {
+	switch {
+	case p.URL == "":
+		return errors.New("payload.url: загрузите PDF")
+	case contentFilePath(p.URL) == "":
+		return errors.New("payload.url: PDF показывается только из загруженных на сайт файлов")
+	case p.PageFrom > 0 && p.PageTo > 0 && p.PageFrom > p.PageTo:
+		return errors.New("payload.page_from: начальная страница больше конечной")
+	case p.Pages > 0 && max(p.PageFrom, p.PageTo) > p.Pages:
+		return fmt.Errorf("payload.page_to: в документе %d стр.", p.Pages)
+	}
+	return nil
+}
+
+// First и Last — показываемые страницы; Last = 0 — число страниц неизвестно, показываются все.
+func (p *pdfPayload) First() int { return max(p.PageFrom, 1) }
+
+func (p *pdfPayload) Last() int {
+	if p.PageTo > 0 {
+		return p.PageTo
+	}
+	return p.Pages
+}
+
+// Restricted — автор показывает только часть документа.
+func (p *pdfPayload) Restricted() bool {
+	return p.First() > 1 || (p.PageTo > 0 && p.PageTo < p.Pages)
+}
+
+var pdfBlock = &blockKind{
+	Type:     "pdf",
+	Title:    "PDF",
+	XAPIType: xapiTypeFile,
+	payload:  func() blockPayload { return &pdfPayload{} },
+	urls:     []string{"url"},
+
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &pdfPayload{
+			Title: strings.TrimSpace(c.PostForm("payload_title")),
+			URL:   strings.TrimSpace(c.PostForm("payload_pdf_url")),
+			Name:  strings.TrimSpace(c.PostForm("payload_pdf_name")),
+		}
+		for field, dst := range map[string]*int{"payload_page_from": &p.PageFrom, "payload_page_to": &p.PageTo} {
+			if s := strings.TrimSpace(c.PostForm(field)); s != "" {
+				v, err := strconv.Atoi(s)
+				if err != nil || v < 1 {
+					return nil, nil, errors.New("номер страницы — целое число от 1")
+				}
+				*dst = v
+			}
+		}
+		if contentFilePath(p.URL) != "" {
+			info, err := describeContent(p.URL)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.Size, p.Pages = info.Size, info.Pages
+			if p.Name == "" {
+				p.Name = uploadStampRe.ReplaceAllString(info.Name, "")
+			}
+		}
+		return p, nil, nil
+	},
+}
+
+///////////////////////////////////////////////////////
+// ВНЕШНЯЯ ССЫЛКА
+///////////////////////////////////////////////////////
+
+type linkPayload struct {
+	Title   string       `json:"title,omitempty" schema:"maxlen=300"`
+	URL     string       `json:"url" schema:"required"`
+	Preview *linkPreview `json:"preview,omitempty"` // Open Graph, читается при сохранении (opengraph.go)
+}
+
+func (p *linkPayload) validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("payload.url: нужен полный адрес http(s)://…")
+	}
+	return nil
+}
+
+// Host — сайт ссылки для подписи.
+func (p *linkPayload) Host() string {
+	if u, err := url.Parse(p.URL); err == nil && u.Host != "" {
+		return strings.TrimPrefix(u.Host, "www.")
+	}
+	return p.URL
+}
+
+// Heading — заголовок карточки: из превью, иначе адрес.
+func (p *linkPayload) Heading() string {
+	if p.Preview != nil && p.Preview.Title != "" {
+		return p.Preview.Title
+	}
+	return p.URL
+}
+
+// refreshLinkPreview читает превью, если адрес новый (или просят обновить); иначе берёт прежнее.
+// Сайт недоступен — ссылка сохраняется без превью, причина возвращается предупреждением.
+func refreshLinkPreview(p, prev *linkPayload, force bool) []string {
+	if !force && prev != nil && prev.URL == p.URL && prev.Preview != nil {
+		p.Preview = prev.Preview
+		return nil
+	}
+	if p.validate() != nil {
+		return nil
+	}
+	preview, err := fetchLinkPreview(p.URL)
+	if err != nil {
+		return []string{"превью ссылки: " + err.Error()}
+	}
+	p.Preview = preview
+	return nil
+}
+
+var linkBlock = &blockKind{
+	Type:     "link",
+	Title:    "Ссылка",
+	XAPIType: xapiTypeLink,
+	payload:  func() blockPayload { return &linkPayload{} },
+	urls:     []string{"url"},
+
+	parseForm: func(c *gin.Context, prev blockPayload) (blockPayload, []string, error) {
+		p := &linkPayload{
+			Title: strings.TrimSpace(c.PostForm("payload_title")),
+			URL:   strings.TrimSpace(c.PostForm("payload_link_url")),
+		}
+		return p, refreshLinkPreview(p, prev.(*linkPayload), c.PostForm("payload_link_refresh") == "on"), nil
+	},
+}
+
+///////////////////////////////////////////////////////
+// КАРТОЧКИ
+///////////////////////////////////////////////////////
+
+// flashcard — одна карточка. id не меняется при правке текста: по нему хранится расписание
+// повторений (FlashcardState), поэтому исправленная опечатка не сбрасывает прогресс ученика.
+type flashcard struct {
+	ID         string `json:"id" schema:"required,maxlen=32"`
+	Front      string `json:"front" schema:"required,maxlen=2000"`
+	Back       string `json:"back" schema:"maxlen=5000"`
+	FrontImage string `json:"front_image,omitempty"`
+	BackImage  string `json:"back_image,omitempty"`
+}
+
+type flashcardsPayload struct {
+	Title string      `json:"title,omitempty" schema:"maxlen=300"`
+	Cards []flashcard `json:"cards" schema:"required"`
+}
+
+func (p *flashcardsPayload) validate() error {
+	if len(p.Cards) == 0 {
+		return errors.New("payload.cards: добавьте хотя бы одну карточку")
+	}
+	seen := map[string]bool{}
+	for i, card := range p.Cards {
+		switch {
+		case !blockItemIDRe.MatchString(card.ID):
+			return fmt.Errorf("payload.cards[%d].id: латинские буквы, цифры, _ и -, до 32 символов", i)
+		case seen[card.ID]:
+			return fmt.Errorf("payload.cards[%d].id: повторяется %q", i, card.ID)
+		case strings.TrimSpace(card.Front) == "":
+			return fmt.Errorf("payload.cards[%d].front: пустая лицевая сторона", i)
+		}
+		seen[card.ID] = true
+		// адреса картинок вложены в cards — санитайзер их не видит, проверяем здесь
+		for field, u := range map[string]string{"front_image": card.FrontImage, "back_image": card.BackImage} {
+			if u != "" && !safeContentURL(u) {
+				return fmt.Errorf("payload.cards[%d].%s: адрес %q — допустимы http(s) и пути на сайте", i, field, u)
+			}
+		}
+	}
+	return nil
+}
+
+// Card — карточка по id; nil, если её удалили из колоды.
+func (p *flashcardsPayload) Card(id string) *flashcard {
+	for i := range p.Cards {
+		if p.Cards[i].ID == id {
+			return &p.Cards[i]
+		}
+	}
+	return nil
+}
+
+func (p *flashcardsPayload) cardIDs() []string {
+	ids := make([]string, len(p.Cards))
+	for i, card := range p.Cards {
+		ids[i] = card.ID
+	}
+	return ids
+}
+
+var flashcardsBlock = &blockKind{
+	Type:     "flashcards",
+	Title:    "Карточки",
+	XAPIType: xapiTypeLesson,
+	payload:  func() blockPayload { return &flashcardsPayload{} },
+
+	// строки формы — параллельные списки card_*; пустые строки пропускаются,
+	// у новых карточек id появляется здесь
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &flashcardsPayload{Title: strings.TrimSpace(c.PostForm("payload_title"))}
+		ids, fronts, backs := c.PostFormArray("card_id"), c.PostFormArray("card_front"), c.PostFormArray("card_back")
+		frontImgs, backImgs := c.PostFormArray("card_front_image"), c.PostFormArray("card_back_image")
+		at := func(list []string, i int) string {
+			if i < len(list) {
+				return strings.TrimSpace(list[i])
+			}
+			return ""
+		}
+		for i := range fronts {
+			card := flashcard{
+				ID:         at(ids, i),
+				Front:      at(fronts, i),
+				Back:       at(backs, i),
+				FrontImage: at(frontImgs, i),
+				BackImage:  at(backImgs, i),
+			}
+			if card.Front == "" && card.Back == "" && card.FrontImage == "" && card.BackImage == "" {
+				continue
+			}
+			if card.ID == "" {
+				id, err := newBlockItemID()
+				if err != nil {
+					return nil, nil, err
+				}
+				card.ID = id
+			}
+			p.Cards = append(p.Cards, card)
+		}
+		return p, nil, nil
+	},
+	load: func(blk *Block, user *User) (err error) {
+		if user != nil {
+			blk.Deck, err = flashcardDeckStats(user.ID, blk, time.Now())
+		}
+		return err
+	},
+	// пройден, когда ученик ответил на каждую карточку колоды хотя бы раз
+	completed: func(userID, blockID uint) bool {
+		var blk Block
+		if err := db.Select("id", "payload").First(&blk, blockID).Error; err != nil {
+			return false
+		}
+		var p flashcardsPayload
+		if json.Unmarshal(blk.Payload, &p) != nil || len(p.Cards) == 0 {
+			return false
+		}
+		var cnt int64
+		db.Model(&FlashcardState{}).
+			Where("user_id = ? AND block_id = ? AND card_id IN ?", userID, blockID, p.cardIDs()).
+			Count(&cnt)
+		return int(cnt) == len(p.Cards)
+	},
+}
+
+///////////////////////////////////////////////////////
+// ОПРОС И ГОЛОСОВАНИЕ
+///////////////////////////////////////////////////////
+
+// Опрос (survey) — несколько вопросов, голосование (poll) — один вопрос с вариантами. Оба без оценки;
+// ответы, итоги и выгрузка общие (survey.go): голосование сводится к опросу из одного вопроса.
+
+const surveyDefaultScale = 5
+
+type surveyQuestion struct {
+	ID       string   `json:"id" schema:"required,maxlen=32"`
+	Kind     string   `json:"kind" schema:"required,enum=likert|single|multi|text"`
+	Text     string   `json:"text" schema:"required,maxlen=1000"`
+	Options  []string `json:"options,omitempty"`                     // single, multi
+	Scale    int      `json:"scale,omitempty" schema:"min=2,max=10"` // likert: баллов, нет — surveyDefaultScale
+	MinLabel string   `json:"min_label,omitempty" schema:"maxlen=100"`
+	MaxLabel string   `json:"max_label,omitempty" schema:"maxlen=100"`
+	Required bool     `json:"required,omitempty"`
+}
+
+// Points — баллы шкалы Лайкерта: 1…Scale.
+func (q *surveyQuestion) Points() []int {
+	n := q.Scale
+	if n == 0 {
+		n = surveyDefaultScale
+	}
+	points := make([]int, n)
+	for i := range points {
+		points[i] = i + 1
+	}
+	return points
+}
+
+func (q *surveyQuestion) check(path string) error {
+	switch {
+	case !blockItemIDRe.MatchString(q.ID):
+		return fmt.Errorf("%s.id: латинские буквы, цифры, _ и -, до 32 символов", path)
+	case strings.TrimSpace(q.Text) == "":
+		return fmt.Errorf("%s.text: пустой вопрос", path)
+	case (q.Kind == "single" || q.Kind == "multi") && len(q.Options) < 2:
+		return fmt.Errorf("%s.options: нужно не меньше двух вариантов", path)
+	case q.Kind != "single" && q.Kind != "multi" && len(q.Options) > 0:
+		return fmt.Errorf("%s.options: варианты бывают только у выбора (single, multi)", path)
+	}
+	seen := map[string]bool{}
+	for i, o := range q.Options {
+		if strings.TrimSpace(o) == "" || seen[o] {
+			return fmt.Errorf("%s.options[%d]: пустой или повторяющийся вариант", path, i)
+		}
+		seen[o] = true
+	}
+	return nil
+}
+
+type surveyPayload struct {
+	Title       string           `json:"title,omitempty" schema:"maxlen=300"`
+	Intro       string           `json:"intro,omitempty" schema:"maxlen=2000"`
+	Questions   []surveyQuestion `json:"questions" schema:"required"`
+	Anonymous   bool             `json:"anonymous,omitempty"`    // ответы не связываются с учеником
+	ShowResults bool             `json:"show_results,omitempty"` // ученик видит итоги после ответа
+}
+
+func (p *surveyPayload) validate() error {
+	if len(p.Questions) == 0 {
+		return errors.New("payload.questions: добавьте хотя бы один вопрос")
+	}
+	seen := map[string]bool{}
+	for i := range p.Questions {
+		q := &p.Questions[i]
+		if seen[q.ID] {
+			return fmt.Errorf("payload.questions[%d].id: повторяется %q", i, q.ID)
+		}
+		seen[q.ID] = true
+		if err := q.check(fmt.Sprintf("payload.questions[%d]", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *surveyPayload) survey() *surveyPayload { return p }
+
+type pollPayload struct {
+	Title       string   `json:"title,omitempty" schema:"maxlen=300"`
+	Question    string   `json:"question" schema:"required,maxlen=1000"`
+	Options     []string `json:"options" schema:"required"`
+	Multiple    bool     `json:"multiple,omitempty"` // можно выбрать несколько вариантов
+	Anonymous   bool     `json:"anonymous,omitempty"`
+	ShowResults bool     `json:"show_results,omitempty"`
+}
+
+func (p *pollPayload) validate() error {
+	q := p.survey().Questions[0]
+	if err := q.check("payload"); err != nil {
+		return errors.New(strings.Replace(err.Error(), "payload.text", "payload.question", 1))
+	}
+	return nil
+}
+
+// survey — голосование как опрос из одного вопроса с id "poll".
+func (p *pollPayload) survey() *surveyPayload {
+	kind := "single"
+	if p.Multiple {
+		kind = "multi"
+	}
+	return &surveyPayload{
+		Title:       p.Title,
+		Questions:   []surveyQuestion{{ID: "poll", Kind: kind, Text: p.Question, Options: p.Options, Required: true}},
+		Anonymous:   p.Anonymous,
+		ShowResults: p.ShowResults,
+	}
+}
+
+// surveyBlockPayload — payload опроса или голосования.
+type surveyBlockPayload interface {
+	blockPayload
+	survey() *surveyPayload
+}
+
+// formLines — варианты из textarea: по одному в строке, пустые строки пропускаются.
+func formLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+var surveyBlock = &blockKind{
+	Type:     "survey",
+	Title:    "Опрос",
+	XAPIType: xapiTypeSurvey,
+	payload:  func() blockPayload { return &surveyPayload{} },
+
+	// вопросы — параллельные списки q_*; у новых вопросов id появляется здесь
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		p := &surveyPayload{
+			Title:       strings.TrimSpace(c.PostForm("payload_title")),
+			Intro:       strings.TrimSpace(c.PostForm("payload_survey_intro")),
+			Anonymous:   c.PostForm("payload_survey_anonymous") == "on",
+			ShowResults: c.PostForm("payload_survey_show_results") == "on",
+		}
+		ids, kinds, texts := c.PostFormArray("q_id"), c.PostFormArray("q_kind"), c.PostFormArray("q_text")
+		options, scales := c.PostFormArray("q_options"), c.PostFormArray("q_scale")
+		minLabels, maxLabels, required := c.PostFormArray("q_min_label"), c.PostFormArray("q_max_label"), c.PostFormArray("q_required")
+		at := func(list []string, i int) string {
+			if i < len(list) {
+				return strings.TrimSpace(list[i])
+			}
+			return ""
+		}
+		for i := range texts {
+			q := surveyQuestion{
+				ID:       at(ids, i),
+				Kind:     at(kinds, i),
+				Text:     at(texts, i),
+				Required: at(required, i) == "on",
+			}
+			if q.Text == "" {
+				continue
+			}
+			if q.ID == "" {
+				id, err := newBlockItemID()
+				if err != nil {
+					return nil, nil, err
+				}
+				q.ID = id
+			}
+			switch q.Kind {
+			case "single", "multi":
+				q.Options = formLines(at(options, i))
+			case "likert":
+				if s := at(scales, i); s != "" {
+					v, err := strconv.Atoi(s)
+					if err != nil {
+						return nil, nil, fmt.Errorf("вопрос %d: шкала — целое число от 2 до 10", i+1)
+					}
+					q.Scale = v
+				}
+				q.MinLabel, q.MaxLabel = at(minLabels, i), at(maxLabels, i)
+			}
+			p.Questions = append(p.Questions, q)
+		}
+		return p, nil, nil
+	},
+	load: loadSurveyView,
+}
+
+var pollBlock = &blockKind{
+	Type:     "poll",
+	Title:    "Голосование",
+	XAPIType: xapiTypeSurvey,
+	payload:  func() blockPayload { return &pollPayload{} },
+
+	parseForm: func(c *gin.Context, _ blockPayload) (blockPayload, []string, error) {
+		return &pollPayload{
+			Title:       strings.TrimSpace(c.PostForm("payload_title")),
+			Question:    strings.TrimSpace(c.PostForm("payload_poll_question")),
+			Options:     formLines(c.PostForm("payload_poll_options")),
+			Multiple:    c.PostForm("payload_poll_multiple") == "on",
+			Anonymous:   c.PostForm("payload_poll_anonymous") == "on",
+			ShowResults: c.PostForm("payload_poll_show_results") == "on",
+		}, nil, nil
+	},
+	load: loadSurveyView,
+}
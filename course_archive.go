@@ -0,0 +1,664 @@
+// course_archive.go
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Архив курса: course.json (структура курса, payload блоков как есть, вопросы тестов)
+// + media/<имя> — файлы из static/uploads/content, на которые ссылаются payload,
+// + scorm/<пакет>/... — распакованные SCORM-пакеты. ID в архиве — исходные, при импорте
+// создаются новые, ссылки на файлы и пакеты переписываются.
+
+const (
+	courseArchiveFormat  = "trainbrain-course"
+	courseArchiveVersion = 1
+	courseArchiveMaxJSON = 32 << 20
+
+	courseImportNew       = "new"
+	courseImportOverwrite = "overwrite"
+)
+
+// archiveMediaKinds — media/ архива раздаётся из /static, как загрузки формы блока,
+// поэтому принимаются только их форматы (без HTML, SVG и скриптов — uploadForbiddenExts).
+var archiveMediaKinds = []string{"file", "image", "pdf", "subtitles"}
+
+func archiveMediaAllowed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, k := range archiveMediaKinds {
+		if uploadKinds[k].allows(ext) {
+			return true
+		}
+	}
+	return false
+}
+
+type courseArchive struct {
+	Format       string        `json:"format"`
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Source       string        `json:"source,omitempty"`        // APP_BASE_URL экспортировавшего
+	MissingMedia []string      `json:"missing_media,omitempty"` // упомянуты в payload, но файла нет
+	Course       archiveCourse `json:"course"`
+}
+
+type archiveCourse struct {
+	ID        uint            `json:"id"`
+	Title     string          `json:"title"`
+	ShortDesc string          `json:"short_desc"`
+	Status    string          `json:"status"`
+	Modules   []archiveModule `json:"modules"`
+}
+
+type archiveModule struct {
+	ID     uint           `json:"id"`
+	Title  string         `json:"title"`
+	Order  int            `json:"order"`
+	Blocks []archiveBlock `json:"blocks"`
+}
+
+type archiveBlock struct {
+	ID        uint              `json:"id"`
+	Type      string            `json:"type"`
+	Order     int               `json:"order"`
+	Payload   json.RawMessage   `json:"payload,omitempty"`
+	Questions []archiveQuestion `json:"questions,omitempty"`
+}
+
+type archiveQuestion struct {
+	Text    string          `json:"text"`
+	Order   int             `json:"order"`
+	Options []archiveOption `json:"options"`
+}
+
+type archiveOption struct {
+	Text      string `json:"text"`
+	IsCorrect bool   `json:"is_correct"`
+}
+
+// contentURLPrefix — начало адресов загруженных материалов: /static/uploads/content/
+func contentURLPrefix() string {
+	return "/static/" + strings.Trim(filepath.ToSlash(contentRelPath()), "/") + "/"
+}
+
+// contentRefs — имена файлов из static/uploads/content, упомянутые в строке.
+func contentRefs(s string) []string {
+	re := regexp.MustCompile(regexp.QuoteMeta(contentURLPrefix()) + `([^\s"'()<>?#/\\]+)`)
+	var names []string
+	for _, m := range re.FindAllStringSubmatch(s, -1) {
+		if m[1] != "." && m[1] != ".." {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// mapPayloadStrings применяет f ко всем строкам в разобранном JSON.
+func mapPayloadStrings(v any, f func(string) string) any {
+	switch x := v.(type) {
+	case string:
+		return f(x)
+	case map[string]any:
+		for k, e := range x {
+			x[k] = mapPayloadStrings(e, f)
+		}
+	case []any:
+		for i, e := range x {
+			x[i] = mapPayloadStrings(e, f)
+		}
+	}
+	return v
+}
+
+func loadCourseTree(tx *gorm.DB, id uint) (*Course, error) {
+	var course Course
+	err := tx.Preload("Modules", func(tx *gorm.DB) *gorm.DB { return tx.Order("\"order\", id") }).
+		Preload("Modules.Blocks", func(tx *gorm.DB) *gorm.DB { return tx.Order("\"order\", id") }).
+		Preload("Modules.Blocks.QuizQuestions", func(tx *gorm.DB) *gorm.DB { return tx.Order("\"order\", id") }).
+		Preload("Modules.Blocks.QuizQuestions.Options", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		First(&course, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &course, nil
+}
+
+///////////////////////////////////////////////////////
+// ЭКСПОРТ
+///////////////////////////////////////////////////////
+
+// writeCourseArchive пишет ZIP с курсом, его материалами и SCORM-пакетами.
+func writeCourseArchive(w io.Writer, course *Course) error {
+	arch := courseArchive{
+		Format:     courseArchiveFormat,
+		Version:    courseArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Source:     appBaseURL(),
+		Course: archiveCourse{
+			ID:        course.ID,
+			Title:     course.Title,
+			ShortDesc: course.ShortDesc,
+			Status:    course.Status,
+		},
+	}
+
+	media := map[string]bool{}
+	packages := map[string]bool{}
+	for _, m := range course.Modules {
+		am := archiveModule{ID: m.ID, Title: m.Title, Order: m.Order}
+		for _, b := range m.Blocks {
+			ab := archiveBlock{ID: b.ID, Type: b.Type, Order: b.Order, Payload: json.RawMessage(b.Payload)}
+			for _, name := range contentRefs(string(b.Payload)) {
+				media[name] = true
+			}
+			if b.Type == "scorm" {
+				if pkg := scormPayload(&b).Package; scormPackagePath(pkg) != "" {
+					packages[pkg] = true
+				}
+			}
+			for _, q := range b.QuizQuestions {
+				aq := archiveQuestion{Text: q.Text, Order: q.Order, Options: []archiveOption{}}
+				for _, o := range q.Options {
+					aq.Options = append(aq.Options, archiveOption{Text: o.Text, IsCorrect: o.IsCorrect})
+				}
+				ab.Questions = append(ab.Questions, aq)
+			}
+			am.Blocks = append(am.Blocks, ab)
+		}
+		arch.Course.Modules = append(arch.Course.Modules, am)
+	}
+
+	zw := zip.NewWriter(w)
+	for name := range media {
+		err := zipFile(zw, "media/"+name, filepath.Join("static", contentRelPath(), name))
+		if errors.Is(err, fs.ErrNotExist) {
+			arch.MissingMedia = append(arch.MissingMedia, name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for pkg := range packages {
+		root := scormPackagePath(pkg)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			return zipFile(zw, "scorm/"+pkg+"/"+filepath.ToSlash(rel), p)
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := zipJSON(zw, "course.json", arch); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+///////////////////////////////////////////////////////
+// ИМПОРТ
+///////////////////////////////////////////////////////
+
+type courseImportOptions struct {
+	Mode     string // courseImportNew | courseImportOverwrite
+	TargetID uint   // курс, который перезаписывается
+}
+
+type courseImportResult struct {
+	Course   *Course
+	Modules  int // создано / обновлено
+	Blocks   int
+	Removed  int // блоков удалено при перезаписи (вместе с результатами учеников)
+	Media    int
+	Warnings []string
+}
+
+func readCourseArchive(zr *zip.Reader) (*courseArchive, error) {
+	var manifest *zip.File
+	for _, f := range zr.File {
+		if f.Name == "course.json" {
+			manifest = f
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("в архиве нет course.json — это не экспорт курса TrainBrain")
+	}
+	rc, err := manifest.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var arch courseArchive
+	if err := json.NewDecoder(io.LimitReader(rc, courseArchiveMaxJSON)).Decode(&arch); err != nil {
+		return nil, fmt.Errorf("course.json: %w", err)
+	}
+	if arch.Format != courseArchiveFormat {
+		return nil, errors.New("course.json: неизвестный формат " + arch.Format)
+	}
+	if arch.Version < 1 || arch.Version > courseArchiveVersion {
+		return nil, fmt.Errorf("course.json: версия формата %d не поддерживается (эта версия TrainBrain читает до %d)", arch.Version, courseArchiveVersion)
+	}
+	if strings.TrimSpace(arch.Course.Title) == "" {
+		return nil, errors.New("course.json: у курса нет названия")
+	}
+	for _, m := range arch.Course.Modules {
+		for _, b := range m.Blocks {
+			if blockKinds[b.Type] == nil {
+				return nil, fmt.Errorf("course.json: блок #%d неизвестного типа %q", b.ID, b.Type)
+			}
+			if len(b.Payload) > 0 && !json.Valid(b.Payload) {
+				return nil, fmt.Errorf("course.json: некорректный payload блока #%d", b.ID)
+			}
+		}
+	}
+	return &arch, nil
+}
+
+// courseImporter держит то, что уже записано на диск: при ошибке это удаляется.
+type courseImporter struct {
+	zr       *zip.Reader
+	urls     map[string]string // старый адрес файла → новый
+	packages map[string]string // старый ID SCORM-пакета → новый
+	files    []string
+	dirs     []string
+	obsolete []datatypes.JSON // payload заменённых/удалённых блоков: их пакеты удаляются после коммита
+	res      *courseImportResult
+}
+
+// importCourseArchive создаёт курс из архива или перезаписывает существующий.
+// Перезапись сопоставляет модули и блоки по порядку: блок того же типа обновляется на месте
+// (результаты учеников сохраняются), лишние удаляются.
+func importCourseArchive(zr *zip.Reader, opts courseImportOptions) (*courseImportResult, error) {
+	arch, err := readCourseArchive(zr)
+	if err != nil {
+		return nil, err
+	}
+	im := &courseImporter{
+		zr:       zr,
+		urls:     map[string]string{},
+		packages: map[string]string{},
+		res:      &courseImportResult{},
+	}
+	if len(arch.MissingMedia) > 0 {
+		im.warn("при экспорте не найдены файлы: " + strings.Join(arch.MissingMedia, ", "))
+	}
+
+	err = im.unpackFiles(arch)
+	if err == nil {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			switch opts.Mode {
+			case courseImportNew:
+				return im.createCourse(tx, arch)
+			case courseImportOverwrite:
+				return im.overwriteCourse(tx, arch, opts.TargetID)
+			}
+			return errors.New("неизвестный режим импорта " + opts.Mode)
+		})
+	}
+	if err != nil {
+		for _, f := range im.files {
+			os.Remove(f)
+		}
+		for _, d := range im.dirs {
+			os.RemoveAll(d)
+		}
+		return nil, err
+	}
+	for _, p := range im.obsolete {
+		cleanupScormPackage(p, nil)
+	}
+	return im.res, nil
+}
+
+func (im *courseImporter) warn(msg string) {
+	im.res.Warnings = append(im.res.Warnings, msg)
+}
+
+// unpackFiles копирует media/ в static/uploads/content и scorm/<id>/ в новые каталоги пакетов.
+// Файл с тем же именем и содержимым используется повторно, с другим содержимым — получает новое имя.
+// Файлы недопустимых форматов пропускаются; всё media/ вместе — не больше COURSE_IMPORT_MAX_UNPACKED_MB.
+func (im *courseImporter) unpackFiles(arch *courseArchive) error {
+	dir := filepath.Join("static", contentRelPath())
+	limit := int64(envInt("COURSE_IMPORT_MAX_UNPACKED_MB", 1024)) << 20
+	var total int64
+	for _, f := range im.zr.File {
+		name, ok := strings.CutPrefix(f.Name, "media/")
+		if !ok || name == "" || path.Base(name) != name || name == ".." || !f.Mode().IsRegular() {
+			continue
+		}
+		if !archiveMediaAllowed(name) {
+			im.warn("media/" + name + ": недопустимый формат файла — пропущен")
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if same, err := zipFileEquals(f, target); err != nil {
+			return err
+		} else if same {
+			im.res.Media++
+			continue
+		} else if _, err := os.Stat(target); err == nil {
+			newName := newContentName(name)
+			target = filepath.Join(dir, newName)
+			im.urls[contentURLPrefix()+name] = contentURLPrefix() + newName
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		n, err := extractZipFile(f, target, limit-total)
+		im.files = append(im.files, target)
+		if err != nil {
+			return err
+		}
+		if total += n; total > limit {
+			return fmt.Errorf("файлы media/ больше %d МБ в распакованном виде", limit>>20)
+		}
+		im.res.Media++
+	}
+
+	for _, m := range arch.Course.Modules {
+		for _, b := range m.Blocks {
+			if b.Type != "scorm" {
+				continue
+			}
+			var p scormBlockPayload
+			_ = json.Unmarshal(b.Payload, &p)
+			if scormPackagePath(p.Package) == "" {
+				continue
+			}
+			if _, done := im.packages[p.Package]; done {
+				continue
+			}
+			id, err := newScormPackageID()
+			if err != nil {
+				return err
+			}
+			dest := scormPackagePath(id)
+			im.dirs = append(im.dirs, dest)
+			if err := scormExtract(im.zr.File, "scorm/"+p.Package+"/", dest); err != nil {
+				return err
+			}
+			if _, err := os.Stat(dest); err != nil {
+				im.warn(fmt.Sprintf("в архиве нет SCORM-пакета блока #%d — загрузите его заново", b.ID))
+				continue
+			}
+			im.packages[p.Package] = id
+		}
+	}
+	return nil
+}
+
+func zipFileEquals(f *zip.File, target string) (bool, error) {
+	st, err := os.Stat(target)
+	if err != nil || st.Size() != int64(f.UncompressedSize64) {
+		return false, nil
+	}
+	a, err := os.ReadFile(target)
+	if err != nil {
+		return false, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return false, err
+	}
+	return string(a) == string(b), nil
+}
+
+// extractZipFile распаковывает файл, читая не больше left+1 байт: больше — значит, лимит превышен.
+func extractZipFile(f *zip.File, target string, left int64) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, io.LimitReader(src, left+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// payload переписывает адреса файлов и ID пакетов на новые.
+func (im *courseImporter) payload(b archiveBlock) (datatypes.JSON, error) {
+	if len(b.Payload) == 0 || string(b.Payload) == "null" {
+		return datatypes.JSON("{}"), nil
+	}
+	var v any
+	if err := json.Unmarshal(b.Payload, &v); err != nil {
+		return nil, err
+	}
+	v = mapPayloadStrings(v, func(s string) string {
+		for from, to := range im.urls {
+			s = strings.ReplaceAll(s, from, to)
+		}
+		return s
+	})
+	if m, ok := v.(map[string]any); ok && b.Type == "scorm" {
+		if pkg, _ := m["package"].(string); pkg != "" {
+			m["package"] = im.packages[pkg]
+		}
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// архив мог быть собран старой версией или вручную: приводим payload к схеме типа,
+	// чистим, недопустимые адреса отбрасываем
+	out, dropped, problem, err := canonicalBlockPayload(blockKinds[b.Type], out)
+	if err != nil {
+		return nil, fmt.Errorf("payload блока #%d: %w", b.ID, err)
+	}
+	if len(dropped) > 0 {
+		im.warn(fmt.Sprintf("блок #%d: отброшены неизвестные поля %s", b.ID, strings.Join(dropped, ", ")))
+	}
+	if problem != nil {
+		im.warn(fmt.Sprintf("блок #%d: %v", b.ID, problem))
+	}
+	clean, problems, err := sanitizeBlockPayload(b.Type, out)
+	for _, p := range problems {
+		im.warn(fmt.Sprintf("блок #%d: %s", b.ID, p))
+	}
+	return clean, err
+}
+
+func (im *courseImporter) createCourse(tx *gorm.DB, arch *courseArchive) error {
+	title := strings.TrimSpace(arch.Course.Title)
+	for n := 1; ; n++ {
+		var cnt int64
+		if err := tx.Model(&Course{}).Where("title = ?", title).Count(&cnt).Error; err != nil {
+			return err
+		}
+		if cnt == 0 {
+			break
+		}
+		title = arch.Course.Title + " (импорт)"
+		if n > 1 {
+			title = fmt.Sprintf("%s (импорт %d)", arch.Course.Title, n)
+		}
+	}
+	if title != arch.Course.Title {
+		im.warn("курс с таким названием уже есть — новый назван «" + title + "»")
+	}
+	if arch.Course.Status == "published" {
+		im.warn("курс создан черновиком — опубликуйте его после проверки")
+	}
+
+	course := Course{Title: title, ShortDesc: arch.Course.ShortDesc, Status: "draft"}
+	if err := tx.Create(&course).Error; err != nil {
+		return err
+	}
+	for _, am := range arch.Course.Modules {
+		m := Module{CourseID: course.ID, Title: am.Title, Order: am.Order}
+		if err := tx.Create(&m).Error; err != nil {
+			return err
+		}
+		im.res.Modules++
+		for _, ab := range am.Blocks {
+			if err := im.createBlock(tx, m.ID, ab); err != nil {
+				return err
+			}
+		}
+	}
+	im.res.Course = &course
+	return nil
+}
+
+func (im *courseImporter) createBlock(tx *gorm.DB, moduleID uint, ab archiveBlock) error {
+	payload, err := im.payload(ab)
+	if err != nil {
+		return err
+	}
+	blk := Block{ModuleID: moduleID, Type: ab.Type, Order: ab.Order, Payload: payload}
+	if err := tx.Create(&blk).Error; err != nil {
+		return err
+	}
+	im.res.Blocks++
+	return im.createQuestions(tx, blk.ID, ab.Questions)
+}
+
+func (im *courseImporter) createQuestions(tx *gorm.DB, blockID uint, qs []archiveQuestion) error {
+	for _, aq := range qs {
+		q := QuizQuestion{BlockID: blockID, Text: aq.Text, Order: aq.Order}
+		for _, o := range aq.Options {
+			q.Options = append(q.Options, QuizOption{Text: o.Text, IsCorrect: o.IsCorrect})
+		}
+		if err := tx.Create(&q).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *courseImporter) overwriteCourse(tx *gorm.DB, arch *courseArchive, targetID uint) error {
+	course, err := loadCourseTree(tx, targetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.New("курс для перезаписи не найден")
+	}
+	if err != nil {
+		return err
+	}
+	course.Title = strings.TrimSpace(arch.Course.Title)
+	course.ShortDesc = arch.Course.ShortDesc
+	if err := tx.Model(&Course{ID: course.ID}).Updates(map[string]any{
+		"title":      course.Title,
+		"short_desc": course.ShortDesc,
+	}).Error; err != nil {
+		return err
+	}
+
+	for i := 0; i < len(arch.Course.Modules) || i < len(course.Modules); i++ {
+		switch {
+		case i >= len(course.Modules):
+			am := arch.Course.Modules[i]
+			m := Module{CourseID: course.ID, Title: am.Title, Order: am.Order}
+			if err := tx.Create(&m).Error; err != nil {
+				return err
+			}
+			for _, ab := range am.Blocks {
+				if err := im.createBlock(tx, m.ID, ab); err != nil {
+					return err
+				}
+			}
+		case i >= len(arch.Course.Modules):
+			m := course.Modules[i]
+			for _, b := range m.Blocks {
+				im.obsolete = append(im.obsolete, b.Payload)
+			}
+			if err := tx.Delete(&m).Error; err != nil {
+				return err
+			}
+			im.res.Removed += len(m.Blocks)
+			continue
+		default:
+			if err := im.overwriteModule(tx, &course.Modules[i], arch.Course.Modules[i]); err != nil {
+				return err
+			}
+		}
+		im.res.Modules++
+	}
+	im.res.Course = course
+	if im.res.Removed > 0 {
+		im.warn(fmt.Sprintf("удалено блоков, которых нет в архиве: %d (вместе с результатами учеников)", im.res.Removed))
+	}
+	return nil
+}
+
+func (im *courseImporter) overwriteModule(tx *gorm.DB, m *Module, am archiveModule) error {
+	if err := tx.Model(m).Updates(map[string]any{"title": am.Title, "order": am.Order}).Error; err != nil {
+		return err
+	}
+	for i := 0; i < len(am.Blocks) || i < len(m.Blocks); i++ {
+		if i >= len(am.Blocks) {
+			b := m.Blocks[i]
+			im.obsolete = append(im.obsolete, b.Payload)
+			if err := tx.Delete(&b).Error; err != nil {
+				return err
+			}
+			im.res.Removed++
+			continue
+		}
+		ab := am.Blocks[i]
+		if i >= len(m.Blocks) || m.Blocks[i].Type != ab.Type {
+			if i < len(m.Blocks) {
+				b := m.Blocks[i]
+				im.obsolete = append(im.obsolete, b.Payload)
+				if err := tx.Delete(&b).Error; err != nil {
+					return err
+				}
+				im.res.Removed++
+			}
+			if err := im.createBlock(tx, m.ID, ab); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b := m.Blocks[i]
+		payload, err := im.payload(ab)
+		if err != nil {
+			return err
+		}
+		im.obsolete = append(im.obsolete, b.Payload)
+		if err := tx.Model(&b).Updates(map[string]any{"order": ab.Order, "payload": payload}).Error; err != nil {
+			return err
+		}
+		if b.Type == "quiz" {
+			if err := tx.Where("block_id = ?", b.ID).Delete(&QuizQuestion{}).Error; err != nil {
+				return err
+			}
+			if err := im.createQuestions(tx, b.ID, ab.Questions); err != nil {
+				return err
+			}
+		}
+		im.res.Blocks++
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// course_archive_test.go
+package main
+
+import "testing"
+
+// media/ архива раздаётся с нашего домена: только форматы загрузок формы блока.
+func TestArchiveMediaAllowed(t *testing.T) {
+	cases := map[string]bool{
+		"lecture.PDF":  true,
+		"photo.jpg":    true,
+		"slides.pptx":  true,
+		"captions.vtt": true,
+		"index.html":   false,
+		"logo.svg":     false,
+		"app.js":       false,
+		"page.xhtml":   false,
+		"noext":        false,
+	}
+	for name, want := range cases {
+		if got := archiveMediaAllowed(name); got != want {
+			t.Errorf("archiveMediaAllowed(%q) = %v, ожидается %v", name, got, want)
+		}
+	}
+	// UPLOAD_FILE_EXTS не открывает запрещённое
+	t.Setenv("UPLOAD_FILE_EXTS", ".pdf .html .svg")
+	for _, name := range []string{"index.html", "logo.svg"} {
+		if archiveMediaAllowed(name) {
+			t.Errorf("archiveMediaAllowed(%q) с UPLOAD_FILE_EXTS: файл принят", name)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// course_clone.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Копия курса: модули, блоки, вопросы и варианты тестов создаются заново, результаты
+// учеников не копируются. SCORM-пакет копируется всегда — он принадлежит блоку и удаляется
+// вместе с ним; файлы из static/uploads/content — по выбору (иначе копия ссылается на те же).
+
+type courseCloneOptions struct {
+	Title      string // пусто — «<название> (копия)»
+	ShortDesc  string // пусто — как у исходного курса
+	Status     string // пусто — черновик
+	CopyMedia  bool   // скопировать загруженные файлы под новыми именами
+	ResetDates bool   // даты создания — текущие; иначе переносятся из исходного курса
+}
+
+type courseCloneResult struct {
+	Course   *Course
+	Modules  int
+	Blocks   int
+	Media    int
+	Warnings []string
+}
+
+var uploadStampRe = regexp.MustCompile(`^\d{14}\.\d{6}_`)
+
+// newContentName — имя для файла в static/uploads/content: метка времени + исходное имя
+// (прежняя метка, если была, отбрасывается).
+func newContentName(name string) string {
+	return time.Now().UTC().Format("20060102150405.000000") + "_" + uploadStampRe.ReplaceAllString(name, "")
+}
+
+// courseCloner держит скопированные файлы и каталоги: при ошибке они удаляются.
+type courseCloner struct {
+	opts     courseCloneOptions
+	urls     map[string]string // адрес файла → адрес копии
+	packages map[string]string // ID SCORM-пакета → ID копии
+	files    []string
+	dirs     []string
+	res      *courseCloneResult
+}
+
+// cloneCourse делает полную копию курса srcID.
+func cloneCourse(srcID uint, opts courseCloneOptions) (*courseCloneResult, error) {
+	src, err := loadCourseTree(db, srcID)
+	if err != nil {
+		return nil, err
+	}
+	cl := &courseCloner{
+		opts:     opts,
+		urls:     map[string]string{},
+		packages: map[string]string{},
+		res:      &courseCloneResult{},
+	}
+
+	err = cl.copyFiles(src)
+	if err == nil {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return cl.createCourse(tx, src)
+		})
+	}
+	if err != nil {
+		for _, f := range cl.files {
+			os.Remove(f)
+		}
+		for _, d := range cl.dirs {
+			os.RemoveAll(d)
+		}
+		return nil, err
+	}
+	return cl.res, nil
+}
+
+func (cl *courseCloner) warn(msg string) {
+	cl.res.Warnings = append(cl.res.Warnings, msg)
+}
+
+func (cl *courseCloner) copyFiles(src *Course) error {
+	dir := filepath.Join("static", contentRelPath())
+	var missing []string
+	for _, m := range src.Modules {
+		for _, b := range m.Blocks {
+			if cl.opts.CopyMedia {
+				for _, name := range contentRefs(string(b.Payload)) {
+					from := contentURLPrefix() + name
+					if _, done := cl.urls[from]; done {
+						continue
+					}
+					newName := newContentName(name)
+					err := copyFile(filepath.Join(dir, name), filepath.Join(dir, newName))
+					if errors.Is(err, fs.ErrNotExist) {
+						cl.urls[from] = from
+						missing = append(missing, name)
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					cl.files = append(cl.files, filepath.Join(dir, newName))
+					cl.urls[from] = contentURLPrefix() + newName
+					cl.res.Media++
+				}
+			}
+
+			if b.Type != "scorm" {
+				continue
+			}
+			pkg := scormPayload(&b).Package
+			if scormPackagePath(pkg) == "" {
+				continue
+			}
+			if _, done := cl.packages[pkg]; done {
+				continue
+			}
+			id, err := newScormPackageID()
+			if err != nil {
+				return err
+			}
+			dest := scormPackagePath(id)
+			cl.dirs = append(cl.dirs, dest)
+			err = copyDir(scormPackagePath(pkg), dest)
+			if errors.Is(err, fs.ErrNotExist) {
+				cl.warn(fmt.Sprintf("не найден SCORM-пакет блока #%d — загрузите его в копии заново", b.ID))
+				cl.packages[pkg] = ""
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			cl.packages[pkg] = id
+		}
+	}
+	if len(missing) > 0 {
+		cl.warn("не найдены файлы (ссылки оставлены как есть): " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// payload переписывает адреса скопированных файлов и ID пакетов.
+func (cl *courseCloner) payload(b *Block) (datatypes.JSON, error) {
+	if len(b.Payload) == 0 {
+		return b.Payload, nil
+	}
+	var v any
+	if err := json.Unmarshal(b.Payload, &v); err != nil {
+		return nil, fmt.Errorf("блок #%d: некорректный payload: %w", b.ID, err)
+	}
+	v = mapPayloadStrings(v, func(s string) string {
+		for from, to := range cl.urls {
+			s = strings.ReplaceAll(s, from, to)
+		}
+		return s
+	})
+	if m, ok := v.(map[string]any); ok && b.Type == "scorm" {
+		if pkg, _ := m["package"].(string); pkg != "" {
+			m["package"] = cl.packages[pkg]
+		}
+	}
+	out, err := json.Marshal(v)
+	return datatypes.JSON(out), err
+}
+
+// stamp возвращает даты для копии: нулевые (GORM поставит текущие) или исходные.
+func (cl *courseCloner) stamp(created, updated time.Time) (time.Time, time.Time) {
+	if cl.opts.ResetDates {
+		return time.Time{}, time.Time{}
+	}
+	return created, updated
+}
+
+func (cl *courseCloner) createCourse(tx *gorm.DB, src *Course) error {
+	course := Course{
+		Title:     strings.TrimSpace(cl.opts.Title),
+		ShortDesc: strings.TrimSpace(cl.opts.ShortDesc),
+		Status:    cl.opts.Status,
+	}
+	if course.Title == "" {
+		course.Title = src.Title + " (копия)"
+	}
+	if course.ShortDesc == "" {
+		course.ShortDesc = src.ShortDesc
+	}
+	if course.Status == "" {
+		course.Status = "draft"
+	}
+	course.CreatedAt, course.UpdatedAt = cl.stamp(src.CreatedAt, src.UpdatedAt)
+	if err := tx.Omit(clause.Associations).Create(&course).Error; err != nil {
+		return err
+	}
+
+	for _, sm := range src.Modules {
+		m := Module{CourseID: course.ID, Title: sm.Title, Order: sm.Order}
+		m.CreatedAt, m.UpdatedAt = cl.stamp(sm.CreatedAt, sm.UpdatedAt)
+		if err := tx.Omit(clause.Associations).Create(&m).Error; err != nil {
+			return err
+		}
+		cl.res.Modules++
+
+		for _, sb := range sm.Blocks {
+			payload, err := cl.payload(&sb)
+			if err != nil {
+				return err
+			}
+			b := Block{ModuleID: m.ID, Type: sb.Type, Order: sb.Order, Payload: payload}
+			b.CreatedAt, b.UpdatedAt = cl.stamp(sb.CreatedAt, sb.UpdatedAt)
+			if err := tx.Omit(clause.Associations).Create(&b).Error; err != nil {
+				return err
+			}
+			cl.res.Blocks++
+
+			for _, sq := range sb.QuizQuestions {
+				q := QuizQuestion{BlockID: b.ID, Text: sq.Text, Order: sq.Order}
+				q.CreatedAt, _ = cl.stamp(sq.CreatedAt, time.Time{})
+				if err := tx.Omit(clause.Associations).Create(&q).Error; err != nil {
+					return err
+				}
+				for _, so := range sq.Options {
+					o := QuizOption{QuestionID: q.ID, Text: so.Text, IsCorrect: so.IsCorrect}
+					o.CreatedAt, _ = cl.stamp(so.CreatedAt, time.Time{})
+					if err := tx.Omit(clause.Associations).Create(&o).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	cl.res.Course = &course
+	return nil
+}
+
+// copyFile копирует обычный файл; если dst уже есть — ошибка.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyDir копирует дерево обычных файлов (ссылки пропускаются).
+func copyDir(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		return copyFile(p, filepath.Join(dst, rel))
+	})
+}
@@ -0,0 +1,89 @@
+// course_search.go
+package main
+
+import (
+	"html/template"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Поиск по курсам (/courses?q=): полнотекстовый поиск Postgres по названию и описанию курса
+// и по тексту блоков — названию, тексту, условию задания и расшифровке видео (payload.transcript,
+// video_captions.go). Запрос — в синтаксисе websearch_to_tsquery: слова, "фраза", -исключить, or.
+// Текст блока индексируется выражением blockSearchDoc (GIN-индекс idx_blocks_search).
+
+const (
+	searchQueryMaxLen   = 200
+	searchHitsPerCourse = 3
+
+	blockSearchText = `coalesce(payload->>'title', '') || ' ' || coalesce(payload->>'text', '') || ' ' || ` +
+		`coalesce(payload->>'prompt', '') || ' ' || coalesce(payload->>'transcript', '')`
+	blockSearchDoc  = `to_tsvector('russian', ` + blockSearchText + `)`
+	courseSearchDoc = `to_tsvector('russian', courses.title || ' ' || coalesce(courses.short_desc, ''))`
+
+	// границы найденных слов в отрывке (ts_headline): управляющие символы в тексте не встречаются,
+	// в HTML они становятся <mark>
+	searchMarkStart = "\x02"
+	searchMarkStop  = "\x03"
+)
+
+// courseSearchHit — блок, в котором нашёлся запрос, с отрывком текста.
+type courseSearchHit struct {
+	CourseID uint
+	BlockID  uint
+	Type     string
+	Title    string
+	Snippet  string
+}
+
+// SnippetHTML — отрывок с подсвеченными словами; остальной текст экранируется.
+func (h courseSearchHit) SnippetHTML() template.HTML {
+	s := template.HTMLEscapeString(h.Snippet)
+	s = strings.ReplaceAll(s, template.HTMLEscapeString(searchMarkStart), "<mark>")
+	s = strings.ReplaceAll(s, template.HTMLEscapeString(searchMarkStop), "</mark>")
+	return template.HTML(s)
+}
+
+// searchCourses — курсы, в названии, описании или блоках которых есть запрос, и найденные блоки
+// по курсам (не больше searchHitsPerCourse на курс, сначала самые подходящие).
+func searchCourses(q string) ([]Course, map[uint][]courseSearchHit, error) {
+	var hits []courseSearchHit
+	err := db.Raw(`SELECT course_id, block_id, type, title, snippet FROM (
+			SELECT modules.course_id, blocks.id AS block_id, blocks.type,
+				coalesce(blocks.payload->>'title', '') AS title,
+				ts_headline('russian', `+blockSearchText+`, query, ?) AS snippet,
+				row_number() OVER (PARTITION BY modules.course_id
+					ORDER BY ts_rank(`+blockSearchDoc+`, query) DESC, modules."order", blocks."order") AS n
+			FROM blocks
+			JOIN modules ON modules.id = blocks.module_id,
+				websearch_to_tsquery('russian', ?) AS query
+			WHERE `+blockSearchDoc+` @@ query
+		) ranked WHERE n <= ? ORDER BY course_id, n`,
+		`StartSel="`+searchMarkStart+`", StopSel="`+searchMarkStop+`", MaxWords=30, MinWords=12, MaxFragments=2`,
+		q, searchHitsPerCourse).Scan(&hits).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	byCourse := map[uint][]courseSearchHit{}
+	var ids []uint
+	for _, h := range hits {
+		if byCourse[h.CourseID] == nil {
+			ids = append(ids, h.CourseID)
+		}
+		byCourse[h.CourseID] = append(byCourse[h.CourseID], h)
+	}
+
+	var courses []Course
+	err = db.Preload("Modules").
+		Where(courseSearchDoc+" @@ websearch_to_tsquery('russian', ?) OR id IN ?", q, ids).
+		Order("created_at desc").
+		Find(&courses).Error
+	return courses, byCourse, err
+}
+
+// migrateBlockSearchIndex — GIN-индекс по тексту блоков. Выражение должно совпадать с blockSearchDoc,
+// иначе планировщик индекс не возьмёт.
+func migrateBlockSearchIndex(tx *gorm.DB) error {
+	return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_blocks_search ON blocks USING gin (` + blockSearchDoc + `)`).Error
+}
@@ -0,0 +1,1145 @@
+// course_sync.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Каталог курса — курс в виде файлов, удобных для Git и ревью:
+//
+//	my-course/
+//	  course.yaml            название, описание, статус, slug
+//	  01-intro/
+//	    module.yaml          название модуля (папка без module.yaml — не модуль, например картинки)
+//	    01-welcome.md        текстовый блок: YAML-шапка между "---" + Markdown
+//	    02-video.yaml        видео
+//	    03-task.md           задание (type: assignment в шапке)
+//	    04-check.yaml        тест с вопросами
+//
+// Порядок модулей и блоков — по именам папок и файлов. slug (имя без числового префикса
+// или поле slug) связывает файл с записью в БД: повторная синхронизация обновляет курс,
+// а не создаёт копию. Курс ищется по slug, модули и блоки — по slug внутри курса.
+
+const (
+	courseSyncCourseFile = "course.yaml"
+	courseSyncModuleFile = "module.yaml"
+)
+
+var (
+	syncOrderPrefixRe = regexp.MustCompile(`^\d+[-_. ]*`)
+	syncSlugRe        = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_.-]{0,127}$`)
+	mdImageRe         = regexp.MustCompile(`(!\[[^\]]*\]\()([^)\s]+)`)
+	errSyncDryRun     = errors.New("dry run")
+)
+
+type syncCourseFile struct {
+	Slug       string `yaml:"slug"`
+	Title      string `yaml:"title"`
+	ShortDesc  string `yaml:"short_desc"`
+	Status     string `yaml:"status"`      // пусто — при создании draft, дальше не меняется
+	IsTemplate *bool  `yaml:"is_template"` // не задано — не меняется
+}
+
+type syncModuleFile struct {
+	Slug  string `yaml:"slug"`
+	Title string `yaml:"title"`
+}
+
+type syncBlockFile struct {
+	Slug      string             `yaml:"slug"`
+	Type      string             `yaml:"type"` // для .md по умолчанию text
+	Title     string             `yaml:"title"`
+	Text      string             `yaml:"text"`       // text — текст, assignment — условие; в .md — тело файла
+	Image     string             `yaml:"image"`      // text: картинка (URL или путь к файлу рядом)
+	URL       string             `yaml:"url"`        // video: ссылка для встраивания; link: адрес
+	Src       string             `yaml:"src"`        // video: mp4; file, pdf: файл (URL или путь к файлу рядом)
+	Name      string             `yaml:"name"`       // file, pdf: имя при скачивании
+	PageFrom  int                `yaml:"page_from"`  // pdf
+	PageTo    int                `yaml:"page_to"`    // pdf
+	PassScore *int               `yaml:"pass_score"` // quiz
+	Questions []syncQuestionFile `yaml:"questions"`  // quiz
+	Cards     []syncCardFile     `yaml:"cards"`      // flashcards
+
+	Intro       string               `yaml:"intro"`        // survey
+	Items       []syncSurveyQuestion `yaml:"items"`        // survey: вопросы
+	Question    string               `yaml:"question"`     // poll
+	Options     []string             `yaml:"options"`      // poll
+	Multiple    bool                 `yaml:"multiple"`     // poll
+	Anonymous   bool                 `yaml:"anonymous"`    // survey, poll
+	ShowResults bool                 `yaml:"show_results"` // survey, poll
+
+	PeerReview *syncPeerReview   `yaml:"peer_review"`      // assignment: взаимное рецензирование
+	Complete   int               `yaml:"complete_percent"` // video (src): пройдено при просмотре от, %
+	Tracks     []syncTrackFile   `yaml:"tracks"`           // video (src): субтитры
+	Chapters   []syncChapterFile `yaml:"chapters"`         // video (src): главы
+}
+
+// syncTrackFile — дорожка субтитров: файл .vtt или .srt рядом с блоком (SRT переводится в VTT).
+type syncTrackFile struct {
+	Lang    string `yaml:"lang"`
+	Label   string `yaml:"label"` // по умолчанию — lang
+	Src     string `yaml:"src"`
+	Default bool   `yaml:"default"`
+}
+
+// syncChapterFile — глава: at — м:сс, ч:мм:сс или секунды.
+type syncChapterFile struct {
+	At    string `yaml:"at"`
+	Title string `yaml:"title"`
+}
+
+// syncPeerReview — сроки (YAML-дата со временем и поясом, например 2025-03-01T23:59:00+03:00),
+// число рецензентов и рубрика; id критерия по умолчанию — хеш названия.
+type syncPeerReview struct {
+	Deadline       time.Time `yaml:"deadline"`
+	ReviewDeadline time.Time `yaml:"review_deadline"`
+	Reviewers      int       `yaml:"reviewers"`
+	Rubric         []struct {
+		ID          string `yaml:"id"`
+		Title       string `yaml:"title"`
+		Description string `yaml:"description"`
+		Points      int    `yaml:"points"`
+	} `yaml:"rubric"`
+}
+
+// syncSurveyQuestion — вопрос опроса; id, как у карточки, по умолчанию — хеш текста.
+type syncSurveyQuestion struct {
+	ID       string   `yaml:"id"`
+	Kind     string   `yaml:"kind"` // likert, single, multi, text
+	Text     string   `yaml:"text"`
+	Options  []string `yaml:"options"`
+	Scale    int      `yaml:"scale"`
+	MinLabel string   `yaml:"min_label"`
+	MaxLabel string   `yaml:"max_label"`
+	Required bool     `yaml:"required"`
+}
+
+// syncCardFile — карточка; без id он берётся из хеша лицевой стороны (правка вопроса
+// тогда начинает карточку заново — чтобы сохранить расписание учеников, задайте id).
+type syncCardFile struct {
+	ID         string `yaml:"id"`
+	Front      string `yaml:"front"`
+	Back       string `yaml:"back"`
+	FrontImage string `yaml:"front_image"`
+	BackImage  string `yaml:"back_image"`
+}
+
+type syncQuestionFile struct {
+	Text    string           `yaml:"text"`
+	Options []syncOptionFile `yaml:"options"`
+}
+
+type syncOptionFile struct {
+	Text    string `yaml:"text"`
+	Correct bool   `yaml:"correct"`
+}
+
+// разобранный каталог
+type syncCourse struct {
+	syncCourseFile
+	Modules []syncModule
+}
+
+type syncModule struct {
+	Slug   string
+	Title  string
+	Dir    string
+	Blocks []syncBlock
+}
+
+type syncBlock struct {
+	Slug      string
+	Type      string
+	File      string // путь относительно каталога курса — для сообщений
+	Payload   map[string]any
+	Questions []syncQuestionFile
+}
+
+///////////////////////////////////////////////////////
+// ЧТЕНИЕ КАТАЛОГА
+///////////////////////////////////////////////////////
+
+// syncSlug — slug из имени файла или папки: без расширения и числового префикса.
+func syncSlug(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if s := syncOrderPrefixRe.ReplaceAllString(name, ""); s != "" {
+		name = s
+	}
+	return strings.ToLower(name)
+}
+
+func decodeYAMLStrict(data []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// splitFrontMatter отделяет YAML-шапку ("---" ... "---") от тела Markdown.
+func splitFrontMatter(data []byte) (head, body []byte) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(s, "---\n") {
+		return nil, []byte(s)
+	}
+	rest := s[4:]
+	end := strings.Index(rest, "\n---\n")
+	if end < 0 {
+		if strings.HasSuffix(rest, "\n---") {
+			return []byte(rest[:len(rest)-4]), nil
+		}
+		return nil, []byte(s)
+	}
+	return []byte(rest[:end]), []byte(strings.TrimLeft(rest[end+5:], "\n"))
+}
+
+// loadCourseDir читает и проверяет каталог курса. Файлы рядом с блоками (картинки, видео)
+// копируются в static/uploads/content через media.
+func loadCourseDir(root string, media *syncMedia) (*syncCourse, error) {
+	data, err := os.ReadFile(filepath.Join(root, courseSyncCourseFile))
+	if err != nil {
+		return nil, err
+	}
+	sc := &syncCourse{}
+	if err := decodeYAMLStrict(data, &sc.syncCourseFile); err != nil {
+		return nil, fmt.Errorf("%s: %w", courseSyncCourseFile, err)
+	}
+	if sc.Slug == "" {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		sc.Slug = syncSlug(filepath.Base(abs))
+	}
+	if !syncSlugRe.MatchString(sc.Slug) {
+		return nil, fmt.Errorf("%s: недопустимый slug %q", courseSyncCourseFile, sc.Slug)
+	}
+	sc.Title = strings.TrimSpace(sc.Title)
+	if sc.Title == "" {
+		return nil, fmt.Errorf("%s: не задано название (title)", courseSyncCourseFile)
+	}
+	switch sc.Status {
+	case "", "draft", "published":
+	default:
+		return nil, fmt.Errorf("%s: статус %q — допустимы draft и published", courseSyncCourseFile, sc.Status)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	moduleSlugs := map[string]string{}
+	blockSlugs := map[string]string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, courseSyncModuleFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var mf syncModuleFile
+		if err := decodeYAMLStrict(data, &mf); err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", e.Name(), courseSyncModuleFile, err)
+		}
+		m := syncModule{Slug: mf.Slug, Title: strings.TrimSpace(mf.Title), Dir: e.Name()}
+		if m.Slug == "" {
+			m.Slug = syncSlug(e.Name())
+		}
+		if !syncSlugRe.MatchString(m.Slug) {
+			return nil, fmt.Errorf("%s: недопустимый slug модуля %q", e.Name(), m.Slug)
+		}
+		if prev, dup := moduleSlugs[m.Slug]; dup {
+			return nil, fmt.Errorf("%s: slug модуля %q уже занят папкой %s", e.Name(), m.Slug, prev)
+		}
+		moduleSlugs[m.Slug] = e.Name()
+		if m.Title == "" {
+			return nil, fmt.Errorf("%s/%s: не задано название (title)", e.Name(), courseSyncModuleFile)
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			ext := strings.ToLower(filepath.Ext(f.Name()))
+			if f.IsDir() || f.Name() == courseSyncModuleFile || (ext != ".md" && ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			rel := filepath.ToSlash(filepath.Join(e.Name(), f.Name()))
+			b, err := loadSyncBlock(root, rel, media)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rel, err)
+			}
+			if prev, dup := blockSlugs[b.Slug]; dup {
+				return nil, fmt.Errorf("%s: slug блока %q уже занят файлом %s (slug уникален в курсе)", rel, b.Slug, prev)
+			}
+			blockSlugs[b.Slug] = rel
+			m.Blocks = append(m.Blocks, *b)
+		}
+		sc.Modules = append(sc.Modules, m)
+	}
+	return sc, nil
+}
+
+func loadSyncBlock(root, rel string, media *syncMedia) (*syncBlock, error) {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	var bf syncBlockFile
+	isMD := strings.EqualFold(filepath.Ext(rel), ".md")
+	if isMD {
+		head, body := splitFrontMatter(data)
+		if err := decodeYAMLStrict(head, &bf); err != nil {
+			return nil, err
+		}
+		if bf.Type == "" {
+			bf.Type = "text"
+		}
+		if strings.TrimSpace(bf.Text) != "" {
+			return nil, errors.New("в .md текст пишется после шапки, поле text не используется")
+		}
+		bf.Text = string(body)
+	} else if err := decodeYAMLStrict(data, &bf); err != nil {
+		return nil, err
+	}
+
+	b := &syncBlock{Slug: bf.Slug, Type: bf.Type, File: rel, Payload: map[string]any{}}
+	if b.Slug == "" {
+		b.Slug = syncSlug(filepath.Base(rel))
+	}
+	if !syncSlugRe.MatchString(b.Slug) {
+		return nil, fmt.Errorf("недопустимый slug %q", b.Slug)
+	}
+	if t := strings.TrimSpace(bf.Title); t != "" {
+		b.Payload["title"] = t
+	}
+	dir := filepath.Dir(rel)
+
+	// те же поля, что в payload типа (blocks.go)
+	switch bf.Type {
+	case "text":
+		text, err := media.rewriteMarkdown(root, dir, bf.Text)
+		if err != nil {
+			return nil, err
+		}
+		b.Payload["text"] = text
+		if bf.Image != "" {
+			u, err := media.url(root, dir, bf.Image)
+			if err != nil {
+				return nil, err
+			}
+			b.Payload["image_url"] = u
+		}
+	case "assignment":
+		text, err := media.rewriteMarkdown(root, dir, bf.Text)
+		if err != nil {
+			return nil, err
+		}
+		b.Payload["prompt"] = text
+		if pr := bf.PeerReview; pr != nil {
+			if pr.Deadline.IsZero() || pr.ReviewDeadline.IsZero() {
+				return nil, errors.New("peer_review: задайте deadline и review_deadline")
+			}
+			var rubric []map[string]any
+			for _, rc := range pr.Rubric {
+				id := rc.ID
+				if id == "" {
+					sum := sha256.Sum256([]byte(strings.TrimSpace(rc.Title)))
+					id = hex.EncodeToString(sum[:6])
+				}
+				rubric = append(rubric, map[string]any{
+					"id": id, "title": strings.TrimSpace(rc.Title),
+					"description": strings.TrimSpace(rc.Description), "points": rc.Points,
+				})
+			}
+			b.Payload["peer_review"] = map[string]any{
+				"deadline":        pr.Deadline.Format(time.RFC3339),
+				"review_deadline": pr.ReviewDeadline.Format(time.RFC3339),
+				"reviewers":       pr.Reviewers,
+				"rubric":          rubric,
+			}
+		}
+	case "video":
+		switch {
+		case bf.URL != "" && bf.Src != "":
+			return nil, errors.New("у видео задаётся url (встраивание) или src (файл), не оба")
+		case bf.URL != "":
+			if bf.Complete != 0 || len(bf.Tracks) > 0 || len(bf.Chapters) > 0 {
+				return nil, errors.New("complete_percent, tracks и chapters бывают только у видео-файла (src)")
+			}
+			b.Payload["mode"] = "embed"
+			b.Payload["url"] = bf.URL
+		case bf.Src != "":
+			u, err := media.url(root, dir, bf.Src)
+			if err != nil {
+				return nil, err
+			}
+			b.Payload["mode"] = "file"
+			b.Payload["src"] = u
+			if bf.Complete != 0 {
+				b.Payload["complete_percent"] = bf.Complete
+			}
+			if err := syncVideoCaptions(b, &bf, media, root, dir); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("у видео не задан url или src")
+		}
+	case "quiz":
+		if bf.PassScore != nil {
+			if *bf.PassScore < 0 || *bf.PassScore > 100 {
+				return nil, errors.New("pass_score — число от 0 до 100")
+			}
+			b.Payload["pass_score"] = *bf.PassScore
+		}
+		if len(bf.Questions) == 0 {
+			return nil, errors.New("в тесте нет вопросов (questions)")
+		}
+		for i, q := range bf.Questions {
+			if strings.TrimSpace(q.Text) == "" {
+				return nil, fmt.Errorf("вопрос %d: пустой текст", i+1)
+			}
+			if problems := mathTextProblems(q.Text); len(problems) > 0 {
+				return nil, fmt.Errorf("вопрос %d: %s", i+1, strings.Join(problems, "; "))
+			}
+			correct := 0
+			for j, o := range q.Options {
+				if strings.TrimSpace(o.Text) == "" {
+					return nil, fmt.Errorf("вопрос %d, вариант %d: пустой текст", i+1, j+1)
+				}
+				if problems := mathTextProblems(o.Text); len(problems) > 0 {
+					return nil, fmt.Errorf("вопрос %d, вариант %d: %s", i+1, j+1, strings.Join(problems, "; "))
+				}
+				if o.Correct {
+					correct++
+				}
+			}
+			if len(q.Options) < 2 || correct == 0 {
+				return nil, fmt.Errorf("вопрос %d: нужно не меньше двух вариантов и хотя бы один правильный", i+1)
+			}
+		}
+		b.Questions = bf.Questions
+	case "file", "pdf":
+		if bf.Src == "" {
+			return nil, fmt.Errorf("у блока %s не задан src", bf.Type)
+		}
+		u, err := media.url(root, dir, bf.Src)
+		if err != nil {
+			return nil, err
+		}
+		b.Payload["url"] = u
+		name := bf.Name
+		if u != bf.Src {
+			// файл рядом с блоком: размер, тип и число страниц — как при загрузке в админке
+			kind := uploadKinds[bf.Type]
+			if !kind.allows(strings.ToLower(filepath.Ext(bf.Src))) {
+				return nil, fmt.Errorf("файл %s: недопустимый формат", bf.Src)
+			}
+			info, err := media.describe(root, dir, bf.Src)
+			if err != nil {
+				return nil, err
+			}
+			b.Payload["size"] = info.Size
+			if bf.Type == "pdf" {
+				b.Payload["pages"] = info.Pages
+			} else {
+				b.Payload["mime"] = info.MIME
+			}
+			if name == "" {
+				name = info.Name
+			}
+		}
+		if name != "" {
+			b.Payload["name"] = name
+		}
+		if bf.Type == "pdf" {
+			if bf.PageFrom != 0 {
+				b.Payload["page_from"] = bf.PageFrom
+			}
+			if bf.PageTo != 0 {
+				b.Payload["page_to"] = bf.PageTo
+			}
+		}
+	case "link":
+		if bf.URL == "" {
+			return nil, errors.New("у ссылки не задан url")
+		}
+		// превью читается при синхронизации (syncCourseTx): прежнее берётся из блока
+		b.Payload["url"] = bf.URL
+	case "flashcards":
+		var cards []map[string]any
+		for i, cf := range bf.Cards {
+			id := cf.ID
+			if id == "" {
+				sum := sha256.Sum256([]byte(strings.TrimSpace(cf.Front)))
+				id = hex.EncodeToString(sum[:6])
+			}
+			card := map[string]any{"id": id, "front": strings.TrimSpace(cf.Front), "back": strings.TrimSpace(cf.Back)}
+			for key, ref := range map[string]string{"front_image": cf.FrontImage, "back_image": cf.BackImage} {
+				if ref == "" {
+					continue
+				}
+				u, err := media.url(root, dir, ref)
+				if err != nil {
+					return nil, fmt.Errorf("карточка %d: %w", i+1, err)
+				}
+				card[key] = u
+			}
+			cards = append(cards, card)
+		}
+		b.Payload["cards"] = cards
+	case "survey":
+		var items []map[string]any
+		for _, qf := range bf.Items {
+			id := qf.ID
+			if id == "" {
+				sum := sha256.Sum256([]byte(strings.TrimSpace(qf.Text)))
+				id = hex.EncodeToString(sum[:6])
+			}
+			q := map[string]any{"id": id, "kind": qf.Kind, "text": strings.TrimSpace(qf.Text), "required": qf.Required}
+			if len(qf.Options) > 0 {
+				q["options"] = qf.Options
+			}
+			if qf.Scale != 0 {
+				q["scale"] = qf.Scale
+			}
+			if qf.MinLabel != "" {
+				q["min_label"] = qf.MinLabel
+			}
+			if qf.MaxLabel != "" {
+				q["max_label"] = qf.MaxLabel
+			}
+			items = append(items, q)
+		}
+		b.Payload["questions"] = items
+		b.Payload["intro"] = strings.TrimSpace(bf.Intro)
+		b.Payload["anonymous"] = bf.Anonymous
+		b.Payload["show_results"] = bf.ShowResults
+	case "poll":
+		b.Payload["question"] = strings.TrimSpace(bf.Question)
+		b.Payload["options"] = bf.Options
+		b.Payload["multiple"] = bf.Multiple
+		b.Payload["anonymous"] = bf.Anonymous
+		b.Payload["show_results"] = bf.ShowResults
+	case "scorm":
+		return nil, errors.New("SCORM-блоки в каталоге не поддерживаются — загрузите пакет в админке")
+	default:
+		return nil, fmt.Errorf("неизвестный тип блока %q", bf.Type)
+	}
+	if bf.Type != "quiz" && (bf.PassScore != nil || len(bf.Questions) > 0) {
+		return nil, errors.New("pass_score и questions бывают только у теста (type: quiz)")
+	}
+	if bf.Type != "flashcards" && len(bf.Cards) > 0 {
+		return nil, errors.New("cards бывают только у карточек (type: flashcards)")
+	}
+	if bf.Type != "survey" && (bf.Intro != "" || len(bf.Items) > 0) {
+		return nil, errors.New("intro и items бывают только у опроса (type: survey)")
+	}
+	if bf.Type != "poll" && (bf.Question != "" || len(bf.Options) > 0 || bf.Multiple) {
+		return nil, errors.New("question, options и multiple бывают только у голосования (type: poll)")
+	}
+	if bf.Type != "survey" && bf.Type != "poll" && (bf.Anonymous || bf.ShowResults) {
+		return nil, errors.New("anonymous и show_results бывают только у опроса и голосования (type: survey, poll)")
+	}
+	if bf.Type != "video" && (bf.Complete != 0 || len(bf.Tracks) > 0 || len(bf.Chapters) > 0) {
+		return nil, errors.New("complete_percent, tracks и chapters бывают только у видео (type: video)")
+	}
+	if bf.Type != "assignment" && bf.PeerReview != nil {
+		return nil, errors.New("peer_review бывает только у задания (type: assignment)")
+	}
+	if bf.Type != "pdf" && (bf.PageFrom != 0 || bf.PageTo != 0) {
+		return nil, errors.New("page_from и page_to бывают только у PDF (type: pdf)")
+	}
+	if bf.Type != "file" && bf.Type != "pdf" && bf.Name != "" {
+		return nil, errors.New("name бывает только у файла и PDF (type: file, pdf)")
+	}
+	// те же правила, что при сохранении блока в админке
+	raw, err := json.Marshal(b.Payload)
+	if err != nil {
+		return nil, err
+	}
+	clean, err := sanitizePayloadForSave(b.Type, raw)
+	if err == nil {
+		err = checkBlockPayload(blockKinds[b.Type], clean)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Payload = map[string]any{}
+	if err := json.Unmarshal(clean, &b.Payload); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// syncVideoCaptions — субтитры и главы видео-файла; текст дорожек — в transcript, как при сохранении в админке.
+func syncVideoCaptions(b *syncBlock, bf *syncBlockFile, media *syncMedia, root, dir string) error {
+	var tracks []map[string]any
+	var texts []string
+	for i, tf := range bf.Tracks {
+		if tf.Lang == "" || tf.Src == "" {
+			return fmt.Errorf("субтитры %d: нужны lang и src", i+1)
+		}
+		u, cues, err := media.subtitles(root, dir, tf.Src)
+		if err != nil {
+			return err
+		}
+		label := tf.Label
+		if label == "" {
+			label = tf.Lang
+		}
+		track := map[string]any{"lang": tf.Lang, "label": label, "src": u}
+		if tf.Default {
+			track["default"] = true
+		}
+		tracks = append(tracks, track)
+		texts = append(texts, cuesText(cues))
+	}
+	if len(tracks) > 0 {
+		b.Payload["tracks"] = tracks
+		b.Payload["transcript"] = strings.Join(texts, "\n\n")
+	}
+	var chapters []map[string]any
+	for i, cf := range bf.Chapters {
+		at, err := parseClock(cf.At)
+		if err != nil {
+			return fmt.Errorf("глава %d: %w", i+1, err)
+		}
+		chapters = append(chapters, map[string]any{"at": at, "title": strings.TrimSpace(cf.Title)})
+	}
+	if len(chapters) > 0 {
+		b.Payload["chapters"] = chapters
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////
+// ФАЙЛЫ КУРСА
+///////////////////////////////////////////////////////
+
+// syncMedia копирует файлы из каталога курса в static/uploads/content под именем
+// <sha256>_<имя>: одинаковое содержимое — тот же адрес, повторная синхронизация ничего не меняет.
+type syncMedia struct {
+	DryRun bool
+	Copied int
+}
+
+func (sm *syncMedia) url(root, dir, ref string) (string, error) {
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "data:") {
+		return ref, nil
+	}
+	p, err := syncMediaPath(root, dir, ref)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("файл %s: %w", ref, err)
+	}
+	return sm.store(filepath.Base(p), data)
+}
+
+// subtitles копирует файл субтитров рядом с блоком, SRT — переведённым в VTT. cues — его реплики.
+func (sm *syncMedia) subtitles(root, dir, ref string) (u string, cues []vttCue, err error) {
+	p, err := syncMediaPath(root, dir, ref)
+	if err != nil {
+		return "", nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(p))
+	if !uploadKinds["subtitles"].allows(ext) {
+		return "", nil, fmt.Errorf("субтитры %s: нужен файл .vtt или .srt рядом с блоком", ref)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("файл %s: %w", ref, err)
+	}
+	data, newExt, err := prepareSubtitles(data, ext)
+	if err != nil {
+		return "", nil, fmt.Errorf("субтитры %s: %w", ref, err)
+	}
+	if cues, err = parseVTT(data); err != nil {
+		return "", nil, err
+	}
+	u, err = sm.store(strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))+newExt, data)
+	return u, cues, err
+}
+
+// store кладёт содержимое в static/uploads/content, если его там ещё нет, и возвращает адрес.
+func (sm *syncMedia) store(base string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:8]) + "_" + base
+	target := filepath.Join("static", contentRelPath(), name)
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		if !sm.DryRun {
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(target, data, 0o644); err != nil {
+				return "", err
+			}
+		}
+		sm.Copied++
+	}
+	return contentURLPrefix() + name, nil
+}
+
+// describe — размер, тип и число страниц файла рядом с блоком.
+func (sm *syncMedia) describe(root, dir, ref string) (*contentUpload, error) {
+	p, err := syncMediaPath(root, dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	info, err := describeFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("файл %s: %w", ref, err)
+	}
+	return info, nil
+}
+
+func syncMediaPath(root, dir, ref string) (string, error) {
+	p := filepath.Join(root, filepath.FromSlash(dir), filepath.FromSlash(ref))
+	if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("файл %s вне каталога курса", ref)
+	}
+	return p, nil
+}
+
+// rewriteMarkdown заменяет относительные пути картинок ![](img.png) адресами скопированных файлов.
+func (sm *syncMedia) rewriteMarkdown(root, dir, text string) (string, error) {
+	var firstErr error
+	out := mdImageRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := mdImageRe.FindStringSubmatch(m)
+		u, err := sm.url(root, dir, sub[2])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return m
+		}
+		return sub[1] + u
+	})
+	return out, firstErr
+}
+
+///////////////////////////////////////////////////////
+// СИНХРОНИЗАЦИЯ С БД
+///////////////////////////////////////////////////////
+
+type courseSyncOptions struct {
+	DryRun bool // всё проверить и показать, ничего не записывать
+	Prune  bool // удалить модули и блоки курса, которых нет в каталоге (вместе с результатами учеников)
+}
+
+type courseSyncReport struct {
+	CourseID  uint
+	Created   int
+	Updated   int
+	Unchanged int
+	Removed   int
+	Media     int
+	Lines     []string // по строке на каждое изменение
+}
+
+func (r *courseSyncReport) log(format string, args ...any) {
+	r.Lines = append(r.Lines, fmt.Sprintf(format, args...))
+}
+
+// syncCourseDir приводит курс в БД к содержимому каталога. Повторный запуск без изменений
+// в файлах ничего не меняет.
+func syncCourseDir(root string, opts courseSyncOptions) (*courseSyncReport, error) {
+	media := &syncMedia{DryRun: opts.DryRun}
+	sc, err := loadCourseDir(root, media)
+	if err != nil {
+		return nil, err
+	}
+	rep := &courseSyncReport{}
+	var obsolete []datatypes.JSON
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		obsolete, err = syncCourseTx(tx, sc, opts, rep)
+		if err == nil && opts.DryRun {
+			return errSyncDryRun
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, errSyncDryRun) {
+		return nil, err
+	}
+	rep.Media = media.Copied
+	if !opts.DryRun {
+		for _, p := range obsolete {
+			cleanupScormPackage(p, nil)
+		}
+	}
+	return rep, nil
+}
+
+func syncCourseTx(tx *gorm.DB, sc *syncCourse, opts courseSyncOptions, rep *courseSyncReport) ([]datatypes.JSON, error) {
+	var course Course
+	err := tx.Where("slug = ?", sc.Slug).First(&course).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		course = Course{Slug: sc.Slug, Title: sc.Title, ShortDesc: sc.ShortDesc, Status: sc.Status}
+		if course.Status == "" {
+			course.Status = "draft"
+		}
+		if sc.IsTemplate != nil {
+			course.IsTemplate = *sc.IsTemplate
+		}
+		if err := tx.Create(&course).Error; err != nil {
+			return nil, err
+		}
+		rep.Created++
+		rep.log("+ курс %s «%s»", sc.Slug, sc.Title)
+	case err != nil:
+		return nil, err
+	default:
+		upd := map[string]any{}
+		if course.Title != sc.Title {
+			upd["title"] = sc.Title
+		}
+		if course.ShortDesc != sc.ShortDesc {
+			upd["short_desc"] = sc.ShortDesc
+		}
+		if sc.Status != "" && course.Status != sc.Status {
+			upd["status"] = sc.Status
+		}
+		if sc.IsTemplate != nil && course.IsTemplate != *sc.IsTemplate {
+			upd["is_template"] = *sc.IsTemplate
+		}
+		if err := syncUpdate(tx, &course, upd, rep, "курс "+sc.Slug); err != nil {
+			return nil, err
+		}
+	}
+	rep.CourseID = course.ID
+
+	var modules []Module
+	if err := tx.Where("course_id = ?", course.ID).Order("id").Find(&modules).Error; err != nil {
+		return nil, err
+	}
+	var blocks []Block
+	if err := tx.Joins("JOIN modules ON modules.id = blocks.module_id").
+		Where("modules.course_id = ?", course.ID).Order("blocks.id").Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	modBySlug := map[string]*Module{}
+	for i := range modules {
+		if modules[i].Slug != "" {
+			modBySlug[modules[i].Slug] = &modules[i]
+		}
+	}
+	blkBySlug := map[string]*Block{}
+	for i := range blocks {
+		if blocks[i].Slug != "" {
+			blkBySlug[blocks[i].Slug] = &blocks[i]
+		}
+	}
+
+	var obsolete []datatypes.JSON
+	keepMod := map[uint]bool{}
+	keepBlk := map[uint]bool{}
+	for mi, sm := range sc.Modules {
+		m := modBySlug[sm.Slug]
+		if m == nil {
+			m = &Module{CourseID: course.ID, Slug: sm.Slug, Title: sm.Title, Order: mi + 1}
+			if err := tx.Create(m).Error; err != nil {
+				return nil, err
+			}
+			rep.Created++
+			rep.log("+ модуль %s «%s»", sm.Slug, sm.Title)
+		} else {
+			upd := map[string]any{}
+			if m.Title != sm.Title {
+				upd["title"] = sm.Title
+			}
+			if m.Order != mi+1 {
+				upd["order"] = mi + 1
+			}
+			if err := syncUpdate(tx, m, upd, rep, "модуль "+sm.Slug); err != nil {
+				return nil, err
+			}
+		}
+		keepMod[m.ID] = true
+
+		for bi, sb := range sm.Blocks {
+			b := blkBySlug[sb.Slug]
+			if sb.Type == "text" {
+				// диаграммы: уже нарисованные берём из блока, новые рисуем
+				var prev map[string]string
+				if b != nil && b.Type == sb.Type {
+					prev = payloadDiagrams(b.Payload)
+				}
+				md, _ := sb.Payload["text"].(string)
+				diagrams, problems := textBlockExtras(md, prev)
+				if len(problems) > 0 {
+					return nil, fmt.Errorf("%s: %s", sb.File, strings.Join(problems, "; "))
+				}
+				delete(sb.Payload, "diagrams")
+				if diagrams != nil {
+					sb.Payload["diagrams"] = diagrams
+				}
+			}
+			if sb.Type == "link" {
+				// превью ссылки: прежнее, если адрес не менялся; сайт недоступен — блок без превью
+				var prev linkPayload
+				if b != nil && b.Type == sb.Type {
+					_ = json.Unmarshal(b.Payload, &prev)
+				}
+				p := &linkPayload{URL: fmt.Sprint(sb.Payload["url"])}
+				for _, w := range refreshLinkPreview(p, &prev, false) {
+					rep.log("! %s: %s", sb.File, w)
+				}
+				delete(sb.Payload, "preview")
+				if p.Preview != nil {
+					sb.Payload["preview"] = p.Preview
+				}
+			}
+			payload, err := json.Marshal(sb.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if b != nil && b.Type != sb.Type {
+				// тип сменился — результаты старого блока к новому не относятся
+				obsolete = append(obsolete, b.Payload)
+				if err := tx.Delete(b).Error; err != nil {
+					return nil, err
+				}
+				rep.Removed++
+				rep.log("- блок %s (%s → %s, пересоздаётся)", sb.Slug, b.Type, sb.Type)
+				b = nil
+			}
+			if b == nil {
+				b = &Block{ModuleID: m.ID, Slug: sb.Slug, Type: sb.Type, Order: bi + 1, Payload: datatypes.JSON(payload)}
+				if err := tx.Create(b).Error; err != nil {
+					return nil, err
+				}
+				rep.Created++
+				rep.log("+ блок %s (%s)", sb.File, sb.Type)
+			} else {
+				upd := map[string]any{}
+				if b.ModuleID != m.ID {
+					upd["module_id"] = m.ID
+				}
+				if b.Order != bi+1 {
+					upd["order"] = bi + 1
+				}
+				if !sameJSON(b.Payload, payload) {
+					upd["payload"] = datatypes.JSON(payload)
+				}
+				if err := syncUpdate(tx, b, upd, rep, "блок "+sb.File); err != nil {
+					return nil, err
+				}
+			}
+			keepBlk[b.ID] = true
+			if sb.Type == "quiz" {
+				changed, err := syncQuestions(tx, b.ID, sb.Questions)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", sb.File, err)
+				}
+				if changed {
+					rep.log("~ вопросы теста %s", sb.File)
+				}
+			}
+		}
+	}
+
+	for _, b := range blocks {
+		if keepBlk[b.ID] {
+			continue
+		}
+		name := b.Slug
+		if name == "" {
+			name = fmt.Sprintf("#%d %s", b.ID, blockTitle(&b))
+		}
+		if !opts.Prune {
+			rep.log("! блок %s есть в БД, но не в каталоге (удалить: -prune)", name)
+			continue
+		}
+		obsolete = append(obsolete, b.Payload)
+		if err := tx.Delete(&b).Error; err != nil {
+			return nil, err
+		}
+		rep.Removed++
+		rep.log("- блок %s", name)
+	}
+	for _, m := range modules {
+		if keepMod[m.ID] {
+			continue
+		}
+		name := m.Slug
+		if name == "" {
+			name = fmt.Sprintf("#%d «%s»", m.ID, m.Title)
+		}
+		if !opts.Prune {
+			rep.log("! модуль %s есть в БД, но не в каталоге (удалить: -prune)", name)
+			continue
+		}
+		if err := tx.Delete(&m).Error; err != nil {
+			return nil, err
+		}
+		rep.Removed++
+		rep.log("- модуль %s", name)
+	}
+	return obsolete, nil
+}
+
+// syncUpdate пишет только изменившиеся поля — иначе запись считается неизменной.
+func syncUpdate(tx *gorm.DB, model any, upd map[string]any, rep *courseSyncReport, what string) error {
+	if len(upd) == 0 {
+		rep.Unchanged++
+		return nil
+	}
+	if err := tx.Model(model).Updates(upd).Error; err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(upd))
+	for k := range upd {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	rep.Updated++
+	rep.log("~ %s: %s", what, strings.Join(fields, ", "))
+	return nil
+}
+
+// sameJSON сравнивает JSON по значению (порядок ключей и пробелы не важны).
+func sameJSON(a, b []byte) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	xa, _ := json.Marshal(x)
+	yb, _ := json.Marshal(y)
+	return bytes.Equal(xa, yb)
+}
+
+// syncQuestions обновляет вопросы и варианты на месте, сопоставляя их по порядку: ID сохраняются,
+// и ответы в прошлых попытках учеников по-прежнему находят свои варианты.
+func syncQuestions(tx *gorm.DB, blockID uint, specs []syncQuestionFile) (bool, error) {
+	var qs []QuizQuestion
+	if err := tx.Where("block_id = ?", blockID).Order("\"order\", id").
+		Preload("Options", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Find(&qs).Error; err != nil {
+		return false, err
+	}
+	changed := false
+	for i, sq := range specs {
+		if i >= len(qs) {
+			q := QuizQuestion{BlockID: blockID, Text: sq.Text, Order: i + 1}
+			for _, so := range sq.Options {
+				q.Options = append(q.Options, QuizOption{Text: so.Text, IsCorrect: so.Correct})
+			}
+			if err := tx.Create(&q).Error; err != nil {
+				return false, err
+			}
+			changed = true
+			continue
+		}
+		q := qs[i]
+		if q.Text != sq.Text || q.Order != i+1 {
+			if err := tx.Model(&q).Updates(map[string]any{"text": sq.Text, "order": i + 1}).Error; err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		for j, so := range sq.Options {
+			if j >= len(q.Options) {
+				o := QuizOption{QuestionID: q.ID, Text: so.Text, IsCorrect: so.Correct}
+				if err := tx.Create(&o).Error; err != nil {
+					return false, err
+				}
+				changed = true
+				continue
+			}
+			o := q.Options[j]
+			if o.Text != so.Text || o.IsCorrect != so.Correct {
+				if err := tx.Model(&o).Updates(map[string]any{"text": so.Text, "is_correct": so.Correct}).Error; err != nil {
+					return false, err
+				}
+				changed = true
+			}
+		}
+		for _, o := range q.Options[min(len(sq.Options), len(q.Options)):] {
+			if err := tx.Delete(&o).Error; err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	for _, q := range qs[min(len(specs), len(qs)):] {
+		if err := tx.Delete(&q).Error; err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+///////////////////////////////////////////////////////
+// КОМАНДА course-sync
+///////////////////////////////////////////////////////
+
+// runCourseSyncCommand — ./server course-sync [-dry-run] [-prune] <каталог>...
+func runCourseSyncCommand(args []string) int {
+	flags := flag.NewFlagSet("course-sync", flag.ContinueOnError)
+	var opts courseSyncOptions
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "проверить и показать изменения, ничего не записывая")
+	flags.BoolVar(&opts.Prune, "prune", false, "удалить модули и блоки курса, которых нет в каталоге (с результатами учеников)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "использование: course-sync [-dry-run] [-prune] <каталог курса>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	db = initDB()
+	rc := 0
+	for _, dir := range flags.Args() {
+		rep, err := syncCourseDir(dir, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			rc = 1
+			continue
+		}
+		for _, l := range rep.Lines {
+			fmt.Println("  " + l)
+		}
+		prefix := ""
+		if opts.DryRun {
+			prefix = "(dry-run) "
+		}
+		fmt.Printf("%s%s: курс #%d — создано %d, обновлено %d, без изменений %d, удалено %d, новых файлов %d\n",
+			prefix, dir, rep.CourseID, rep.Created, rep.Updated, rep.Unchanged, rep.Removed, rep.Media)
+	}
+	return rc
+}
@@ -0,0 +1,158 @@
+// csrf.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfFormField  = "_csrf"        // скрытое поле в формах
+	csrfHeader     = "X-CSRF-Token" // для fetch/XHR (JSON-эндпоинты)
+)
+
+// csrfExemptPrefixes — пути, которые не проверяются: машинные эндпоинты без cookie-сессии
+// (вебхуки, обратные вызовы внешних систем). Пополняется через csrfExempt при регистрации маршрутов.
+var csrfExemptPrefixes []string
+
+func csrfExempt(prefix string) {
+	csrfExemptPrefixes = append(csrfExemptPrefixes, prefix)
+}
+
+// csrfToken возвращает токен текущей сессии, создавая его при первом обращении.
+func csrfToken(c *gin.Context) string {
+	sess := sessions.Default(c)
+	if tok, ok := sess.Get(csrfSessionKey).(string); ok && tok != "" {
+		return tok
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	sess.Set(csrfSessionKey, tok)
+	_ = sess.Save()
+	return tok
+}
+
+// resetCSRFToken — новый токен после входа (старый мог видеть кто-то ещё до логина).
+func resetCSRFToken(c *gin.Context) {
+	sessions.Default(c).Delete(csrfSessionKey)
+}
+
+// csrfMiddleware проверяет токен у всех изменяющих запросов (POST/PUT/PATCH/DELETE).
+// Токен берётся из поля формы _csrf или заголовка X-CSRF-Token.
+func csrfMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// шаблонам токен нужен лениво: сессию создаём, только если страница его выводит
+		c.Writer = &csrfResponseWriter{ResponseWriter: c.Writer, c: c}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		// Bearer-токен не отправляется браузером сам — подделать такой запрос нельзя
+		if currentAPIToken(c) != nil {
+			c.Next()
+			return
+		}
+		for _, p := range csrfExemptPrefixes {
+			if strings.HasPrefix(c.Request.URL.Path, p) {
+				c.Next()
+				return
+			}
+		}
+
+		sent := c.GetHeader(csrfHeader)
+		if sent == "" {
+			sent = c.PostForm(csrfFormField)
+		}
+		expected, _ := sessions.Default(c).Get(csrfSessionKey).(string)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			msg := "Форма устарела или запрос отправлен с другого сайта. Обновите страницу и попробуйте снова."
+			if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+				apiError(c, http.StatusForbidden, "csrf_failed", "Нет или неверный заголовок "+csrfHeader)
+				return
+			}
+			if c.GetHeader(csrfHeader) != "" || strings.Contains(c.GetHeader("Accept"), "application/json") {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": msg})
+				return
+			}
+			c.String(http.StatusForbidden, msg)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// csrfResponseWriter несёт контекст запроса до рендера шаблона (у render.Render есть только writer).
+type csrfResponseWriter struct {
+	gin.ResponseWriter
+	c *gin.Context
+}
+
+// lazyCSRF выводится в шаблоне как {{ $.CSRF }}; токен создаётся в момент вывода.
+type lazyCSRF struct{ c *gin.Context }
+
+func (l lazyCSRF) String() string { return csrfToken(l.c) }
+
+// csrfHTMLRender — обёртка над штатным рендером gin: добавляет CSRF в gin.H каждого шаблона.
+type csrfHTMLRender struct {
+	render.HTMLRender
+}
+
+func (r csrfHTMLRender) Instance(name string, data any) render.Render {
+	return csrfHTML{base: r.HTMLRender, name: name, data: data}
+}
+
+type csrfHTML struct {
+	base render.HTMLRender
+	name string
+	data any
+}
+
+func (h csrfHTML) WriteContentType(w http.ResponseWriter) {
+	h.base.Instance(h.name, h.data).WriteContentType(w)
+}
+
+// Render рендерит в буфер: если шаблон впервые запросил токен, cookie сессии
+// должна уйти в заголовках раньше тела.
+func (h csrfHTML) Render(w http.ResponseWriter) error {
+	data := h.data
+	if cw, ok := w.(*csrfResponseWriter); ok {
+		if m, ok := data.(gin.H); ok {
+			withToken := make(gin.H, len(m)+1)
+			for k, v := range m {
+				withToken[k] = v
+			}
+			withToken["CSRF"] = lazyCSRF{c: cw.c}
+			data = withToken
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := h.base.Instance(h.name, data).Render(&bufferWriter{ResponseWriter: w, buf: &buf}); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// bufferWriter перехватывает тело, оставляя заголовки исходному writer.
+type bufferWriter struct {
+	http.ResponseWriter
+	buf *bytes.Buffer
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) { return b.buf.Write(p) }
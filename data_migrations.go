@@ -0,0 +1,45 @@
+// data_migrations.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Миграции данных — то, что AutoMigrate не умеет: переписать уже сохранённые записи
+// под новые правила. Каждая выполняется один раз, в своей транзакции; имя — ключ в data_migrations,
+// поэтому переименовывать применённые нельзя. Новые — в конец списка.
+var dataMigrations = []struct {
+	Name string
+	Run  func(tx *gorm.DB) error
+}{
+	{"2026-10-sanitize-block-payloads", migrateSanitizePayloads},
+	{"2026-10-canonical-block-payloads", migrateCanonicalPayloads},
+	{"2026-10-block-search-index", migrateBlockSearchIndex},
+}
+
+func runDataMigrations(gormDB *gorm.DB) error {
+	for _, m := range dataMigrations {
+		var cnt int64
+		if err := gormDB.Model(&DataMigration{}).Where("name = ?", m.Name).Count(&cnt).Error; err != nil {
+			return err
+		}
+		if cnt > 0 {
+			continue
+		}
+		log.Printf("data migration %s...\n", m.Name)
+		err := gormDB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&DataMigration{Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
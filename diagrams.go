@@ -0,0 +1,186 @@
+// diagrams.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"gorm.io/datatypes"
+)
+
+// Диаграммы в тексте блока (```mermaid, ```dot / ```graphviz) рисуются в SVG при сохранении
+// внешними инструментами — Graphviz (dot) и mermaid-cli (mmdc) — и хранятся в payload.diagrams
+// по ключу «вид + исходник». При показе инструменты не нужны; неизменённые диаграммы
+// повторно не рисуются. Нет инструмента или ошибка в исходнике — автор видит это в форме блока,
+// а на странице остаётся исходник диаграммы блоком кода.
+
+var diagramKinds = map[string]string{"mermaid": "mermaid", "dot": "graphviz", "graphviz": "graphviz"}
+
+const (
+	diagramMaxSource = 20 << 10
+	diagramMaxSVG    = 2 << 20
+)
+
+func diagramKey(kind, src string) string {
+	sum := sha256.Sum256([]byte(kind + "\n" + src))
+	return hex.EncodeToString(sum[:12])
+}
+
+// renderDiagramSVG запускает инструмент; stderr инструмента — в тексте ошибки.
+func renderDiagramSVG(kind, src string) (string, error) {
+	if len(src) > diagramMaxSource {
+		return "", fmt.Errorf("исходник длиннее %d КБ", diagramMaxSource>>10)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("DIAGRAM_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	var cmd *exec.Cmd
+	var outFile string
+	switch kind {
+	case "graphviz":
+		cmd = exec.CommandContext(ctx, envOr("DIAGRAM_DOT_CMD", "dot"), "-Tsvg")
+		cmd.Stdin = strings.NewReader(src)
+	case "mermaid":
+		dir, err := os.MkdirTemp("", "mermaid-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		in, cfg := filepath.Join(dir, "in.mmd"), filepath.Join(dir, "config.json")
+		outFile = filepath.Join(dir, "out.svg")
+		// htmlLabels выключены: подписи — текст SVG, а не HTML внутри foreignObject
+		conf := `{"securityLevel":"strict","htmlLabels":false,"flowchart":{"htmlLabels":false}}`
+		if err := os.WriteFile(in, []byte(src), 0o600); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+			return "", err
+		}
+		args := []string{"-q", "-i", in, "-o", outFile, "-c", cfg}
+		if p := os.Getenv("DIAGRAM_MERMAID_PUPPETEER_CONFIG"); p != "" {
+			args = append(args, "-p", p)
+		}
+		cmd = exec.CommandContext(ctx, envOr("DIAGRAM_MERMAID_CMD", "mmdc"), args...)
+	default:
+		return "", fmt.Errorf("неизвестный вид диаграммы %q", kind)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		var ee *exec.Error
+		switch {
+		case errors.As(err, &ee):
+			return "", fmt.Errorf("%s не установлен на сервере", filepath.Base(cmd.Path))
+		case ctx.Err() != nil:
+			return "", errors.New("не дорисовалась за отведённое время")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > 500 {
+				msg = msg[:500] + "…"
+			}
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+
+	svg := stdout.Bytes()
+	if outFile != "" {
+		b, err := os.ReadFile(outFile)
+		if err != nil {
+			return "", err
+		}
+		svg = b
+	}
+	if len(svg) > diagramMaxSVG {
+		return "", errors.New("слишком большая картинка")
+	}
+	if !bytes.Contains(svg, []byte("<svg")) {
+		return "", errors.New("инструмент не вернул SVG")
+	}
+	return string(svg), nil
+}
+
+// payloadDiagrams — payload.diagrams сохранённого блока.
+func payloadDiagrams(raw datatypes.JSON) map[string]string {
+	var p struct {
+		Diagrams map[string]string `json:"diagrams"`
+	}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &p)
+	}
+	return p.Diagrams
+}
+
+// blockDiagrams рисует диаграммы из Markdown. Уже нарисованные (prev) берутся как есть,
+// в результат попадают только диаграммы, которые в тексте остались.
+func blockDiagrams(md string, prev map[string]string) (map[string]string, []string) {
+	source := []byte(md)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	out := map[string]string{}
+	var problems []string
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		fc, ok := n.(*ast.FencedCodeBlock)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		kind, src, ok := fencedDiagram(fc, source)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		key := diagramKey(kind, src)
+		if _, done := out[key]; done {
+			return ast.WalkContinue, nil
+		}
+		if svg, ok := prev[key]; ok {
+			out[key] = svg
+			return ast.WalkContinue, nil
+		}
+		svg, err := renderDiagramSVG(kind, src)
+		if err != nil {
+			line := 1
+			if fc.Lines().Len() > 0 {
+				line = bytes.Count(source[:fc.Lines().At(0).Start], []byte("\n"))
+			}
+			problems = append(problems, fmt.Sprintf("диаграмма %s (строка %d): %v", kind, line, err))
+			return ast.WalkContinue, nil
+		}
+		out[key] = svg
+		return ast.WalkContinue, nil
+	})
+	return out, problems
+}
+
+// markdownMathProblems — ошибки в формулах Markdown-текста.
+func markdownMathProblems(md string) []string {
+	f := newMDFragments()
+	ctx := parser.NewContext()
+	ctx.Set(mdFragmentsKey, f)
+	markdown.Parser().Parse(text.NewReader([]byte(md)), parser.WithContext(ctx))
+	return f.errors
+}
+
+// textBlockExtras рисует диаграммы текстового блока (nil, если их нет) и возвращает
+// ошибки формул и диаграмм. Блок с ними сохраняется: формула с ошибкой показывается исходником,
+// диаграмма — блоком кода.
+func textBlockExtras(md string, prev map[string]string) (map[string]string, []string) {
+	problems := markdownMathProblems(md)
+	diagrams, dp := blockDiagrams(md, prev)
+	if len(diagrams) == 0 {
+		diagrams = nil
+	}
+	return diagrams, append(problems, dp...)
+}
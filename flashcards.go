@@ -0,0 +1,507 @@
+// flashcards.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Карточки с интервальным повторением. Ученик оценивает, насколько легко вспомнил ответ
+// (1 — забыл … 4 — легко), и планировщик назначает следующий показ: SM-2 (интервал и ease)
+// или FSRS (стабильность и сложность памяти) — FLASHCARDS_SCHEDULER=sm2|fsrs.
+// Расписание — FlashcardState на пару «ученик + карточка», каждый ответ — FlashcardReview.
+// Страница /review собирает карточки к повторению по всем начатым курсам; новые карточки
+// добавляются в неё не больше FLASHCARDS_NEW_PER_DAY в день.
+
+const (
+	gradeAgain = 1 // забыл — карточка вернётся через flashcardRelearnDelay
+	gradeHard  = 2
+	gradeGood  = 3
+	gradeEasy  = 4
+
+	flashcardRelearnDelay = 10 * time.Minute
+	flashcardMaxInterval  = 36500 // дней
+)
+
+// flashcardGrades — кнопки оценки на странице повторения.
+var flashcardGrades = []struct {
+	Grade int
+	Title string
+	Class string
+}{
+	{gradeAgain, "Забыл", "btn-outline-danger"},
+	{gradeHard, "Трудно", "btn-outline-warning"},
+	{gradeGood, "Хорошо", "btn-outline-success"},
+	{gradeEasy, "Легко", "btn-outline-primary"},
+}
+
+///////////////////////////////////////////////////////
+// ПЛАНИРОВЩИК
+///////////////////////////////////////////////////////
+
+func flashcardScheduler() string {
+	if envOr("FLASHCARDS_SCHEDULER", "sm2") == "fsrs" {
+		return "fsrs"
+	}
+	return "sm2"
+}
+
+// scheduleFlashcard применяет ответ к расписанию карточки: интервал, ease / стабильность,
+// счётчики и время следующего показа.
+func scheduleFlashcard(st *FlashcardState, grade int, now time.Time) {
+	if flashcardScheduler() == "fsrs" {
+		fsrsReview(st, grade, now)
+	} else {
+		sm2Review(st, grade)
+	}
+	if grade == gradeAgain {
+		if st.Reps > 0 {
+			st.Lapses++
+		}
+		st.Reps = 0
+		st.Interval = 0
+		st.Due = now.Add(flashcardRelearnDelay)
+	} else {
+		st.Reps++
+		st.Interval = min(max(st.Interval, 1), flashcardMaxInterval)
+		st.Due = now.Add(time.Duration(st.Interval * 24 * float64(time.Hour)))
+	}
+	st.LastReview = now
+}
+
+// sm2Review — SuperMemo-2: оценки 1–4 соответствуют качеству ответа 1, 3, 4, 5.
+// Первые интервалы — 1 и 6 дней, дальше интервал умножается на ease (не меньше 1,3).
+// Забытая карточка начинает интервалы заново, ease при этом не меняется.
+func sm2Review(st *FlashcardState, grade int) {
+	if st.Ease == 0 {
+		st.Ease = 2.5
+	}
+	if grade == gradeAgain {
+		return
+	}
+	q := [...]float64{gradeHard: 3, gradeGood: 4, gradeEasy: 5}[grade]
+	st.Ease = max(1.3, st.Ease+0.1-(5-q)*(0.08+(5-q)*0.02))
+	switch st.Reps {
+	case 0:
+		st.Interval = 1
+	case 1:
+		st.Interval = 6
+	default:
+		st.Interval = math.Round(st.Interval * st.Ease)
+	}
+}
+
+// Параметры FSRS-4.5 по умолчанию (open-spaced-repetition) и целевая вероятность вспомнить.
+var fsrsW = [17]float64{0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031,
+	1.6474, 0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755}
+
+const (
+	fsrsDecay     = -0.5
+	fsrsFactor    = 19.0 / 81
+	fsrsRetention = 0.9
+)
+
+// fsrsRetrievability — вероятность вспомнить через t дней при стабильности s.
+func fsrsRetrievability(t, s float64) float64 {
+	return math.Pow(1+fsrsFactor*t/s, fsrsDecay)
+}
+
+func fsrsInitDifficulty(grade int) float64 {
+	return min(max(fsrsW[4]-float64(grade-3)*fsrsW[5], 1), 10)
+}
+
+// fsrsReview — FSRS-4.5: стабильность растёт тем сильнее, чем ниже сложность и чем больше
+// карточка успела забыться; интервал — срок, за который вероятность вспомнить упадёт до fsrsRetention.
+// Ease для FSRS не используется — сложность (Difficulty) играет её роль.
+func fsrsReview(st *FlashcardState, grade int, now time.Time) {
+	w := fsrsW
+	if st.Stability == 0 {
+		st.Stability = w[grade-1]
+		st.Difficulty = fsrsInitDifficulty(grade)
+	} else {
+		t := max(now.Sub(st.LastReview).Hours()/24, 0)
+		r := fsrsRetrievability(t, st.Stability)
+		d, s := st.Difficulty, st.Stability
+		if grade == gradeAgain {
+			st.Stability = min(s, w[11]*math.Pow(d, -w[12])*(math.Pow(s+1, w[13])-1)*math.Exp(w[14]*(1-r)))
+		} else {
+			bonus := 1.0
+			if grade == gradeHard {
+				bonus = w[15]
+			} else if grade == gradeEasy {
+				bonus = w[16]
+			}
+			st.Stability = s * (1 + math.Exp(w[8])*(11-d)*math.Pow(s, -w[9])*(math.Exp(w[10]*(1-r))-1)*bonus)
+		}
+		// возврат к среднему в FSRS-4.5 — к начальной сложности оценки «Хорошо»
+		next := d - w[6]*float64(grade-3)
+		st.Difficulty = min(max(w[7]*fsrsInitDifficulty(gradeGood)+(1-w[7])*next, 1), 10)
+	}
+	if grade != gradeAgain {
+		st.Interval = math.Round(st.Stability / fsrsFactor * (math.Pow(fsrsRetention, 1/fsrsDecay) - 1))
+	}
+}
+
+// flashcardIntervalLabel — через сколько карточка вернётся при оценке: «10 мин», «3 дн.», «2 мес.».
+func flashcardIntervalLabel(st FlashcardState, grade int, now time.Time) string {
+	scheduleFlashcard(&st, grade, now)
+	d := st.Due.Sub(now)
+	days := d.Hours() / 24
+	switch {
+	case d < time.Hour:
+		return strconv.Itoa(int(math.Round(d.Minutes()))) + " мин"
+	case days < 30:
+		return strconv.Itoa(int(math.Round(days))) + " дн."
+	case days < 365:
+		return strconv.Itoa(int(math.Round(days/30))) + " мес."
+	}
+	return strings.Replace(strconv.FormatFloat(days/365, 'f', 1, 64), ".", ",", 1) + " г."
+}
+
+///////////////////////////////////////////////////////
+// ОЧЕРЕДЬ
+///////////////////////////////////////////////////////
+
+// flashcardDeck — колода глазами ученика (для плеера курса).
+type flashcardDeck struct {
+	Total   int
+	New     int // ещё не показывались
+	Due     int // пора повторить
+	Learned int // интервал от 21 дня
+}
+
+func flashcardDeckStats(userID uint, blk *Block, now time.Time) (*flashcardDeck, error) {
+	p := blk.Data.(*flashcardsPayload)
+	var states []FlashcardState
+	if err := db.Where("user_id = ? AND block_id = ?", userID, blk.ID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+	byCard := make(map[string]*FlashcardState, len(states))
+	for i := range states {
+		byCard[states[i].CardID] = &states[i]
+	}
+	d := &flashcardDeck{Total: len(p.Cards)}
+	for _, card := range p.Cards {
+		st := byCard[card.ID]
+		switch {
+		case st == nil:
+			d.New++
+		case !st.Due.After(now):
+			d.Due++
+		case st.Interval >= 21:
+			d.Learned++
+		}
+	}
+	return d, nil
+}
+
+// flashcardItem — карточка в очереди повторения; State == nil — новая.
+type flashcardItem struct {
+	Block *Block
+	Card  flashcard
+	State *FlashcardState
+}
+
+type flashcardQueue struct {
+	Items    []flashcardItem
+	Due, New int
+	NextDue  *time.Time // ближайший показ после очереди (карточка «Забыл» вернётся через 10 минут)
+}
+
+// startOfDay — полночь дня t по часовому поясу сервера (TZ): от неё считаются лимит новых
+// карточек и серия дней.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// loadFlashcardQueue — карточки к повторению: сначала просроченные (самые давние первыми),
+// потом новые. blockID > 0 — одна колода, новые без дневного лимита (ученик открыл её сам);
+// иначе — все колоды начатых курсов.
+func loadFlashcardQueue(userID, blockID uint, now time.Time) (*flashcardQueue, error) {
+	q := db.Preload("Module.Course").Where("type = ?", flashcardsBlock.Type)
+	if blockID > 0 {
+		q = q.Where("id = ?", blockID)
+	} else {
+		courseIDs, err := userCourseIDs(userID)
+		if err != nil || len(courseIDs) == 0 {
+			return &flashcardQueue{}, err
+		}
+		q = q.Where("module_id IN (SELECT id FROM modules WHERE course_id IN ?)", courseIDs)
+	}
+	var blocks []Block
+	if err := q.Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		a, b := blocks[i].Module, blocks[j].Module
+		if a.CourseID != b.CourseID {
+			return a.CourseID < b.CourseID
+		}
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return blocks[i].Order < blocks[j].Order
+	})
+
+	blockIDs := make([]uint, len(blocks))
+	for i := range blocks {
+		blockIDs[i] = blocks[i].ID
+	}
+	var states []FlashcardState
+	if len(blockIDs) > 0 {
+		if err := db.Where("user_id = ? AND block_id IN ?", userID, blockIDs).Find(&states).Error; err != nil {
+			return nil, err
+		}
+	}
+	byCard := make(map[string]*FlashcardState, len(states))
+	for i := range states {
+		byCard[fmt.Sprintf("%d/%s", states[i].BlockID, states[i].CardID)] = &states[i]
+	}
+
+	newLimit := -1
+	if blockID == 0 {
+		var introduced int64
+		if err := db.Model(&FlashcardState{}).
+			Where("user_id = ? AND created_at >= ?", userID, startOfDay(now)).
+			Count(&introduced).Error; err != nil {
+			return nil, err
+		}
+		newLimit = max(envInt("FLASHCARDS_NEW_PER_DAY", 20)-int(introduced), 0)
+	}
+
+	res := &flashcardQueue{}
+	var due, fresh []flashcardItem
+	for i := range blocks {
+		blk := &blocks[i]
+		decodeBlockData(blk)
+		for _, card := range blk.Data.(*flashcardsPayload).Cards {
+			st := byCard[fmt.Sprintf("%d/%s", blk.ID, card.ID)]
+			switch {
+			case st == nil:
+				if newLimit < 0 || len(fresh) < newLimit {
+					fresh = append(fresh, flashcardItem{Block: blk, Card: card})
+				}
+			case !st.Due.After(now):
+				due = append(due, flashcardItem{Block: blk, Card: card, State: st})
+			case res.NextDue == nil || st.Due.Before(*res.NextDue):
+				res.NextDue = &st.Due
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].State.Due.Before(due[j].State.Due) })
+	res.Items = append(due, fresh...)
+	res.Due, res.New = len(due), len(fresh)
+	return res, nil
+}
+
+// reviewFlashcard записывает ответ: расписание и строку журнала — в одной транзакции.
+// deckCompleted — этим ответом колода пройдена (первый ответ на последнюю неотвеченную карточку).
+func reviewFlashcard(userID uint, blk *Block, cardID string, grade int, now time.Time) (st *FlashcardState, deckCompleted bool, err error) {
+	st = &FlashcardState{}
+	first := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND block_id = ? AND card_id = ?", userID, blk.ID, cardID).First(st).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			*st = FlashcardState{UserID: userID, BlockID: blk.ID, CardID: cardID}
+			first = true
+		} else if err != nil {
+			return err
+		}
+		scheduleFlashcard(st, grade, now)
+		if err := tx.Save(st).Error; err != nil {
+			return err
+		}
+		return tx.Create(&FlashcardReview{
+			UserID:     userID,
+			BlockID:    blk.ID,
+			CardID:     cardID,
+			Grade:      grade,
+			Interval:   st.Interval,
+			ReviewedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	// колода пройдена, когда у каждой карточки есть расписание, — поменяться это могло только сейчас
+	return st, first && flashcardsBlock.completed(userID, blk.ID), nil
+}
+
+///////////////////////////////////////////////////////
+// СТАТИСТИКА
+///////////////////////////////////////////////////////
+
+// flashcardStats — для панели ученика.
+type flashcardStats struct {
+	Due, New      int // в очереди сейчас
+	Cards         int // карточек в изучении
+	ReviewedToday int
+	Streak        int // дней подряд с повторением, включая сегодня (или по вчера, если сегодня ещё нет)
+	BestStreak    int
+}
+
+func loadFlashcardStats(userID uint, now time.Time) (*flashcardStats, error) {
+	q, err := loadFlashcardQueue(userID, 0, now)
+	if err != nil {
+		return nil, err
+	}
+	s := &flashcardStats{Due: q.Due, New: q.New}
+	var cards int64
+	if err := db.Model(&FlashcardState{}).Where("user_id = ?", userID).Count(&cards).Error; err != nil {
+		return nil, err
+	}
+	s.Cards = int(cards)
+
+	// дни считаются в Go, а не в SQL: граница суток — по часовому поясу сервера, а не БД
+	var times []time.Time
+	if err := db.Model(&FlashcardReview{}).Where("user_id = ?", userID).
+		Order("reviewed_at desc").Pluck("reviewed_at", &times).Error; err != nil {
+		return nil, err
+	}
+	s.ReviewedToday, s.Streak, s.BestStreak = flashcardStreaks(times, now)
+	return s, nil
+}
+
+// flashcardStreaks — ответы за сегодня, текущая и лучшая серия дней по временам ответов (по убыванию).
+func flashcardStreaks(times []time.Time, now time.Time) (today, streak, best int) {
+	day := startOfDay(now)
+	prevDay := func(d time.Time) time.Time { return startOfDay(d.Add(-12 * time.Hour)) }
+	var days []time.Time // по убыванию, без повторов
+	for _, t := range times {
+		d := startOfDay(t.In(now.Location()))
+		if d.Equal(day) {
+			today++
+		}
+		if len(days) == 0 || !d.Equal(days[len(days)-1]) {
+			days = append(days, d)
+		}
+	}
+	run := 0
+	for i, d := range days {
+		if i == 0 || !d.Equal(prevDay(days[i-1])) {
+			run = 0
+		}
+		run++
+		best = max(best, run)
+	}
+	// серия не прервана, пока есть повторение сегодня или вчера
+	if len(days) > 0 && (days[0].Equal(day) || days[0].Equal(prevDay(day))) {
+		streak = 1
+		for i := 1; i < len(days) && days[i].Equal(prevDay(days[i-1])); i++ {
+			streak++
+		}
+	}
+	return today, streak, best
+}
+
+///////////////////////////////////////////////////////
+// СТРАНИЦА ПОВТОРЕНИЯ
+///////////////////////////////////////////////////////
+
+func registerFlashcardRoutes(r *gin.Engine) {
+	g := r.Group("/review", authRequired())
+	{
+		g.GET("", reviewPageHandler)
+		g.POST("", reviewAnswerHandler)
+	}
+}
+
+// reviewPageHandler — первая карточка очереди; ?block=<id> — только эта колода.
+func reviewPageHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	now := time.Now()
+	blockID, _ := strconv.Atoi(c.Query("block"))
+
+	var deck *Block
+	if blockID > 0 {
+		blk, err := loadBlockCourse(uint(blockID))
+		if err != nil || blk.Type != flashcardsBlock.Type {
+			c.String(http.StatusNotFound, "Колода карточек не найдена")
+			return
+		}
+		decodeBlockData(blk)
+		deck = blk
+	}
+
+	queue, err := loadFlashcardQueue(user.ID, uint(max(blockID, 0)), now)
+	if err != nil {
+		log.Printf("flashcards: очередь пользователя %d: %v\n", user.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка загрузки карточек")
+		return
+	}
+
+	data := gin.H{
+		"User":  user,
+		"Deck":  deck,
+		"Queue": queue,
+		"Flash": popFlash(c),
+	}
+	if len(queue.Items) > 0 {
+		item := queue.Items[0]
+		st := FlashcardState{}
+		if item.State != nil {
+			st = *item.State
+		}
+		type gradeButton struct {
+			Grade        int
+			Title, Class string
+			Next         string
+		}
+		var buttons []gradeButton
+		for _, g := range flashcardGrades {
+			buttons = append(buttons, gradeButton{g.Grade, g.Title, g.Class, flashcardIntervalLabel(st, g.Grade, now)})
+		}
+		data["Item"] = item
+		data["Grades"] = buttons
+	}
+	c.HTML(http.StatusOK, "review.html", data)
+}
+
+func reviewAnswerHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	blockID, err := strconv.Atoi(c.PostForm("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return
+	}
+	grade, err := strconv.Atoi(c.PostForm("grade"))
+	if err != nil || grade < gradeAgain || grade > gradeEasy {
+		c.String(http.StatusBadRequest, "Оценка — число от 1 до 4")
+		return
+	}
+	blk, err := loadBlockCourse(uint(blockID))
+	if err != nil || blk.Type != flashcardsBlock.Type {
+		c.String(http.StatusNotFound, "Колода карточек не найдена")
+		return
+	}
+	decodeBlockData(blk)
+	cardID := c.PostForm("card_id")
+	if blk.Data.(*flashcardsPayload).Card(cardID) == nil {
+		// карточку удалили, пока она была на экране — просто показываем следующую
+		setFlash(c, "warning", "Карточку удалили из колоды — ответ не записан")
+	} else if _, deckCompleted, err := reviewFlashcard(user.ID, blk, cardID, grade, time.Now()); err != nil {
+		log.Printf("flashcards: ответ пользователя %d на %d/%s: %v\n", user.ID, blk.ID, cardID, err)
+		c.String(http.StatusInternalServerError, "Ошибка сохранения ответа")
+		return
+	} else if deckCompleted {
+		// колода входит в прогресс курса, как тест или задание
+		checkCourseCompletion(user, blockCourseID(blk))
+	}
+
+	target := "/review"
+	if c.PostForm("deck") != "" {
+		target += "?block=" + strconv.Itoa(blockID)
+	}
+	c.Redirect(http.StatusFound, target)
+}
@@ -0,0 +1,155 @@
+// flashcards_test.go
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type flashcardStep struct {
+	grade      int
+	interval   float64 // дней
+	ease       float64 // SM-2
+	stability  float64 // FSRS
+	difficulty float64 // FSRS
+}
+
+// reviewSequence отвечает на карточку по шагам; каждый ответ — в срок предыдущего показа.
+func reviewSequence(t *testing.T, name string, steps []flashcardStep, check func(i int, st FlashcardState, s flashcardStep)) FlashcardState {
+	var st FlashcardState
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i, s := range steps {
+		scheduleFlashcard(&st, s.grade, now)
+		if st.Interval != s.interval {
+			t.Errorf("%s, шаг %d (оценка %d): интервал %v, ожидается %v", name, i+1, s.grade, st.Interval, s.interval)
+		}
+		check(i, st, s)
+		now = st.Due
+	}
+	return st
+}
+
+// SM-2: 1 → 6 → интервал × ease, ease в пределах от 1,3, «Забыл» начинает интервалы заново.
+func TestSM2Review(t *testing.T) {
+	t.Setenv("FLASHCARDS_SCHEDULER", "sm2")
+	cases := []struct {
+		name   string
+		steps  []flashcardStep
+		lapses int
+	}{
+		{"хорошо подряд", []flashcardStep{
+			{grade: gradeGood, interval: 1, ease: 2.5},
+			{grade: gradeGood, interval: 6, ease: 2.5},
+			{grade: gradeGood, interval: 15, ease: 2.5},
+			{grade: gradeGood, interval: 38, ease: 2.5},
+		}, 0},
+		{"легко подряд", []flashcardStep{
+			{grade: gradeEasy, interval: 1, ease: 2.6},
+			{grade: gradeEasy, interval: 6, ease: 2.7},
+			{grade: gradeEasy, interval: 17, ease: 2.8},
+		}, 0},
+		{"трудно до нижней границы ease", []flashcardStep{
+			{grade: gradeHard, interval: 1, ease: 2.36},
+			{grade: gradeHard, interval: 6, ease: 2.22},
+			{grade: gradeHard, interval: 12, ease: 2.08},
+			{grade: gradeHard, interval: 23, ease: 1.94},
+			{grade: gradeHard, interval: 41, ease: 1.80},
+			{grade: gradeHard, interval: 68, ease: 1.66},
+			{grade: gradeHard, interval: 103, ease: 1.52},
+			{grade: gradeHard, interval: 142, ease: 1.38},
+			{grade: gradeHard, interval: 185, ease: 1.3},
+			{grade: gradeHard, interval: 241, ease: 1.3},
+		}, 0},
+		{"забыл и снова выучил", []flashcardStep{
+			{grade: gradeGood, interval: 1, ease: 2.5},
+			{grade: gradeGood, interval: 6, ease: 2.5},
+			{grade: gradeGood, interval: 15, ease: 2.5},
+			{grade: gradeAgain, interval: 0, ease: 2.5},
+			{grade: gradeGood, interval: 1, ease: 2.5},
+			{grade: gradeGood, interval: 6, ease: 2.5},
+		}, 1},
+	}
+	for _, tc := range cases {
+		st := reviewSequence(t, tc.name, tc.steps, func(i int, st FlashcardState, s flashcardStep) {
+			if math.Abs(st.Ease-s.ease) > 1e-9 {
+				t.Errorf("%s, шаг %d: ease %v, ожидается %v", tc.name, i+1, st.Ease, s.ease)
+			}
+		})
+		if st.Lapses != tc.lapses {
+			t.Errorf("%s: забываний %d, ожидается %d", tc.name, st.Lapses, tc.lapses)
+		}
+	}
+}
+
+// FSRS-4.5 с параметрами по умолчанию: значения посчитаны по формулам open-spaced-repetition
+// (стабильность после успеха и после забывания, сложность с возвратом к D0(«Хорошо»)).
+func TestFSRSReview(t *testing.T) {
+	t.Setenv("FLASHCARDS_SCHEDULER", "fsrs")
+	cases := []struct {
+		name   string
+		steps  []flashcardStep
+		lapses int
+	}{
+		{"хорошо подряд", []flashcardStep{
+			{grade: gradeGood, interval: 4, stability: 3.7145, difficulty: 5.1618},
+			{grade: gradeGood, interval: 15, stability: 14.8081, difficulty: 5.1618},
+			{grade: gradeGood, interval: 49, stability: 49.4616, difficulty: 5.1618},
+			{grade: gradeGood, interval: 146, stability: 145.6706, difficulty: 5.1618},
+			{grade: gradeGood, interval: 393, stability: 392.6979, difficulty: 5.1618},
+		}, 0},
+		{"легко подряд", []flashcardStep{
+			{grade: gradeEasy, interval: 14, stability: 13.8206, difficulty: 3.932},
+			{grade: gradeEasy, interval: 127, stability: 127.4815, difficulty: 3.1004},
+			{grade: gradeEasy, interval: 979, stability: 979.4274, difficulty: 2.2947},
+		}, 0},
+		{"трудно подряд", []flashcardStep{
+			{grade: gradeHard, interval: 1, stability: 1.4003, difficulty: 6.3916},
+			{grade: gradeHard, interval: 2, stability: 1.9898, difficulty: 7.2232},
+			{grade: gradeHard, interval: 3, stability: 2.884, difficulty: 8.0289},
+		}, 0},
+		{"забыл и снова выучил", []flashcardStep{
+			{grade: gradeGood, interval: 4, stability: 3.7145, difficulty: 5.1618},
+			{grade: gradeGood, interval: 15, stability: 14.8081, difficulty: 5.1618},
+			{grade: gradeGood, interval: 49, stability: 49.4616, difficulty: 5.1618},
+			{grade: gradeAgain, interval: 0, stability: 5.5673, difficulty: 6.9012},
+			{grade: gradeGood, interval: 6, stability: 5.5816, difficulty: 6.8472},
+			{grade: gradeGood, interval: 17, stability: 16.7793, difficulty: 6.795},
+		}, 1},
+	}
+	for _, tc := range cases {
+		st := reviewSequence(t, tc.name, tc.steps, func(i int, st FlashcardState, s flashcardStep) {
+			if math.Abs(st.Stability-s.stability) > 1e-3 || math.Abs(st.Difficulty-s.difficulty) > 1e-3 {
+				t.Errorf("%s, шаг %d: S=%.4f D=%.4f, ожидается S=%v D=%v",
+					tc.name, i+1, st.Stability, st.Difficulty, s.stability, s.difficulty)
+			}
+		})
+		if st.Lapses != tc.lapses {
+			t.Errorf("%s: забываний %d, ожидается %d", tc.name, st.Lapses, tc.lapses)
+		}
+	}
+}
+
+// Второй ответ в срок (через 4 дня после первого «Хорошо»): каждая оценка по-своему меняет S и D.
+func TestFSRSSecondReview(t *testing.T) {
+	cases := []struct {
+		grade                 int
+		stability, difficulty float64
+	}{
+		{gradeAgain, 1.4332, 6.9012},
+		{gradeHard, 6.2350, 6.0315},
+		{gradeGood, 14.8081, 5.1618},
+		{gradeEasy, 35.6141, 4.2921},
+	}
+	first := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for _, tc := range cases {
+		var st FlashcardState
+		fsrsReview(&st, gradeGood, first)
+		st.LastReview = first
+		fsrsReview(&st, tc.grade, first.AddDate(0, 0, 4))
+		if math.Abs(st.Stability-tc.stability) > 1e-3 || math.Abs(st.Difficulty-tc.difficulty) > 1e-3 {
+			t.Errorf("оценка %d: S=%.4f D=%.4f, ожидается S=%v D=%v",
+				tc.grade, st.Stability, st.Difficulty, tc.stability, tc.difficulty)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// jsonschema.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON Schema payload блоков строится из Go-структур: имя свойства — тег json, ограничения —
+// тег schema: `schema:"required,enum=embed|file,min=0,max=100,maxlen=200"`. Проверка покрывает
+// то подмножество draft 2020-12, которое дают эти структуры: type, properties, required,
+// additionalProperties, items, enum, minimum/maximum, maxLength.
+
+type jsonSchema = map[string]any
+
+// schemaOf — схема типа. Ошибка в теге schema — ошибка программы, поэтому panic (реестр блоков
+// строится при старте).
+func schemaOf(t reflect.Type) jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := jsonSchema{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s := schemaOf(f.Type)
+			if tag := f.Tag.Get("schema"); tag != "" {
+				for _, opt := range strings.Split(tag, ",") {
+					if opt == "required" {
+						required = append(required, name)
+						continue
+					}
+					if err := schemaOption(s, opt); err != nil {
+						panic(fmt.Sprintf("%s.%s: тег schema: %v", t.Name(), f.Name, err))
+					}
+				}
+			}
+			props[name] = s
+		}
+		s := jsonSchema{"type": "object", "properties": props, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	}
+	panic("schemaOf: тип " + t.String() + " не поддерживается")
+}
+
+func schemaOption(s jsonSchema, opt string) error {
+	key, val, _ := strings.Cut(opt, "=")
+	switch key {
+	case "enum":
+		var enum []any
+		for _, v := range strings.Split(val, "|") {
+			enum = append(enum, v)
+		}
+		s["enum"] = enum
+	case "min", "max":
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		s[map[string]string{"min": "minimum", "max": "maximum"}[key]] = v
+	case "maxlen":
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		s["maxLength"] = v
+	default:
+		return fmt.Errorf("неизвестное ограничение %q", key)
+	}
+	return nil
+}
+
+// validateSchema проверяет значение из json.Unmarshal (map[string]any, []any, float64...)
+// и возвращает нарушения в виде «путь: что не так».
+func validateSchema(s jsonSchema, v any, path string) []string {
+	var out []string
+	bad := func(format string, args ...any) {
+		out = append(out, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch typ, _ := s["type"].(string); typ {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			bad("ожидается объект")
+			return out
+		}
+		props, _ := s["properties"].(jsonSchema)
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := m[name]; !ok {
+				out = append(out, path+"."+name+": обязательное поле")
+			}
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(jsonSchema); ok {
+				out = append(out, validateSchema(ps, m[k], path+"."+k)...)
+				continue
+			}
+			switch ap := s["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					out = append(out, path+"."+k+": неизвестное поле")
+				}
+			case jsonSchema:
+				out = append(out, validateSchema(ap, m[k], path+"."+k)...)
+			}
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			bad("ожидается массив")
+			return out
+		}
+		if items, ok := s["items"].(jsonSchema); ok {
+			for i, it := range a {
+				out = append(out, validateSchema(items, it, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			bad("ожидается строка")
+			return out
+		}
+		if n, ok := s["maxLength"].(int); ok && utf8.RuneCountInString(str) > n {
+			bad("длиннее %d символов", n)
+		}
+	case "number", "integer":
+		f, ok := v.(float64)
+		if !ok {
+			bad("ожидается число")
+			return out
+		}
+		if typ == "integer" && f != math.Trunc(f) {
+			bad("ожидается целое число")
+		}
+		if min, ok := s["minimum"].(float64); ok && f < min {
+			bad("не меньше %v", min)
+		}
+		if max, ok := s["maximum"].(float64); ok && f > max {
+			bad("не больше %v", max)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			bad("ожидается true или false")
+		}
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		allowed := make([]string, 0, len(enum))
+		for _, e := range enum {
+			if e == v {
+				return out
+			}
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		bad("допустимо: %s", strings.Join(allowed, ", "))
+	}
+	return out
+}
@@ -0,0 +1,313 @@
+// login_throttle.go
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// throttlePolicy — сколько неудач допускается за окно и как растёт блокировка.
+type throttlePolicy struct {
+	MaxFailures int
+	Window      time.Duration // неудачи старше окна забываются
+	LockoutBase time.Duration // первая блокировка; каждая следующая ×2
+	LockoutMax  time.Duration
+}
+
+var (
+	accountPolicy  throttlePolicy // по email
+	ipPolicy       throttlePolicy // по IP (мягче: за одним NAT много людей)
+	registerPolicy throttlePolicy // регистрации с одного IP
+
+	loginLimiter LoginLimiter
+)
+
+// LoginLimiter хранит счётчики неудач. Реализации: в памяти (один инстанс) и в Postgres (несколько).
+type LoginLimiter interface {
+	// Check возвращает, сколько ещё длится блокировка ключа (0 — можно пробовать).
+	Check(key string) (time.Duration, error)
+	// Fail учитывает неудачу и возвращает длительность блокировки, если она наступила.
+	Fail(key string, p throttlePolicy) (time.Duration, error)
+	// Reset сбрасывает счётчики (успешный вход, ручная разблокировка).
+	Reset(key string) error
+	// Locked — все ключи, заблокированные прямо сейчас.
+	Locked() ([]AuthThrottle, error)
+}
+
+func initLoginLimiter() {
+	window := envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	base := envDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	maxLock := envDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+
+	accountPolicy = throttlePolicy{
+		MaxFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		Window:      window,
+		LockoutBase: base,
+		LockoutMax:  maxLock,
+	}
+	ipPolicy = throttlePolicy{
+		MaxFailures: envInt("LOGIN_IP_MAX_FAILURES", 20),
+		Window:      window,
+		LockoutBase: base,
+		LockoutMax:  maxLock,
+	}
+	registerPolicy = throttlePolicy{
+		MaxFailures: envInt("REGISTER_MAX_PER_HOUR", 10),
+		Window:      time.Hour,
+		LockoutBase: time.Hour,
+		LockoutMax:  24 * time.Hour,
+	}
+
+	switch envOr("RATELIMIT_BACKEND", "memory") {
+	case "memory":
+		loginLimiter = newMemoryLimiter()
+	case "postgres":
+		loginLimiter = pgLimiter{}
+	default:
+		log.Fatalf("RATELIMIT_BACKEND: неизвестное значение %q (memory|postgres)", os.Getenv("RATELIMIT_BACKEND"))
+	}
+}
+
+// fail — общая для обеих реализаций логика счётчика с экспоненциальной блокировкой.
+func (t *AuthThrottle) fail(now time.Time, p throttlePolicy) time.Duration {
+	// долго было тихо — прощаем прошлые блокировки
+	if !t.UpdatedAt.IsZero() && now.Sub(t.UpdatedAt) > 24*time.Hour {
+		t.Lockouts = 0
+	}
+	if now.Sub(t.WindowStart) > p.Window {
+		t.Failures = 0
+		t.WindowStart = now
+	}
+	t.Failures++
+	t.UpdatedAt = now
+
+	if t.Failures < p.MaxFailures {
+		return 0
+	}
+
+	t.Lockouts++
+	d := p.LockoutBase
+	for i := 1; i < t.Lockouts && d < p.LockoutMax; i++ {
+		d *= 2
+	}
+	if d > p.LockoutMax {
+		d = p.LockoutMax
+	}
+	t.Failures = 0
+	t.WindowStart = now
+	t.LockedUntil = now.Add(d)
+	return d
+}
+
+func (t AuthThrottle) remaining(now time.Time) time.Duration {
+	if t.LockedUntil.After(now) {
+		return t.LockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// ---------- in-memory ----------
+
+type memoryLimiter struct {
+	mu    sync.Mutex
+	state map[string]*AuthThrottle
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{state: map[string]*AuthThrottle{}}
+}
+
+func (m *memoryLimiter) Check(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.state[key]; ok {
+		return t.remaining(time.Now()), nil
+	}
+	return 0, nil
+}
+
+func (m *memoryLimiter) Fail(key string, p throttlePolicy) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.gc(now)
+
+	t, ok := m.state[key]
+	if !ok {
+		t = &AuthThrottle{Key: key}
+		m.state[key] = t
+	}
+	return t.fail(now, p), nil
+}
+
+func (m *memoryLimiter) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.state, key)
+	return nil
+}
+
+func (m *memoryLimiter) Locked() ([]AuthThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var res []AuthThrottle
+	for _, t := range m.state {
+		if t.remaining(now) > 0 {
+			res = append(res, *t)
+		}
+	}
+	return res, nil
+}
+
+// gc выкидывает давно неактивные ключи, чтобы карта не росла бесконечно.
+func (m *memoryLimiter) gc(now time.Time) {
+	for k, t := range m.state {
+		if t.remaining(now) == 0 && now.Sub(t.UpdatedAt) > 24*time.Hour {
+			delete(m.state, k)
+		}
+	}
+}
+
+// ---------- Postgres ----------
+
+type pgLimiter struct{}
+
+func (pgLimiter) Check(key string) (time.Duration, error) {
+	var t AuthThrottle
+	err := db.Where("throttle_key = ?", key).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return t.remaining(time.Now()), nil
+}
+
+func (pgLimiter) Fail(key string, p throttlePolicy) (time.Duration, error) {
+	var locked time.Duration
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// строка может ещё не существовать — создаём, затем берём под блокировку
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&AuthThrottle{Key: key, WindowStart: time.Now()}).Error; err != nil {
+			return err
+		}
+		var t AuthThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("throttle_key = ?", key).First(&t).Error; err != nil {
+			return err
+		}
+		locked = t.fail(time.Now(), p)
+		return tx.Save(&t).Error
+	})
+	return locked, err
+}
+
+func (pgLimiter) Reset(key string) error {
+	return db.Where("throttle_key = ?", key).Delete(&AuthThrottle{}).Error
+}
+
+func (pgLimiter) Locked() ([]AuthThrottle, error) {
+	var res []AuthThrottle
+	err := db.Where("locked_until > ?", time.Now()).Order("locked_until desc").Find(&res).Error
+	return res, err
+}
+
+// ---------- использование в обработчиках ----------
+
+func accountThrottleKey(email string) string {
+	return "acct:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string { return "ip:" + ip }
+
+// loginBlockedFor — сколько ещё ждать перед попыткой входа (берём максимум по IP и аккаунту).
+func loginBlockedFor(ip, email string) time.Duration {
+	var wait time.Duration
+	for _, key := range []string{ipThrottleKey(ip), accountThrottleKey(email)} {
+		d, err := loginLimiter.Check(key)
+		if err != nil {
+			log.Printf("login throttle: %v\n", err)
+			continue
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure учитывает неудачу в лимитере и пишет её в историю.
+func recordLoginFailure(ip, email, userAgent, reason string) {
+	if _, err := loginLimiter.Fail(ipThrottleKey(ip), ipPolicy); err != nil {
+		log.Printf("login throttle: %v\n", err)
+	}
+	if email != "" {
+		d, err := loginLimiter.Fail(accountThrottleKey(email), accountPolicy)
+		if err != nil {
+			log.Printf("login throttle: %v\n", err)
+		}
+		if d > 0 {
+			log.Printf("login throttle: %s заблокирован на %s\n", email, d)
+		}
+	}
+	logLoginAttempt(ip, email, userAgent, false, reason)
+}
+
+// loginPasswordOK — причина в истории входов: пароль верен, ждём второй фактор.
+const loginPasswordOK = "password_ok"
+
+// recordLoginSuccess сбрасывает счётчик аккаунта и пишет успех — только когда вход завершён
+// (после второго фактора, если он нужен).
+func recordLoginSuccess(ip, email, userAgent string) {
+	if err := loginLimiter.Reset(accountThrottleKey(email)); err != nil {
+		log.Printf("login throttle: %v\n", err)
+	}
+	logLoginAttempt(ip, email, userAgent, true, "")
+}
+
+func logLoginAttempt(ip, email, userAgent string, success bool, reason string) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	a := LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		IP:        ip,
+		UserAgent: userAgent,
+		Success:   success,
+		Reason:    reason,
+	}
+	if err := db.Create(&a).Error; err != nil {
+		log.Printf("login attempt log: %v\n", err)
+	}
+}
+
+// registerBlockedFor — лимит регистраций с одного IP; каждая попытка считается.
+func registerBlockedFor(ip string) time.Duration {
+	key := "reg:" + ip
+	if d, err := loginLimiter.Check(key); err == nil && d > 0 {
+		return d
+	}
+	if _, err := loginLimiter.Fail(key, registerPolicy); err != nil {
+		log.Printf("register throttle: %v\n", err)
+	}
+	return 0
+}
+
+// humanDuration — «3 мин», «45 сек» для сообщений пользователю.
+func humanDuration(d time.Duration) string {
+	if d >= time.Minute {
+		return strconv.Itoa(int((d+time.Minute-1)/time.Minute)) + " мин"
+	}
+	return strconv.Itoa(int((d+time.Second-1)/time.Second)) + " сек"
+}
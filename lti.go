@@ -0,0 +1,961 @@
+// lti.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LTI 1.3: TrainBrain — инструмент (tool), LMS партнёра — платформа. Вход идёт по OIDC
+// (третья сторона инициирует вход → редирект на платформу → id_token на /lti/launch),
+// оценки за тесты уходят в журнал платформы через Assignment and Grade Services (AGS).
+
+const (
+	ltiVersion = "1.3.0"
+
+	ltiMsgResourceLink = "LtiResourceLinkRequest"
+	ltiMsgDeepLinking  = "LtiDeepLinkingRequest"
+
+	ltiScopeScore = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+	ltiStateTTL    = 10 * time.Minute
+	ltiDeepLinkTTL = time.Hour
+	ltiJWKSTTL     = time.Hour
+)
+
+const (
+	ltiGradeNone    = "none" // ссылку запускали, оценки ещё нет
+	ltiGradePending = "pending"
+	ltiGradeSent    = "sent"
+	ltiGradeFailed  = "failed"
+)
+
+// AuthSourceLTI — аккаунт создан при первом запуске из LMS; пароля нет, входит только через платформу.
+const AuthSourceLTI = "lti"
+
+func ltiLaunchURL() string { return appBaseURL() + "/lti/launch" }
+
+func (p LTIPlatform) DeploymentList() []string { return strings.Fields(p.DeploymentIDs) }
+
+///////////////////////////////////////////////////////
+// КЛЮЧ ИНСТРУМЕНТА
+///////////////////////////////////////////////////////
+
+var ltiKeyState struct {
+	sync.Mutex
+	key *rsa.PrivateKey
+	kid string
+}
+
+// ltiToolKey — ключ из LTI_PRIVATE_KEY_FILE (PEM, PKCS#1 или PKCS#8) либо сгенерированный
+// при первом обращении и сохранённый в БД, чтобы все экземпляры подписывали одним ключом.
+func ltiToolKey() (*rsa.PrivateKey, string, error) {
+	ltiKeyState.Lock()
+	defer ltiKeyState.Unlock()
+	if ltiKeyState.key != nil {
+		return ltiKeyState.key, ltiKeyState.kid, nil
+	}
+
+	var pemData []byte
+	if path := envOr("LTI_PRIVATE_KEY_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("LTI_PRIVATE_KEY_FILE: %w", err)
+		}
+		pemData = data
+	} else {
+		var row LTIKey
+		err := db.Order("id").First(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			row, err = ltiGenerateKey()
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		pemData = []byte(row.PrivateKeyPEM)
+	}
+
+	key, err := parseRSAPrivateKey(pemData)
+	if err != nil {
+		return nil, "", err
+	}
+	ltiKeyState.key = key
+	ltiKeyState.kid = rsaKeyID(&key.PublicKey)
+	return ltiKeyState.key, ltiKeyState.kid, nil
+}
+
+func ltiGenerateKey() (LTIKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return LTIKey{}, err
+	}
+	row := LTIKey{
+		Kid:           rsaKeyID(&key.PublicKey),
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}
+	if err := db.Create(&row).Error; err != nil {
+		return LTIKey{}, err
+	}
+	// другой экземпляр мог успеть раньше — берём самый первый ключ
+	err = db.Order("id").First(&row).Error
+	return row, err
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("ключ LTI: не PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ключ LTI: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("ключ LTI: нужен RSA")
+	}
+	return key, nil
+}
+
+// rsaKeyID — kid из отпечатка публичного ключа: одинаковый на всех экземплярах.
+func rsaKeyID(pub *rsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+func ltiToolJWKS() (jwkSet, error) {
+	key, kid, err := ltiToolKey()
+	if err != nil {
+		return jwkSet{}, err
+	}
+	return jwkSet{Keys: []jwk{{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}, nil
+}
+
+// ltiToolPublicKeyPEM — для платформ, которые принимают ключ, а не адрес JWKS.
+func ltiToolPublicKeyPEM() (string, error) {
+	key, _, err := ltiToolKey()
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ltiSign подписывает JWT ключом инструмента (RS256, kid в заголовке).
+func ltiSign(claims jwt.MapClaims) (string, error) {
+	key, kid, err := ltiToolKey()
+	if err != nil {
+		return "", err
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	return tok.SignedString(key)
+}
+
+///////////////////////////////////////////////////////
+// КЛЮЧИ ПЛАТФОРМ
+///////////////////////////////////////////////////////
+
+type ltiKeySet struct {
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+var ltiPlatformKeys = struct {
+	sync.Mutex
+	byPlatform map[uint]*ltiKeySet
+}{byPlatform: map[uint]*ltiKeySet{}}
+
+// ltiPlatformKey ищет ключ платформы по kid. Незнакомый kid — повод перечитать JWKS
+// (платформа сменила ключ), но не чаще раза в 30 секунд.
+func ltiPlatformKey(p *LTIPlatform, kid string) (*rsa.PublicKey, error) {
+	ltiPlatformKeys.Lock()
+	defer ltiPlatformKeys.Unlock()
+
+	set := ltiPlatformKeys.byPlatform[p.ID]
+	if set != nil && time.Since(set.fetchedAt) < ltiJWKSTTL {
+		if key := set.find(kid); key != nil {
+			return key, nil
+		}
+		if time.Since(set.fetchedAt) < 30*time.Second {
+			return nil, fmt.Errorf("ключ %q не найден в JWKS платформы", kid)
+		}
+	}
+
+	fresh, err := fetchJWKS(p.KeySetURL)
+	if err != nil {
+		return nil, err
+	}
+	ltiPlatformKeys.byPlatform[p.ID] = fresh
+	if key := fresh.find(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ключ %q не найден в JWKS платформы", kid)
+}
+
+func (s *ltiKeySet) find(kid string) *rsa.PublicKey {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k
+		}
+	}
+	return s.keys[kid]
+}
+
+// forgetPlatformKeys — после правки платформы (мог смениться адрес JWKS).
+func forgetPlatformKeys(platformID uint) {
+	ltiPlatformKeys.Lock()
+	delete(ltiPlatformKeys.byPlatform, platformID)
+	ltiPlatformKeys.Unlock()
+}
+
+func fetchJWKS(u string) (*ltiKeySet, error) {
+	client := &http.Client{Timeout: ltiCfg.Timeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS платформы: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS платформы: HTTP %d", resp.StatusCode)
+	}
+	var set jwkSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("JWKS платформы: %w", err)
+	}
+
+	out := &ltiKeySet{keys: map[string]*rsa.PublicKey{}, fetchedAt: time.Now()}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			continue
+		}
+		out.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(out.keys) == 0 {
+		return nil, errors.New("JWKS платформы: нет RSA-ключей")
+	}
+	return out, nil
+}
+
+///////////////////////////////////////////////////////
+// ПРОВЕРКА id_token
+///////////////////////////////////////////////////////
+
+// ltiClaims — нужные нам утверждения id_token (LTI Core 1.3, AGS 2.0, Deep Linking 2.0).
+type ltiClaims struct {
+	Subject         string `json:"sub"`
+	Email           string `json:"email"`
+	Name            string `json:"name"`
+	GivenName       string `json:"given_name"`
+	FamilyName      string `json:"family_name"`
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+
+	MessageType   string   `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version       string   `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID  string   `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI string   `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	Roles         []string `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	ResourceLink  struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link"`
+	Context struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/context"`
+	Custom map[string]any `json:"https://purl.imsglobal.org/spec/lti/claim/custom"`
+
+	AGS *struct {
+		Scope     []string `json:"scope"`
+		LineItems string   `json:"lineitems"`
+		LineItem  string   `json:"lineitem"`
+	} `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"`
+
+	DeepLinking *struct {
+		ReturnURL      string   `json:"deep_link_return_url"`
+		AcceptTypes    []string `json:"accept_types"`
+		AcceptMultiple bool     `json:"accept_multiple"`
+		Data           string   `json:"data"`
+	} `json:"https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings"`
+}
+
+func (cl *ltiClaims) FullName() string {
+	if cl.Name != "" {
+		return cl.Name
+	}
+	return strings.TrimSpace(cl.GivenName + " " + cl.FamilyName)
+}
+
+// canPostScores — платформа выдала колонку журнала и право выставлять в неё оценки.
+func (cl *ltiClaims) canPostScores() bool {
+	if cl.AGS == nil || cl.AGS.LineItem == "" {
+		return false
+	}
+	for _, s := range cl.AGS.Scope {
+		if s == ltiScopeScore {
+			return true
+		}
+	}
+	return false
+}
+
+// customUint — custom-параметр ссылки (course_id, block_id); платформы присылают их строками.
+func (cl *ltiClaims) customUint(name string) uint {
+	switch v := cl.Custom[name].(type) {
+	case string:
+		n, _ := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		return uint(n)
+	case float64:
+		if v > 0 {
+			return uint(v)
+		}
+	}
+	return 0
+}
+
+// ltiValidateIDToken проверяет подпись id_token ключом платформы и обязательные утверждения:
+// iss, aud/azp, exp/iat, nonce из нашего состояния, версию и deployment_id.
+func ltiValidateIDToken(p *LTIPlatform, raw, nonce string) (*ltiClaims, error) {
+	tok, err := jwt.Parse(raw, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return ltiPlatformKey(p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	mc, _ := tok.Claims.(jwt.MapClaims)
+	aud, _ := mc.GetAudience()
+	raw2, err := json.Marshal(mc)
+	if err != nil {
+		return nil, err
+	}
+	var cl ltiClaims
+	if err := json.Unmarshal(raw2, &cl); err != nil {
+		return nil, fmt.Errorf("утверждения id_token: %w", err)
+	}
+
+	if len(aud) > 1 && cl.AuthorizedParty != p.ClientID {
+		return nil, errors.New("azp не совпадает с client_id")
+	}
+	if cl.Nonce == "" || cl.Nonce != nonce {
+		return nil, errors.New("nonce не совпадает")
+	}
+	if cl.Version != ltiVersion {
+		return nil, fmt.Errorf("неподдерживаемая версия LTI %q", cl.Version)
+	}
+	if cl.DeploymentID == "" {
+		return nil, errors.New("нет deployment_id")
+	}
+	if allowed := p.DeploymentList(); len(allowed) > 0 {
+		ok := false
+		for _, d := range allowed {
+			if d == cl.DeploymentID {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("deployment_id %q не зарегистрирован", cl.DeploymentID)
+		}
+	}
+	return &cl, nil
+}
+
+///////////////////////////////////////////////////////
+// ПОЛЬЗОВАТЕЛИ
+///////////////////////////////////////////////////////
+
+// ltiProvisionUser находит или создаёт аккаунт для пользователя платформы.
+// Роль всегда student: роли LMS (в т.ч. Instructor) прав в TrainBrain не дают.
+// С существующим аккаунтом по email связываем, только если платформе доверяют (TrustEmail),
+// и никогда — с администраторами и сервисными аккаунтами.
+func ltiProvisionUser(p *LTIPlatform, cl *ltiClaims) (*User, error) {
+	if cl.Subject == "" {
+		return nil, errors.New("нет sub")
+	}
+
+	var link LTIUserLink
+	err := db.Preload("User").Where("platform_id = ? AND subject = ?", p.ID, cl.Subject).First(&link).Error
+	if err == nil {
+		db.Model(&link).Update("last_launch_at", time.Now())
+		u := link.User
+		if u.FullName == "" && cl.FullName() != "" {
+			db.Model(&u).Update("full_name", cl.FullName())
+		}
+		return &u, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(cl.Email))
+	var user User
+	found := false
+	if p.TrustEmail && email != "" {
+		err := db.Where("email = ? AND erased_at IS NULL AND role <> ? AND auth_source <> ?", email, "admin", AuthSourceService).
+			First(&user).Error
+		found = err == nil
+	}
+
+	created := false
+	if !found {
+		var taken int64
+		if email != "" {
+			db.Model(&User{}).Where("email = ?", email).Count(&taken)
+		}
+		if email == "" || taken > 0 {
+			// адрес не прислали или он занят чужим аккаунтом — служебный, уникальный для платформы
+			sum := sha256.Sum256([]byte(cl.Subject))
+			email = "lti-" + strconv.Itoa(int(p.ID)) + "-" + hex.EncodeToString(sum[:8]) + "@lti.invalid"
+		}
+		user = User{
+			Email:        email,
+			PasswordHash: "!", // не bcrypt — пароль задать нельзя
+			Role:         "student",
+			FullName:     cl.FullName(),
+			AuthSource:   AuthSourceLTI,
+			ExternalID:   cl.Subject,
+			CreatedAt:    time.Now(),
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return nil, err
+		}
+		created = true
+	}
+
+	link = LTIUserLink{PlatformID: p.ID, Subject: cl.Subject, UserID: user.ID, LastLaunchAt: time.Now()}
+	if err := db.Create(&link).Error; err != nil {
+		// параллельный запуск успел создать связь — берём её
+		if created {
+			db.Delete(&User{}, user.ID)
+		}
+		if err := db.Preload("User").Where("platform_id = ? AND subject = ?", p.ID, cl.Subject).First(&link).Error; err != nil {
+			return nil, err
+		}
+		return &link.User, nil
+	}
+	if created {
+		emitUserRegistered(&user)
+	}
+	return &user, nil
+}
+
+// ltiTarget — куда ведёт ссылка: custom-параметры из deep linking, иначе ?course=&block= в target_link_uri.
+func ltiTarget(cl *ltiClaims) (courseID uint, blockID *uint) {
+	courseID = cl.customUint("course_id")
+	bid := cl.customUint("block_id")
+	if courseID == 0 && bid == 0 {
+		if u, err := url.Parse(cl.TargetLinkURI); err == nil {
+			n, _ := strconv.ParseUint(u.Query().Get("course"), 10, 64)
+			courseID = uint(n)
+			n, _ = strconv.ParseUint(u.Query().Get("block"), 10, 64)
+			bid = uint(n)
+		}
+	}
+	if bid != 0 {
+		if blk, err := loadBlockCourse(bid); err == nil {
+			return blk.Module.CourseID, &blk.ID
+		}
+	}
+	if courseID != 0 {
+		var cnt int64
+		db.Model(&Course{}).Where("id = ?", courseID).Count(&cnt)
+		if cnt > 0 {
+			return courseID, nil
+		}
+	}
+	return 0, nil
+}
+
+// ltiRecordLaunch сохраняет ссылку LMS и отмечает, что пользователь её запускал:
+// без этого оценка не может попасть в журнал платформы.
+func ltiRecordLaunch(p *LTIPlatform, cl *ltiClaims, u *User, courseID uint, blockID *uint) {
+	if cl.ResourceLink.ID == "" || courseID == 0 {
+		return
+	}
+	link := LTIResourceLink{
+		PlatformID:     p.ID,
+		ResourceLinkID: cl.ResourceLink.ID,
+		DeploymentID:   cl.DeploymentID,
+		ContextTitle:   cl.Context.Title,
+		Title:          cl.ResourceLink.Title,
+		CourseID:       courseID,
+		BlockID:        blockID,
+	}
+	if cl.canPostScores() {
+		link.LineItemURL = cl.AGS.LineItem
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "platform_id"}, {Name: "resource_link_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"deployment_id", "context_title", "title", "course_id", "block_id", "line_item_url", "updated_at"}),
+	}).Create(&link).Error
+	if err == nil {
+		err = db.Where("platform_id = ? AND resource_link_id = ?", p.ID, cl.ResourceLink.ID).First(&link).Error
+	}
+	if err != nil {
+		log.Printf("lti: ссылка %s: %v\n", cl.ResourceLink.ID, err)
+		return
+	}
+
+	grade := LTIGrade{ResourceLinkID: link.ID, UserID: u.ID, Status: ltiGradeNone}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&grade).Error; err != nil {
+		log.Printf("lti: оценка %d/%d: %v\n", link.ID, u.ID, err)
+		return
+	}
+	// тест могли пройти раньше, до первого запуска из LMS
+	if link.LineItemURL != "" {
+		ltiRefreshGrades(u.ID, "lti_grades.resource_link_id = ?", link.ID)
+	}
+}
+
+///////////////////////////////////////////////////////
+// ОЦЕНКИ (AGS)
+///////////////////////////////////////////////////////
+
+// ltiQueueScores — после попытки теста или сдачи задания: пересчитать оценки по ссылкам
+// на этот блок и на курс целиком.
+func ltiQueueScores(u *User, blk *Block) {
+	courseID := blockCourseID(blk)
+	ltiRefreshGrades(u.ID, "(lti_resource_links.block_id = ? OR (lti_resource_links.block_id IS NULL AND lti_resource_links.course_id = ?))", blk.ID, courseID)
+}
+
+// ltiRefreshGrades ставит в очередь изменившиеся оценки пользователя по подходящим ссылкам.
+// Для блока-теста оценка — лучший результат, для курса — процент выполнения.
+func ltiRefreshGrades(userID uint, where string, args ...any) {
+	var grades []LTIGrade
+	if err := db.Select("lti_grades.*").Preload("ResourceLink").
+		Joins("JOIN lti_resource_links ON lti_resource_links.id = lti_grades.resource_link_id").
+		Where("lti_grades.user_id = ? AND lti_resource_links.line_item_url <> ''", userID).
+		Where(where, args...).
+		Find(&grades).Error; err != nil {
+		log.Printf("lti: оценки пользователя %d: %v\n", userID, err)
+		return
+	}
+
+	for _, g := range grades {
+		score, ok := ltiScoreFor(userID, &g.ResourceLink)
+		if !ok || (g.Status != ltiGradeNone && g.ScoreGiven == score) {
+			continue
+		}
+		if err := db.Model(&LTIGrade{}).Where("id = ?", g.ID).Updates(map[string]any{
+			"score_given":     score,
+			"status":          ltiGradePending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"last_error":      "",
+		}).Error; err != nil {
+			log.Printf("lti: оценка %d: %v\n", g.ID, err)
+			continue
+		}
+		wakeLTIWorker()
+	}
+}
+
+func ltiScoreFor(userID uint, link *LTIResourceLink) (float64, bool) {
+	if link.BlockID != nil {
+		var sa ScormAttempt
+		if db.Where("user_id = ? AND block_id = ?", userID, *link.BlockID).First(&sa).Error == nil {
+			switch {
+			case sa.Score != nil:
+				return *sa.Score, true
+			case sa.Completed: // пакет без балла — засчитываем завершение
+				return 100, true
+			}
+			return 0, false
+		}
+		var best struct{ Score *float64 }
+		db.Model(&QuizAttempt{}).Select("MAX(score) AS score").
+			Where("user_id = ? AND block_id = ?", userID, *link.BlockID).Scan(&best)
+		if best.Score == nil {
+			return 0, false
+		}
+		return *best.Score, true
+	}
+
+	var course Course
+	if err := db.Preload("Modules.Blocks").First(&course, link.CourseID).Error; err != nil {
+		return 0, false
+	}
+	p := courseProgress(userID, &course)
+	if p.Graded == 0 || p.Completed == 0 {
+		return 0, false
+	}
+	return p.Percent, true
+}
+
+// ltiConfig — LTI_POLL_INTERVAL, LTI_TIMEOUT, LTI_MAX_ATTEMPTS.
+type ltiConfig struct {
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+}
+
+var ltiCfg = ltiConfig{Timeout: 10 * time.Second}
+
+var ltiWake = make(chan struct{}, 1)
+
+func wakeLTIWorker() {
+	select {
+	case ltiWake <- struct{}{}:
+	default:
+	}
+}
+
+// startLTIWorker отправляет оценки в журналы платформ и чистит просроченные состояния входа.
+func startLTIWorker() {
+	ltiCfg = ltiConfig{
+		PollInterval: envDuration("LTI_POLL_INTERVAL", 30*time.Second),
+		Timeout:      envDuration("LTI_TIMEOUT", 10*time.Second),
+		MaxAttempts:  envInt("LTI_MAX_ATTEMPTS", 8),
+	}
+	client := &http.Client{Timeout: ltiCfg.Timeout}
+
+	go func() {
+		ticker := time.NewTicker(ltiCfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			db.Where("expires_at < ?", now).Delete(&LTILaunchState{})
+			db.Where("expires_at < ?", now).Delete(&LTIDeepLinkRequest{})
+			for {
+				n, err := sendLTIGrades(client)
+				if err != nil {
+					log.Printf("lti: %v\n", err)
+				}
+				if n == 0 || err != nil {
+					break
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ltiWake:
+			}
+		}
+	}()
+}
+
+func sendLTIGrades(client *http.Client) (int, error) {
+	var batch []LTIGrade
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", ltiGradePending, time.Now()).
+			Order("next_attempt_at, id").Limit(20).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(batch))
+		for _, g := range batch {
+			ids = append(ids, g.ID)
+		}
+		lease := time.Now().Add(2*ltiCfg.Timeout + time.Minute)
+		return tx.Model(&LTIGrade{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+	for i := range batch {
+		sendLTIGrade(client, &batch[i])
+	}
+	return len(batch), nil
+}
+
+// errLTIPermanent — платформа отвергла оценку (4xx): повтор не поможет.
+var errLTIPermanent = errors.New("отклонено платформой")
+
+func sendLTIGrade(client *http.Client, g *LTIGrade) {
+	var link LTIResourceLink
+	var userLink LTIUserLink
+	err := db.Preload("Platform").First(&link, g.ResourceLinkID).Error
+	if err == nil {
+		err = db.Where("platform_id = ? AND user_id = ?", link.PlatformID, g.UserID).First(&userLink).Error
+	}
+	if err == nil {
+		err = ltiPostScore(client, &link, userLink.Subject, g.ScoreGiven)
+	}
+
+	if err == nil {
+		now := time.Now()
+		// оценка могла измениться, пока шёл запрос, — тогда строка остаётся в очереди
+		db.Model(&LTIGrade{}).Where("id = ? AND score_given = ?", g.ID, g.ScoreGiven).Updates(map[string]any{
+			"status":     ltiGradeSent,
+			"sent_at":    &now,
+			"last_error": "",
+		})
+		return
+	}
+
+	attempt := g.Attempts + 1
+	updates := map[string]any{"attempts": attempt, "last_error": err.Error()}
+	if errors.Is(err, errLTIPermanent) || errors.Is(err, gorm.ErrRecordNotFound) || attempt >= ltiCfg.MaxAttempts {
+		updates["status"] = ltiGradeFailed
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempt))
+	}
+	if err := db.Model(&LTIGrade{}).Where("id = ? AND score_given = ?", g.ID, g.ScoreGiven).Updates(updates).Error; err != nil {
+		log.Printf("lti: оценка %d: %v\n", g.ID, err)
+	}
+}
+
+// ltiPostScore — POST {lineitem}/scores (AGS 2.0, application/vnd.ims.lis.v1.score+json).
+func ltiPostScore(client *http.Client, link *LTIResourceLink, subject string, score float64) error {
+	token, err := ltiAccessToken(client, &link.Platform)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(link.LineItemURL)
+	if err != nil {
+		return fmt.Errorf("%w: lineitem %v", errLTIPermanent, err)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/scores"
+
+	progress := "Completed"
+	if link.BlockID == nil && score < 100 {
+		progress = "InProgress"
+	}
+	body, _ := json.Marshal(map[string]any{
+		"userId":           subject,
+		"scoreGiven":       score,
+		"scoreMaximum":     100,
+		"activityProgress": progress,
+		"gradingProgress":  "FullyGraded",
+		"timestamp":        time.Now().UTC().Format(time.RFC3339Nano),
+	})
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/vnd.ims.lis.v1.score+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	msg := strings.ToValidUTF8(strings.TrimSpace(string(respBody)), "")
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		forgetLTIToken(link.PlatformID)
+		return fmt.Errorf("HTTP 401: %s", msg)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: HTTP %d: %s", errLTIPermanent, resp.StatusCode, msg)
+	default:
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, msg)
+	}
+}
+
+var ltiTokens = struct {
+	sync.Mutex
+	byPlatform map[uint]ltiToken
+}{byPlatform: map[uint]ltiToken{}}
+
+type ltiToken struct {
+	value   string
+	expires time.Time
+}
+
+func forgetLTIToken(platformID uint) {
+	ltiTokens.Lock()
+	delete(ltiTokens.byPlatform, platformID)
+	ltiTokens.Unlock()
+}
+
+// ltiAccessToken — OAuth2 client_credentials с JWT-утверждением, подписанным ключом инструмента
+// (IMS Security Framework). Токен кэшируется до истечения.
+func ltiAccessToken(client *http.Client, p *LTIPlatform) (string, error) {
+	ltiTokens.Lock()
+	cached, ok := ltiTokens.byPlatform[p.ID]
+	ltiTokens.Unlock()
+	if ok && time.Until(cached.expires) > time.Minute {
+		return cached.value, nil
+	}
+	if p.AuthTokenURL == "" {
+		return "", fmt.Errorf("%w: у платформы не задан адрес токена", errLTIPermanent)
+	}
+
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	assertion, err := ltiSign(jwt.MapClaims{
+		"iss": p.ClientID,
+		"sub": p.ClientID,
+		"aud": p.AuthTokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": jti,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {ltiScopeScore},
+	}
+	resp, err := client.PostForm(p.AuthTokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out)
+	if resp.StatusCode != http.StatusOK || out.AccessToken == "" {
+		return "", fmt.Errorf("токен платформы: HTTP %d %s", resp.StatusCode, out.Error)
+	}
+	if out.ExpiresIn <= 0 {
+		out.ExpiresIn = 3600
+	}
+
+	ltiTokens.Lock()
+	ltiTokens.byPlatform[p.ID] = ltiToken{value: out.AccessToken, expires: now.Add(time.Duration(out.ExpiresIn) * time.Second)}
+	ltiTokens.Unlock()
+	return out.AccessToken, nil
+}
+
+///////////////////////////////////////////////////////
+// DEEP LINKING
+///////////////////////////////////////////////////////
+
+// ltiContentItem — выбранный курс или блок, см. ltiDeepLinkResponse.
+type ltiContentItem struct {
+	Course *Course
+	Block  *Block // nil — весь курс
+}
+
+// ltiDeepLinkResponse — JWT LtiDeepLinkingResponse для POST на deep_link_return_url.
+// Цель ссылки передаётся в custom-параметрах, колонка журнала создаётся для тестов и курсов.
+func ltiDeepLinkResponse(req *LTIDeepLinkRequest, items []ltiContentItem) (string, error) {
+	content := make([]map[string]any, 0, len(items))
+	for _, it := range items {
+		title := it.Course.Title
+		custom := map[string]string{"course_id": strconv.Itoa(int(it.Course.ID))}
+		resourceID := "course-" + strconv.Itoa(int(it.Course.ID))
+		graded := true
+		if it.Block != nil {
+			title = it.Course.Title + " — " + blockTitle(it.Block)
+			custom["block_id"] = strconv.Itoa(int(it.Block.ID))
+			resourceID = "block-" + strconv.Itoa(int(it.Block.ID))
+			k := blockKinds[it.Block.Type]
+			graded = k != nil && k.Scored
+		}
+		ci := map[string]any{
+			"type":   "ltiResourceLink",
+			"title":  title,
+			"url":    ltiLaunchURL(),
+			"custom": custom,
+		}
+		if it.Block == nil && it.Course.ShortDesc != "" {
+			ci["text"] = it.Course.ShortDesc
+		}
+		if graded {
+			ci["lineItem"] = map[string]any{
+				"scoreMaximum": 100,
+				"label":        title,
+				"resourceId":   resourceID,
+			}
+		}
+		content = append(content, ci)
+	}
+
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   req.Platform.ClientID,
+		"aud":   req.Platform.Issuer,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+		"https://purl.imsglobal.org/spec/lti/claim/message_type":     "LtiDeepLinkingResponse",
+		"https://purl.imsglobal.org/spec/lti/claim/version":          ltiVersion,
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id":    req.DeploymentID,
+		"https://purl.imsglobal.org/spec/lti-dl/claim/content_items": content,
+	}
+	if req.Data != "" {
+		claims["https://purl.imsglobal.org/spec/lti-dl/claim/data"] = req.Data
+	}
+	return ltiSign(claims)
+}
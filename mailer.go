@@ -0,0 +1,50 @@
+// mailer.go
+package main
+
+import (
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// appBaseURL — внешний адрес приложения для ссылок в письмах.
+func appBaseURL() string {
+	return strings.TrimRight(envOr("APP_BASE_URL", "http://localhost:5001"), "/")
+}
+
+// sendMail отправляет текстовое письмо через SMTP_HOST.
+// Без SMTP_HOST письмо только пишется в лог (режим разработки).
+func sendMail(to, subject, body string) error {
+	host := envOr("SMTP_HOST", "")
+	if host == "" {
+		log.Printf("mail (SMTP_HOST не задан) → %s: %s\n%s\n", to, subject, body)
+		return nil
+	}
+	port := envOr("SMTP_PORT", "587")
+	from := envOr("SMTP_FROM", "TrainBrain <no-reply@trainbrain.local>")
+
+	var auth smtp.Auth
+	if user := envOr("SMTP_USER", ""); user != "" {
+		auth = smtp.PlainAuth("", user, envOr("SMTP_PASSWORD", ""), host)
+	}
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+	return smtp.SendMail(net.JoinHostPort(host, port), auth, envelopeAddr(from), []string{to}, []byte(msg))
+}
+
+// envelopeAddr вытаскивает адрес из «Имя <addr>».
+func envelopeAddr(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
@@ -0,0 +1,173 @@
+// markdown.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Markdown текстовых блоков: CommonMark + GFM (таблицы, зачёркивание, списки задач, автоссылки),
+// подсветка кода в ```-блоках (классы chroma, стили — static/css/markdown.css), якоря у заголовков,
+// формулы и диаграммы (markdown_ext.go).
+// Сырой HTML в тексте разрешён, но результат всегда проходит через allowlist-санитайзер (sanitize.go).
+
+var (
+	markdown = goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			highlighting.NewHighlighting(
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(
+				util.Prioritized(headingAnchors{}, 100),
+				util.Prioritized(diagramTransformer{}, 200),
+			),
+			// формулы $…$ и $$…$$ (markdown_ext.go)
+			parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 750)),
+			parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+		),
+		goldmark.WithRendererOptions(
+			goldhtml.WithHardWraps(), // одиночный перевод строки — <br>, как было в pre-wrap
+			goldhtml.WithUnsafe(),    // сырой HTML пропускаем дальше — его чистит санитайзер
+			renderer.WithNodeRenderers(util.Prioritized(mdExtRenderer{}, 500)),
+		),
+	)
+)
+
+// renderMarkdown — Markdown → безопасный HTML. idPrefix отделяет якоря разных блоков на одной странице,
+// diagrams — SVG диаграмм из payload блока (может быть nil).
+func renderMarkdown(src, idPrefix string, diagrams map[string]string) template.HTML {
+	if strings.TrimSpace(src) == "" {
+		return ""
+	}
+	frags := newMDFragments()
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{prefix: idPrefix, used: map[string]bool{}}))
+	ctx.Set(mdFragmentsKey, frags)
+	ctx.Set(mdDiagramsKey, diagrams)
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf, parser.WithContext(ctx)); err != nil {
+		log.Printf("markdown: %v\n", err)
+		return template.HTML("<p>" + template.HTMLEscapeString(src) + "</p>")
+	}
+	return template.HTML(frags.apply(htmlPolicy.Sanitize(buf.String())))
+}
+
+// headingIDs — id заголовков из текста с сохранением кириллицы («Введение» → «введение»).
+// Штатный генератор goldmark оставляет только ASCII.
+type headingIDs struct {
+	prefix string
+	used   map[string]bool
+}
+
+func (h *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(string(value))) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			dash = false
+		case (unicode.IsSpace(r) || r == '-' || r == '_') && b.Len() > 0 && !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	id := strings.TrimSuffix(b.String(), "-")
+	if id == "" {
+		id = "section"
+	}
+	id = h.prefix + id
+	out := id
+	for i := 1; h.used[out]; i++ {
+		out = fmt.Sprintf("%s-%d", id, i)
+	}
+	h.used[out] = true
+	return []byte(out)
+}
+
+func (h *headingIDs) Put(value []byte) {
+	h.used[string(value)] = true
+}
+
+// headingAnchors добавляет в конец заголовка ссылку-якорь «#» на него самого.
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if id, ok := h.AttributeString("id"); ok {
+			link := ast.NewLink()
+			link.Destination = append([]byte("#"), id.([]byte)...)
+			link.SetAttributeString("class", []byte("heading-anchor"))
+			link.AppendChild(link, ast.NewString([]byte("#")))
+			h.AppendChild(h, link)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+///////////////////////////////////////////////////////
+// КЕШ ПО РЕВИЗИИ БЛОКА
+///////////////////////////////////////////////////////
+
+// Ревизия блока — UpdatedAt: любое сохранение (форма, импорт, course-sync) её меняет,
+// и старая запись кеша просто перестаёт совпадать.
+type mdCacheKey struct {
+	BlockID uint
+	Field   string
+}
+
+type mdCacheEntry struct {
+	Rev  int64
+	HTML template.HTML
+}
+
+var mdCache = struct {
+	sync.Mutex
+	m map[mdCacheKey]mdCacheEntry
+}{m: map[mdCacheKey]mdCacheEntry{}}
+
+// blockMarkdown — HTML Markdown-поля field блока b (src — его текст, diagrams — payload.diagrams).
+func blockMarkdown(b *Block, field, src string, diagrams map[string]string) template.HTML {
+	key := mdCacheKey{b.ID, field}
+	rev := b.UpdatedAt.UnixNano()
+
+	mdCache.Lock()
+	e, ok := mdCache.m[key]
+	mdCache.Unlock()
+	if ok && e.Rev == rev {
+		return e.HTML
+	}
+
+	out := renderMarkdown(src, fmt.Sprintf("b%d-", b.ID), diagrams)
+	mdCache.Lock()
+	if len(mdCache.m) >= envInt("MARKDOWN_CACHE_SIZE", 5000) {
+		// переполнение — начинаем заново: записи дешевле пересчитать, чем вести LRU
+		mdCache.m = map[mdCacheKey]mdCacheEntry{}
+	}
+	mdCache.m[key] = mdCacheEntry{Rev: rev, HTML: out}
+	mdCache.Unlock()
+	return out
+}
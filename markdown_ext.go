@@ -0,0 +1,391 @@
+// markdown_ext.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Формулы и диаграммы в Markdown.
+//
+// $…$ и $$…$$ (в строке или отдельным блоком) превращаются в MathML (texmath.go), блоки
+// ```mermaid / ```dot — в SVG, отрисованный при сохранении (diagrams.go). Этот HTML генерирует сервер,
+// а allowlist-санитайзер MathML и data:-картинки не пропускает — поэтому на их месте в тексте стоит
+// метка со случайным для каждого рендера nonce, а готовые фрагменты подставляются уже после санитайзера.
+
+var (
+	mdFragmentsKey = parser.NewContextKey()
+	mdDiagramsKey  = parser.NewContextKey()
+
+	kindMath     = ast.NewNodeKind("Math")
+	kindMathBlk  = ast.NewNodeKind("MathBlock")
+	kindDiagram  = ast.NewNodeKind("Diagram")
+	mdTokenOpen  = "\uE000" // символы Private Use Area — в тексте авторов их не бывает
+	mdTokenClose = "\uE001"
+)
+
+// mdFragments — готовый HTML формул и диаграмм одного рендера и ошибки в формулах.
+type mdFragments struct {
+	nonce  string
+	html   []string
+	errors []string
+}
+
+func newMDFragments() *mdFragments {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &mdFragments{nonce: hex.EncodeToString(b)}
+}
+
+func (f *mdFragments) add(s string) string {
+	f.html = append(f.html, s)
+	return mdTokenOpen + f.nonce + ":" + strconv.Itoa(len(f.html)-1) + mdTokenClose
+}
+
+// apply подставляет фрагменты на место меток в уже очищенном HTML.
+func (f *mdFragments) apply(s string) string {
+	if len(f.html) == 0 {
+		return s
+	}
+	prefix := mdTokenOpen + f.nonce + ":"
+	var b strings.Builder
+	for {
+		i := strings.Index(s, prefix)
+		if i < 0 {
+			break
+		}
+		j := strings.Index(s[i:], mdTokenClose)
+		if j < 0 {
+			break
+		}
+		n, err := strconv.Atoi(s[i+len(prefix) : i+j])
+		if err != nil || n < 0 || n >= len(f.html) {
+			break
+		}
+		b.WriteString(s[:i])
+		b.WriteString(f.html[n])
+		s = s[i+j+len(mdTokenClose):]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// mathFragment — MathML формулы или её исходник с подсветкой ошибки; выключную формулу
+// обёртку (div для блока, span внутри абзаца) добавляет вызывающий.
+func (f *mdFragments) mathFragment(tex []byte, display bool) string {
+	out, err := texToMathML(string(tex), display)
+	if err == nil {
+		return out
+	}
+	f.errors = append(f.errors, "формула «"+string(tex)+"»: "+err.Error())
+	delim := "$"
+	if display {
+		delim = "$$"
+	}
+	return `<code class="math-error" title="` + html.EscapeString(err.Error()) + `">` +
+		html.EscapeString(delim+string(tex)+delim) + `</code>`
+}
+
+///////////////////////////////////////////////////////
+// ФОРМУЛЫ
+///////////////////////////////////////////////////////
+
+type mathInline struct {
+	ast.BaseInline
+	TeX   []byte
+	Token string
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMath }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.TeX)}, nil)
+}
+
+type mathBlock struct {
+	ast.BaseBlock
+	TeX    []byte
+	Token  string
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlk }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.TeX)}, nil)
+}
+
+// mathSpan ищет формулу в начале s (s[0] == '$'): $…$ или $$…$$.
+// Как в Pandoc: после открывающего $ и перед закрывающим нет пробела, за закрывающим — не цифра,
+// поэтому «от $5 до $10» остаётся текстом.
+func mathSpan(s []byte) (tex []byte, n int, display bool, ok bool) {
+	if len(s) < 2 || s[0] != '$' {
+		return nil, 0, false, false
+	}
+	if s[1] == '$' {
+		end := bytes.Index(s[2:], []byte("$$"))
+		if end <= 0 || len(bytes.TrimSpace(s[2:2+end])) == 0 {
+			return nil, 0, false, false
+		}
+		return s[2 : 2+end], end + 4, true, true
+	}
+	if s[1] == ' ' || s[1] == '\t' || s[1] == '\n' {
+		return nil, 0, false, false
+	}
+	for i := 2; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // \$ внутри формулы
+		case '\n':
+			return nil, 0, false, false
+		case '$':
+			if s[i-1] == ' ' || s[i-1] == '\t' || i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+				continue
+			}
+			return s[1:i], i + 1, false, true
+		}
+	}
+	return nil, 0, false, false
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(_ ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	tex, n, display, ok := mathSpan(line)
+	if !ok {
+		return nil
+	}
+	node := &mathInline{TeX: append([]byte(nil), tex...)}
+	if f, _ := pc.Get(mdFragmentsKey).(*mdFragments); f != nil {
+		out := f.mathFragment(node.TeX, display)
+		if display {
+			out = `<span class="math-display">` + out + `</span>`
+		}
+		node.Token = f.add(out)
+	}
+	block.Advance(n)
+	return node
+}
+
+// mathBlockParser — $$ на отдельной строке, формула до строки с закрывающим $$.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(_ ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	rest := bytes.TrimSpace(line[pos+2:])
+	node := &mathBlock{}
+	switch {
+	case len(rest) == 0:
+	case bytes.HasSuffix(rest, []byte("$$")) && len(rest) > 2 && bytes.Count(rest, []byte("$$")) == 1:
+		node.TeX = append(node.TeX, rest[:len(rest)-2]...)
+		node.closed = true
+	default:
+		return nil, parser.NoChildren // $$…$$ посреди текста — это формула в строке
+	}
+	reader.Advance(len(line) - 1)
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, _ parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+	line, _ := reader.PeekLine()
+	trimmed := bytes.TrimSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		n.TeX = append(n.TeX, trimmed[:len(trimmed)-2]...)
+		reader.Advance(len(line) - 1)
+		n.closed = true
+		return parser.Close
+	}
+	n.TeX = append(n.TeX, line...)
+	reader.Advance(len(line) - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, _ text.Reader, pc parser.Context) {
+	n := node.(*mathBlock)
+	if f, _ := pc.Get(mdFragmentsKey).(*mdFragments); f != nil {
+		n.Token = f.add(`<div class="math-display">` + f.mathFragment(bytes.TrimSpace(n.TeX), true) + `</div>`)
+	}
+}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+///////////////////////////////////////////////////////
+// ДИАГРАММЫ
+///////////////////////////////////////////////////////
+
+type diagramBlock struct {
+	ast.BaseBlock
+	Token string
+}
+
+func (n *diagramBlock) Kind() ast.NodeKind { return kindDiagram }
+
+func (n *diagramBlock) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+// fencedDiagram — вид диаграммы и исходник, если блок кода — ```mermaid или ```dot.
+func fencedDiagram(n *ast.FencedCodeBlock, source []byte) (kind, src string, ok bool) {
+	kind, ok = diagramKinds[strings.ToLower(string(n.Language(source)))]
+	if !ok {
+		return "", "", false
+	}
+	var b strings.Builder
+	for i := 0; i < n.Lines().Len(); i++ {
+		seg := n.Lines().At(i)
+		b.Write(seg.Value(source))
+	}
+	return kind, b.String(), true
+}
+
+// diagramTransformer заменяет блоки-диаграммы, для которых есть SVG, на картинку.
+// Без SVG (инструмент недоступен, ошибка) блок остаётся кодом — исходник диаграммы читаем и так.
+type diagramTransformer struct{}
+
+func (diagramTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	svgs, _ := pc.Get(mdDiagramsKey).(map[string]string)
+	f, _ := pc.Get(mdFragmentsKey).(*mdFragments)
+	if len(svgs) == 0 || f == nil {
+		return
+	}
+	var found []*ast.FencedCodeBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fc, ok := n.(*ast.FencedCodeBlock); ok && entering {
+			found = append(found, fc)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, fc := range found {
+		kind, src, ok := fencedDiagram(fc, reader.Source())
+		if !ok {
+			continue
+		}
+		svg, ok := svgs[diagramKey(kind, src)]
+		if !ok {
+			continue
+		}
+		d := &diagramBlock{}
+		d.Token = f.add(`<figure class="diagram diagram-` + kind + `"><img src="data:image/svg+xml;base64,` +
+			base64.StdEncoding.EncodeToString([]byte(svg)) + `" alt="Диаграмма"></figure>`)
+		fc.Parent().ReplaceChild(fc.Parent(), fc, d)
+	}
+}
+
+///////////////////////////////////////////////////////
+// ВЫВОД
+///////////////////////////////////////////////////////
+
+// mdExtRenderer выводит метки фрагментов (без контекста рендера — исходник формулы).
+type mdExtRenderer struct{}
+
+func (r mdExtRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, r.render)
+	reg.Register(kindMathBlk, r.render)
+	reg.Register(kindDiagram, r.render)
+}
+
+func (mdExtRenderer) render(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	switch x := n.(type) {
+	case *mathInline:
+		if x.Token == "" {
+			_, _ = w.WriteString("<code>" + html.EscapeString(string(x.TeX)) + "</code>")
+		}
+		_, _ = w.WriteString(x.Token)
+	case *mathBlock:
+		if x.Token == "" {
+			_, _ = w.WriteString("<pre><code>" + html.EscapeString(string(x.TeX)) + "</code></pre>")
+		}
+		_, _ = w.WriteString(x.Token + "\n")
+	case *diagramBlock:
+		_, _ = w.WriteString(x.Token + "\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderMathText — текст с формулами $…$ без остальной разметки (вопросы и варианты тестов).
+func renderMathText(s string) template.HTML {
+	if !strings.Contains(s, "$") {
+		return template.HTML(html.EscapeString(s))
+	}
+	src := []byte(s)
+	f := newMDFragments()
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '\\' && i+1 < len(src) && src[i+1] == '$':
+			b.WriteByte('$')
+			i++
+		case src[i] == '$':
+			if tex, n, display, ok := mathSpan(src[i:]); ok {
+				if display {
+					b.WriteString(`<span class="math-display">` + f.mathFragment(tex, display) + `</span>`)
+				} else {
+					b.WriteString(f.mathFragment(tex, display))
+				}
+				i += n - 1
+				continue
+			}
+			b.WriteByte('$')
+		default:
+			j := i
+			for j < len(src) && src[j] != '$' && src[j] != '\\' {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			b.WriteString(html.EscapeString(string(src[i:j])))
+			i = j - 1
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// mathTextProblems — ошибки в формулах текста вопроса или варианта (для формы).
+func mathTextProblems(s string) []string {
+	var out []string
+	src := []byte(s)
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\\' {
+			i++
+			continue
+		}
+		if src[i] != '$' {
+			continue
+		}
+		if tex, n, display, ok := mathSpan(src[i:]); ok {
+			if _, err := texToMathML(string(tex), display); err != nil {
+				out = append(out, "формула «"+string(tex)+"»: "+err.Error())
+			}
+			i += n - 1
+		}
+	}
+	return out
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"html/template"
 	"time"
 
 	"gorm.io/datatypes"
@@ -15,20 +16,115 @@ type User struct {
 	PasswordHash string    `gorm:"not null"`
 	Role         string    `gorm:"type:varchar(20);not null;default:student"`
 	FullName     string    `gorm:"type:varchar(255)"`
+	AuthSource   string    `gorm:"type:varchar(20);not null;default:local"` // "local" | "ldap"
+	ExternalID   string    `gorm:"type:varchar(512);index"`                  // DN в каталоге для LDAP
 	CreatedAt    time.Time
+
+	// двухфакторная аутентификация (TOTP, RFC 6238)
+	TOTPSecret   string `gorm:"column:totp_secret;type:varchar(64)"`
+	TOTPEnabled  bool   `gorm:"column:totp_enabled;not null;default:false"`
+	TOTPLastStep int64  `gorm:"column:totp_last_step;not null;default:0"` // последний принятый шаг — защита от повтора кода
+
+	// профиль
+	AvatarPath string     `gorm:"type:varchar(512)"` // путь в static/uploads/avatars
+	ErasedAt   *time.Time // аккаунт обезличен по запросу пользователя
 }
 
+const (
+	AuthSourceLocal   = "local"
+	AuthSourceLDAP    = "ldap"
+	AuthSourceService = "service" // сервисный аккаунт: входит только по API-токену
+)
+
 func (u User) IsAdmin() bool { return u.Role == "admin" }
 
+// Смена email: новый адрес вступает в силу после перехода по ссылке из письма
+type EmailChange struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	NewEmail  string    `gorm:"type:varchar(255);not null"`
+	TokenHash string    `gorm:"type:char(64);uniqueIndex;not null"` // sha256 токена из письма
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// API-токен (Authorization: Bearer). Храним только sha256, сам токен показывается один раз.
+type APIToken struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"` // владелец: человек или сервисный аккаунт
+	Name        string `gorm:"type:varchar(100);not null"`
+	Prefix      string `gorm:"type:varchar(16);not null"` // начало токена — чтобы узнать его в списке
+	TokenHash   string `gorm:"type:char(64);uniqueIndex;not null"`
+	Scopes      string `gorm:"type:varchar(255);not null"` // через пробел
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	LastUsedIP  string `gorm:"type:varchar(64)"`
+	CreatedByID uint
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Резервные коды 2FA (храним только sha256)
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	CodeHash  string     `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Серверная сессия (cookie хранит только подписанный ID)
+type UserSession struct {
+	ID         string    `gorm:"primaryKey;size:64"`
+	UserID     *uint     `gorm:"index"` // nil — гость (флеши, второй шаг входа)
+	Data       []byte    `gorm:"type:bytea"`
+	IP         string    `gorm:"size:64"`
+	UserAgent  string    `gorm:"size:255"`
+	CreatedAt  time.Time
+	LastSeenAt time.Time `gorm:"index"`
+	ExpiresAt  time.Time `gorm:"index"` // абсолютный таймаут
+
+	User *User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Счётчик неудачных входов для лимитера (используется и в памяти, и в Postgres)
+type AuthThrottle struct {
+	Key         string    `gorm:"column:throttle_key;primaryKey;size:320"` // "acct:<email>" | "ip:<addr>" | "reg:<addr>"
+	Failures    int       `gorm:"not null;default:0"`
+	Lockouts    int       `gorm:"not null;default:0"` // сколько раз уже блокировали — для экспоненты
+	WindowStart time.Time
+	LockedUntil time.Time `gorm:"index"`
+	UpdatedAt   time.Time
+}
+
+// История попыток входа (для админки)
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	Email     string    `gorm:"size:320;index"`
+	IP        string    `gorm:"size:64;index"`
+	UserAgent string    `gorm:"size:255"`
+	Success   bool      `gorm:"not null;default:false"`
+	Reason    string    `gorm:"size:32"` // bad_credentials | bad_2fa | locked | password_ok
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
 // ---------- Курс / Модуль / Блок ----------
 
 type Course struct {
-	ID        uint      `gorm:"primaryKey"`
-	Title     string    `gorm:"size:255;not null"`
-	ShortDesc string    `gorm:"type:text"`
-	Status    string    `gorm:"size:32;not null;default:'draft'"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID         uint   `gorm:"primaryKey"`
+	Title      string `gorm:"size:255;not null"`
+	ShortDesc  string `gorm:"type:text"`
+	Status     string `gorm:"size:32;not null;default:'draft'"`
+	IsTemplate bool   `gorm:"not null;default:false"`                                // предлагается как основа на форме нового курса
+	Slug       string `gorm:"size:128;uniqueIndex:idx_course_slug,where:slug <> ''"` // каталог курса (course-sync)
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 
 	Modules []Module `gorm:"foreignKey:CourseID;constraint:OnDelete:CASCADE;"`
 }
@@ -38,6 +134,7 @@ type Module struct {
 	CourseID  uint      `gorm:"index;not null"`
 	Title     string    `gorm:"size:255;not null"`
 	Order     int       `gorm:"not null;default:1"`
+	Slug      string    `gorm:"size:128;index"` // папка модуля в каталоге курса (course-sync)
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
@@ -50,17 +147,25 @@ type Block struct {
 	ModuleID uint   `gorm:"index;not null"`
 	Module   Module `gorm:"constraint:OnDelete:CASCADE;"`
 
-	Type    string         `gorm:"size:32;not null"`   // "text", "video", "assignment", "quiz"
+	Type    string         `gorm:"size:32;not null"`   // тип из реестра blockKinds (blocks.go)
 	Order   int            `gorm:"not null;default:1"`
 	Payload datatypes.JSON `gorm:"type:jsonb"`          // сырой JSON в БД
+	Slug    string         `gorm:"size:128;index"`     // файл блока в каталоге курса, уникален в курсе (course-sync)
 
 	// ВСПОМОГАТЕЛЬНОЕ ПОЛЕ ДЛЯ ШАБЛОНОВ (в памяти, в БД НЕ хранится)
-	PayloadMap map[string]any `gorm:"-"`
+	Data blockPayload `gorm:"-"` // payload в структуре своего типа (blocks.go)
 
 	// ✅ НУЖНО ДЛЯ course_player.html (в памяти, в БД НЕ хранится)
-	// заполняется в viewCourseHandler: последняя попытка квиза / последняя сдача
-	LastAttempt    *QuizAttempt `gorm:"-"`
-	LastSubmission *Submission  `gorm:"-"`
+	// заполняется при загрузке блоков для плеера (blockKind.load): последняя попытка квиза / последняя сдача
+	LastAttempt    *QuizAttempt      `gorm:"-"`
+	LastSubmission *Submission       `gorm:"-"`
+	Scorm          *ScormAttempt     `gorm:"-"` // состояние SCORM-пакета
+	Deck           *flashcardDeck    `gorm:"-"` // карточки: сколько новых, к повторению, выученных
+	Survey         *surveyView       `gorm:"-"` // опрос: ответил ли ученик, итоги
+	Peer           *peerReviewView   `gorm:"-"` // задание с рецензированием: этап, рецензии ученика, отзывы
+	Watch          *VideoWatch       `gorm:"-"` // видео-файл: просмотренные отрезки и место остановки
+	Transcripts    []videoTranscript `gorm:"-"` // видео-файл: реплики дорожек субтитров
+	TextHTML       template.HTML     `gorm:"-"` // payload.text после Markdown (кеш по ревизии, markdown.go)
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -82,6 +187,7 @@ type Submission struct {
 	SizeBytes    int64
 	Comment      string    `gorm:"type:text"`
 	Status       string    `gorm:"type:varchar(32);not null;default:'submitted'"`
+	PeerOverride *float64  // оценка преподавателя вместо взаимной, % (peer_review.go)
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
 
 	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
@@ -123,3 +229,317 @@ type QuizAttempt struct {
 	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
 	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
 }
+
+// Курс завершён (все тесты пройдены, все задания сданы). Фиксируется один раз —
+// по этой записи событие course.completed не отправляется повторно.
+type CourseCompletion struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"uniqueIndex:idx_course_completion;not null"`
+	CourseID    uint      `gorm:"uniqueIndex:idx_course_completion;not null"`
+	CompletedAt time.Time `gorm:"not null"`
+
+	User   User   `gorm:"constraint:OnDelete:CASCADE;"`
+	Course Course `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Вебхуки ----------
+
+// Подписка внешней системы на события
+type Webhook struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"size:100;not null"`
+	URL       string `gorm:"size:1024;not null"`
+	Secret    string `gorm:"size:128;not null"` // ключ HMAC-подписи, получатель хранит у себя
+	Events    string `gorm:"size:512;not null"` // через пробел
+	Active    bool   `gorm:"not null;default:true"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Deliveries []WebhookDelivery `gorm:"foreignKey:WebhookID;constraint:OnDelete:CASCADE;"`
+}
+
+// Доставка события подписчику — она же запись outbox: создаётся вместе с событием,
+// фоновый воркер отправляет её с повторами; после — остаётся в журнале.
+type WebhookDelivery struct {
+	ID             uint           `gorm:"primaryKey"`
+	WebhookID      uint           `gorm:"index;not null"`
+	EventID        string         `gorm:"size:64;index;not null"` // одинаковый у повторных доставок — для дедупликации у получателя
+	Event          string         `gorm:"size:64;not null"`
+	Payload        datatypes.JSON `gorm:"type:jsonb;not null"`
+	Status         string         `gorm:"size:16;not null;default:'pending';index"` // pending | delivered | failed
+	Attempts       int            `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time      `gorm:"index"`
+	LastStatusCode int
+	LastError      string `gorm:"type:text"`
+	ResponseBody   string `gorm:"type:text"` // начало ответа получателя
+	DurationMs     int64
+	RedeliveryOf   *uint // ручная повторная отправка
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time `gorm:"index"`
+
+	Webhook Webhook `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- xAPI ----------
+
+// Очередь xAPI-заявлений для LRS. ID заявления детерминирован (из SourceKey), поэтому
+// повторная генерация (backfill) не создаёт дублей ни у нас, ни в LRS.
+type XAPIStatement struct {
+	ID            string         `gorm:"primaryKey;type:uuid"`
+	SourceKey     string         `gorm:"size:128;uniqueIndex;not null"` // "quiz:<attempt_id>", "answered:<attempt_id>:<question_id>" ...
+	UserID        uint           `gorm:"index;not null"`
+	Verb          string         `gorm:"size:32;not null"`
+	Statement     datatypes.JSON `gorm:"type:jsonb;not null"`
+	Status        string         `gorm:"size:16;not null;default:'pending';index"` // pending | sent | failed
+	Attempts      int            `gorm:"not null;default:0"`
+	NextAttemptAt time.Time      `gorm:"index"`
+	LastError     string         `gorm:"type:text"`
+	SentAt        *time.Time
+	CreatedAt     time.Time `gorm:"index"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+
+// ---------- LTI 1.3 ----------
+
+// Платформа (LMS), из которой курсы запускаются по LTI 1.3. Данные берутся из регистрации
+// инструмента в самой LMS (Moodle: «Внешний инструмент», Canvas: «Developer Key»).
+type LTIPlatform struct {
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"size:100;not null"`
+	Issuer        string `gorm:"size:512;not null;uniqueIndex:idx_lti_platform"` // iss в id_token
+	ClientID      string `gorm:"size:255;not null;uniqueIndex:idx_lti_platform"` // aud в id_token
+	DeploymentIDs string `gorm:"size:1024"`                                      // через пробел; пусто — любой
+	AuthLoginURL  string `gorm:"size:1024;not null"`                             // OIDC authorization endpoint
+	AuthTokenURL  string `gorm:"size:1024"`                                      // OAuth2 token endpoint — нужен для оценок (AGS)
+	KeySetURL     string `gorm:"size:1024;not null"`                             // JWKS платформы
+	TrustEmail    bool   `gorm:"not null;default:false"`                         // связывать с существующими аккаунтами по email
+	Active        bool   `gorm:"not null;default:true"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Ключ RSA, которым TrainBrain подписывает ответы deep linking и запросы токена AGS.
+// Публичная часть — в /lti/jwks. Используется, если не задан LTI_PRIVATE_KEY_FILE.
+type LTIKey struct {
+	ID            uint   `gorm:"primaryKey"`
+	Kid           string `gorm:"size:64;uniqueIndex;not null"`
+	PrivateKeyPEM string `gorm:"type:text;not null"`
+	CreatedAt     time.Time
+}
+
+// Состояние OIDC-входа между /lti/login и /lti/launch (одноразовое).
+type LTILaunchState struct {
+	State      string    `gorm:"primaryKey;size:64"`
+	Nonce      string    `gorm:"size:64;not null"`
+	PlatformID uint      `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+
+	Platform LTIPlatform `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Пользователь платформы (sub из id_token) → аккаунт TrainBrain.
+type LTIUserLink struct {
+	ID           uint   `gorm:"primaryKey"`
+	PlatformID   uint   `gorm:"uniqueIndex:idx_lti_user_link;not null"`
+	Subject      string `gorm:"size:255;uniqueIndex:idx_lti_user_link;not null"`
+	UserID       uint   `gorm:"index;not null"`
+	LastLaunchAt time.Time
+	CreatedAt    time.Time
+
+	Platform LTIPlatform `gorm:"constraint:OnDelete:CASCADE;"`
+	User     User        `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Ссылка на ресурс в LMS (элемент курса платформы), ведущая на курс или блок TrainBrain.
+type LTIResourceLink struct {
+	ID             uint   `gorm:"primaryKey"`
+	PlatformID     uint   `gorm:"uniqueIndex:idx_lti_resource_link;not null"`
+	ResourceLinkID string `gorm:"size:255;uniqueIndex:idx_lti_resource_link;not null"`
+	DeploymentID   string `gorm:"size:255"`
+	ContextTitle   string `gorm:"size:255"` // курс в LMS
+	Title          string `gorm:"size:255"`
+	CourseID       uint   `gorm:"index;not null"`
+	BlockID        *uint  `gorm:"index"`     // nil — ссылка на весь курс
+	LineItemURL    string `gorm:"size:1024"` // колонка журнала оценок (AGS); пусто — оценки не передаются
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	Platform LTIPlatform `gorm:"constraint:OnDelete:CASCADE;"`
+	Course   Course      `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Оценка пользователя по ссылке LMS — она же очередь на отправку в журнал платформы.
+// Строка появляется при первом запуске ссылки; новая оценка перезаписывает неотправленную.
+type LTIGrade struct {
+	ID             uint      `gorm:"primaryKey"`
+	ResourceLinkID uint      `gorm:"uniqueIndex:idx_lti_grade;not null"`
+	UserID         uint      `gorm:"uniqueIndex:idx_lti_grade;not null"`
+	ScoreGiven     float64   `gorm:"not null;default:0"`                    // процент
+	Status         string    `gorm:"size:16;not null;default:'none';index"` // none | pending | sent | failed
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index"`
+	LastError      string    `gorm:"type:text"`
+	SentAt         *time.Time
+	UpdatedAt      time.Time
+
+	ResourceLink LTIResourceLink `gorm:"constraint:OnDelete:CASCADE;"`
+	User         User            `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Запрос deep linking: преподаватель в LMS выбирает курс или блок на странице /lti/deep-link/<id>.
+type LTIDeepLinkRequest struct {
+	ID             string `gorm:"primaryKey;size:64"` // случайный, он же доступ к странице выбора
+	PlatformID     uint   `gorm:"not null"`
+	DeploymentID   string `gorm:"size:255"`
+	ReturnURL      string `gorm:"size:1024;not null"`
+	Data           string `gorm:"type:text"` // возвращается платформе как есть
+	AcceptMultiple bool
+	ExpiresAt      time.Time `gorm:"index;not null"`
+
+	Platform LTIPlatform `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- SCORM ----------
+
+// Состояние SCORM-пакета у пользователя: одна запись на блок, продолжается между сеансами.
+type ScormAttempt struct {
+	ID            uint           `gorm:"primaryKey"`
+	UserID        uint           `gorm:"uniqueIndex:idx_scorm_attempt;not null"`
+	BlockID       uint           `gorm:"uniqueIndex:idx_scorm_attempt;not null"`
+	CMI           datatypes.JSON `gorm:"column:cmi;type:jsonb"`                    // элементы cmi.*, записанные пакетом
+	Status        string         `gorm:"size:32;not null;default:'not attempted'"` // lesson_status (1.2) / completion_status (2004)
+	SuccessStatus string         `gorm:"size:16;not null;default:'unknown'"`       // passed | failed | unknown
+	Score         *float64       // процент
+	Completed     bool           `gorm:"not null;default:false;index"`
+	TotalTime     int64          `gorm:"not null;default:0"` // секунды
+	Sessions      int            `gorm:"not null;default:0"`
+	Entry         string         `gorm:"size:16"` // ab-initio | resume | ""
+	CompletedAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Карточки (интервальное повторение) ----------
+
+// Расписание карточки у пользователя (flashcards.go): одна запись на карточку, создаётся первым ответом.
+type FlashcardState struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"uniqueIndex:idx_flashcard_state;index:idx_flashcard_due,priority:1;not null"`
+	BlockID    uint      `gorm:"uniqueIndex:idx_flashcard_state;not null"`
+	CardID     string    `gorm:"uniqueIndex:idx_flashcard_state;size:32;not null"` // id карточки в payload
+	Due        time.Time `gorm:"index:idx_flashcard_due,priority:2;not null"`
+	Interval   float64   `gorm:"not null;default:0"`   // дней до следующего показа
+	Ease       float64   `gorm:"not null;default:2.5"` // SM-2
+	Stability  float64   `gorm:"not null;default:0"`   // FSRS, дней
+	Difficulty float64   `gorm:"not null;default:0"`   // FSRS, 1–10
+	Reps       int       `gorm:"not null;default:0"`   // успешных ответов подряд
+	Lapses     int       `gorm:"not null;default:0"`   // сколько раз забыта
+	LastReview time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Ответ на карточку — журнал для статистики и серии дней подряд.
+type FlashcardReview struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"index:idx_flashcard_review_user,priority:1;not null"`
+	BlockID    uint      `gorm:"index;not null"`
+	CardID     string    `gorm:"size:32;not null"`
+	Grade      int       `gorm:"not null"`           // 1 — забыл, 2 — трудно, 3 — хорошо, 4 — легко
+	Interval   float64   `gorm:"not null;default:0"` // назначенный интервал, дней
+	ReviewedAt time.Time `gorm:"index:idx_flashcard_review_user,priority:2;not null"`
+
+	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Опросы ----------
+
+// Ответ на опрос (survey, poll). У анонимного опроса UserID пуст, а время — с точностью до дня;
+// ID случайный, а не по порядку, — чтобы ответ нельзя было сопоставить с SurveyParticipant.
+type SurveyResponse struct {
+	ID        string         `gorm:"primaryKey;size:32"`
+	BlockID   uint           `gorm:"index;not null"`
+	UserID    *uint          `gorm:"index"`
+	Answers   datatypes.JSON `gorm:"type:jsonb"` // id вопроса → строка, список строк или число
+	CreatedAt time.Time
+
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+	User  *User `gorm:"constraint:OnDelete:SET NULL;"`
+}
+
+// Кто уже ответил: один ответ на опрос, в том числе анонимный.
+type SurveyParticipant struct {
+	ID        uint `gorm:"primaryKey"`
+	BlockID   uint `gorm:"uniqueIndex:idx_survey_participant;not null"`
+	UserID    uint `gorm:"uniqueIndex:idx_survey_participant;not null"`
+	CreatedAt time.Time
+
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Взаимное рецензирование ----------
+
+// Распределение работ по рецензентам: одна запись на задание, создаётся после срока сдачи.
+type PeerReviewRound struct {
+	BlockID    uint `gorm:"primaryKey"`
+	Reviewers  int  `gorm:"not null"` // рецензентов на работу при распределении
+	AssignedAt time.Time
+
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Рецензия: работа (последняя сдача автора на момент распределения) и рецензент.
+// SubmittedAt пуст, пока рецензент не отправил оценки.
+type PeerReview struct {
+	ID           uint           `gorm:"primaryKey"`
+	BlockID      uint           `gorm:"index;not null"`
+	SubmissionID uint           `gorm:"uniqueIndex:idx_peer_review;not null"`
+	ReviewerID   uint           `gorm:"uniqueIndex:idx_peer_review;index;not null"`
+	Scores       datatypes.JSON `gorm:"type:jsonb"` // id критерия → баллы
+	Comment      string         `gorm:"type:text"`
+	SubmittedAt  *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Block      Block      `gorm:"constraint:OnDelete:CASCADE;"`
+	Submission Submission `gorm:"constraint:OnDelete:CASCADE;"`
+	Reviewer   User       `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Просмотр видео ----------
+
+// Просмотр видео-файла учеником (video_watch.go): объединённые просмотренные отрезки и место,
+// где он остановился. CompletedAt — когда просмотр впервые достиг порога payload.complete_percent.
+type VideoWatch struct {
+	ID          uint           `gorm:"primaryKey"`
+	UserID      uint           `gorm:"uniqueIndex:idx_video_watch;not null"`
+	BlockID     uint           `gorm:"uniqueIndex:idx_video_watch;index;not null"`
+	Duration    float64        // длительность по плееру, с
+	Intervals   datatypes.JSON `gorm:"type:jsonb"` // [[от, до], ...] по возрастанию, без пересечений, с
+	Watched     float64        // сумма отрезков, с
+	Position    float64        // место остановки, с
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	User  User  `gorm:"constraint:OnDelete:CASCADE;"`
+	Block Block `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// ---------- Миграции данных ----------
+
+// Разовые преобразования уже сохранённых данных (см. data_migrations.go); схему ведёт AutoMigrate.
+type DataMigration struct {
+	Name      string `gorm:"primaryKey;size:128"`
+	AppliedAt time.Time
+}
@@ -0,0 +1,176 @@
+// opengraph.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Превью внешней ссылки — теги Open Graph (og:title, og:description, og:image, og:site_name),
+// а если их нет — <title> и <meta name="description">. Страница читается при сохранении блока,
+// в payload попадает только текст и адрес картинки; при показе сервер никуда не ходит.
+
+const linkPreviewMaxBody = 1 << 20
+
+type linkPreview struct {
+	Title       string `json:"title,omitempty" schema:"maxlen=500"`
+	Description string `json:"description,omitempty" schema:"maxlen=2000"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty" schema:"maxlen=200"`
+}
+
+// fetchLinkPreview загружает страницу и разбирает её заголовок. Адреса во внутренних сетях
+// запрещены (LINK_PREVIEW_PRIVATE=true разрешает — для локальной разработки).
+func fetchLinkPreview(rawURL string) (*linkPreview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("нужен адрес http(s)")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("LINK_PREVIEW_TIMEOUT", 5*time.Second))
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	if os.Getenv("LINK_PREVIEW_PRIVATE") != "true" {
+		dialer.Control = denyPrivateAddr
+	}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("слишком много перенаправлений")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "TrainBrain link preview")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("сайт не ответил вовремя")
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("сайт ответил %s", resp.Status)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.Contains(ct, "html") {
+		return nil, fmt.Errorf("по ссылке не страница, а %s", ct)
+	}
+	p := parseLinkPreview(io.LimitReader(resp.Body, linkPreviewMaxBody), resp.Request.URL)
+	if p.Title == "" && p.Description == "" {
+		return nil, errors.New("на странице нет заголовка и описания")
+	}
+	return p, nil
+}
+
+// denyPrivateAddr не даёт превью ходить на loopback, внутренние и служебные адреса
+// (проверяется адрес после DNS, поэтому перенаправления и DNS-трюки не помогают).
+func denyPrivateAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("адрес %s во внутренней сети", host)
+	}
+	return nil
+}
+
+// parseLinkPreview читает <head>: метатеги до </head> или до начала <body>.
+func parseLinkPreview(r io.Reader, base *url.URL) *linkPreview {
+	meta := map[string]string{}
+	var title strings.Builder
+	inTitle := false
+
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "body":
+				break loop
+			case "title":
+				inTitle = true
+			case "meta":
+				var key, content string
+				for _, a := range t.Attr {
+					switch a.Key {
+					case "property", "name":
+						key = strings.ToLower(strings.TrimSpace(a.Val))
+					case "content":
+						content = strings.TrimSpace(a.Val)
+					}
+				}
+				if key != "" && content != "" && meta[key] == "" {
+					meta[key] = content
+				}
+			}
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "head":
+				break loop
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	p := &linkPreview{
+		Title:       truncateRunes(first("og:title", "twitter:title"), 500),
+		Description: truncateRunes(first("og:description", "twitter:description", "description"), 2000),
+		SiteName:    truncateRunes(first("og:site_name"), 200),
+	}
+	if p.Title == "" {
+		p.Title = truncateRunes(strings.Join(strings.Fields(title.String()), " "), 500)
+	}
+	if img := first("og:image", "og:image:url", "twitter:image"); img != "" {
+		if iu, err := base.Parse(img); err == nil && (iu.Scheme == "http" || iu.Scheme == "https") {
+			p.Image = iu.String()
+		}
+	}
+	return p
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
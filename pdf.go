@@ -0,0 +1,68 @@
+// pdf.go
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Число страниц PDF без внешних библиотек: корневой словарь /Type /Pages хранит /Count всех страниц.
+// В PDF 1.5+ словари часто лежат в сжатых потоках объектов (/Type /ObjStm) — их распаковываем.
+// Если дерево страниц не нашлось, считаем словари /Type /Page.
+
+var (
+	pdfPagesRe   = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	pdfPageRe    = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfObjStmRe  = regexp.MustCompile(`/Type\s*/ObjStm\b[^>]*>>\s*stream\r?\n`)
+	pdfMaxObjStm = 64 << 20 // распакованных потоков объектов на один файл
+)
+
+var errNotPDF = errors.New("файл не похож на PDF")
+
+func isPDF(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data[:min(len(data), 1024)], "\x00\t\r\n "), []byte("%PDF-"))
+}
+
+// pdfPageCount — число страниц документа.
+func pdfPageCount(data []byte) (int, error) {
+	if !isPDF(data) {
+		return 0, errNotPDF
+	}
+	sources := [][]byte{data}
+	budget := pdfMaxObjStm
+	for _, loc := range pdfObjStmRe.FindAllIndex(data, -1) {
+		if budget <= 0 {
+			break
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[loc[1]:]))
+		if err != nil {
+			continue
+		}
+		// поток заканчивается сам (конец zlib-данных), /Length читать не нужно
+		b, _ := io.ReadAll(io.LimitReader(zr, int64(budget)))
+		zr.Close()
+		budget -= len(b)
+		sources = append(sources, b)
+	}
+
+	pages := 0
+	for _, src := range sources {
+		for _, m := range pdfPagesRe.FindAllSubmatch(src, -1) {
+			n, _ := strconv.Atoi(string(m[1]) + string(m[2]))
+			pages = max(pages, n) // у корня дерева /Count самый большой
+		}
+	}
+	if pages == 0 {
+		for _, src := range sources {
+			pages += len(pdfPageRe.FindAllIndex(src, -1))
+		}
+	}
+	if pages == 0 {
+		return 0, errors.New("не удалось определить число страниц PDF")
+	}
+	return pages, nil
+}
@@ -0,0 +1,478 @@
+// peer_review.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Взаимное рецензирование заданий (настройки — payload.peer_review, blocks.go).
+// До срока сдачи ученики сдают работы как обычно, после — сдача закрыта, и работы распределяются:
+// сдавшие перемешиваются по кругу, и каждый рецензирует работы N следующих. Так каждая работа
+// получает N рецензий, каждый рецензент пишет N, своя работа ему не попадается. Рецензируется
+// последняя сдача автора. Имена не показываются ни рецензенту, ни автору.
+// Оценка работы — среднее рецензий в процентах от максимума рубрики без выбросов; оценка
+// преподавателя (Submission.PeerOverride) её заменяет. Отзывы автор видит после срока рецензирования.
+
+const (
+	peerPhaseSubmission = "submission" // идёт сдача
+	peerPhaseReview     = "review"     // работы распределены, идёт рецензирование
+	peerPhaseClosed     = "closed"     // отзывы открыты авторам
+
+	peerCommentMaxLen = 5000
+	// выброс — рецензия дальше от медианы, чем 3 масштабированных MAD, но не ближе peerOutlierMinGap
+	peerOutlierMinGap = 15.0 // процентных пунктов
+)
+
+var errSubmissionClosed = errors.New("срок сдачи задания прошёл")
+
+// peerSettings — настройки рецензирования задания; nil — рецензирования нет.
+func peerSettings(blk *Block) *peerReviewSettings {
+	if p, ok := blk.Data.(*assignmentPayload); ok {
+		return p.PeerReview
+	}
+	var p assignmentPayload
+	if blk.Type != assignmentBlock.Type || len(blk.Payload) == 0 || json.Unmarshal(blk.Payload, &p) != nil {
+		return nil
+	}
+	return p.PeerReview
+}
+
+func peerPhase(s *peerReviewSettings, now time.Time) string {
+	switch {
+	case now.Before(s.DeadlineAt()):
+		return peerPhaseSubmission
+	case now.Before(s.ReviewDeadlineAt()):
+		return peerPhaseReview
+	}
+	return peerPhaseClosed
+}
+
+///////////////////////////////////////////////////////
+// РАСПРЕДЕЛЕНИЕ
+///////////////////////////////////////////////////////
+
+// latestSubmissions — последняя сдача каждого ученика по заданию.
+func latestSubmissions(tx *gorm.DB, blockID uint) ([]Submission, error) {
+	var all []Submission
+	if err := tx.Preload("User").Where("block_id = ?", blockID).
+		Order("created_at desc, id desc").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	seen := map[uint]bool{}
+	var out []Submission
+	for _, s := range all {
+		if !seen[s.UserID] {
+			seen[s.UserID] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// ensurePeerAssignments распределяет работы, если срок сдачи прошёл, а распределения ещё не было.
+// Запускается при открытии задания, страницы рецензий и админки; повторный вызов ничего не делает —
+// распределение одно, даже если потом изменить сроки или число рецензентов.
+func ensurePeerAssignments(blk *Block, s *peerReviewSettings, now time.Time) error {
+	if now.Before(s.DeadlineAt()) {
+		return nil
+	}
+	var done int64
+	if err := db.Model(&PeerReviewRound{}).Where("block_id = ?", blk.ID).Count(&done).Error; err != nil || done > 0 {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&PeerReviewRound{BlockID: blk.ID, Reviewers: s.Reviewers, AssignedAt: now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		subs, err := latestSubmissions(tx, blk.ID)
+		if err != nil {
+			return err
+		}
+		rand.Shuffle(len(subs), func(i, j int) { subs[i], subs[j] = subs[j], subs[i] })
+		n := min(s.Reviewers, len(subs)-1)
+		var reviews []PeerReview
+		for i, sub := range subs {
+			for k := 1; k <= n; k++ {
+				reviews = append(reviews, PeerReview{
+					BlockID:      blk.ID,
+					SubmissionID: sub.ID,
+					ReviewerID:   subs[(i+k)%len(subs)].UserID,
+				})
+			}
+		}
+		log.Printf("peer review: задание %d: работ %d, рецензий %d\n", blk.ID, len(subs), len(reviews))
+		if len(reviews) == 0 {
+			return nil
+		}
+		return tx.Create(&reviews).Error
+	})
+}
+
+///////////////////////////////////////////////////////
+// ОЦЕНКА
+///////////////////////////////////////////////////////
+
+// peerReviewScore — отправленная рецензия с итогом в процентах.
+type peerReviewScore struct {
+	Review  PeerReview
+	Scores  map[string]int // id критерия → баллы
+	Percent float64
+	Outlier bool // не входит в среднее
+}
+
+// Points — баллы по критерию (для шаблонов).
+func (r peerReviewScore) Points(id string) int { return r.Scores[id] }
+
+type peerGrade struct {
+	Submission Submission
+	Assigned   int
+	Reviews    []peerReviewScore // отправленные
+	Peer       *float64          // среднее рецензий без выбросов, %
+	Final      *float64          // оценка преподавателя или Peer
+	Overridden bool
+}
+
+// OverrideValue — оценка преподавателя для поля формы; пусто, если её нет.
+func (g *peerGrade) OverrideValue() string {
+	if g.Submission.PeerOverride == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*g.Submission.PeerOverride, 'f', -1, 64)
+}
+
+func decodePeerScores(r *PeerReview) map[string]int {
+	m := map[string]int{}
+	if len(r.Scores) > 0 {
+		if err := json.Unmarshal(r.Scores, &m); err != nil {
+			log.Printf("peer review: рецензия %d: %v\n", r.ID, err)
+		}
+	}
+	return m
+}
+
+// peerPercent — сумма баллов по критериям рубрики в процентах от максимума.
+func peerPercent(s *peerReviewSettings, scores map[string]int) float64 {
+	total, max := 0, s.MaxPoints()
+	for _, rc := range s.Rubric {
+		total += min(scores[rc.ID], rc.Points)
+	}
+	if max == 0 {
+		return 0
+	}
+	return float64(total) * 100 / float64(max)
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// peerOutliers отмечает оценки, далёкие от медианы: дальше 3·1,4826·MAD (устойчивая оценка
+// стандартного отклонения) и не ближе peerOutlierMinGap. Меньше трёх оценок — выбросов нет.
+func peerOutliers(xs []float64) []bool {
+	out := make([]bool, len(xs))
+	if len(xs) < 3 {
+		return out
+	}
+	m := median(xs)
+	dev := make([]float64, len(xs))
+	for i, x := range xs {
+		dev[i] = math.Abs(x - m)
+	}
+	limit := max(3*1.4826*median(dev), peerOutlierMinGap)
+	for i := range xs {
+		out[i] = dev[i] > limit
+	}
+	return out
+}
+
+// gradePeerSubmission — оценка работы по её рецензиям (все назначенные, в том числе не отправленные).
+func gradePeerSubmission(s *peerReviewSettings, sub Submission, reviews []PeerReview) *peerGrade {
+	g := &peerGrade{Submission: sub, Assigned: len(reviews)}
+	var xs []float64
+	for _, r := range reviews {
+		if r.SubmittedAt == nil {
+			continue
+		}
+		scores := decodePeerScores(&r)
+		pct := peerPercent(s, scores)
+		g.Reviews = append(g.Reviews, peerReviewScore{Review: r, Scores: scores, Percent: pct})
+		xs = append(xs, pct)
+	}
+	sum, n := 0.0, 0
+	for i, out := range peerOutliers(xs) {
+		g.Reviews[i].Outlier = out
+		if !out {
+			sum += xs[i]
+			n++
+		}
+	}
+	if n > 0 {
+		avg := sum / float64(n)
+		g.Peer = &avg
+	}
+	g.Final = g.Peer
+	if sub.PeerOverride != nil {
+		g.Final, g.Overridden = sub.PeerOverride, true
+	}
+	return g
+}
+
+// loadPeerGrades — оценки всех распределённых работ задания, по автору.
+func loadPeerGrades(blk *Block, s *peerReviewSettings) ([]*peerGrade, error) {
+	var reviews []PeerReview
+	if err := db.Preload("Submission.User").Preload("Reviewer").
+		Where("block_id = ?", blk.ID).Order("id").Find(&reviews).Error; err != nil {
+		return nil, err
+	}
+	bySub := map[uint][]PeerReview{}
+	var order []uint
+	for _, r := range reviews {
+		if _, ok := bySub[r.SubmissionID]; !ok {
+			order = append(order, r.SubmissionID)
+		}
+		bySub[r.SubmissionID] = append(bySub[r.SubmissionID], r)
+	}
+	grades := make([]*peerGrade, 0, len(order))
+	for _, id := range order {
+		rs := bySub[id]
+		grades = append(grades, gradePeerSubmission(s, rs[0].Submission, rs))
+	}
+	sort.Slice(grades, func(i, j int) bool {
+		return grades[i].Submission.User.Email < grades[j].Submission.User.Email
+	})
+	return grades, nil
+}
+
+///////////////////////////////////////////////////////
+// ПЛЕЕР
+///////////////////////////////////////////////////////
+
+// peerReviewView — задание с рецензированием глазами ученика.
+type peerReviewView struct {
+	Settings    *peerReviewSettings
+	Phase       string
+	Tasks, Done int        // рецензии, назначенные ученику, и отправленные из них
+	Feedback    *peerGrade // отзывы о работе ученика; после срока рецензирования
+}
+
+func loadPeerReviewView(blk *Block, user *User, now time.Time) (*peerReviewView, error) {
+	s := blk.Data.(*assignmentPayload).PeerReview
+	v := &peerReviewView{Settings: s, Phase: peerPhase(s, now)}
+	if v.Phase == peerPhaseSubmission {
+		return v, nil
+	}
+	if err := ensurePeerAssignments(blk, s, now); err != nil {
+		return nil, err
+	}
+	var mine []PeerReview
+	if err := db.Where("block_id = ? AND reviewer_id = ?", blk.ID, user.ID).Find(&mine).Error; err != nil {
+		return nil, err
+	}
+	v.Tasks = len(mine)
+	for _, r := range mine {
+		if r.SubmittedAt != nil {
+			v.Done++
+		}
+	}
+	if v.Phase != peerPhaseClosed {
+		return v, nil
+	}
+	var received []PeerReview
+	if err := db.Preload("Submission").
+		Joins("JOIN submissions s ON s.id = peer_reviews.submission_id").
+		Where("peer_reviews.block_id = ? AND s.user_id = ?", blk.ID, user.ID).
+		Order("peer_reviews.id").Find(&received).Error; err != nil {
+		return nil, err
+	}
+	if len(received) > 0 {
+		v.Feedback = gradePeerSubmission(s, received[0].Submission, received)
+	}
+	return v, nil
+}
+
+///////////////////////////////////////////////////////
+// СТРАНИЦА РЕЦЕНЗЕНТА
+///////////////////////////////////////////////////////
+
+func registerPeerReviewRoutes(r *gin.Engine) {
+	g := r.Group("/peer-review", authRequired())
+	{
+		g.GET("/:block_id", peerReviewPageHandler)
+		g.POST("/:block_id/:review_id", peerReviewSaveHandler)
+		g.GET("/:block_id/:review_id/file", peerReviewFileHandler)
+	}
+}
+
+// peerReviewBlock — задание с рецензированием из :block_id; false — ошибка уже отдана клиенту.
+func peerReviewBlock(c *gin.Context) (*Block, *peerReviewSettings, bool) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return nil, nil, false
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err == nil && blk.Type == assignmentBlock.Type {
+		decodeBlockData(blk)
+		if s := peerSettings(blk); s != nil {
+			return blk, s, true
+		}
+	}
+	c.String(http.StatusNotFound, "Задание с рецензированием не найдено")
+	return nil, nil, false
+}
+
+// reviewerTask — рецензия, назначенная пользователю; nil, если она не его или из другого задания.
+func reviewerTask(c *gin.Context, blk *Block, user *User) *PeerReview {
+	id, err := strconv.Atoi(c.Param("review_id"))
+	if err != nil {
+		return nil
+	}
+	var r PeerReview
+	if err := db.Preload("Submission").
+		Where("id = ? AND block_id = ? AND reviewer_id = ?", id, blk.ID, user.ID).First(&r).Error; err != nil {
+		return nil
+	}
+	return &r
+}
+
+// peerTask — работа на странице рецензента: номер вместо имени автора.
+type peerTask struct {
+	Number int
+	Review PeerReview
+	Scores map[string]int
+	Ext    string // расширение файла работы
+}
+
+// Points — баллы по критерию (для шаблонов).
+func (t peerTask) Points(id string) int { return t.Scores[id] }
+
+func peerReviewPageHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	blk, s, ok := peerReviewBlock(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	phase := peerPhase(s, now)
+	if err := ensurePeerAssignments(blk, s, now); err != nil {
+		log.Printf("peer review: распределение задания %d: %v\n", blk.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка распределения работ")
+		return
+	}
+
+	var reviews []PeerReview
+	if err := db.Preload("Submission").Where("block_id = ? AND reviewer_id = ?", blk.ID, user.ID).
+		Order("id").Find(&reviews).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки рецензий")
+		return
+	}
+	tasks := make([]peerTask, len(reviews))
+	for i, r := range reviews {
+		tasks[i] = peerTask{
+			Number: i + 1,
+			Review: r,
+			Scores: decodePeerScores(&reviews[i]),
+			Ext:    strings.ToLower(filepath.Ext(r.Submission.OriginalName)),
+		}
+	}
+
+	c.HTML(http.StatusOK, "peer_review.html", gin.H{
+		"User":     user,
+		"Block":    blk,
+		"Settings": s,
+		"Phase":    phase,
+		"Tasks":    tasks,
+		"Flash":    popFlash(c),
+	})
+}
+
+func peerReviewSaveHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	blk, s, ok := peerReviewBlock(c)
+	if !ok {
+		return
+	}
+	r := reviewerTask(c, blk, user)
+	if r == nil {
+		c.String(http.StatusNotFound, "Рецензия не найдена")
+		return
+	}
+	page := "/peer-review/" + strconv.Itoa(int(blk.ID))
+	if peerPhase(s, time.Now()) != peerPhaseReview {
+		setFlash(c, "warning", "Рецензирование закрыто — оценки не сохранены.")
+		c.Redirect(http.StatusFound, page)
+		return
+	}
+
+	scores := map[string]int{}
+	for _, rc := range s.Rubric {
+		v, err := strconv.Atoi(strings.TrimSpace(c.PostForm("rc_" + rc.ID)))
+		if err != nil || v < 0 || v > rc.Points {
+			setFlash(c, "warning", fmt.Sprintf("Критерий «%s»: баллы от 0 до %d.", rc.Title, rc.Points))
+			c.Redirect(http.StatusFound, page+"#review-"+strconv.Itoa(int(r.ID)))
+			return
+		}
+		scores[rc.ID] = v
+	}
+	comment := strings.TrimSpace(c.PostForm("comment"))
+	if len([]rune(comment)) > peerCommentMaxLen {
+		setFlash(c, "warning", fmt.Sprintf("Комментарий длиннее %d символов.", peerCommentMaxLen))
+		c.Redirect(http.StatusFound, page+"#review-"+strconv.Itoa(int(r.ID)))
+		return
+	}
+
+	raw, _ := json.Marshal(scores)
+	now := time.Now()
+	if err := db.Model(r).Updates(map[string]any{
+		"scores":       datatypes.JSON(raw),
+		"comment":      comment,
+		"submitted_at": now,
+	}).Error; err != nil {
+		log.Printf("peer review: рецензия %d: %v\n", r.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка сохранения рецензии")
+		return
+	}
+	setFlash(c, "success", "Рецензия сохранена. Её можно исправить до конца рецензирования.")
+	c.Redirect(http.StatusFound, page+"#review-"+strconv.Itoa(int(r.ID)))
+}
+
+// peerReviewFileHandler отдаёт работу рецензенту под нейтральным именем: в сохранённом
+// имени файла есть ID автора, а исходное имя может содержать его фамилию.
+func peerReviewFileHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	blk, _, ok := peerReviewBlock(c)
+	if !ok {
+		return
+	}
+	r := reviewerTask(c, blk, user)
+	if r == nil || r.Submission.StoredPath == "" {
+		c.String(http.StatusNotFound, "Файл не найден")
+		return
+	}
+	name := fmt.Sprintf("work-%d%s", r.ID, strings.ToLower(filepath.Ext(r.Submission.OriginalName)))
+	c.FileAttachment(r.Submission.StoredPath, name)
+}
@@ -0,0 +1,130 @@
+// peer_review_test.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestMedian(t *testing.T) {
+	cases := []struct {
+		xs   []float64
+		want float64
+	}{
+		{[]float64{5}, 5},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+		{[]float64{10, 10, 90}, 10},
+	}
+	for _, tc := range cases {
+		in := append([]float64(nil), tc.xs...)
+		if got := median(tc.xs); got != tc.want {
+			t.Errorf("median(%v) = %v, ожидается %v", in, got, tc.want)
+		}
+		if !reflect.DeepEqual(tc.xs, in) {
+			t.Errorf("median(%v) изменила входной срез: %v", in, tc.xs)
+		}
+	}
+}
+
+// Выброс — дальше от медианы, чем max(3·1,4826·MAD, peerOutlierMinGap); меньше трёх оценок — выбросов нет.
+func TestPeerOutliers(t *testing.T) {
+	cases := []struct {
+		name string
+		xs   []float64
+		want []bool
+	}{
+		{"нет оценок", nil, []bool{}},
+		{"две оценки — не судим", []float64{0, 100}, []bool{false, false}},
+		{"все одинаковые", []float64{50, 50, 50}, []bool{false, false, false}},
+		// медиана 81, MAD 2,5 → 3·1,4826·2,5 ≈ 11,1 < 15: порог — peerOutlierMinGap
+		{"далеко от согласных", []float64{80, 82, 85, 20}, []bool{false, false, false, true}},
+		// медиана 73: отклонение 15 — ровно порог, не выброс; 17 — выброс
+		{"на пороге MinGap", []float64{70, 72, 74, 88}, []bool{false, false, false, false}},
+		{"за порогом MinGap", []float64{70, 72, 74, 90}, []bool{false, false, false, true}},
+		// MAD 0 — порог 15 с обеих сторон
+		{"выбросы с обеих сторон", []float64{0, 80, 80, 80, 100}, []bool{true, false, false, false, true}},
+		// медиана 52,5, MAD 5 → порог 3·1,4826·5 ≈ 22,24 > 15
+		{"порог по MAD: внутри", []float64{45, 50, 50, 55, 60, 74}, []bool{false, false, false, false, false, false}},
+		{"порог по MAD: снаружи", []float64{45, 50, 50, 55, 60, 75}, []bool{false, false, false, false, false, true}},
+		{"большой разброс — не выбросы", []float64{10, 40, 60, 90}, []bool{false, false, false, false}},
+	}
+	for _, tc := range cases {
+		if got := peerOutliers(tc.xs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: peerOutliers(%v) = %v, ожидается %v", tc.name, tc.xs, got, tc.want)
+		}
+	}
+}
+
+func TestGradePeerSubmission(t *testing.T) {
+	s := &peerReviewSettings{Rubric: []rubricCriterion{{ID: "a", Points: 10}, {ID: "b", Points: 10}}}
+	sent := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	review := func(a, b int) PeerReview {
+		return PeerReview{Scores: datatypes.JSON(fmt.Sprintf(`{"a":%d,"b":%d}`, a, b)), SubmittedAt: &sent}
+	}
+	pending := PeerReview{} // назначена, не отправлена
+	pct := func(v float64) *float64 { return &v }
+
+	cases := []struct {
+		name       string
+		override   *float64
+		reviews    []PeerReview
+		assigned   int
+		outliers   []bool
+		peer       *float64
+		final      *float64
+		overridden bool
+	}{
+		{"рецензий ещё нет", nil, []PeerReview{pending, pending}, 2, nil, nil, nil, false},
+		{"две рецензии — среднее без отсева", nil,
+			[]PeerReview{review(10, 10), review(1, 1), pending}, 3,
+			[]bool{false, false}, pct(55), pct(55), false},
+		{"баллы выше максимума критерия обрезаются", nil,
+			[]PeerReview{review(15, 5)}, 1,
+			[]bool{false}, pct(75), pct(75), false},
+		{"выброс не входит в среднее", nil,
+			[]PeerReview{review(8, 8), review(8, 9), review(9, 8), review(1, 1)}, 4,
+			[]bool{false, false, false, true}, pct(250.0 / 3), pct(250.0 / 3), false},
+		{"оценка преподавателя важнее рецензий", pct(40),
+			[]PeerReview{review(8, 8), review(8, 9), review(9, 8), review(1, 1)}, 4,
+			[]bool{false, false, false, true}, pct(250.0 / 3), pct(40), true},
+		{"оценка преподавателя без рецензий", pct(0),
+			[]PeerReview{pending}, 1, nil, nil, pct(0), true},
+	}
+	for _, tc := range cases {
+		g := gradePeerSubmission(s, Submission{PeerOverride: tc.override}, tc.reviews)
+		if g.Assigned != tc.assigned {
+			t.Errorf("%s: назначено %d, ожидается %d", tc.name, g.Assigned, tc.assigned)
+		}
+		var outliers []bool
+		for _, r := range g.Reviews {
+			outliers = append(outliers, r.Outlier)
+		}
+		if !reflect.DeepEqual(outliers, tc.outliers) {
+			t.Errorf("%s: выбросы %v, ожидается %v", tc.name, outliers, tc.outliers)
+		}
+		if !samePercent(g.Peer, tc.peer) || !samePercent(g.Final, tc.final) || g.Overridden != tc.overridden {
+			t.Errorf("%s: Peer=%v Final=%v Overridden=%v, ожидается %v %v %v", tc.name,
+				fmtPercent(g.Peer), fmtPercent(g.Final), g.Overridden, fmtPercent(tc.peer), fmtPercent(tc.final), tc.overridden)
+		}
+	}
+}
+
+func samePercent(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-9
+}
+
+func fmtPercent(p *float64) string {
+	if p == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%.2f", *p)
+}
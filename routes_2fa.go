@@ -0,0 +1,350 @@
+// routes_2fa.go
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// сколько живёт «полувход» между паролем и кодом
+const pendingLoginTTL = 5 * time.Minute
+
+var errBadTOTPCode = errors.New("неверный код")
+
+func registerTwoFactorRoutes(r *gin.Engine) {
+	// второй шаг входа (user_id в сессии ещё нет)
+	r.GET("/login/2fa", loginTwoFactorGetHandler)
+	r.POST("/login/2fa", loginTwoFactorPostHandler)
+	r.GET("/login/2fa/setup", loginTwoFactorSetupGetHandler)
+	r.POST("/login/2fa/setup", loginTwoFactorSetupPostHandler)
+
+	// управление 2FA из аккаунта
+	acc := r.Group("/account/2fa", authRequired())
+	{
+		acc.GET("", accountTwoFactorGetHandler)
+		acc.POST("/enable", accountTwoFactorEnableHandler)
+		acc.POST("/disable", accountTwoFactorDisableHandler)
+		acc.POST("/recovery-codes", accountTwoFactorRecoveryHandler)
+	}
+}
+
+// pendingUser — пользователь, который ввёл пароль, но ещё не прошёл второй шаг.
+func pendingUser(c *gin.Context) *User {
+	sess := sessions.Default(c)
+	id, ok := sessionUint(sess.Get("pending_user_id"))
+	if !ok {
+		return nil
+	}
+	at, _ := sess.Get("pending_at").(int64)
+	if time.Since(time.Unix(at, 0)) > pendingLoginTTL {
+		sess.Delete("pending_user_id")
+		sess.Delete("pending_at")
+		_ = sess.Save()
+		return nil
+	}
+
+	var user User
+	if err := db.First(&user, id).Error; err != nil {
+		return nil
+	}
+	return &user
+}
+
+// verifySecondFactor принимает либо 6-значный TOTP, либо резервный код вида XXXX-XXXX.
+func verifySecondFactor(u *User, code string) (usedRecovery bool, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		return false, verifyTOTP(u, code)
+	}
+	return true, useRecoveryCode(u.ID, code)
+}
+
+///////////////////////////////////////////////////////
+// ВТОРОЙ ШАГ ВХОДА
+///////////////////////////////////////////////////////
+
+// pendingNext — куда перейти после второго шага (challengeSecondFactor); читать до finishLogin.
+func pendingNext(c *gin.Context) string {
+	next, _ := sessions.Default(c).Get("pending_next").(string)
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/dashboard"
+	}
+	return next
+}
+
+func loginTwoFactorGetHandler(c *gin.Context) {
+	user := pendingUser(c)
+	if user == nil || !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	c.HTML(http.StatusOK, "login_2fa.html", gin.H{})
+}
+
+func loginTwoFactorPostHandler(c *gin.Context) {
+	user := pendingUser(c)
+	if user == nil || !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	ip := c.ClientIP()
+	if wait := loginBlockedFor(ip, user.Email); wait > 0 {
+		c.HTML(http.StatusTooManyRequests, "login_2fa.html", gin.H{
+			"Error": "Слишком много неудачных попыток. Повторите через " + humanDuration(wait),
+		})
+		return
+	}
+
+	usedRecovery, ok := verifySecondFactor(user, c.PostForm("code"))
+	if !ok {
+		recordLoginFailure(ip, user.Email, c.Request.UserAgent(), "bad_2fa")
+		c.HTML(http.StatusUnauthorized, "login_2fa.html", gin.H{
+			"Error": "Неверный или уже использованный код",
+		})
+		return
+	}
+
+	recordLoginSuccess(ip, user.Email, c.Request.UserAgent())
+	next := pendingNext(c)
+	finishLogin(c, user)
+	if usedRecovery {
+		setFlash(c, "warning", "Вы вошли по резервному коду. Осталось кодов: "+
+			strconv.FormatInt(remainingRecoveryCodes(user.ID), 10)+".")
+	}
+	c.Redirect(http.StatusFound, next)
+}
+
+// Обязательная по политике 2FA, которую пользователь ещё не подключил
+func loginTwoFactorSetupGetHandler(c *gin.Context) {
+	user := pendingUser(c)
+	if user == nil || user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	renderTwoFactorSetup(c, http.StatusOK, user, true, "")
+}
+
+func loginTwoFactorSetupPostHandler(c *gin.Context) {
+	user := pendingUser(c)
+	if user == nil || user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	codes, err := enableTOTP(c, user, c.PostForm("code"))
+	if err != nil {
+		renderTwoFactorSetup(c, http.StatusBadRequest, user, true, err.Error())
+		return
+	}
+
+	recordLoginSuccess(c.ClientIP(), user.Email, c.Request.UserAgent())
+	next := pendingNext(c)
+	finishLogin(c, user)
+	c.HTML(http.StatusOK, "account_2fa.html", gin.H{
+		"User":          user,
+		"Account":       user,
+		"RecoveryCodes": codes,
+		"Next":          next,
+	})
+}
+
+///////////////////////////////////////////////////////
+// УПРАВЛЕНИЕ 2FA В АККАУНТЕ
+///////////////////////////////////////////////////////
+
+func accountTwoFactorGetHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.TOTPEnabled {
+		c.HTML(http.StatusOK, "account_2fa.html", gin.H{
+			"User":      user,
+			"Account":   user,
+			"Required":  twoFactorRequired(user),
+			"Remaining": remainingRecoveryCodes(user.ID),
+			"Flash":     popFlash(c),
+		})
+		return
+	}
+	renderTwoFactorSetup(c, http.StatusOK, user, false, "")
+}
+
+func accountTwoFactorEnableHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+
+	codes, err := enableTOTP(c, user, c.PostForm("code"))
+	if err != nil {
+		renderTwoFactorSetup(c, http.StatusBadRequest, user, false, err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "account_2fa.html", gin.H{
+		"User":          user,
+		"Account":       user,
+		"RecoveryCodes": codes,
+		"Next":          "/account/2fa",
+	})
+}
+
+func accountTwoFactorDisableHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	if twoFactorRequired(user) {
+		setFlash(c, "danger", "Для вашей роли двухфакторная аутентификация обязательна.")
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	if _, ok := verifySecondFactor(user, c.PostForm("code")); !ok {
+		setFlash(c, "danger", "Неверный код — 2FA не отключена.")
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]any{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка отключения 2FA")
+		return
+	}
+
+	rotateSession(c)
+	setFlash(c, "success", "Двухфакторная аутентификация отключена.")
+	c.Redirect(http.StatusFound, "/account/2fa")
+}
+
+func accountTwoFactorRecoveryHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	if !verifyTOTP(user, c.PostForm("code")) {
+		setFlash(c, "danger", "Неверный код — резервные коды не изменены.")
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = regenerateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации резервных кодов")
+		return
+	}
+
+	c.HTML(http.StatusOK, "account_2fa.html", gin.H{
+		"User":          user,
+		"Account":       user,
+		"RecoveryCodes": codes,
+		"Next":          "/account/2fa",
+	})
+}
+
+///////////////////////////////////////////////////////
+// helpers
+///////////////////////////////////////////////////////
+
+// renderTwoFactorSetup показывает QR для подключения; секрет до подтверждения живёт в сессии.
+func renderTwoFactorSetup(c *gin.Context, status int, user *User, pending bool, errMsg string) {
+	sess := sessions.Default(c)
+	secret, _ := sess.Get("totp_setup_secret").(string)
+
+	key, err := newTOTPKey(user, secret)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации ключа 2FA")
+		return
+	}
+	if secret == "" {
+		sess.Set("totp_setup_secret", key.Secret())
+		_ = sess.Save()
+	}
+
+	qr, err := totpQRDataURI(key)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации QR-кода")
+		return
+	}
+
+	action := "/account/2fa/enable"
+	var current *User
+	if pending {
+		action = "/login/2fa/setup"
+	} else {
+		current = user
+	}
+
+	c.HTML(status, "account_2fa.html", gin.H{
+		"User":     current,
+		"Account":  user,
+		"Setup":    true,
+		"Pending":  pending,
+		"Required": twoFactorRequired(user),
+		"QR":       template.URL(qr), // data:image/png;base64,...
+		"Secret":   key.Secret(),
+		"Action":   action,
+		"Error":    errMsg,
+		"Flash":    popFlash(c),
+	})
+}
+
+// enableTOTP подтверждает выданный секрет кодом и включает 2FA; возвращает резервные коды.
+func enableTOTP(c *gin.Context, user *User, code string) ([]string, error) {
+	sess := sessions.Default(c)
+	secret, _ := sess.Get("totp_setup_secret").(string)
+	if secret == "" {
+		return nil, errors.New("сессия настройки истекла, отсканируйте QR-код ещё раз")
+	}
+
+	step := matchTOTPStep(secret, code, time.Now())
+	if step < 0 {
+		return nil, errBadTOTPCode
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]any{
+			"totp_secret":    secret,
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = regenerateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("ошибка сохранения настроек 2FA")
+	}
+
+	// смена уровня защиты аккаунта — новая сессия
+	rotateSession(c)
+	sess.Delete("totp_setup_secret")
+	_ = sess.Save()
+	return codes, nil
+}
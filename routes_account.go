@@ -0,0 +1,546 @@
+// routes_account.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	AvatarsDir     = "static/uploads/avatars"
+	avatarMaxBytes = 2 << 20
+	emailChangeTTL = 24 * time.Hour
+)
+
+func registerAccountRoutes(r *gin.Engine) {
+	// ссылка из письма: работает и без входа, токен одноразовый
+	r.GET("/account/email/confirm", accountEmailConfirmHandler)
+
+	acc := r.Group("/account", authRequired())
+	{
+		// профиль
+		acc.GET("/profile", accountProfileHandler)
+		acc.POST("/profile", accountProfileUpdateHandler)
+		acc.POST("/profile/email", accountEmailChangeHandler)
+		acc.POST("/profile/password", accountPasswordHandler)
+		acc.POST("/profile/avatar", accountAvatarHandler)
+		acc.POST("/profile/avatar/delete", accountAvatarDeleteHandler)
+
+		// мои данные
+		acc.GET("/export", accountExportHandler)
+		acc.POST("/delete", accountDeleteHandler)
+
+		// активные сессии (устройства)
+		acc.GET("/sessions", accountSessionsHandler)
+		acc.POST("/sessions/:session_id/revoke", accountSessionRevokeHandler)
+		acc.POST("/sessions/revoke-others", accountSessionsRevokeOthersHandler)
+		acc.POST("/sessions/revoke-all", accountSessionsRevokeAllHandler)
+
+		// личные API-токены
+		acc.GET("/tokens", accountTokensHandler)
+		acc.POST("/tokens", accountTokenCreateHandler)
+		acc.POST("/tokens/:token_id/revoke", accountTokenRevokeHandler)
+	}
+}
+
+///////////////////////////////////////////////////////
+// ПРОФИЛЬ
+///////////////////////////////////////////////////////
+
+func accountProfileHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+
+	var pending EmailChange
+	hasPending := db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).
+		Order("created_at desc").First(&pending).Error == nil
+
+	data := gin.H{
+		"User":      user,
+		"Local":     user.AuthSource == AuthSourceLocal,
+		"Retention": loadRetentionPolicy(),
+		"Flash":     popFlash(c),
+	}
+	if hasPending {
+		data["PendingEmail"] = pending.NewEmail
+	}
+	c.HTML(http.StatusOK, "account_profile.html", data)
+}
+
+func accountProfileUpdateHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.AuthSource != AuthSourceLocal {
+		setFlash(c, "warning", "Имя берётся из корпоративного каталога.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("full_name"))
+	if len([]rune(name)) > 255 {
+		setFlash(c, "danger", "Слишком длинное имя.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	if err := db.Model(user).Update("full_name", name).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения профиля")
+		return
+	}
+	setFlash(c, "success", "Профиль сохранён.")
+	c.Redirect(http.StatusFound, "/account/profile")
+}
+
+// Смена email: пишем на новый адрес ссылку, адрес меняется только после перехода по ней.
+func accountEmailChangeHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.AuthSource != AuthSourceLocal {
+		setFlash(c, "warning", "Email берётся из корпоративного каталога.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	if !checkUserPassword(user, c.PostForm("password")) {
+		setFlash(c, "danger", "Неверный текущий пароль.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	// только голый адрес: без имени ("X" <y@z>) и без списка (a@b, c@d) — на него уходит письмо
+	newEmail := strings.ToLower(strings.TrimSpace(c.PostForm("email")))
+	if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+		setFlash(c, "danger", "Укажите корректный email.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	if newEmail == strings.ToLower(user.Email) {
+		setFlash(c, "info", "Это ваш текущий email.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	var count int64
+	db.Model(&User{}).Where("lower(email) = ?", newEmail).Count(&count)
+	if count > 0 {
+		setFlash(c, "danger", "Пользователь с таким email уже существует.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации токена")
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// действует только последний запрос
+		if err := tx.Where("user_id = ?", user.ID).Delete(&EmailChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&EmailChange{
+			UserID:    user.ID,
+			NewEmail:  newEmail,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(emailChangeTTL),
+		}).Error
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения запроса")
+		return
+	}
+
+	link := appBaseURL() + "/account/email/confirm?token=" + token
+	if err := sendMail(newEmail, "Подтвердите новый email в TrainBrain",
+		"Чтобы сменить email аккаунта TrainBrain на этот адрес, перейдите по ссылке:\n\n"+
+			link+"\n\nСсылка действует 24 часа. Если вы ничего не меняли — просто проигнорируйте письмо.\n"); err != nil {
+		c.String(http.StatusInternalServerError, "Не удалось отправить письмо")
+		return
+	}
+	// старый адрес тоже предупреждаем
+	_ = sendMail(user.Email, "Запрошена смена email в TrainBrain",
+		"Для вашего аккаунта TrainBrain запрошена смена email на "+newEmail+
+			".\nЕсли это были не вы — смените пароль и завершите все сессии в профиле.\n")
+
+	setFlash(c, "success", "Письмо со ссылкой для подтверждения отправлено на "+newEmail+".")
+	c.Redirect(http.StatusFound, "/account/profile")
+}
+
+func accountEmailConfirmHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.String(http.StatusBadRequest, "Ссылка некорректна")
+		return
+	}
+
+	var ch EmailChange
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&ch).Error; err != nil {
+		c.String(http.StatusNotFound, "Ссылка недействительна или уже использована")
+		return
+	}
+	if time.Now().After(ch.ExpiresAt) {
+		db.Delete(&ch)
+		c.String(http.StatusGone, "Срок действия ссылки истёк — запросите смену email ещё раз")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&User{}).Where("lower(email) = ? AND id <> ?", ch.NewEmail, ch.UserID).Count(&count)
+		if count > 0 {
+			return errors.New("email занят")
+		}
+		if err := tx.Model(&User{}).Where("id = ?", ch.UserID).Update("email", ch.NewEmail).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ch).Error
+	})
+	if err != nil {
+		c.String(http.StatusConflict, "Не удалось сменить email: адрес уже используется")
+		return
+	}
+
+	setFlash(c, "success", "Email изменён на "+ch.NewEmail+".")
+	if getCurrentUser(c) != nil {
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	c.Redirect(http.StatusFound, "/login")
+}
+
+func accountPasswordHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.AuthSource != AuthSourceLocal {
+		setFlash(c, "warning", "Пароль меняется в корпоративном каталоге.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	if !checkUserPassword(user, c.PostForm("current_password")) {
+		setFlash(c, "danger", "Неверный текущий пароль.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	password := c.PostForm("password")
+	if password == "" || password != c.PostForm("password2") {
+		setFlash(c, "danger", "Новые пароли пусты или не совпадают.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сервера")
+		return
+	}
+	if err := db.Model(user).Update("password_hash", string(hash)).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения пароля")
+		return
+	}
+
+	// остальные устройства выходят, текущая сессия получает новый ID
+	if _, err := revokeUserSessions(user.ID, sessions.Default(c).ID()); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка завершения сессий")
+		return
+	}
+	rotateSession(c)
+	setFlash(c, "success", "Пароль изменён. Сессии на других устройствах завершены.")
+	c.Redirect(http.StatusFound, "/account/profile")
+}
+
+func accountAvatarHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		setFlash(c, "danger", "Файл не передан.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+	if file.Size > avatarMaxBytes {
+		setFlash(c, "danger", "Аватар должен быть не больше 2 МБ.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	// расширение выбираем по содержимому, а не по имени файла
+	src, err := file.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка чтения файла")
+		return
+	}
+	head := make([]byte, 512)
+	n, _ := src.Read(head)
+	src.Close()
+	exts := map[string]string{
+		"image/png":  ".png",
+		"image/jpeg": ".jpg",
+		"image/gif":  ".gif",
+		"image/webp": ".webp",
+	}
+	ext, ok := exts[http.DetectContentType(head[:n])]
+	if !ok {
+		setFlash(c, "danger", "Допустимые форматы: PNG, JPEG, GIF, WebP.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	if err := os.MkdirAll(AvatarsDir, 0o755); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка создания директории")
+		return
+	}
+	path := filepath.Join(AvatarsDir, strconv.Itoa(int(user.ID))+"_"+strconv.FormatInt(time.Now().UnixNano(), 10)+ext)
+	if err := c.SaveUploadedFile(file, path); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения файла")
+		return
+	}
+
+	old := user.AvatarPath
+	if err := db.Model(user).Update("avatar_path", path).Error; err != nil {
+		os.Remove(path)
+		c.String(http.StatusInternalServerError, "Ошибка сохранения профиля")
+		return
+	}
+	if old != "" {
+		os.Remove(old)
+	}
+	setFlash(c, "success", "Аватар обновлён.")
+	c.Redirect(http.StatusFound, "/account/profile")
+}
+
+func accountAvatarDeleteHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user.AvatarPath != "" {
+		if err := db.Model(user).Update("avatar_path", "").Error; err != nil {
+			c.String(http.StatusInternalServerError, "Ошибка сохранения профиля")
+			return
+		}
+		os.Remove(user.AvatarPath)
+	}
+	c.Redirect(http.StatusFound, "/account/profile")
+}
+
+///////////////////////////////////////////////////////
+// МОИ ДАННЫЕ: ВЫГРУЗКА И УДАЛЕНИЕ
+///////////////////////////////////////////////////////
+
+func accountExportHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	name := "trainbrain-export-" + strconv.Itoa(int(user.ID)) + "-" + time.Now().Format("20060102") + ".zip"
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Status(http.StatusOK)
+	if err := writeUserExport(c.Writer, user); err != nil {
+		// заголовки уже ушли — остаётся только оборвать архив и записать в лог
+		_ = c.Error(err)
+	}
+}
+
+func accountDeleteHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+
+	// подтверждение: локальные — паролем, из каталога — повторным вводом email
+	confirmed := false
+	if user.AuthSource == AuthSourceLocal {
+		confirmed = checkUserPassword(user, c.PostForm("password"))
+	} else {
+		confirmed = strings.EqualFold(strings.TrimSpace(c.PostForm("email")), user.Email)
+	}
+	if !confirmed {
+		setFlash(c, "danger", "Подтверждение не совпало — аккаунт не удалён.")
+		c.Redirect(http.StatusFound, "/account/profile")
+		return
+	}
+
+	if err := eraseUser(user, loadRetentionPolicy()); err != nil {
+		if errors.Is(err, errLastAdmin) {
+			setFlash(c, "danger", "Нельзя удалить единственного администратора.")
+			c.Redirect(http.StatusFound, "/account/profile")
+			return
+		}
+		c.String(http.StatusInternalServerError, "Ошибка удаления данных")
+		return
+	}
+
+	logoutSession(c)
+	c.Redirect(http.StatusFound, "/")
+}
+
+// ---------- helpers ----------
+
+func checkUserPassword(u *User, password string) bool {
+	if password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// randomToken — 32 случайных байта в base64url (для ссылок в письмах и т.п.).
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken — в БД храним только sha256 от токена.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+///////////////////////////////////////////////////////
+// СЕССИИ
+///////////////////////////////////////////////////////
+
+// sessionRow — строка списка сессий для шаблона
+type sessionRow struct {
+	ID         string
+	Device     string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool
+}
+
+func accountSessionsHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+
+	var rows []sessionRow
+	if sessionStoreIsServerSide {
+		var list []UserSession
+		if err := db.Where("user_id = ?", user.ID).
+			Order("last_seen_at desc").
+			Find(&list).Error; err != nil {
+			c.String(http.StatusInternalServerError, "Ошибка загрузки сессий")
+			return
+		}
+
+		currentID := sessions.Default(c).ID()
+		for _, s := range list {
+			rows = append(rows, sessionRow{
+				ID:         s.ID,
+				Device:     deviceLabel(s.UserAgent),
+				IP:         s.IP,
+				CreatedAt:  s.CreatedAt,
+				LastSeenAt: s.LastSeenAt,
+				Current:    s.ID == currentID,
+			})
+		}
+	}
+
+	c.HTML(http.StatusOK, "account_sessions.html", gin.H{
+		"User":       user,
+		"Sessions":   rows,
+		"ServerSide": sessionStoreIsServerSide,
+		"Flash":      popFlash(c),
+	})
+}
+
+func accountSessionRevokeHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	id := c.Param("session_id")
+
+	if id == sessions.Default(c).ID() {
+		logoutSession(c)
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	if err := revokeUserSession(user.ID, id); err != nil {
+		setFlash(c, "warning", "Сессия уже завершена.")
+	} else {
+		setFlash(c, "success", "Сессия завершена.")
+	}
+	c.Redirect(http.StatusFound, "/account/sessions")
+}
+
+func accountSessionsRevokeOthersHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	n, err := revokeUserSessions(user.ID, sessions.Default(c).ID())
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка завершения сессий")
+		return
+	}
+	setFlash(c, "success", "Завершено сессий на других устройствах: "+strconv.FormatInt(n, 10)+".")
+	c.Redirect(http.StatusFound, "/account/sessions")
+}
+
+// «Выйти везде» — включая текущее устройство
+func accountSessionsRevokeAllHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if _, err := revokeUserSessions(user.ID, ""); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка завершения сессий")
+		return
+	}
+	logoutSession(c)
+	c.Redirect(http.StatusFound, "/login")
+}
+
+///////////////////////////////////////////////////////
+// API-ТОКЕНЫ
+///////////////////////////////////////////////////////
+
+func renderAccountTokens(c *gin.Context, status int, user *User, extra gin.H) {
+	var tokens []APIToken
+	if err := db.Where("user_id = ?", user.ID).Order("created_at desc").Find(&tokens).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки токенов")
+		return
+	}
+	data := gin.H{
+		"User":   user,
+		"Tokens": tokens,
+		"Scopes": scopesFor(user),
+		"Flash":  popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "account_tokens.html", data)
+}
+
+func accountTokensHandler(c *gin.Context) {
+	renderAccountTokens(c, http.StatusOK, getCurrentUser(c), nil)
+}
+
+// accountTokenCreateHandler выпускает токен и показывает его прямо в ответе —
+// без редиректа, чтобы открытый текст не попадал в сессию/flash.
+func accountTokenCreateHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+
+	plain, tok, err := issueAPIToken(user, user, c.PostForm("name"), c.PostFormArray("scopes"), tokenTTLFromForm(c))
+	if err != nil {
+		renderAccountTokens(c, http.StatusBadRequest, user, gin.H{"Error": err.Error()})
+		return
+	}
+	renderAccountTokens(c, http.StatusOK, user, gin.H{"NewToken": plain, "NewTokenName": tok.Name})
+}
+
+func accountTokenRevokeHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	id, err := strconv.Atoi(c.Param("token_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID токена")
+		return
+	}
+	if err := revokeAPIToken(uint(id), user.ID); err != nil {
+		setFlash(c, "warning", "Токен не найден или уже отозван.")
+	} else {
+		setFlash(c, "success", "Токен отозван.")
+	}
+	c.Redirect(http.StatusFound, "/account/tokens")
+}
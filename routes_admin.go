@@ -3,13 +3,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/datatypes"
@@ -29,56 +33,52 @@ func payloadToMap(j datatypes.JSON) map[string]any {
 	return pm
 }
 
-func buildBlockPayloadFromForm(c *gin.Context, blockType string) (datatypes.JSON, error) {
-	pm := map[string]any{}
-
-	title := strings.TrimSpace(c.PostForm("payload_title"))
-	if title != "" {
-		pm["title"] = title
+// buildBlockPayloadFromForm собирает payload из формы разбором своего типа блока (blocks.go).
+// prev — текущий payload блока (nil для нового): нужен блокам, которые хранят загруженный пакет
+// или уже нарисованные диаграммы. warnings — ошибки в формулах и диаграммах: блок с ними
+// сохраняется, а автор видит их в форме.
+func buildBlockPayloadFromForm(c *gin.Context, blockType string, prev datatypes.JSON) (payload datatypes.JSON, warnings []string, err error) {
+	k := blockKinds[blockType]
+	if k == nil {
+		return nil, nil, fmt.Errorf("неизвестный тип блока %q", blockType)
 	}
-
-	switch blockType {
-	case "text":
-		pm["text"] = c.PostForm("payload_text")
-		img := strings.TrimSpace(c.PostForm("payload_image_url"))
-		if img != "" {
-			pm["image_url"] = img
-		}
-
-	case "assignment":
-		pm["prompt"] = c.PostForm("payload_prompt")
-
-	case "video":
-		mode := strings.TrimSpace(c.PostForm("payload_mode"))
-		if mode == "" {
-			mode = "embed"
-		}
-		pm["mode"] = mode
-
-		if u := strings.TrimSpace(c.PostForm("payload_url")); u != "" {
-			pm["url"] = u
-			// если где-то в старом коде ты читаешь video_url — оставим совместимость
-			pm["video_url"] = u
-		}
-		if s := strings.TrimSpace(c.PostForm("payload_src")); s != "" {
-			pm["src"] = s
-			pm["path"] = s
-		}
-
-	case "quiz":
-		ps := strings.TrimSpace(c.PostForm("payload_pass_score"))
-		if ps != "" {
-			if v, err := strconv.Atoi(ps); err == nil {
-				pm["pass_score"] = v
-			}
-		}
+	pp := k.payload()
+	if len(prev) > 0 {
+		_ = json.Unmarshal(prev, pp)
 	}
-
-	b, err := json.Marshal(pm)
+	p, warnings, err := k.parseForm(c, pp)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	// сырой HTML в тексте чистим, ссылки и адреса с недопустимой схемой не принимаем
+	payload, err = sanitizePayloadForSave(blockType, datatypes.JSON(b))
+	if err == nil {
+		err = checkBlockPayload(k, payload)
+	}
+	if err != nil {
+		cleanupScormPackage(datatypes.JSON(b), prev)
+		return nil, nil, err
+	}
+	return payload, warnings, nil
+}
+
+// cleanupScormPackage удаляет пакет из old, если в cur он уже не используется
+// (пакет заменён, тип блока сменился или блок удалён — тогда cur == nil).
+func cleanupScormPackage(old, cur datatypes.JSON) {
+	var o, n scormBlockPayload
+	if len(old) == 0 || json.Unmarshal(old, &o) != nil || o.Package == "" {
+		return
+	}
+	if len(cur) > 0 {
+		_ = json.Unmarshal(cur, &n)
+	}
+	if n.Package != o.Package {
+		removeScormPackage(o.Package)
 	}
-	return datatypes.JSON(b), nil
 }
 
 
@@ -92,6 +92,11 @@ func registerAdminRoutes(r *gin.Engine) {
 		admin.GET("/courses", adminCoursesListHandler)
 		admin.GET("/courses/new", adminCourseNewGetHandler)
 		admin.POST("/courses/new", adminCourseNewPostHandler)
+		admin.GET("/courses/import", adminCourseImportHandler)
+		admin.POST("/courses/import", adminCourseImportPostHandler)
+		admin.GET("/courses/:course_id/export", adminCourseExportHandler)
+		admin.GET("/courses/:course_id/clone", adminCourseCloneGetHandler)
+		admin.POST("/courses/:course_id/clone", adminCourseClonePostHandler)
 		admin.GET("/courses/:course_id/edit", adminCourseEditGetHandler)
 		admin.POST("/courses/:course_id/edit", adminCourseEditPostHandler)
 		admin.POST("/courses/:course_id/delete", adminCourseDeleteHandler)
@@ -110,8 +115,8 @@ func registerAdminRoutes(r *gin.Engine) {
 		admin.POST("/blocks/:block_id/edit", adminBlockEditPostHandler)
 		admin.POST("/blocks/:block_id/delete", adminBlockDeleteHandler)
 
-		// UPLOAD IMAGE
-		admin.POST("/uploads/image", adminUploadImageHandler)
+		// UPLOADS: image, file, pdf
+		admin.POST("/uploads/:kind", adminUploadHandler)
 
 		// SUBMISSIONS
 		admin.GET("/submissions", adminSubmissionsListHandler)
@@ -122,6 +127,12 @@ func registerAdminRoutes(r *gin.Engine) {
 
 		// QUIZ attempts overview
 		admin.GET("/courses/:course_id/quiz-attempts", adminQuizAttemptsHandler)
+		admin.GET("/blocks/:block_id/scorm", adminScormAttemptsHandler)
+		admin.GET("/blocks/:block_id/survey", adminSurveyResultsHandler)
+		admin.GET("/blocks/:block_id/survey/export", adminSurveyExportHandler)
+		admin.GET("/blocks/:block_id/peer-review", adminPeerReviewHandler)
+		admin.POST("/submissions/:submission_id/peer-grade", adminPeerGradeHandler)
+		admin.GET("/blocks/:block_id/video", adminVideoReportHandler)
 
 		// QUIZ admin
 		admin.GET("/quizzes/:block_id", adminQuizEditHandler)
@@ -135,6 +146,43 @@ func registerAdminRoutes(r *gin.Engine) {
 		admin.GET("/quizzes/options/:option_id/edit", adminQuizOptionEditGetHandler)
 		admin.POST("/quizzes/options/:option_id/edit", adminQuizOptionEditPostHandler)
 		admin.POST("/quizzes/options/:option_id/delete", adminQuizOptionDeleteHandler)
+
+		// SECURITY: блокировки и история входов
+		admin.GET("/security/logins", adminLoginSecurityHandler)
+		admin.POST("/security/unlock", adminLoginUnlockHandler)
+		admin.POST("/security/revoke-sessions", adminRevokeSessionsHandler)
+
+		// API-ТОКЕНЫ и сервисные аккаунты
+		admin.GET("/tokens", adminTokensHandler)
+		admin.POST("/tokens/:token_id/revoke", adminTokenRevokeHandler)
+		admin.POST("/service-accounts", adminServiceAccountCreateHandler)
+		admin.POST("/service-accounts/:user_id/tokens", adminServiceTokenCreateHandler)
+		admin.POST("/service-accounts/:user_id/delete", adminServiceAccountDeleteHandler)
+
+		// ВЕБХУКИ
+		admin.GET("/webhooks", adminWebhooksHandler)
+		admin.POST("/webhooks", adminWebhookCreateHandler)
+		admin.GET("/webhooks/:webhook_id", adminWebhookHandler)
+		admin.POST("/webhooks/:webhook_id", adminWebhookUpdateHandler)
+		admin.POST("/webhooks/:webhook_id/rotate-secret", adminWebhookRotateSecretHandler)
+		admin.POST("/webhooks/:webhook_id/test", adminWebhookTestHandler)
+		admin.POST("/webhooks/:webhook_id/delete", adminWebhookDeleteHandler)
+		admin.GET("/webhook-deliveries/:delivery_id", adminWebhookDeliveryHandler)
+		admin.POST("/webhook-deliveries/:delivery_id/redeliver", adminWebhookRedeliverHandler)
+
+		// xAPI / LRS
+		admin.GET("/xapi", adminXAPIHandler)
+		admin.POST("/xapi/check", adminXAPICheckHandler)
+		admin.POST("/xapi/backfill", adminXAPIBackfillHandler)
+		admin.POST("/xapi/retry-failed", adminXAPIRetryHandler)
+
+		// LTI 1.3: платформы (LMS)
+		admin.GET("/lti", adminLTIHandler)
+		admin.POST("/lti", adminLTICreateHandler)
+		admin.GET("/lti/:platform_id", adminLTIPlatformHandler)
+		admin.POST("/lti/:platform_id", adminLTIPlatformUpdateHandler)
+		admin.POST("/lti/:platform_id/retry-failed", adminLTIRetryHandler)
+		admin.POST("/lti/:platform_id/delete", adminLTIDeleteHandler)
 	}
 }
 
@@ -174,7 +222,10 @@ func adminIndexHandler(c *gin.Context) {
     <div class="ms-auto d-flex gap-2 align-items-center">
       <span class="navbar-text text-light me-3">` + html.EscapeString(email) + `</span>
       <a class="btn btn-outline-light btn-sm" href="/">На сайт</a>
-      <a class="btn btn-outline-warning btn-sm" href="/logout">Выйти</a>
+      <form method="post" action="/logout" class="d-inline m-0">
+        <input type="hidden" name="_csrf" value="` + html.EscapeString(csrfToken(c)) + `">
+        <button type="submit" class="btn btn-outline-warning btn-sm">Выйти</button>
+      </form>
     </div>
   </div>
 </nav>
@@ -192,6 +243,11 @@ func adminIndexHandler(c *gin.Context) {
   <div class="d-flex flex-column gap-2">
     <a href="/admin/courses" class="btn btn-primary btn-sm" style="max-width: 260px;">Управление курсами</a>
     <a href="/admin/submissions" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">Проверка заданий</a>
+    <a href="/admin/security/logins" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">Безопасность входа</a>
+    <a href="/admin/tokens" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">API-токены</a>
+    <a href="/admin/webhooks" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">Вебхуки</a>
+    <a href="/admin/xapi" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">xAPI / LRS</a>
+    <a href="/admin/lti" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">LTI 1.3 (LMS)</a>
     <a href="/courses" class="btn btn-outline-secondary btn-sm" style="max-width: 260px;">Список курсов (для пользователей)</a>
     <a href="/" class="btn btn-link btn-sm" style="max-width: 260px;">На главную</a>
   </div>
@@ -221,8 +277,10 @@ func adminCoursesListHandler(c *gin.Context) {
 
 func adminCourseNewGetHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "admin/course_form.html", gin.H{
-		"course": nil,
-		"title":  "Новый курс",
+		"course":      nil,
+		"title":       "Новый курс",
+		"templates":   courseTemplates(),
+		"template_id": c.Query("template_id"),
 	})
 }
 
@@ -236,13 +294,40 @@ func adminCourseNewPostHandler(c *gin.Context) {
 
 	if title == "" {
 		c.HTML(http.StatusBadRequest, "admin/course_form.html", gin.H{
-			"Error":  "Название курса обязательно",
-			"title":  "Новый курс",
-			"course": nil,
+			"Error":       "Название курса обязательно",
+			"title":       "Новый курс",
+			"course":      nil,
+			"templates":   courseTemplates(),
+			"template_id": c.PostForm("template_id"),
 		})
 		return
 	}
 
+	// курс на основе шаблона — полная копия шаблона с новыми названием и статусом
+	if tplID, _ := strconv.Atoi(c.PostForm("template_id")); tplID > 0 {
+		res, err := cloneCourse(uint(tplID), courseCloneOptions{
+			Title:      title,
+			ShortDesc:  shortDesc,
+			Status:     status,
+			CopyMedia:  c.PostForm("copy_media") == "on",
+			ResetDates: true,
+		})
+		if err != nil {
+			log.Printf("course from template %d: %v\n", tplID, err)
+			c.HTML(http.StatusInternalServerError, "admin/course_form.html", gin.H{
+				"Error":       "Не удалось создать курс из шаблона",
+				"title":       "Новый курс",
+				"course":      nil,
+				"templates":   courseTemplates(),
+				"template_id": c.PostForm("template_id"),
+			})
+			return
+		}
+		setCloneFlash(c, res)
+		c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(int(res.Course.ID))+"/edit")
+		return
+	}
+
 	course := Course{
 		Title:     title,
 		ShortDesc: shortDesc,
@@ -281,9 +366,8 @@ func adminCourseEditGetHandler(c *gin.Context) {
 	}
 
 	for mi := range course.Modules {
-	for bi := range course.Modules[mi].Blocks {
-		b := &course.Modules[mi].Blocks[bi]
-		b.PayloadMap = payloadToMap(b.Payload)
+		for bi := range course.Modules[mi].Blocks {
+			decodeBlockData(&course.Modules[mi].Blocks[bi])
 		}
 	}
 
@@ -294,6 +378,7 @@ func adminCourseEditGetHandler(c *gin.Context) {
 		"short_desc": course.ShortDesc,
 		"status":     course.Status,
 		"modules":    course.Modules,
+		"Flash":      popFlash(c),
 	})
 }
 
@@ -331,6 +416,7 @@ func adminCourseEditPostHandler(c *gin.Context) {
 	course.Title = title
 	course.ShortDesc = shortDesc
 	course.Status = status
+	course.IsTemplate = c.PostForm("is_template") == "on"
 
 	if err := db.Save(&course).Error; err != nil {
 		c.HTML(http.StatusInternalServerError, "admin/course_form.html", gin.H{
@@ -505,11 +591,12 @@ func adminBlockNewGetHandler(c *gin.Context) {
 	}
 
 	c.HTML(http.StatusOK, "admin/block_form.html", gin.H{
-		"Module":   module,
-		"Block":    nil,
-		"Payload":  map[string]any{},
-		"CourseID": module.CourseID,
-		"Error":    "",
+		"Module":     module,
+		"Block":      nil,
+		"Payload":    map[string]any{},
+		"CourseID":   module.CourseID,
+		"BlockTypes": blockKindList,
+		"Error":      "",
 	})
 }
 
@@ -546,14 +633,15 @@ func adminBlockNewPostHandler(c *gin.Context) {
 		}
 	}
 
-	payloadJSON, err := buildBlockPayloadFromForm(c, blockType)
+	payloadJSON, warnings, err := buildBlockPayloadFromForm(c, blockType, nil)
 	if err != nil {
 		c.HTML(http.StatusBadRequest, "admin/block_form.html", gin.H{
-			"Module":   module,
-			"Block":    nil,
-			"Payload":  map[string]any{},
-			"CourseID": module.CourseID,
-			"Error":    "Ошибка формирования payload",
+			"Module":     module,
+			"Block":      nil,
+			"Payload":    map[string]any{},
+			"CourseID":   module.CourseID,
+			"BlockTypes": blockKindList,
+			"Error":      "Ошибка формирования payload: " + err.Error(),
 		})
 		return
 	}
@@ -565,19 +653,32 @@ func adminBlockNewPostHandler(c *gin.Context) {
 		Payload:  payloadJSON,
 	}
 	if err := db.Create(&block).Error; err != nil {
+		cleanupScormPackage(payloadJSON, nil)
 		c.HTML(http.StatusInternalServerError, "admin/block_form.html", gin.H{
-			"Module":   module,
-			"Block":    nil,
-			"Payload":  payloadToMap(payloadJSON),
-			"CourseID": module.CourseID,
-			"Error":    "Ошибка сохранения блока",
+			"Module":     module,
+			"Block":      nil,
+			"Payload":    payloadToMap(payloadJSON),
+			"CourseID":   module.CourseID,
+			"BlockTypes": blockKindList,
+			"Error":      "Ошибка сохранения блока",
 		})
 		return
 	}
+	if len(warnings) > 0 {
+		setBlockWarningsFlash(c, warnings)
+		c.Redirect(http.StatusFound, "/admin/blocks/"+strconv.Itoa(int(block.ID))+"/edit")
+		return
+	}
 
 	c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(int(module.CourseID))+"/edit")
 }
 
+// setBlockWarningsFlash — блок сохранён, но не всё получилось: формулы или диаграммы
+// не отрисовались, превью ссылки не загрузилось.
+func setBlockWarningsFlash(c *gin.Context, warnings []string) {
+	setFlash(c, "warning", "Блок сохранён с замечаниями: "+strings.Join(warnings, "; ")+".")
+}
+
 
 func adminBlockEditGetHandler(c *gin.Context) {
 	blockID, err := strconv.Atoi(c.Param("block_id"))
@@ -593,11 +694,13 @@ func adminBlockEditGetHandler(c *gin.Context) {
 	}
 
 	c.HTML(http.StatusOK, "admin/block_form.html", gin.H{
-		"Module":   block.Module,
-		"Block":    block,
-		"Payload":  payloadToMap(block.Payload),
-		"CourseID": block.Module.CourseID,
-		"Error":    "",
+		"Module":     block.Module,
+		"Block":      block,
+		"Payload":    payloadToMap(block.Payload),
+		"CourseID":   block.Module.CourseID,
+		"BlockTypes": blockKindList,
+		"Error":      "",
+		"Flash":      popFlash(c),
 	})
 }
 
@@ -626,64 +729,232 @@ func adminBlockEditPostHandler(c *gin.Context) {
 		}
 	}
 
-	payloadJSON, err := buildBlockPayloadFromForm(c, blockType)
+	prevPayload := block.Payload
+	if block.Type != blockType {
+		prevPayload = nil
+	}
+	payloadJSON, warnings, err := buildBlockPayloadFromForm(c, blockType, prevPayload)
 	if err != nil {
 		c.HTML(http.StatusBadRequest, "admin/block_form.html", gin.H{
-			"Module":   block.Module,
-			"Block":    block,
-			"Payload":  payloadToMap(block.Payload),
-			"CourseID": block.Module.CourseID,
-			"Error":    "Ошибка формирования payload",
+			"Module":     block.Module,
+			"Block":      block,
+			"Payload":    payloadToMap(block.Payload),
+			"CourseID":   block.Module.CourseID,
+			"BlockTypes": blockKindList,
+			"Error":      "Ошибка формирования payload: " + err.Error(),
 		})
 		return
 	}
 
+	oldPayload := block.Payload
 	block.Type = blockType
 	block.Payload = payloadJSON
 
 	if err := db.Save(&block).Error; err != nil {
+		cleanupScormPackage(payloadJSON, oldPayload)
 		c.HTML(http.StatusInternalServerError, "admin/block_form.html", gin.H{
-			"Module":   block.Module,
-			"Block":    block,
-			"Payload":  payloadToMap(payloadJSON),
-			"CourseID": block.Module.CourseID,
-			"Error":    "Ошибка сохранения блока",
+			"Module":     block.Module,
+			"Block":      block,
+			"Payload":    payloadToMap(payloadJSON),
+			"CourseID":   block.Module.CourseID,
+			"BlockTypes": blockKindList,
+			"Error":      "Ошибка сохранения блока",
 		})
 		return
 	}
+	cleanupScormPackage(oldPayload, payloadJSON)
+	if len(warnings) > 0 {
+		setBlockWarningsFlash(c, warnings)
+		c.Redirect(http.StatusFound, "/admin/blocks/"+strconv.Itoa(int(block.ID))+"/edit")
+		return
+	}
 
 	c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(int(block.Module.CourseID))+"/edit")
 }
 
 
 ///////////////////////////////////////////////////////
-// UPLOAD IMAGE
+// UPLOADS
 ///////////////////////////////////////////////////////
 
-func adminUploadImageHandler(c *gin.Context) {
-	file, err := c.FormFile("image")
+// contentRelPath — каталог загруженных материалов курсов относительно static/.
+func contentRelPath() string {
+	return envOr("CONTENT_IMAGES_REL_PATH", "uploads/content")
+}
+
+// uploadKind — что принимает загрузка для формы блока: расширения через пробел
+// (Env переопределяет список), проверка начала файла и приведение к формату хранения
+// (Convert получает весь файл и его расширение, возвращает данные и новое расширение).
+type uploadKind struct {
+	Exts    string
+	Env     string
+	Check   func(head []byte) error
+	Convert func(data []byte, ext string) ([]byte, string, error)
+}
+
+var uploadKinds = map[string]uploadKind{
+	"image": {Exts: ".png .jpg .jpeg .gif .webp"},
+	"pdf": {Exts: ".pdf", Check: func(head []byte) error {
+		if !isPDF(head) {
+			return errNotPDF
+		}
+		return nil
+	}},
+	"file": {
+		Exts: ".pdf .doc .docx .xls .xlsx .ppt .pptx .odt .ods .odp .rtf .txt .csv .md .epub " +
+			".zip .7z .rar .tar .gz .png .jpg .jpeg .gif .webp .mp3 .mp4",
+		Env: "UPLOAD_FILE_EXTS",
+	},
+	// субтитры видео: SRT сохраняется как WebVTT (video_captions.go)
+	"subtitles": {Exts: ".vtt .srt", Convert: prepareSubtitles},
+}
+
+// Файлы раздаются из /static с нашего домена: HTML, SVG и скрипты выполнились бы в браузере
+// от имени сайта, поэтому их не принимает никакой вид загрузки, даже через UPLOAD_FILE_EXTS.
+var uploadForbiddenExts = map[string]bool{
+	".html": true, ".htm": true, ".xhtml": true, ".shtml": true, ".xml": true,
+	".svg": true, ".svgz": true, ".js": true, ".mjs": true, ".php": true,
+}
+
+func (k uploadKind) allows(ext string) bool {
+	exts := k.Exts
+	if k.Env != "" {
+		exts = envOr(k.Env, exts)
+	}
+	for _, e := range strings.FieldsFunc(strings.ToLower(exts), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if e == ext || "."+e == ext {
+			return !uploadForbiddenExts[ext]
+		}
+	}
+	return false
+}
+
+// contentUpload — ответ загрузки и сведения о файле для payload блока.
+type contentUpload struct {
+	URL   string `json:"url"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MIME  string `json:"mime"`
+	Pages int    `json:"pages,omitempty"`
+}
+
+// contentFilePath — путь на диске для адреса загруженного материала ("" — адрес не наш).
+func contentFilePath(u string) string {
+	name, ok := strings.CutPrefix(u, contentURLPrefix())
+	if !ok || name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\?#`) {
+		return ""
+	}
+	return filepath.Join("static", contentRelPath(), name)
+}
+
+// describeContent — размер, тип и (для PDF) число страниц загруженного файла.
+func describeContent(u string) (*contentUpload, error) {
+	path := contentFilePath(u)
+	if path == "" {
+		return nil, errors.New("файл не загружен на сайт")
+	}
+	info, err := describeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info.URL = u
+	return info, nil
+}
+
+// describeFile — то же для файла на диске (course-sync читает файлы из каталога курса).
+func describeFile(path string) (*contentUpload, error) {
+	st, err := os.Stat(path)
+	if err != nil || st.IsDir() {
+		return nil, errors.New("файл не найден: " + filepath.Base(path))
+	}
+	info := &contentUpload{Name: filepath.Base(path), Size: st.Size(), MIME: contentMIME(path)}
+	if strings.EqualFold(filepath.Ext(path), ".pdf") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Pages, err = pdfPageCount(data); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+func contentMIME(name string) string {
+	t := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if t == "" {
+		return "application/octet-stream"
+	}
+	t, _, _ = strings.Cut(t, ";")
+	return t
+}
+
+// adminUploadHandler — загрузка для формы блока: kind — image (картинка текста), file (вложение),
+// pdf или subtitles. Файл — в поле file (image — поле старых форм). Ответ — contentUpload.
+func adminUploadHandler(c *gin.Context) {
+	kind, ok := uploadKinds[c.Param("kind")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Неизвестный вид загрузки"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		file, err = c.FormFile("image")
+	}
 	if err != nil || file.Filename == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не передан"})
 		return
 	}
+	if maxMB := envInt("UPLOAD_MAX_MB", 100); file.Size > int64(maxMB)<<20 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл больше " + strconv.Itoa(maxMB) + " МБ"})
+		return
+	}
 
 	ext := strings.ToLower(filepath.Ext(file.Filename))
-	allowed := map[string]bool{
-		".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
-	}
-	if !allowed[ext] {
+	if !kind.allows(ext) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Недопустимый формат файла"})
 		return
 	}
+	if kind.Check != nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не читается"})
+			return
+		}
+		head := make([]byte, 1024)
+		n, _ := io.ReadFull(f, head)
+		f.Close()
+		if err := kind.Check(head[:n]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	safeName := filepath.Base(file.Filename)
-	name := time.Now().UTC().Format("20060102150405.000000") + "_" + safeName
-
-	contentRelPath := os.Getenv("CONTENT_IMAGES_REL_PATH")
-	if contentRelPath == "" {
-		contentRelPath = "uploads/content"
+	base := filepath.Base(file.Filename)
+	var data []byte // приведённое содержимое; nil — файл сохраняется как есть
+	if kind.Convert != nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не читается"})
+			return
+		}
+		data, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не читается"})
+			return
+		}
+		var newExt string
+		if data, newExt, err = kind.Convert(data, ext); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		base = strings.TrimSuffix(base, filepath.Ext(base)) + newExt
 	}
-	relPath := filepath.Join(contentRelPath, name)
+
+	name := newContentName(base)
+
+	relPath := filepath.Join(contentRelPath(), name)
 	absPath := filepath.Join("static", relPath)
 
 	if err := os.MkdirAll(filepath.Dir(absPath), 0o755); err != nil {
@@ -691,13 +962,24 @@ func adminUploadImageHandler(c *gin.Context) {
 		return
 	}
 
-	if err := c.SaveUploadedFile(file, absPath); err != nil {
+	if data != nil {
+		err = os.WriteFile(absPath, data, 0o644)
+	} else {
+		err = c.SaveUploadedFile(file, absPath)
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения файла"})
 		return
 	}
 
-	url := "/static/" + filepath.ToSlash(relPath)
-	c.JSON(http.StatusOK, gin.H{"url": url})
+	info, err := describeContent("/static/" + filepath.ToSlash(relPath))
+	if err != nil {
+		os.Remove(absPath)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info.Name = base
+	c.JSON(http.StatusOK, info)
 }
 
 ///////////////////////////////////////////////////////
@@ -740,11 +1022,12 @@ func adminSubmissionViewPostHandler(c *gin.Context) {
 		return
 	}
 	var sub Submission
-	if err := db.First(&sub, subID).Error; err != nil {
+	if err := db.Preload("User").First(&sub, subID).Error; err != nil {
 		c.String(http.StatusNotFound, "Отправка не найдена")
 		return
 	}
 
+	prevStatus := sub.Status
 	sub.Status = c.PostForm("status")
 	sub.Comment = c.PostForm("comment")
 
@@ -752,6 +1035,7 @@ func adminSubmissionViewPostHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, "Ошибка обновления статуса")
 		return
 	}
+	emitSubmissionReviewed(&sub, prevStatus, getCurrentUser(c))
 
 	c.Redirect(http.StatusFound, "/admin/submissions/"+strconv.Itoa(int(sub.ID)))
 }
@@ -838,6 +1122,36 @@ func adminQuizAttemptsHandler(c *gin.Context) {
 	})
 }
 
+// adminScormAttemptsHandler — состояние SCORM-пакета у каждого, кто его открывал.
+func adminScormAttemptsHandler(c *gin.Context) {
+	blockID, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return
+	}
+	block, err := loadBlockCourse(uint(blockID))
+	if err != nil || block.Type != "scorm" {
+		c.String(http.StatusNotFound, "SCORM-блок не найден")
+		return
+	}
+
+	var attempts []ScormAttempt
+	if err := db.Preload("User").
+		Where("block_id = ?", block.ID).
+		Order("updated_at desc").
+		Find(&attempts).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки результатов")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin/scorm_attempts.html", gin.H{
+		"block":    block,
+		"title":    blockTitle(block),
+		"payload":  scormPayload(block),
+		"attempts": attempts,
+	})
+}
+
 func adminBlockDeleteHandler(c *gin.Context) {
 	blockID, err := strconv.Atoi(c.Param("block_id"))
 	if err != nil {
@@ -857,6 +1171,7 @@ func adminBlockDeleteHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, "Ошибка удаления блока")
 		return
 	}
+	cleanupScormPackage(block.Payload, nil)
 
 	c.Redirect(http.StatusFound,
 		"/admin/courses/"+strconv.Itoa(int(courseID))+"/edit")
@@ -901,6 +1216,14 @@ func adminQuizEditHandler(c *gin.Context) {
 }
 
 
+// quizMathError — ошибка в формулах $…$ текста вопроса или варианта ("" — всё в порядке).
+func quizMathError(text string) string {
+	if problems := mathTextProblems(text); len(problems) > 0 {
+		return "Ошибка в формуле: " + strings.Join(problems, "; ")
+	}
+	return ""
+}
+
 func adminQuizQuestionNewGetHandler(c *gin.Context) {
 	blockID, err := strconv.Atoi(c.Param("block_id"))
 	if err != nil {
@@ -938,6 +1261,14 @@ func adminQuizQuestionNewPostHandler(c *gin.Context) {
 		})
 		return
 	}
+	if msg := quizMathError(text); msg != "" {
+		c.HTML(http.StatusBadRequest, "admin/quiz_question_form.html", gin.H{
+			"Error": msg,
+			"block": block,
+			"title": "Новый вопрос",
+		})
+		return
+	}
 	q := QuizQuestion{
 		BlockID: block.ID,
 		Text:    text,
@@ -988,6 +1319,16 @@ func adminQuizQuestionEditPostHandler(c *gin.Context) {
 		})
 		return
 	}
+	if msg := quizMathError(text); msg != "" {
+		q.Text = text
+		c.HTML(http.StatusBadRequest, "admin/quiz_question_form.html", gin.H{
+			"Error": msg,
+			"block": q.Block,
+			"title": "Редактирование вопроса",
+			"q":     q,
+		})
+		return
+	}
 	q.Text = text
 	if err := db.Save(&q).Error; err != nil {
 		c.String(http.StatusInternalServerError, "Ошибка сохранения вопроса")
@@ -1075,6 +1416,16 @@ func adminQuizOptionNewPostHandler(c *gin.Context) {
 		}
 	}
 
+	if msg := quizMathError(text); msg != "" {
+		c.HTML(http.StatusBadRequest, "admin/quiz_question_form.html", gin.H{
+			"Error": msg,
+			"block": q.Block,
+			"title": "Новый вариант для вопроса #" + strconv.Itoa(int(q.ID)),
+			"q":     q,
+		})
+		return
+	}
+
 	opt := QuizOption{
 		QuestionID: q.ID,
 		Text:       text,
@@ -1153,6 +1504,17 @@ func adminQuizOptionEditPostHandler(c *gin.Context) {
 		}
 	}
 
+	if msg := quizMathError(text); msg != "" {
+		opt.Text = text
+		c.HTML(http.StatusBadRequest, "admin/quiz_question_form.html", gin.H{
+			"Error": msg,
+			"opt":   opt,
+			"block": opt.Question.Block,
+			"title": "Редактирование варианта для вопроса #" + strconv.Itoa(int(opt.QuestionID)),
+		})
+		return
+	}
+
 	opt.Text = text
 	opt.IsCorrect = isCorrect
 
@@ -0,0 +1,121 @@
+// routes_admin_course_archive.go
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// adminCourseExportHandler отдаёт курс архивом (см. course_archive.go).
+func adminCourseExportHandler(c *gin.Context) {
+	courseID, err := strconv.Atoi(c.Param("course_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID курса")
+		return
+	}
+	course, err := loadCourseTree(db, uint(courseID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Курс не найден")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки курса")
+		return
+	}
+
+	name := "trainbrain-course-" + strconv.Itoa(int(course.ID)) + "-" + time.Now().Format("20060102") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Status(http.StatusOK)
+	if err := writeCourseArchive(c.Writer, course); err != nil {
+		// заголовки уже ушли — остаётся только оборвать архив и записать в лог
+		_ = c.Error(err)
+	}
+}
+
+func adminCourseImportHandler(c *gin.Context) {
+	renderAdminCourseImport(c, http.StatusOK, gin.H{"mode": courseImportNew, "target": ""})
+}
+
+func renderAdminCourseImport(c *gin.Context, status int, extra gin.H) {
+	var courses []Course
+	if err := db.Order("title").Find(&courses).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки курсов")
+		return
+	}
+	data := gin.H{
+		"User":    getCurrentUser(c),
+		"courses": courses,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/course_import.html", data)
+}
+
+func adminCourseImportPostHandler(c *gin.Context) {
+	opts := courseImportOptions{Mode: c.PostForm("mode")}
+	form := gin.H{"mode": opts.Mode, "target": c.PostForm("target_id")}
+	fail := func(status int, msg string) {
+		form["Error"] = msg
+		renderAdminCourseImport(c, status, form)
+	}
+
+	switch opts.Mode {
+	case courseImportNew:
+	case courseImportOverwrite:
+		id, err := strconv.Atoi(c.PostForm("target_id"))
+		if err != nil || id <= 0 {
+			fail(http.StatusBadRequest, "Выберите курс, который нужно перезаписать")
+			return
+		}
+		opts.TargetID = uint(id)
+	default:
+		fail(http.StatusBadRequest, "Выберите режим импорта")
+		return
+	}
+
+	fh, err := c.FormFile("archive")
+	if err != nil {
+		fail(http.StatusBadRequest, "Файл архива не передан")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		fail(http.StatusBadRequest, "Не удалось прочитать файл")
+		return
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, fh.Size)
+	if err != nil {
+		fail(http.StatusBadRequest, "Файл не является ZIP-архивом")
+		return
+	}
+
+	res, err := importCourseArchive(zr, opts)
+	if err != nil {
+		fail(http.StatusBadRequest, "Импорт не выполнен: "+err.Error())
+		return
+	}
+	user := getCurrentUser(c)
+	log.Printf("course import (%s) by %s: course %d, modules %d, blocks %d, removed %d, media %d\n",
+		opts.Mode, user.Email, res.Course.ID, res.Modules, res.Blocks, res.Removed, res.Media)
+
+	msg := "Курс «" + res.Course.Title + "» импортирован: модулей " + strconv.Itoa(res.Modules) +
+		", блоков " + strconv.Itoa(res.Blocks) + ", файлов " + strconv.Itoa(res.Media) + "."
+	kind := "success"
+	if len(res.Warnings) > 0 {
+		msg += " Обратите внимание: " + strings.Join(res.Warnings, "; ") + "."
+		kind = "warning"
+	}
+	setFlash(c, kind, msg)
+	c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(int(res.Course.ID))+"/edit")
+}
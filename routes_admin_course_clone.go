@@ -0,0 +1,80 @@
+// routes_admin_course_clone.go
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// courseTemplates — курсы-шаблоны для формы нового курса.
+func courseTemplates() []Course {
+	var list []Course
+	if err := db.Where("is_template = ?", true).Order("title").Find(&list).Error; err != nil {
+		log.Printf("course templates: %v\n", err)
+	}
+	return list
+}
+
+func adminCourseCloneGetHandler(c *gin.Context) {
+	courseID, err := strconv.Atoi(c.Param("course_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID курса")
+		return
+	}
+	var course Course
+	if err := db.First(&course, courseID).Error; err != nil {
+		c.String(http.StatusNotFound, "Курс не найден")
+		return
+	}
+	c.HTML(http.StatusOK, "admin/course_clone.html", gin.H{
+		"User":        getCurrentUser(c),
+		"course":      course,
+		"new_title":   course.Title + " (копия)",
+		"copy_media":  true,
+		"reset_dates": true,
+	})
+}
+
+func adminCourseClonePostHandler(c *gin.Context) {
+	courseID, err := strconv.Atoi(c.Param("course_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID курса")
+		return
+	}
+	opts := courseCloneOptions{
+		Title:      strings.TrimSpace(c.PostForm("title")),
+		CopyMedia:  c.PostForm("copy_media") == "on",
+		ResetDates: c.PostForm("reset_dates") == "on",
+	}
+
+	res, err := cloneCourse(uint(courseID), opts)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Курс не найден")
+		return
+	}
+	if err != nil {
+		log.Printf("course clone %d: %v\n", courseID, err)
+		setFlash(c, "danger", "Не удалось скопировать курс: "+err.Error())
+		c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(courseID)+"/edit")
+		return
+	}
+	setCloneFlash(c, res)
+	c.Redirect(http.StatusFound, "/admin/courses/"+strconv.Itoa(int(res.Course.ID))+"/edit")
+}
+
+func setCloneFlash(c *gin.Context, res *courseCloneResult) {
+	msg := "Создан курс «" + res.Course.Title + "»: модулей " + strconv.Itoa(res.Modules) +
+		", блоков " + strconv.Itoa(res.Blocks) + ", скопировано файлов " + strconv.Itoa(res.Media) + "."
+	kind := "success"
+	if len(res.Warnings) > 0 {
+		msg += " Обратите внимание: " + strings.Join(res.Warnings, "; ") + "."
+		kind = "warning"
+	}
+	setFlash(c, kind, msg)
+}
@@ -0,0 +1,232 @@
+// routes_admin_lti.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ltiGradeLogLimit = 100
+
+// ltiPlatformRow — платформа со счётчиками для списка
+type ltiPlatformRow struct {
+	LTIPlatform
+	Users  int64
+	Links  int64
+	Failed int64
+}
+
+func adminLTIHandler(c *gin.Context) {
+	renderAdminLTI(c, http.StatusOK, nil)
+}
+
+// ltiToolInfo — что администратор LMS вводит при регистрации инструмента.
+func ltiToolInfo() gin.H {
+	info := gin.H{
+		"LoginURL":  appBaseURL() + "/lti/login",
+		"LaunchURL": ltiLaunchURL(),
+		"JWKSURL":   appBaseURL() + "/lti/jwks",
+	}
+	if pemStr, err := ltiToolPublicKeyPEM(); err == nil {
+		info["PublicKey"] = pemStr
+	}
+	return info
+}
+
+func renderAdminLTI(c *gin.Context, status int, extra gin.H) {
+	var platforms []LTIPlatform
+	if err := db.Order("id").Find(&platforms).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки платформ")
+		return
+	}
+	rows := make([]ltiPlatformRow, 0, len(platforms))
+	for _, p := range platforms {
+		row := ltiPlatformRow{LTIPlatform: p}
+		db.Model(&LTIUserLink{}).Where("platform_id = ?", p.ID).Count(&row.Users)
+		db.Model(&LTIResourceLink{}).Where("platform_id = ?", p.ID).Count(&row.Links)
+		db.Model(&LTIGrade{}).
+			Joins("JOIN lti_resource_links ON lti_resource_links.id = lti_grades.resource_link_id").
+			Where("lti_resource_links.platform_id = ? AND lti_grades.status = ?", p.ID, ltiGradeFailed).
+			Count(&row.Failed)
+		rows = append(rows, row)
+	}
+
+	data := gin.H{
+		"User":      getCurrentUser(c),
+		"platforms": rows,
+		"tool":      ltiToolInfo(),
+		"Flash":     popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/lti.html", data)
+}
+
+// ltiPlatformFromForm заполняет платформу из формы и проверяет адреса.
+func ltiPlatformFromForm(c *gin.Context, p *LTIPlatform) error {
+	p.Name = strings.TrimSpace(c.PostForm("name"))
+	p.Issuer = strings.TrimSpace(c.PostForm("issuer"))
+	p.ClientID = strings.TrimSpace(c.PostForm("client_id"))
+	p.DeploymentIDs = strings.Join(strings.Fields(strings.ReplaceAll(c.PostForm("deployment_ids"), ",", " ")), " ")
+	p.AuthLoginURL = strings.TrimSpace(c.PostForm("auth_login_url"))
+	p.AuthTokenURL = strings.TrimSpace(c.PostForm("auth_token_url"))
+	p.KeySetURL = strings.TrimSpace(c.PostForm("key_set_url"))
+	p.TrustEmail = c.PostForm("trust_email") == "on"
+
+	if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+		return errors.New("Укажите название, Issuer и Client ID")
+	}
+	for _, f := range []struct{ title, value string }{
+		{"адрес авторизации (OIDC)", p.AuthLoginURL},
+		{"адрес JWKS", p.KeySetURL},
+		{"адрес токена", p.AuthTokenURL},
+	} {
+		if f.value == "" && f.title == "адрес токена" {
+			continue // без него не будет только передачи оценок
+		}
+		u, err := url.Parse(f.value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("Некорректный " + f.title)
+		}
+	}
+	return nil
+}
+
+func adminLTICreateHandler(c *gin.Context) {
+	p := LTIPlatform{Active: true}
+	if err := ltiPlatformFromForm(c, &p); err != nil {
+		renderAdminLTI(c, http.StatusBadRequest, gin.H{"Error": err.Error(), "form": &p})
+		return
+	}
+	var dup int64
+	db.Model(&LTIPlatform{}).Where("issuer = ? AND client_id = ?", p.Issuer, p.ClientID).Count(&dup)
+	if dup > 0 {
+		renderAdminLTI(c, http.StatusConflict, gin.H{"Error": "Платформа с таким Issuer и Client ID уже зарегистрирована", "form": &p})
+		return
+	}
+	if err := db.Create(&p).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения платформы")
+		return
+	}
+	setFlash(c, "success", "Платформа «"+p.Name+"» зарегистрирована.")
+	c.Redirect(http.StatusFound, "/admin/lti/"+strconv.Itoa(int(p.ID)))
+}
+
+func loadLTIPlatform(c *gin.Context) (*LTIPlatform, bool) {
+	id, err := strconv.Atoi(c.Param("platform_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID платформы")
+		return nil, false
+	}
+	var p LTIPlatform
+	if err := db.First(&p, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Платформа не найдена")
+		return nil, false
+	}
+	return &p, true
+}
+
+// adminLTIPlatformHandler — настройки платформы, ссылки из её курсов и журнал оценок.
+func adminLTIPlatformHandler(c *gin.Context) {
+	p, ok := loadLTIPlatform(c)
+	if !ok {
+		return
+	}
+	renderAdminLTIPlatform(c, http.StatusOK, p, nil)
+}
+
+func renderAdminLTIPlatform(c *gin.Context, status int, p *LTIPlatform, extra gin.H) {
+	var links []LTIResourceLink
+	if err := db.Preload("Course").Where("platform_id = ?", p.ID).Order("updated_at desc").Find(&links).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки ссылок")
+		return
+	}
+
+	q := db.Preload("User").Preload("ResourceLink").
+		Joins("JOIN lti_resource_links ON lti_resource_links.id = lti_grades.resource_link_id").
+		Where("lti_resource_links.platform_id = ? AND lti_grades.status <> ?", p.ID, ltiGradeNone)
+	filter := c.Query("status")
+	if filter != "" {
+		q = q.Where("lti_grades.status = ?", filter)
+	}
+	var grades []LTIGrade
+	if err := q.Select("lti_grades.*").Order("lti_grades.updated_at desc").Limit(ltiGradeLogLimit).Find(&grades).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки оценок")
+		return
+	}
+
+	data := gin.H{
+		"User":     getCurrentUser(c),
+		"platform": p,
+		"links":    links,
+		"grades":   grades,
+		"filter":   filter,
+		"limit":    ltiGradeLogLimit,
+		"tool":     ltiToolInfo(),
+		"Flash":    popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/lti_platform.html", data)
+}
+
+func adminLTIPlatformUpdateHandler(c *gin.Context) {
+	p, ok := loadLTIPlatform(c)
+	if !ok {
+		return
+	}
+	if err := ltiPlatformFromForm(c, p); err != nil {
+		renderAdminLTIPlatform(c, http.StatusBadRequest, p, gin.H{"Error": err.Error()})
+		return
+	}
+	p.Active = c.PostForm("active") == "on"
+	if err := db.Save(p).Error; err != nil {
+		renderAdminLTIPlatform(c, http.StatusConflict, p, gin.H{"Error": "Платформа с таким Issuer и Client ID уже зарегистрирована"})
+		return
+	}
+	forgetPlatformKeys(p.ID)
+	forgetLTIToken(p.ID)
+	setFlash(c, "success", "Настройки сохранены.")
+	c.Redirect(http.StatusFound, "/admin/lti/"+strconv.Itoa(int(p.ID)))
+}
+
+func adminLTIRetryHandler(c *gin.Context) {
+	p, ok := loadLTIPlatform(c)
+	if !ok {
+		return
+	}
+	res := db.Model(&LTIGrade{}).
+		Where("status = ? AND resource_link_id IN (?)", ltiGradeFailed,
+			db.Model(&LTIResourceLink{}).Select("id").Where("platform_id = ?", p.ID)).
+		Updates(map[string]any{"status": ltiGradePending, "attempts": 0, "next_attempt_at": time.Now()})
+	if res.Error != nil {
+		c.String(http.StatusInternalServerError, "Ошибка постановки в очередь")
+		return
+	}
+	wakeLTIWorker()
+	setFlash(c, "info", "В очередь возвращено оценок: "+strconv.FormatInt(res.RowsAffected, 10)+".")
+	c.Redirect(http.StatusFound, "/admin/lti/"+strconv.Itoa(int(p.ID)))
+}
+
+func adminLTIDeleteHandler(c *gin.Context) {
+	p, ok := loadLTIPlatform(c)
+	if !ok {
+		return
+	}
+	if err := db.Delete(p).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка удаления платформы")
+		return
+	}
+	forgetPlatformKeys(p.ID)
+	forgetLTIToken(p.ID)
+	setFlash(c, "success", "Платформа «"+p.Name+"» удалена. Созданные через неё аккаунты остались.")
+	c.Redirect(http.StatusFound, "/admin/lti")
+}
@@ -0,0 +1,92 @@
+// routes_admin_peer_review.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminPeerReviewHandler — распределение и оценки взаимного рецензирования по заданию.
+// До срока сдачи — только список сдавших; рецензенты видны лишь здесь.
+func adminPeerReviewHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || blk.Type != assignmentBlock.Type {
+		c.String(http.StatusNotFound, "Задание не найдено")
+		return
+	}
+	decodeBlockData(blk)
+	s := peerSettings(blk)
+	if s == nil {
+		c.String(http.StatusNotFound, "У задания нет рецензирования")
+		return
+	}
+	now := time.Now()
+	if err := ensurePeerAssignments(blk, s, now); err != nil {
+		log.Printf("peer review: распределение задания %d: %v\n", blk.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка распределения работ")
+		return
+	}
+	grades, err := loadPeerGrades(blk, s)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки рецензий")
+		return
+	}
+	var round PeerReviewRound
+	assigned := db.Where("block_id = ?", blk.ID).Limit(1).Find(&round).RowsAffected > 0
+	var waiting []Submission // сдавшие до распределения
+	if !assigned {
+		if waiting, err = latestSubmissions(db, blk.ID); err != nil {
+			c.String(http.StatusInternalServerError, "Ошибка загрузки отправок")
+			return
+		}
+	}
+
+	c.HTML(http.StatusOK, "admin/peer_review.html", gin.H{
+		"block":    blk,
+		"title":    blockTitle(blk),
+		"settings": s,
+		"phase":    peerPhase(s, now),
+		"round":    round,
+		"assigned": assigned,
+		"grades":   grades,
+		"waiting":  waiting,
+	})
+}
+
+// adminPeerGradeHandler ставит оценку преподавателя вместо взаимной (0–100 %); пустое поле — снять.
+func adminPeerGradeHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("submission_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID отправки")
+		return
+	}
+	var sub Submission
+	if err := db.First(&sub, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Отправка не найдена")
+		return
+	}
+	var grade *float64
+	if raw := strings.TrimSpace(strings.ReplaceAll(c.PostForm("grade"), ",", ".")); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || v > 100 {
+			c.String(http.StatusBadRequest, "Оценка — число от 0 до 100")
+			return
+		}
+		grade = &v
+	}
+	if err := db.Model(&sub).Update("peer_override", grade).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения оценки")
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/blocks/"+strconv.Itoa(int(sub.BlockID))+"/peer-review#sub-"+strconv.Itoa(int(sub.ID)))
+}
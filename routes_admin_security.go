@@ -0,0 +1,104 @@
+// routes_admin_security.go
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lockedRow — строка таблицы заблокированных ключей
+type lockedRow struct {
+	Key         string
+	Kind        string // "аккаунт" | "IP" | "регистрация"
+	Subject     string
+	LockedUntil time.Time
+	Remaining   string
+	Lockouts    int
+}
+
+func adminLoginSecurityHandler(c *gin.Context) {
+	locked, err := loginLimiter.Locked()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки блокировок")
+		return
+	}
+
+	now := time.Now()
+	rows := make([]lockedRow, 0, len(locked))
+	for _, t := range locked {
+		kind, subject, _ := strings.Cut(t.Key, ":")
+		switch kind {
+		case "acct":
+			kind = "аккаунт"
+		case "ip":
+			kind = "IP"
+		case "reg":
+			kind = "регистрация"
+		}
+		rows = append(rows, lockedRow{
+			Key:         t.Key,
+			Kind:        kind,
+			Subject:     subject,
+			LockedUntil: t.LockedUntil,
+			Remaining:   humanDuration(t.remaining(now)),
+			Lockouts:    t.Lockouts,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].LockedUntil.After(rows[j].LockedUntil) })
+
+	q := db.Where("success = ?", false)
+	filter := strings.TrimSpace(c.Query("q"))
+	if filter != "" {
+		q = q.Where("email ILIKE ? OR ip = ?", "%"+filter+"%", filter)
+	}
+	var attempts []LoginAttempt
+	if err := q.Order("created_at desc").Limit(200).Find(&attempts).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки истории входов")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin/security.html", gin.H{
+		"User":     getCurrentUser(c),
+		"locked":   rows,
+		"attempts": attempts,
+		"q":        filter,
+		"Flash":    popFlash(c),
+	})
+}
+
+func adminLoginUnlockHandler(c *gin.Context) {
+	key := strings.TrimSpace(c.PostForm("key"))
+	if key == "" {
+		c.String(http.StatusBadRequest, "Не указан ключ блокировки")
+		return
+	}
+	if err := loginLimiter.Reset(key); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка снятия блокировки")
+		return
+	}
+	setFlash(c, "success", "Блокировка снята: "+key)
+	c.Redirect(http.StatusFound, "/admin/security/logins")
+}
+
+// adminRevokeSessionsHandler — принудительный выход пользователя на всех устройствах
+func adminRevokeSessionsHandler(c *gin.Context) {
+	email := strings.ToLower(strings.TrimSpace(c.PostForm("email")))
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		setFlash(c, "danger", "Пользователь не найден: "+email)
+		c.Redirect(http.StatusFound, "/admin/security/logins")
+		return
+	}
+	n, err := revokeUserSessions(user.ID, "")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка завершения сессий")
+		return
+	}
+	setFlash(c, "success", "Сессий завершено у "+user.Email+": "+strconv.FormatInt(n, 10))
+	c.Redirect(http.StatusFound, "/admin/security/logins")
+}
@@ -0,0 +1,101 @@
+// routes_admin_survey.go
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminSurveyBlock — опрос или голосование из :block_id с payload; false — ошибка уже отдана клиенту.
+func adminSurveyBlock(c *gin.Context) (*Block, bool) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return nil, false
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || !isSurveyType(blk.Type) {
+		c.String(http.StatusNotFound, "Опрос не найден")
+		return nil, false
+	}
+	decodeBlockData(blk)
+	return blk, true
+}
+
+// adminSurveyResultsHandler — итоги опроса со свободными ответами.
+func adminSurveyResultsHandler(c *gin.Context) {
+	blk, ok := adminSurveyBlock(c)
+	if !ok {
+		return
+	}
+	responses, err := loadSurveyResponses(blk.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки ответов")
+		return
+	}
+	var participants int64
+	db.Model(&SurveyParticipant{}).Where("block_id = ?", blk.ID).Count(&participants)
+
+	p := surveyOf(blk)
+	c.HTML(http.StatusOK, "admin/survey_results.html", gin.H{
+		"block":        blk,
+		"title":        blockTitle(blk),
+		"survey":       p,
+		"results":      aggregateSurvey(p, responses, true),
+		"participants": participants,
+	})
+}
+
+// adminSurveyExportHandler — ответы в CSV: строка на ответ, столбец на вопрос. Несколько
+// вариантов — через «; ». У анонимного опроса нет столбца пользователя и времени ответа —
+// только дата; то же для ответов, собранных до того, как опрос сделали анонимным.
+func adminSurveyExportHandler(c *gin.Context) {
+	blk, ok := adminSurveyBlock(c)
+	if !ok {
+		return
+	}
+	responses, err := loadSurveyResponses(blk.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки ответов")
+		return
+	}
+	p := surveyOf(blk)
+
+	header := []string{"date"}
+	if !p.Anonymous {
+		header = []string{"submitted_at", "email", "full_name"}
+	}
+	for _, q := range p.Questions {
+		header = append(header, q.Text)
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="survey-%d.csv"`, blk.ID))
+	// BOM — чтобы Excel открыл файл в UTF-8
+	c.Writer.WriteString("\ufeff")
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	for i := range responses {
+		r := &responses[i]
+		var row []string
+		switch {
+		case p.Anonymous:
+			row = []string{r.CreatedAt.Format("2006-01-02")}
+		case r.User != nil:
+			row = []string{r.CreatedAt.Format("2006-01-02 15:04"), r.User.Email, r.User.FullName}
+		default:
+			row = []string{r.CreatedAt.Format("2006-01-02 15:04"), "", ""}
+		}
+		answers := decodeSurveyAnswers(r)
+		for _, q := range p.Questions {
+			row = append(row, strings.Join(surveyAnswerStrings(answers[q.ID]), "; "))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+}
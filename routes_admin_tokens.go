@@ -0,0 +1,182 @@
+// routes_admin_tokens.go
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Сервисный аккаунт — пользователь без пароля (auth_source = service) для интеграций:
+// войти через /login нельзя, работает только по API-токенам, выпущенным администратором.
+const serviceEmailDomain = "service.local"
+
+// serviceAccountRow — сервисный аккаунт с его токенами
+type serviceAccountRow struct {
+	User   User
+	Tokens []APIToken
+	Scopes []apiScope
+}
+
+func adminTokensHandler(c *gin.Context) {
+	renderAdminTokens(c, http.StatusOK, nil)
+}
+
+func renderAdminTokens(c *gin.Context, status int, extra gin.H) {
+	var services []User
+	if err := db.Where("auth_source = ?", AuthSourceService).Order("id").Find(&services).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки сервисных аккаунтов")
+		return
+	}
+	rows := make([]serviceAccountRow, 0, len(services))
+	for i := range services {
+		var tokens []APIToken
+		if err := db.Where("user_id = ?", services[i].ID).Order("created_at desc").Find(&tokens).Error; err != nil {
+			c.String(http.StatusInternalServerError, "Ошибка загрузки токенов")
+			return
+		}
+		rows = append(rows, serviceAccountRow{User: services[i], Tokens: tokens, Scopes: scopesFor(&services[i])})
+	}
+
+	// все активные токены пользователей — чтобы админ мог отозвать утёкший
+	var personal []APIToken
+	if err := db.Preload("User").
+		Joins("JOIN users ON users.id = api_tokens.user_id").
+		Where("users.auth_source <> ? AND api_tokens.revoked_at IS NULL", AuthSourceService).
+		Where("api_tokens.expires_at IS NULL OR api_tokens.expires_at > ?", time.Now()).
+		Order("api_tokens.created_at desc").
+		Find(&personal).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки токенов")
+		return
+	}
+
+	data := gin.H{
+		"User":     getCurrentUser(c),
+		"Services": rows,
+		"Personal": personal,
+		"Flash":    popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/tokens.html", data)
+}
+
+func adminServiceAccountCreateHandler(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	role := c.PostForm("role")
+	if role != "admin" {
+		role = "student"
+	}
+
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r == ' ' || r == '_':
+			return '-'
+		}
+		return -1
+	}, strings.ToLower(name))
+	slug = strings.Trim(slug, "-")
+	if name == "" || slug == "" || len(slug) > 64 {
+		setFlash(c, "danger", "Название — латиница, цифры и дефис, до 64 символов.")
+		c.Redirect(http.StatusFound, "/admin/tokens")
+		return
+	}
+
+	email := slug + "@" + serviceEmailDomain
+	var count int64
+	db.Model(&User{}).Where("email = ?", email).Count(&count)
+	if count > 0 {
+		setFlash(c, "danger", "Сервисный аккаунт "+email+" уже существует.")
+		c.Redirect(http.StatusFound, "/admin/tokens")
+		return
+	}
+
+	user := User{
+		Email:        email,
+		PasswordHash: "!", // не bcrypt — войти по паролю невозможно
+		FullName:     name,
+		Role:         role,
+		AuthSource:   AuthSourceService,
+		CreatedAt:    time.Now(),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка создания сервисного аккаунта")
+		return
+	}
+	setFlash(c, "success", "Сервисный аккаунт "+email+" создан. Выпустите для него токен.")
+	c.Redirect(http.StatusFound, "/admin/tokens")
+}
+
+// loadServiceAccount — сервисный аккаунт из :user_id (обычных пользователей здесь не трогаем).
+func loadServiceAccount(c *gin.Context) (*User, bool) {
+	id, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID аккаунта")
+		return nil, false
+	}
+	var user User
+	if err := db.Where("auth_source = ?", AuthSourceService).First(&user, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Сервисный аккаунт не найден")
+		return nil, false
+	}
+	return &user, true
+}
+
+func adminServiceTokenCreateHandler(c *gin.Context) {
+	svc, ok := loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+
+	plain, tok, err := issueAPIToken(svc, getCurrentUser(c), c.PostForm("name"), c.PostFormArray("scopes"), tokenTTLFromForm(c))
+	if err != nil {
+		renderAdminTokens(c, http.StatusBadRequest, gin.H{"Error": svc.Email + ": " + err.Error()})
+		return
+	}
+	renderAdminTokens(c, http.StatusOK, gin.H{
+		"NewToken":     plain,
+		"NewTokenName": tok.Name,
+		"NewTokenFor":  svc.Email,
+	})
+}
+
+func adminServiceAccountDeleteHandler(c *gin.Context) {
+	svc, ok := loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	// у сервисного аккаунта нечего обезличивать — удаляем всё
+	all := retentionPolicy{Submissions: retentionDelete, QuizAttempts: retentionDelete, LoginHistory: retentionDelete,
+		PeerReviews: retentionDelete}
+	if err := eraseUser(svc, all); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка удаления сервисного аккаунта")
+		return
+	}
+	setFlash(c, "success", "Сервисный аккаунт "+svc.Email+" удалён, его токены больше не действуют.")
+	c.Redirect(http.StatusFound, "/admin/tokens")
+}
+
+// adminTokenRevokeHandler отзывает любой токен (личный или сервисный).
+func adminTokenRevokeHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("token_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID токена")
+		return
+	}
+	if err := revokeAPIToken(uint(id), 0); err != nil {
+		setFlash(c, "warning", "Токен не найден или уже отозван.")
+	} else {
+		setFlash(c, "success", "Токен отозван.")
+	}
+	c.Redirect(http.StatusFound, "/admin/tokens")
+}
@@ -0,0 +1,36 @@
+// routes_admin_video.go
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminVideoReportHandler — вовлечённость по видео-файлу: кривая удержания, средняя доля, зрители.
+func adminVideoReportHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || blk.Type != videoBlock.Type {
+		c.String(http.StatusNotFound, "Видео не найдено")
+		return
+	}
+	decodeBlockData(blk)
+	var watches []VideoWatch
+	if err := db.Preload("User").Where("block_id = ?", blk.ID).Find(&watches).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки просмотров")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin/video_report.html", gin.H{
+		"block":  blk,
+		"title":  blockTitle(blk),
+		"video":  blk.Data,
+		"report": buildVideoReport(watches),
+	})
+}
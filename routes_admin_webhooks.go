@@ -0,0 +1,271 @@
+// routes_admin_webhooks.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const webhookLogLimit = 100
+
+func adminWebhooksHandler(c *gin.Context) {
+	renderAdminWebhooks(c, http.StatusOK, nil)
+}
+
+// webhookRow — подписка со счётчиками для списка
+type webhookRow struct {
+	Webhook
+	Pending int64
+	Failed  int64
+}
+
+func renderAdminWebhooks(c *gin.Context, status int, extra gin.H) {
+	var hooks []Webhook
+	if err := db.Order("id").Find(&hooks).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки вебхуков")
+		return
+	}
+	rows := make([]webhookRow, 0, len(hooks))
+	for _, h := range hooks {
+		row := webhookRow{Webhook: h}
+		db.Model(&WebhookDelivery{}).Where("webhook_id = ? AND status = ?", h.ID, deliveryPending).Count(&row.Pending)
+		db.Model(&WebhookDelivery{}).Where("webhook_id = ? AND status = ?", h.ID, deliveryFailed).Count(&row.Failed)
+		rows = append(rows, row)
+	}
+
+	data := gin.H{
+		"User":     getCurrentUser(c),
+		"webhooks": rows,
+		"events":   webhookEvents,
+		"Flash":    popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/webhooks.html", data)
+}
+
+func adminWebhookCreateHandler(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		renderAdminWebhooks(c, http.StatusBadRequest, gin.H{"Error": "Укажите название"})
+		return
+	}
+	events, err := validateWebhook(c.PostForm("url"), c.PostFormArray("events"))
+	if err != nil {
+		renderAdminWebhooks(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации секрета")
+		return
+	}
+	hook := Webhook{
+		Name:   name,
+		URL:    strings.TrimSpace(c.PostForm("url")),
+		Secret: "whsec_" + secret,
+		Events: events,
+		Active: true,
+	}
+	if err := db.Create(&hook).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения вебхука")
+		return
+	}
+	setFlash(c, "success", "Вебхук создан. Секрет для проверки подписи — на этой странице.")
+	c.Redirect(http.StatusFound, "/admin/webhooks/"+strconv.Itoa(int(hook.ID)))
+}
+
+func loadWebhook(c *gin.Context) (*Webhook, bool) {
+	id, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID вебхука")
+		return nil, false
+	}
+	var hook Webhook
+	if err := db.First(&hook, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Вебхук не найден")
+		return nil, false
+	}
+	return &hook, true
+}
+
+// adminWebhookHandler — настройки подписки и журнал доставок.
+func adminWebhookHandler(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	renderAdminWebhook(c, http.StatusOK, hook, nil)
+}
+
+func renderAdminWebhook(c *gin.Context, status int, hook *Webhook, extra gin.H) {
+	q := db.Where("webhook_id = ?", hook.ID)
+	filter := c.Query("status")
+	if filter != "" {
+		q = q.Where("status = ?", filter)
+	}
+	var deliveries []WebhookDelivery
+	if err := q.Order("created_at desc, id desc").Limit(webhookLogLimit).Find(&deliveries).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки журнала доставок")
+		return
+	}
+
+	subscribed := map[string]bool{}
+	for _, e := range hook.EventList() {
+		subscribed[e] = true
+	}
+
+	data := gin.H{
+		"User":       getCurrentUser(c),
+		"webhook":    hook,
+		"events":     webhookEvents,
+		"subscribed": subscribed,
+		"deliveries": deliveries,
+		"filter":     filter,
+		"limit":      webhookLogLimit,
+		"Flash":      popFlash(c),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	c.HTML(status, "admin/webhook.html", data)
+}
+
+func adminWebhookUpdateHandler(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		renderAdminWebhook(c, http.StatusBadRequest, hook, gin.H{"Error": "Укажите название"})
+		return
+	}
+	events, err := validateWebhook(c.PostForm("url"), c.PostFormArray("events"))
+	if err != nil {
+		renderAdminWebhook(c, http.StatusBadRequest, hook, gin.H{"Error": err.Error()})
+		return
+	}
+
+	hook.Name = name
+	hook.URL = strings.TrimSpace(c.PostForm("url"))
+	hook.Events = events
+	hook.Active = c.PostForm("active") == "on"
+	if err := db.Save(hook).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения вебхука")
+		return
+	}
+	setFlash(c, "success", "Настройки сохранены.")
+	c.Redirect(http.StatusFound, "/admin/webhooks/"+strconv.Itoa(int(hook.ID)))
+}
+
+func adminWebhookRotateSecretHandler(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	secret, err := randomToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации секрета")
+		return
+	}
+	if err := db.Model(hook).Update("secret", "whsec_"+secret).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения вебхука")
+		return
+	}
+	setFlash(c, "warning", "Секрет заменён — обновите его у получателя, иначе подпись не совпадёт.")
+	c.Redirect(http.StatusFound, "/admin/webhooks/"+strconv.Itoa(int(hook.ID)))
+}
+
+func adminWebhookTestHandler(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	if err := sendTestEvent(*hook); err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка постановки в очередь")
+		return
+	}
+	setFlash(c, "info", "Тестовое событие ping поставлено в очередь — результат появится в журнале.")
+	c.Redirect(http.StatusFound, "/admin/webhooks/"+strconv.Itoa(int(hook.ID)))
+}
+
+func adminWebhookDeleteHandler(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	if err := db.Delete(hook).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка удаления вебхука")
+		return
+	}
+	setFlash(c, "success", "Вебхук «"+hook.Name+"» удалён вместе с журналом доставок.")
+	c.Redirect(http.StatusFound, "/admin/webhooks")
+}
+
+///////////////////////////////////////////////////////
+// ЖУРНАЛ ДОСТАВОК
+///////////////////////////////////////////////////////
+
+func loadWebhookDelivery(c *gin.Context) (*WebhookDelivery, bool) {
+	id, err := strconv.Atoi(c.Param("delivery_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID доставки")
+		return nil, false
+	}
+	var d WebhookDelivery
+	if err := db.Preload("Webhook").First(&d, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Доставка не найдена")
+		return nil, false
+	}
+	return &d, true
+}
+
+func adminWebhookDeliveryHandler(c *gin.Context) {
+	d, ok := loadWebhookDelivery(c)
+	if !ok {
+		return
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, d.Payload, "", "  "); err != nil {
+		pretty.Write(d.Payload)
+	}
+
+	c.HTML(http.StatusOK, "admin/webhook_delivery.html", gin.H{
+		"User":     getCurrentUser(c),
+		"delivery": d,
+		"payload":  pretty.String(),
+		"Flash":    popFlash(c),
+	})
+}
+
+// adminWebhookRedeliverHandler — повторная отправка того же события (новая запись в журнале).
+func adminWebhookRedeliverHandler(c *gin.Context) {
+	d, ok := loadWebhookDelivery(c)
+	if !ok {
+		return
+	}
+	nd, err := redeliver(d)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка постановки в очередь")
+		return
+	}
+	setFlash(c, "info", "Событие поставлено в очередь повторно (доставка #"+strconv.Itoa(int(nd.ID))+").")
+	c.Redirect(http.StatusFound, "/admin/webhook-deliveries/"+strconv.Itoa(int(nd.ID)))
+}
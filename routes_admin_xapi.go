@@ -0,0 +1,96 @@
+// routes_admin_xapi.go
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const xapiLogLimit = 100
+
+func adminXAPIHandler(c *gin.Context) {
+	counts := map[string]int64{}
+	for _, st := range []string{xapiPending, xapiSent, xapiFailed} {
+		var n int64
+		db.Model(&XAPIStatement{}).Where("status = ?", st).Count(&n)
+		counts[st] = n
+	}
+
+	q := db.Preload("User")
+	filter := c.Query("status")
+	if filter != "" {
+		q = q.Where("status = ?", filter)
+	}
+	var rows []XAPIStatement
+	if err := q.Order("created_at desc").Limit(xapiLogLimit).Find(&rows).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки очереди xAPI")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin/xapi.html", gin.H{
+		"User":       getCurrentUser(c),
+		"cfg":        xapiCfg,
+		"enabled":    xapiEnabled(),
+		"counts":     counts,
+		"statements": rows,
+		"filter":     filter,
+		"limit":      xapiLogLimit,
+		"backfill":   xapiBackfillStatus(),
+		"Flash":      popFlash(c),
+	})
+}
+
+// adminXAPICheckHandler — запрос /about к LRS (адрес, логин/пароль, версия).
+func adminXAPICheckHandler(c *gin.Context) {
+	if !xapiEnabled() {
+		setFlash(c, "warning", "XAPI_ENDPOINT не задан.")
+		c.Redirect(http.StatusFound, "/admin/xapi")
+		return
+	}
+	about, err := xapiAbout()
+	if err != nil {
+		setFlash(c, "danger", "LRS недоступен: "+err.Error())
+	} else {
+		setFlash(c, "success", "LRS отвечает: "+about)
+	}
+	c.Redirect(http.StatusFound, "/admin/xapi")
+}
+
+func adminXAPIBackfillHandler(c *gin.Context) {
+	if !xapiEnabled() {
+		setFlash(c, "warning", "XAPI_ENDPOINT не задан — заявления некуда отправлять.")
+		c.Redirect(http.StatusFound, "/admin/xapi")
+		return
+	}
+	since, err := time.ParseInLocation("2006-01-02", c.PostForm("since"), time.Local)
+	if err != nil {
+		setFlash(c, "danger", "Укажите дату начала в формате ГГГГ-ММ-ДД.")
+		c.Redirect(http.StatusFound, "/admin/xapi")
+		return
+	}
+	if !startXAPIBackfill(since) {
+		setFlash(c, "warning", "Выгрузка истории уже выполняется.")
+	} else {
+		setFlash(c, "info", "Выгрузка истории с "+since.Format("02.01.2006")+" запущена.")
+	}
+	c.Redirect(http.StatusFound, "/admin/xapi")
+}
+
+// adminXAPIRetryHandler возвращает заявления с ошибкой в очередь (например, после правки пароля LRS).
+func adminXAPIRetryHandler(c *gin.Context) {
+	res := db.Model(&XAPIStatement{}).Where("status = ?", xapiFailed).Updates(map[string]any{
+		"status":          xapiPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	if res.Error != nil {
+		c.String(http.StatusInternalServerError, "Ошибка обновления очереди")
+		return
+	}
+	wakeXAPIWorker()
+	setFlash(c, "success", "Возвращено в очередь: "+strconv.FormatInt(res.RowsAffected, 10)+".")
+	c.Redirect(http.StatusFound, "/admin/xapi")
+}
@@ -0,0 +1,747 @@
+// routes_api.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func registerAPIRoutes(r *gin.Engine) {
+	api := r.Group(apiPrefix, apiRecovery())
+	{
+		api.GET("/openapi.json", func(c *gin.Context) {
+			c.Data(http.StatusOK, "application/json", openAPISpec)
+		})
+
+		// каталог — доступен без входа, как и /courses
+		catalog := api.Group("", requireScope(scopeCatalogRead))
+		catalog.GET("/courses", apiCoursesHandler)
+		catalog.GET("/courses/:course_id", apiCourseHandler)
+		catalog.GET("/block-types", apiBlockTypesHandler)
+
+		api.GET("/me", authRequired(), apiMeHandler)
+
+		learn := api.Group("", authRequired(), requireScope(scopeLearn))
+		learn.GET("/progress", apiProgressHandler)
+
+		learn.GET("/quizzes/:block_id", apiQuizStartHandler)
+		learn.GET("/quizzes/:block_id/attempts", apiQuizAttemptsHandler)
+		learn.POST("/quizzes/:block_id/attempts", apiQuizSubmitHandler)
+
+		learn.GET("/submissions", apiSubmissionsHandler)
+		learn.GET("/submissions/:submission_id", apiSubmissionHandler)
+		learn.POST("/blocks/:block_id/submissions", apiSubmitHandler)
+
+		// администрирование (сессия админа или токен с нужным правом)
+		grade := api.Group("/admin", adminRequired(), requireScope(scopeSubmissionsGrade))
+		grade.GET("/submissions", apiAdminSubmissionsHandler)
+		grade.PATCH("/submissions/:submission_id", apiAdminSubmissionReviewHandler)
+
+		users := api.Group("/admin", adminRequired(), requireScope(scopeUsersManage))
+		users.GET("/users", apiAdminUsersHandler)
+		users.POST("/users", apiAdminUserCreateHandler)
+		users.PATCH("/users/:user_id", apiAdminUserUpdateHandler)
+	}
+
+	// неизвестный путь внутри /api — тоже JSON, а не HTML 404
+	r.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+			apiError(c, http.StatusNotFound, "not_found", "Нет такого метода API")
+			return
+		}
+		c.String(http.StatusNotFound, "404 page not found")
+	})
+}
+
+///////////////////////////////////////////////////////
+// DTO
+///////////////////////////////////////////////////////
+
+type apiUser struct {
+	ID          uint      `json:"id"`
+	Email       string    `json:"email"`
+	FullName    string    `json:"full_name"`
+	Role        string    `json:"role"`
+	AuthSource  string    `json:"auth_source"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type apiCourse struct {
+	ID           uint      `json:"id"`
+	Title        string    `json:"title"`
+	ShortDesc    string    `json:"short_desc"`
+	Status       string    `json:"status"`
+	ModulesCount int       `json:"modules_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type apiCourseTree struct {
+	apiCourse
+	Modules []apiModule `json:"modules"`
+}
+
+type apiModule struct {
+	ID     uint       `json:"id"`
+	Title  string     `json:"title"`
+	Order  int        `json:"order"`
+	Blocks []apiBlock `json:"blocks"`
+}
+
+type apiBlock struct {
+	ID      uint           `json:"id"`
+	Type    string         `json:"type"`
+	Order   int            `json:"order"`
+	Payload map[string]any `json:"payload"`
+}
+
+type apiBlockType struct {
+	Type   string     `json:"type"`
+	Title  string     `json:"title"`
+	Graded bool       `json:"graded"`
+	Scored bool       `json:"scored"`
+	Schema jsonSchema `json:"schema"`
+}
+
+type apiQuiz struct {
+	BlockID     uint               `json:"block_id"`
+	PassScore   float64            `json:"pass_score"`
+	Questions   []apiQuizQuestion  `json:"questions"`
+	LastAttempt *apiQuizAttemptOut `json:"last_attempt"`
+}
+
+type apiQuizQuestion struct {
+	ID      uint            `json:"id"`
+	Text    string          `json:"text"`
+	Order   int             `json:"order"`
+	Options []apiQuizOption `json:"options"`
+}
+
+// правильность варианта наружу не отдаём
+type apiQuizOption struct {
+	ID   uint   `json:"id"`
+	Text string `json:"text"`
+}
+
+type apiQuizAttemptOut struct {
+	ID        uint           `json:"id"`
+	BlockID   uint           `json:"block_id"`
+	Score     float64        `json:"score"`
+	Passed    bool           `json:"passed"`
+	Answers   datatypes.JSON `json:"answers"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type apiQuizAttemptIn struct {
+	// question_id → option_id; ключи — строки, как в JSON-объекте
+	Answers map[uint]int `json:"answers" binding:"required"`
+}
+
+type apiSubmission struct {
+	ID           uint      `json:"id"`
+	BlockID      uint      `json:"block_id"`
+	OriginalName string    `json:"original_name"`
+	Mimetype     string    `json:"mimetype"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Status       string    `json:"status"`
+	Comment      string    `json:"comment"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func toAPIUser(u User) apiUser {
+	return apiUser{
+		ID:          u.ID,
+		Email:       u.Email,
+		FullName:    u.FullName,
+		Role:        u.Role,
+		AuthSource:  u.AuthSource,
+		TOTPEnabled: u.TOTPEnabled,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+func toAPICourse(c Course) apiCourse {
+	return apiCourse{
+		ID:           c.ID,
+		Title:        c.Title,
+		ShortDesc:    c.ShortDesc,
+		Status:       c.Status,
+		ModulesCount: len(c.Modules),
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+	}
+}
+
+type apiAdminSubmission struct {
+	apiSubmission
+	UserID    uint   `json:"user_id"`
+	UserEmail string `json:"user_email"`
+}
+
+type apiSubmissionReviewIn struct {
+	Status  *string `json:"status"`
+	Comment *string `json:"comment"`
+}
+
+type apiUserCreateIn struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	FullName string `json:"full_name"`
+	Role     string `json:"role"`
+}
+
+type apiUserUpdateIn struct {
+	FullName *string `json:"full_name"`
+	Role     *string `json:"role"`
+}
+
+func toAPIQuizAttempt(a QuizAttempt) apiQuizAttemptOut {
+	return apiQuizAttemptOut{
+		ID:        a.ID,
+		BlockID:   a.BlockID,
+		Score:     a.Score,
+		Passed:    a.Passed,
+		Answers:   a.Details,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+func toAPISubmission(s Submission) apiSubmission {
+	return apiSubmission{
+		ID:           s.ID,
+		BlockID:      s.BlockID,
+		OriginalName: s.OriginalName,
+		Mimetype:     s.Mimetype,
+		SizeBytes:    s.SizeBytes,
+		Status:       s.Status,
+		Comment:      s.Comment,
+		CreatedAt:    s.CreatedAt,
+	}
+}
+
+///////////////////////////////////////////////////////
+// ПОЛЬЗОВАТЕЛЬ
+///////////////////////////////////////////////////////
+
+func apiMeHandler(c *gin.Context) {
+	resp := gin.H{"user": toAPIUser(*getCurrentUser(c))}
+	if tok := currentAPIToken(c); tok != nil {
+		resp["token"] = gin.H{"name": tok.Name, "scopes": tok.ScopeList(), "expires_at": tok.ExpiresAt}
+	} else {
+		// для POST из браузера с cookie-сессией: передавать в заголовке X-CSRF-Token
+		resp["csrf_token"] = csrfToken(c)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func apiProgressHandler(c *gin.Context) {
+	progress, err := userCourseProgress(getCurrentUser(c).ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка расчёта прогресса")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": progress})
+}
+
+///////////////////////////////////////////////////////
+// КАТАЛОГ
+///////////////////////////////////////////////////////
+
+func apiCoursesHandler(c *gin.Context) {
+	page, ok := apiPagination(c)
+	if !ok {
+		return
+	}
+
+	q := db.Model(&Course{})
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	q = q.Session(&gorm.Session{}) // один запрос и для Count, и для Find
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки курсов")
+		return
+	}
+
+	var courses []Course
+	if err := q.Preload("Modules").
+		Order("created_at desc").
+		Offset(page.Offset()).Limit(page.PerPage).
+		Find(&courses).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки курсов")
+		return
+	}
+
+	out := make([]apiCourse, 0, len(courses))
+	for _, course := range courses {
+		out = append(out, toAPICourse(course))
+	}
+	apiList(c, out, total, page)
+}
+
+// apiCourseHandler — дерево курса: модули → блоки с разобранным payload.
+func apiCourseHandler(c *gin.Context) {
+	id, ok := apiParamID(c, "course_id")
+	if !ok {
+		return
+	}
+
+	var course Course
+	err := db.Preload("Modules", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("modules.\"order\" asc")
+	}).Preload("Modules.Blocks", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("blocks.\"order\" asc")
+	}).First(&course, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apiError(c, http.StatusNotFound, "not_found", "Курс не найден")
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки курса")
+		return
+	}
+
+	tree := apiCourseTree{apiCourse: toAPICourse(course), Modules: make([]apiModule, 0, len(course.Modules))}
+	for _, m := range course.Modules {
+		am := apiModule{ID: m.ID, Title: m.Title, Order: m.Order, Blocks: make([]apiBlock, 0, len(m.Blocks))}
+		for _, b := range m.Blocks {
+			payload := map[string]any{}
+			if len(b.Payload) > 0 {
+				_ = json.Unmarshal(b.Payload, &payload)
+			}
+			am.Blocks = append(am.Blocks, apiBlock{ID: b.ID, Type: b.Type, Order: b.Order, Payload: payload})
+		}
+		tree.Modules = append(tree.Modules, am)
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// apiBlockTypesHandler — типы блоков и JSON Schema их payload.
+func apiBlockTypesHandler(c *gin.Context) {
+	out := make([]apiBlockType, 0, len(blockKindList))
+	for _, k := range blockKindList {
+		out = append(out, apiBlockType{Type: k.Type, Title: k.Title, Graded: k.Graded(), Scored: k.Scored, Schema: k.Schema})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+///////////////////////////////////////////////////////
+// ТЕСТЫ
+///////////////////////////////////////////////////////
+
+// apiBlockOfType загружает блок и проверяет его тип; при ошибке уже ответил.
+func apiBlockOfType(c *gin.Context, typ string) (*Block, bool) {
+	id, ok := apiParamID(c, "block_id")
+	if !ok {
+		return nil, false
+	}
+	var blk Block
+	if err := db.First(&blk, id).Error; err != nil {
+		apiError(c, http.StatusNotFound, "not_found", "Блок не найден")
+		return nil, false
+	}
+	if blk.Type != typ {
+		apiError(c, http.StatusUnprocessableEntity, "wrong_block_type", "Блок имеет тип "+blk.Type+", ожидался "+typ)
+		return nil, false
+	}
+	return &blk, true
+}
+
+// apiQuizStartHandler — вопросы теста без правильных ответов и последняя попытка.
+func apiQuizStartHandler(c *gin.Context) {
+	blk, ok := apiBlockOfType(c, "quiz")
+	if !ok {
+		return
+	}
+	user := getCurrentUser(c)
+
+	var qs []QuizQuestion
+	if err := db.Preload("Options").
+		Where("block_id = ?", blk.ID).
+		Order("\"order\" asc").
+		Find(&qs).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки вопросов")
+		return
+	}
+
+	quiz := apiQuiz{BlockID: blk.ID, PassScore: quizPassScore(blk), Questions: make([]apiQuizQuestion, 0, len(qs))}
+	for _, q := range qs {
+		aq := apiQuizQuestion{ID: q.ID, Text: q.Text, Order: q.Order, Options: make([]apiQuizOption, 0, len(q.Options))}
+		for _, o := range q.Options {
+			aq.Options = append(aq.Options, apiQuizOption{ID: o.ID, Text: o.Text})
+		}
+		quiz.Questions = append(quiz.Questions, aq)
+	}
+
+	var last QuizAttempt
+	err := db.Where("user_id = ? AND block_id = ?", user.ID, blk.ID).
+		Order("created_at desc").First(&last).Error
+	if err == nil {
+		out := toAPIQuizAttempt(last)
+		quiz.LastAttempt = &out
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки попытки теста")
+		return
+	}
+
+	c.JSON(http.StatusOK, quiz)
+}
+
+func apiQuizAttemptsHandler(c *gin.Context) {
+	blk, ok := apiBlockOfType(c, "quiz")
+	if !ok {
+		return
+	}
+	page, ok := apiPagination(c)
+	if !ok {
+		return
+	}
+
+	q := db.Model(&QuizAttempt{}).Where("user_id = ? AND block_id = ?", getCurrentUser(c).ID, blk.ID).
+		Session(&gorm.Session{})
+	var total int64
+	var attempts []QuizAttempt
+	if err := q.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки попыток")
+		return
+	}
+	if err := q.Order("created_at desc").Offset(page.Offset()).Limit(page.PerPage).Find(&attempts).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки попыток")
+		return
+	}
+
+	out := make([]apiQuizAttemptOut, 0, len(attempts))
+	for _, a := range attempts {
+		out = append(out, toAPIQuizAttempt(a))
+	}
+	apiList(c, out, total, page)
+}
+
+func apiQuizSubmitHandler(c *gin.Context) {
+	blk, ok := apiBlockOfType(c, "quiz")
+	if !ok {
+		return
+	}
+
+	var in apiQuizAttemptIn
+	if err := c.ShouldBindJSON(&in); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_body", "Ожидается {\"answers\": {\"<question_id>\": <option_id>}}")
+		return
+	}
+
+	attempt, err := gradeQuizAttempt(getCurrentUser(c), blk, in.Answers)
+	if errors.Is(err, errQuizNoQuestions) {
+		apiError(c, http.StatusUnprocessableEntity, "quiz_empty", "У теста нет вопросов")
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка сохранения результата")
+		return
+	}
+	c.JSON(http.StatusCreated, toAPIQuizAttempt(*attempt))
+}
+
+///////////////////////////////////////////////////////
+// ОТПРАВКИ ЗАДАНИЙ
+///////////////////////////////////////////////////////
+
+func apiSubmissionsHandler(c *gin.Context) {
+	page, ok := apiPagination(c)
+	if !ok {
+		return
+	}
+
+	blockID, ok := apiQueryID(c, "block_id")
+	if !ok {
+		return
+	}
+
+	q := db.Model(&Submission{}).Where("user_id = ?", getCurrentUser(c).ID)
+	if blockID != 0 {
+		q = q.Where("block_id = ?", blockID)
+	}
+	q = q.Session(&gorm.Session{})
+
+	var total int64
+	var subs []Submission
+	if err := q.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки отправок")
+		return
+	}
+	if err := q.Order("created_at desc").Offset(page.Offset()).Limit(page.PerPage).Find(&subs).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки отправок")
+		return
+	}
+
+	out := make([]apiSubmission, 0, len(subs))
+	for _, s := range subs {
+		out = append(out, toAPISubmission(s))
+	}
+	apiList(c, out, total, page)
+}
+
+func apiSubmissionHandler(c *gin.Context) {
+	id, ok := apiParamID(c, "submission_id")
+	if !ok {
+		return
+	}
+	var sub Submission
+	// чужие отправки не видны: для API они «не существуют»
+	if err := db.Where("id = ? AND user_id = ?", id, getCurrentUser(c).ID).First(&sub).Error; err != nil {
+		apiError(c, http.StatusNotFound, "not_found", "Отправка не найдена")
+		return
+	}
+	c.JSON(http.StatusOK, toAPISubmission(sub))
+}
+
+// apiSubmitHandler — загрузка решения (multipart/form-data, поле file).
+func apiSubmitHandler(c *gin.Context) {
+	blk, ok := apiBlockOfType(c, "assignment")
+	if !ok {
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_body", "Файл обязателен (multipart/form-data, поле file)")
+		return
+	}
+
+	sub, err := saveSubmission(getCurrentUser(c), blk, file)
+	if errors.Is(err, errSubmissionClosed) {
+		apiError(c, http.StatusConflict, "deadline_passed", "Срок сдачи задания прошёл")
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка сохранения отправки")
+		return
+	}
+	c.JSON(http.StatusCreated, toAPISubmission(*sub))
+}
+
+///////////////////////////////////////////////////////
+// АДМИНИСТРИРОВАНИЕ
+///////////////////////////////////////////////////////
+
+func apiAdminSubmissionsHandler(c *gin.Context) {
+	page, ok := apiPagination(c)
+	if !ok {
+		return
+	}
+
+	blockID, ok := apiQueryID(c, "block_id")
+	if !ok {
+		return
+	}
+
+	q := db.Model(&Submission{})
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if blockID != 0 {
+		q = q.Where("block_id = ?", blockID)
+	}
+	q = q.Session(&gorm.Session{})
+
+	var total int64
+	var subs []Submission
+	if err := q.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки отправок")
+		return
+	}
+	if err := q.Preload("User").Order("created_at desc").
+		Offset(page.Offset()).Limit(page.PerPage).Find(&subs).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки отправок")
+		return
+	}
+
+	out := make([]apiAdminSubmission, 0, len(subs))
+	for _, s := range subs {
+		out = append(out, apiAdminSubmission{apiSubmission: toAPISubmission(s), UserID: s.UserID, UserEmail: s.User.Email})
+	}
+	apiList(c, out, total, page)
+}
+
+// apiAdminSubmissionReviewHandler — выставить статус и комментарий (как форма в админке).
+func apiAdminSubmissionReviewHandler(c *gin.Context) {
+	id, ok := apiParamID(c, "submission_id")
+	if !ok {
+		return
+	}
+	var in apiSubmissionReviewIn
+	if err := c.ShouldBindJSON(&in); err != nil || (in.Status == nil && in.Comment == nil) {
+		apiError(c, http.StatusBadRequest, "invalid_body", "Ожидается {\"status\": \"...\", \"comment\": \"...\"}")
+		return
+	}
+
+	var sub Submission
+	if err := db.Preload("User").First(&sub, id).Error; err != nil {
+		apiError(c, http.StatusNotFound, "not_found", "Отправка не найдена")
+		return
+	}
+	prevStatus := sub.Status
+	if in.Status != nil {
+		valid := false
+		for _, st := range SubmissionStatuses {
+			if st == *in.Status {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			apiError(c, http.StatusUnprocessableEntity, "invalid_status",
+				"Допустимые статусы: "+strings.Join(SubmissionStatuses, ", "))
+			return
+		}
+		sub.Status = *in.Status
+	}
+	if in.Comment != nil {
+		sub.Comment = *in.Comment
+	}
+	if err := db.Save(&sub).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка обновления статуса")
+		return
+	}
+	emitSubmissionReviewed(&sub, prevStatus, getCurrentUser(c))
+	c.JSON(http.StatusOK, apiAdminSubmission{apiSubmission: toAPISubmission(sub), UserID: sub.UserID, UserEmail: sub.User.Email})
+}
+
+func apiAdminUsersHandler(c *gin.Context) {
+	page, ok := apiPagination(c)
+	if !ok {
+		return
+	}
+
+	q := db.Model(&User{}).Where("erased_at IS NULL")
+	if role := c.Query("role"); role != "" {
+		q = q.Where("role = ?", role)
+	}
+	if search := strings.TrimSpace(c.Query("q")); search != "" {
+		like := "%" + strings.ToLower(search) + "%"
+		q = q.Where("lower(email) LIKE ? OR lower(full_name) LIKE ?", like, like)
+	}
+	q = q.Session(&gorm.Session{})
+
+	var total int64
+	var users []User
+	if err := q.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки пользователей")
+		return
+	}
+	if err := q.Order("id").Offset(page.Offset()).Limit(page.PerPage).Find(&users).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка загрузки пользователей")
+		return
+	}
+
+	out := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		out = append(out, toAPIUser(u))
+	}
+	apiList(c, out, total, page)
+}
+
+var apiUserRoles = map[string]bool{"student": true, "admin": true}
+
+func apiAdminUserCreateHandler(c *gin.Context) {
+	var in apiUserCreateIn
+	if err := c.ShouldBindJSON(&in); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_body", "Обязательны email и password")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(in.Email))
+	if !strings.Contains(email, "@") {
+		apiError(c, http.StatusUnprocessableEntity, "invalid_email", "Некорректный email")
+		return
+	}
+	if in.Role == "" {
+		in.Role = "student"
+	}
+	if !apiUserRoles[in.Role] {
+		apiError(c, http.StatusUnprocessableEntity, "invalid_role", "Роль: student или admin")
+		return
+	}
+
+	var count int64
+	db.Model(&User{}).Where("lower(email) = ?", email).Count(&count)
+	if count > 0 {
+		apiError(c, http.StatusConflict, "email_taken", "Пользователь с таким email уже существует")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка сервера")
+		return
+	}
+	user := User{
+		Email:        email,
+		PasswordHash: string(hash),
+		FullName:     strings.TrimSpace(in.FullName),
+		Role:         in.Role,
+		AuthSource:   AuthSourceLocal,
+		CreatedAt:    time.Now(),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка сохранения пользователя")
+		return
+	}
+	emitUserRegistered(&user)
+	c.JSON(http.StatusCreated, toAPIUser(user))
+}
+
+func apiAdminUserUpdateHandler(c *gin.Context) {
+	id, ok := apiParamID(c, "user_id")
+	if !ok {
+		return
+	}
+	var in apiUserUpdateIn
+	if err := c.ShouldBindJSON(&in); err != nil || (in.FullName == nil && in.Role == nil) {
+		apiError(c, http.StatusBadRequest, "invalid_body", "Ожидается {\"full_name\": \"...\", \"role\": \"...\"}")
+		return
+	}
+
+	var user User
+	if err := db.Where("erased_at IS NULL").First(&user, id).Error; err != nil {
+		apiError(c, http.StatusNotFound, "not_found", "Пользователь не найден")
+		return
+	}
+
+	roleChanged := false
+	if in.Role != nil {
+		if !apiUserRoles[*in.Role] {
+			apiError(c, http.StatusUnprocessableEntity, "invalid_role", "Роль: student или admin")
+			return
+		}
+		if user.AuthSource == AuthSourceLDAP {
+			apiError(c, http.StatusUnprocessableEntity, "managed_by_ldap", "Роль этого пользователя задаётся группами LDAP")
+			return
+		}
+		roleChanged = user.Role != *in.Role
+		user.Role = *in.Role
+	}
+	if in.FullName != nil {
+		user.FullName = strings.TrimSpace(*in.FullName)
+	}
+	if err := db.Save(&user).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, "internal", "Ошибка сохранения пользователя")
+		return
+	}
+	if roleChanged {
+		// права изменились — пусть войдёт заново
+		if _, err := revokeUserSessions(user.ID, ""); err != nil {
+			apiError(c, http.StatusInternalServerError, "internal", "Ошибка завершения сессий")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, toAPIUser(user))
+}
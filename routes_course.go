@@ -3,8 +3,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/datatypes"
@@ -17,6 +20,7 @@ func registerCourseRoutes(r *gin.Engine) {
 		courseGroup.GET("/courses", listCoursesHandler)
 		courseGroup.GET("/courses/:id", viewCourseHandler)
 		courseGroup.POST("/courses/:blockID/quiz-submit", authRequired(), submitQuizHandler)
+		courseGroup.POST("/blocks/:block_id/viewed", authRequired(), blockViewedHandler)
 	}
 }
 
@@ -24,10 +28,24 @@ func registerCourseRoutes(r *gin.Engine) {
 func listCoursesHandler(c *gin.Context) {
 	user := getCurrentUser(c)
 
+	// ?q= — поиск по курсам и тексту блоков (course_search.go)
+	q := strings.TrimSpace(c.Query("q"))
+	if r := []rune(q); len(r) > searchQueryMaxLen {
+		q = string(r[:searchQueryMaxLen])
+	}
+
 	var courses []Course
-	if err := db.Preload("Modules").
-		Order("created_at desc").
-		Find(&courses).Error; err != nil {
+	var hits map[uint][]courseSearchHit
+	var err error
+	if q != "" {
+		courses, hits, err = searchCourses(q)
+	} else {
+		err = db.Preload("Modules").
+			Order("created_at desc").
+			Find(&courses).Error
+	}
+	if err != nil {
+		log.Printf("courses: список (q=%q): %v\n", q, err)
 		c.String(http.StatusInternalServerError, "Ошибка загрузки курсов")
 		return
 	}
@@ -35,6 +53,8 @@ func listCoursesHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "courses.html", gin.H{
 		"User":    user,
 		"Courses": courses,
+		"Query":   q,
+		"Hits":    hits,
 		"Flash":   popFlash(c),
 	})
 }
@@ -59,80 +79,41 @@ func viewCourseHandler(c *gin.Context) {
 		return
 	}
 
-	// Преобразуем Payload → PayloadMap, подгружаем вопросы/варианты для квизов,
-	// и заполняем LastAttempt / LastSubmission для текущего пользователя.
-	for mi := range course.Modules {
-		for bi := range course.Modules[mi].Blocks {
-			blk := &course.Modules[mi].Blocks[bi]
-
-			// JSON → map для шаблона
-			if len(blk.Payload) > 0 {
-				var pm map[string]any
-				if err := json.Unmarshal(blk.Payload, &pm); err == nil {
-					blk.PayloadMap = pm
-				} else {
-					blk.PayloadMap = map[string]any{}
-				}
-			} else {
-				blk.PayloadMap = map[string]any{}
-			}
-
-			// Для квизов подгружаем вопросы/варианты
-			if blk.Type == "quiz" {
-				var qs []QuizQuestion
-				if err := db.Preload("Options").
-					Where("block_id = ?", blk.ID).
-					Order("\"order\" asc").
-					Find(&qs).Error; err == nil {
-					blk.QuizQuestions = qs
-				}
-
-				// Последняя попытка для пользователя
-				if user != nil {
-					var last QuizAttempt
-					err := db.
-						Where("user_id = ? AND block_id = ?", user.ID, blk.ID).
-						Order("created_at desc").
-						First(&last).Error
-
-					if err == nil {
-						blk.LastAttempt = &last
-					} else if err == gorm.ErrRecordNotFound {
-						// ОК: попыток ещё нет
-					} else {
-						c.String(http.StatusInternalServerError, "Ошибка загрузки попытки теста")
-						return
-					}
-				}
-			}
+	// payload в структуре типа и данные ученика: вопросы теста, последняя попытка, сдача, SCORM
+	if err := loadBlocksForPlayer(&course, user); err != nil {
+		log.Printf("course %d: %v\n", course.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка загрузки блоков курса")
+		return
+	}
 
-			// Для заданий — последняя сдача
-			if blk.Type == "assignment" && user != nil {
-				var lastS Submission
-				err := db.
-					Where("user_id = ? AND block_id = ?", user.ID, blk.ID).
-					Order("created_at desc").
-					First(&lastS).Error
-
-				if err == nil {
-					blk.LastSubmission = &lastS
-				} else if err == gorm.ErrRecordNotFound {
-					// ОК: сдач ещё нет
-				} else {
-					c.String(http.StatusInternalServerError, "Ошибка загрузки отправки задания")
-					return
-				}
-			}
-		}
+	if user != nil {
+		xapiCourseLaunched(user, &course)
 	}
 
 	c.HTML(http.StatusOK, "course_player.html", gin.H{
-		"User":   user,
-		"Course": course,
-		"Flash":  popFlash(c),
+		"User":       user,
+		"Course":     course,
+		"TrackViews": xapiEnabled(),
+		"Flash":      popFlash(c),
 	})
 }
 
+// blockViewedHandler — маяк из плеера: блок попал в зону видимости (xAPI experienced).
+func blockViewedHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	xapiBlockExperienced(getCurrentUser(c), blk)
+	c.Status(http.StatusNoContent)
+}
+
 // Отправка квиза — считает результат и пишет его в БД, после чего редиректит обратно в курс
 func submitQuizHandler(c *gin.Context) {
 	user := getCurrentUser(c)
@@ -158,31 +139,91 @@ func submitQuizHandler(c *gin.Context) {
 		return
 	}
 
+	// ответы из формы: question_<id> = <option id>
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+	answers := map[uint]int{}
+	for key, vals := range c.Request.PostForm {
+		qIDStr, ok := strings.CutPrefix(key, "question_")
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		qID, err1 := strconv.Atoi(qIDStr)
+		optID, err2 := strconv.Atoi(vals[0])
+		if err1 == nil && err2 == nil {
+			answers[uint(qID)] = optID
+		}
+	}
+
+	attempt, err := gradeQuizAttempt(user, &blk, answers)
+	if errors.Is(err, errQuizNoQuestions) {
+		c.String(http.StatusBadRequest, "У теста нет вопросов")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения результата")
+		return
+	}
+	score, passed := attempt.Score, attempt.Passed
+
+	// найдём courseID через module
+	var module Module
+	if err := db.First(&module, blk.ModuleID).Error; err != nil {
+		setFlash(c, "success", "Результат сохранён.")
+		c.Redirect(http.StatusFound, "/courses")
+		return
+	}
+
+	kind := "warning"
+	msg := "Тест не пройден."
+	if passed {
+		kind = "success"
+		msg = "Тест пройден!"
+	}
+	setFlash(c, kind, msg+" Балл: "+strconv.FormatFloat(score, 'f', 1, 64)+"%")
+
+	c.Redirect(http.StatusFound,
+		"/courses/"+strconv.Itoa(int(module.CourseID))+"?quiz=1#block-"+strconv.Itoa(int(blk.ID)),
+	)
+}
+
+var errQuizNoQuestions = errors.New("у теста нет вопросов")
+
+// quizPassScore — порог прохождения из payload.pass_score (если есть), иначе quizDefaultPassScore
+func quizPassScore(blk *Block) float64 {
+	var p quizPayload
+	if len(blk.Payload) > 0 {
+		_ = json.Unmarshal(blk.Payload, &p)
+	}
+	return p.PassPercent()
+}
+
+// gradeQuizAttempt считает результат по ответам (вопрос → вариант) и сохраняет попытку.
+// Общий код для формы в плеере и для API.
+func gradeQuizAttempt(user *User, blk *Block, answers map[uint]int) (*QuizAttempt, error) {
 	var questions []QuizQuestion
 	if err := db.Preload("Options").
 		Where("block_id = ?", blk.ID).
 		Order("\"order\" asc").
 		Find(&questions).Error; err != nil {
-		c.String(http.StatusInternalServerError, "Ошибка загрузки вопросов")
-		return
+		return nil, err
 	}
 
 	total := len(questions)
 	if total == 0 {
-		c.String(http.StatusBadRequest, "У теста нет вопросов")
-		return
+		return nil, errQuizNoQuestions
 	}
 
 	correctCount := 0
 	detailsMap := make(map[string]interface{})
 
 	for _, q := range questions {
-		fieldName := "question_" + strconv.Itoa(int(q.ID))
-		optIDStr := c.PostForm(fieldName)
-		if optIDStr == "" {
+		optID, ok := answers[q.ID]
+		if !ok {
 			continue
 		}
-		optID, _ := strconv.Atoi(optIDStr)
 
 		detailsMap[strconv.Itoa(int(q.ID))] = optID
 
@@ -195,19 +236,7 @@ func submitQuizHandler(c *gin.Context) {
 	}
 
 	score := float64(correctCount) / float64(total) * 100.0
-
-	// Порог прохождения из payload.pass_score (если есть), иначе 60
-	passScore := 60.0
-	var payload struct {
-		PassScore float64 `json:"pass_score"`
-	}
-	if len(blk.Payload) > 0 {
-		if err := json.Unmarshal(blk.Payload, &payload); err == nil && payload.PassScore > 0 {
-			passScore = payload.PassScore
-		}
-	}
-
-	passed := score >= passScore
+	passed := score >= quizPassScore(blk)
 
 	detailsBytes, _ := json.Marshal(detailsMap)
 
@@ -219,27 +248,8 @@ func submitQuizHandler(c *gin.Context) {
 		Details: datatypes.JSON(detailsBytes),
 	}
 	if err := db.Create(&attempt).Error; err != nil {
-		c.String(http.StatusInternalServerError, "Ошибка сохранения результата")
-		return
-	}
-
-	// найдём courseID через module
-	var module Module
-	if err := db.First(&module, blk.ModuleID).Error; err != nil {
-		setFlash(c, "success", "Результат сохранён.")
-		c.Redirect(http.StatusFound, "/courses")
-		return
-	}
-
-	kind := "warning"
-	msg := "Тест не пройден."
-	if passed {
-		kind = "success"
-		msg = "Тест пройден!"
+		return nil, err
 	}
-	setFlash(c, kind, msg+" Балл: "+strconv.FormatFloat(score, 'f', 1, 64)+"%")
-
-	c.Redirect(http.StatusFound,
-		"/courses/"+strconv.Itoa(int(module.CourseID))+"?quiz=1#block-"+strconv.Itoa(int(blk.ID)),
-	)
+	emitQuizAttempted(user, blk, &attempt)
+	return &attempt, nil
 }
@@ -0,0 +1,314 @@
+// routes_lti.go
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Точки входа LTI 1.3. Запросы приходят POST-ом с сайта платформы, без нашей сессии и
+// CSRF-токена — защищены одноразовым state/nonce и подписью id_token.
+func registerLTIRoutes(r *gin.Engine) {
+	csrfExempt("/lti/")
+
+	lti := r.Group("/lti")
+	{
+		lti.GET("/jwks", ltiJWKSHandler)
+		lti.GET("/login", ltiLoginHandler)
+		lti.POST("/login", ltiLoginHandler)
+		lti.POST("/launch", ltiLaunchHandler)
+		lti.GET("/deep-link/:request_id", ltiDeepLinkHandler)
+		lti.POST("/deep-link/:request_id", ltiDeepLinkRespondHandler)
+	}
+}
+
+func ltiJWKSHandler(c *gin.Context) {
+	set, err := ltiToolJWKS()
+	if err != nil {
+		log.Printf("lti jwks: %v\n", err)
+		c.String(http.StatusInternalServerError, "Ключ LTI недоступен")
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, set)
+}
+
+// ltiLoginHandler — третья сторона инициирует вход (OIDC): запоминаем state и nonce
+// и отправляем браузер на авторизацию платформы.
+func ltiLoginHandler(c *gin.Context) {
+	iss := c.Request.FormValue("iss")
+	loginHint := c.Request.FormValue("login_hint")
+	if iss == "" || loginHint == "" {
+		c.String(http.StatusBadRequest, "LTI: нет iss или login_hint")
+		return
+	}
+
+	q := db.Where("issuer = ? AND active", iss)
+	if clientID := c.Request.FormValue("client_id"); clientID != "" {
+		q = q.Where("client_id = ?", clientID)
+	}
+	var platforms []LTIPlatform
+	if err := q.Limit(2).Find(&platforms).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки платформы")
+		return
+	}
+	if len(platforms) != 1 {
+		c.String(http.StatusBadRequest, "LTI: платформа не зарегистрирована (или не передан client_id)")
+		return
+	}
+	p := platforms[0]
+
+	state, err := randomToken()
+	nonce, err2 := randomToken()
+	if err != nil || err2 != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации state")
+		return
+	}
+	if err := db.Create(&LTILaunchState{
+		State:      state,
+		Nonce:      nonce,
+		PlatformID: p.ID,
+		ExpiresAt:  time.Now().Add(ltiStateTTL),
+	}).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения state")
+		return
+	}
+
+	params := url.Values{
+		"scope":         {"openid"},
+		"response_type": {"id_token"},
+		"response_mode": {"form_post"},
+		"prompt":        {"none"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {ltiLaunchURL()},
+		"login_hint":    {loginHint},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	if hint := c.Request.FormValue("lti_message_hint"); hint != "" {
+		params.Set("lti_message_hint", hint)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthLoginURL, "?") {
+		sep = "&"
+	}
+	c.Redirect(http.StatusFound, p.AuthLoginURL+sep+params.Encode())
+}
+
+// ltiLaunchHandler принимает id_token: запуск ссылки (вход и переход к курсу/блоку)
+// или запрос deep linking (выбор материала преподавателем).
+func ltiLaunchHandler(c *gin.Context) {
+	if e := c.PostForm("error"); e != "" {
+		c.String(http.StatusBadRequest, "LTI: платформа вернула ошибку: "+e+" "+c.PostForm("error_description"))
+		return
+	}
+
+	var st LTILaunchState
+	if err := db.Preload("Platform").Where("state = ?", c.PostForm("state")).First(&st).Error; err != nil {
+		c.String(http.StatusBadRequest, "LTI: неизвестный или уже использованный state — запустите материал из LMS ещё раз")
+		return
+	}
+	// одноразовый: повтор того же ответа платформы не пройдёт
+	if res := db.Where("state = ?", st.State).Delete(&LTILaunchState{}); res.Error != nil || res.RowsAffected != 1 {
+		c.String(http.StatusBadRequest, "LTI: state уже использован")
+		return
+	}
+	if time.Now().After(st.ExpiresAt) {
+		c.String(http.StatusBadRequest, "LTI: время входа истекло — запустите материал из LMS ещё раз")
+		return
+	}
+	p := &st.Platform
+	if !p.Active {
+		c.String(http.StatusForbidden, "LTI: платформа отключена")
+		return
+	}
+
+	cl, err := ltiValidateIDToken(p, c.PostForm("id_token"), st.Nonce)
+	if err != nil {
+		log.Printf("lti launch (%s): %v\n", p.Issuer, err)
+		c.String(http.StatusUnauthorized, "LTI: id_token не прошёл проверку: "+err.Error())
+		return
+	}
+
+	switch cl.MessageType {
+	case ltiMsgResourceLink:
+		ltiResourceLaunch(c, p, cl)
+	case ltiMsgDeepLinking:
+		ltiStartDeepLinking(c, p, cl)
+	default:
+		c.String(http.StatusBadRequest, "LTI: неподдерживаемый тип сообщения "+cl.MessageType)
+	}
+}
+
+func ltiResourceLaunch(c *gin.Context, p *LTIPlatform, cl *ltiClaims) {
+	user, err := ltiProvisionUser(p, cl)
+	if err != nil {
+		log.Printf("lti provision (%s, %s): %v\n", p.Issuer, cl.Subject, err)
+		c.String(http.StatusInternalServerError, "Ошибка создания пользователя")
+		return
+	}
+	if user.ErasedAt != nil {
+		c.String(http.StatusForbidden, "Аккаунт удалён")
+		return
+	}
+	// платформа проверяет только свой вход: сессия через LTI не даёт прав администратора
+	// (связанный аккаунт могли сделать админом позже), а второй фактор спрашивается как при входе по паролю
+	if user.IsAdmin() {
+		c.String(http.StatusForbidden, "LTI: администратор входит только через форму входа TrainBrain")
+		return
+	}
+
+	courseID, blockID := ltiTarget(cl)
+	ltiRecordLaunch(p, cl, user, courseID, blockID)
+
+	target := "/courses"
+	switch {
+	case blockID != nil:
+		target = "/courses/" + strconv.Itoa(int(courseID)) + "#block-" + strconv.Itoa(int(*blockID))
+	case courseID != 0:
+		target = "/courses/" + strconv.Itoa(int(courseID))
+	}
+	if user.TOTPEnabled || twoFactorRequired(user) {
+		challengeSecondFactor(c, user, target)
+		return
+	}
+	finishLogin(c, user)
+	c.Redirect(http.StatusFound, target)
+}
+
+func ltiStartDeepLinking(c *gin.Context, p *LTIPlatform, cl *ltiClaims) {
+	if cl.DeepLinking == nil || cl.DeepLinking.ReturnURL == "" {
+		c.String(http.StatusBadRequest, "LTI: нет deep_link_return_url")
+		return
+	}
+	accepts := len(cl.DeepLinking.AcceptTypes) == 0
+	for _, t := range cl.DeepLinking.AcceptTypes {
+		if t == "ltiResourceLink" {
+			accepts = true
+		}
+	}
+	if !accepts {
+		c.String(http.StatusBadRequest, "LTI: платформа не принимает ссылки ltiResourceLink")
+		return
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка генерации запроса")
+		return
+	}
+	req := LTIDeepLinkRequest{
+		ID:             id,
+		PlatformID:     p.ID,
+		DeploymentID:   cl.DeploymentID,
+		ReturnURL:      cl.DeepLinking.ReturnURL,
+		Data:           cl.DeepLinking.Data,
+		AcceptMultiple: cl.DeepLinking.AcceptMultiple,
+		ExpiresAt:      time.Now().Add(ltiDeepLinkTTL),
+	}
+	if err := db.Create(&req).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения запроса")
+		return
+	}
+	c.Redirect(http.StatusFound, "/lti/deep-link/"+id)
+}
+
+func loadDeepLinkRequest(c *gin.Context) (*LTIDeepLinkRequest, bool) {
+	var req LTIDeepLinkRequest
+	err := db.Preload("Platform").
+		Where("id = ? AND expires_at > ?", c.Param("request_id"), time.Now()).
+		First(&req).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Запрос выбора материала истёк — начните заново в LMS")
+		return nil, false
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки запроса")
+		return nil, false
+	}
+	return &req, true
+}
+
+// ltiDeepLinkHandler — страница выбора: опубликованные курсы и их блоки.
+func ltiDeepLinkHandler(c *gin.Context) {
+	req, ok := loadDeepLinkRequest(c)
+	if !ok {
+		return
+	}
+	var courses []Course
+	if err := db.Where("status = ?", "published").
+		Preload("Modules", func(tx *gorm.DB) *gorm.DB { return tx.Order("\"order\" asc") }).
+		Preload("Modules.Blocks", func(tx *gorm.DB) *gorm.DB { return tx.Order("\"order\" asc") }).
+		Order("title").Find(&courses).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки курсов")
+		return
+	}
+	c.HTML(http.StatusOK, "lti_deep_link.html", gin.H{
+		"request":  req,
+		"courses":  courses,
+		"platform": req.Platform,
+	})
+}
+
+// ltiDeepLinkRespondHandler возвращает выбор платформе: подписанный JWT автоматически
+// отправляется формой на deep_link_return_url.
+func ltiDeepLinkRespondHandler(c *gin.Context) {
+	req, ok := loadDeepLinkRequest(c)
+	if !ok {
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "Некорректная форма")
+		return
+	}
+	selected := c.PostFormArray("item")
+	if c.PostForm("cancel") != "" {
+		selected = nil
+	}
+	if !req.AcceptMultiple && len(selected) > 1 {
+		selected = selected[:1]
+	}
+
+	var items []ltiContentItem
+	for _, v := range selected {
+		kind, idStr, _ := strings.Cut(v, ":")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		switch kind {
+		case "course":
+			var course Course
+			if db.Where("status = ?", "published").First(&course, id).Error == nil {
+				items = append(items, ltiContentItem{Course: &course})
+			}
+		case "block":
+			blk, err := loadBlockCourse(uint(id))
+			if err == nil && blk.Module.Course.Status == "published" {
+				items = append(items, ltiContentItem{Course: &blk.Module.Course, Block: blk})
+			}
+		}
+	}
+
+	// пустой список — тоже ответ: преподаватель передумал, платформа закроет окно
+	jwtStr, err := ltiDeepLinkResponse(req, items)
+	if err != nil {
+		log.Printf("lti deep link: %v\n", err)
+		c.String(http.StatusInternalServerError, "Ошибка подписи ответа")
+		return
+	}
+	db.Delete(req)
+
+	c.HTML(http.StatusOK, "lti_autopost.html", gin.H{
+		"action": req.ReturnURL,
+		"jwt":    jwtStr,
+	})
+}
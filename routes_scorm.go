@@ -0,0 +1,266 @@
+// routes_scorm.go
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"gorm.io/gorm"
+)
+
+// Плеер SCORM: страница плеера и iframe с содержимым пакета. Пакет — чужой JavaScript, поэтому
+// он открыт в песочнице без allow-same-origin: у его страниц свой непрозрачный origin, ни cookie
+// сессии, ни окна плеера они не видят. Файлы пакета отдаются по подписанной ссылке
+// /scorm/<id>/content/<токен>/..., а в каждую HTML-страницу пакета подставляется API
+// (window.API / window.API_1484_11, static/js/scorm_api.js), которое передаёт значения плееру
+// через postMessage; на сервер их отправляет плеер (POST /scorm/<id>/runtime).
+func registerScormRoutes(r *gin.Engine) {
+	g := r.Group("/scorm", authRequired())
+	{
+		g.GET("/:block_id", scormPlayerHandler)
+		g.POST("/:block_id/runtime", scormRuntimeHandler)
+	}
+	r.GET("/scorm/:block_id/content/:token/*filepath", scormContentHandler)
+}
+
+const (
+	scormContentTTL = 12 * time.Hour
+	// песочница для файлов пакета: скрипты и формы — да, свой origin (cookie, окно плеера) — нет
+	scormContentCSP = "sandbox allow-scripts allow-forms"
+)
+
+var (
+	scormKeyOnce sync.Once
+	scormKey     []byte
+
+	scormHeadRe = regexp.MustCompile(`(?i)<head[^>]*>`)
+)
+
+// scormContentKey — ключ подписи ссылок на файлы пакета: из SESSION_SECRET, чтобы ссылки
+// работали на всех экземплярах; без него — случайный до перезапуска.
+func scormContentKey() []byte {
+	scormKeyOnce.Do(func() {
+		if s := os.Getenv("SESSION_SECRET"); s != "" {
+			scormKey = []byte(s)
+		} else {
+			scormKey = securecookie.GenerateRandomKey(32)
+		}
+	})
+	return scormKey
+}
+
+func scormContentMAC(blockID, userID uint, exp int64) string {
+	mac := hmac.New(sha256.New, scormContentKey())
+	fmt.Fprintf(mac, "scorm-content|%d|%d|%d", blockID, userID, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// scormContentToken — часть пути к файлам пакета: <user_id>.<срок>.<подпись>. Cookie в песочницу
+// не попадают, поэтому пользователь и доступ к блоку берутся из токена.
+func scormContentToken(blockID, userID uint, now time.Time) string {
+	exp := now.Add(scormContentTTL).Unix()
+	return strconv.FormatUint(uint64(userID), 10) + "." + strconv.FormatInt(exp, 10) + "." +
+		scormContentMAC(blockID, userID, exp)
+}
+
+// scormContentUser проверяет токен и возвращает ID пользователя.
+func scormContentUser(blockID uint, token string, now time.Time) (uint, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	userID, err1 := strconv.ParseUint(parts[0], 10, 32)
+	exp, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil || now.Unix() > exp {
+		return 0, false
+	}
+	want := scormContentMAC(blockID, uint(userID), exp)
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// scormInjectAPI подставляет скрипт API в начало <head> (или страницы, если его нет),
+// чтобы window.API появился раньше скриптов пакета.
+func scormInjectAPI(page []byte, version, state string) []byte {
+	tag := []byte(`<script src="/static/js/scorm_api.js" data-version="` + html.EscapeString(version) +
+		`" data-state="` + html.EscapeString(state) + `"></script>`)
+	if loc := scormHeadRe.FindIndex(page); loc != nil {
+		out := make([]byte, 0, len(page)+len(tag))
+		out = append(out, page[:loc[1]]...)
+		out = append(out, tag...)
+		return append(out, page[loc[1]:]...)
+	}
+	return append(tag, page...)
+}
+
+func loadScormBlock(c *gin.Context) (*Block, bool) {
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return nil, false
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || blk.Type != "scorm" {
+		c.String(http.StatusNotFound, "SCORM-блок не найден")
+		return nil, false
+	}
+	return blk, true
+}
+
+func loadScormAttempt(userID, blockID uint) (*ScormAttempt, error) {
+	var a ScormAttempt
+	err := db.Where("user_id = ? AND block_id = ?", userID, blockID).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func scormPlayerHandler(c *gin.Context) {
+	blk, ok := loadScormBlock(c)
+	if !ok {
+		return
+	}
+	user := getCurrentUser(c)
+	p := scormPayload(blk)
+	if scormPackagePath(p.Package) == "" {
+		c.String(http.StatusNotFound, "К блоку не загружен SCORM-пакет")
+		return
+	}
+
+	c.HTML(http.StatusOK, "scorm_player.html", gin.H{
+		"User":    user,
+		"Block":   blk,
+		"Title":   blockTitle(blk),
+		"Version": p.Version,
+		"Launch": "/scorm/" + strconv.Itoa(int(blk.ID)) + "/content/" +
+			scormContentToken(blk.ID, user.ID, time.Now()) + "/" + p.Launch,
+	})
+}
+
+// scormContentHandler отдаёт файлы пакета по подписанной ссылке. Только обычные файлы внутри
+// каталога пакета; в HTML-страницы подставляется API с текущим состоянием пользователя.
+func scormContentHandler(c *gin.Context) {
+	// заголовок — и на ответы с ошибкой: вне песочницы из пакета ничего не открывается
+	c.Header("Content-Security-Policy", scormContentCSP)
+	blk, ok := loadScormBlock(c)
+	if !ok {
+		return
+	}
+	userID, ok := scormContentUser(blk.ID, c.Param("token"), time.Now())
+	if !ok {
+		c.String(http.StatusForbidden, "Ссылка на пакет устарела — откройте блок заново")
+		return
+	}
+	p := scormPayload(blk)
+	dir := scormPackagePath(p.Package)
+	if dir == "" {
+		c.String(http.StatusNotFound, "Файл не найден")
+		return
+	}
+	rel := path.Clean("/" + c.Param("filepath"))
+	full := filepath.Join(dir, filepath.FromSlash(rel))
+	if rel == "/" || !strings.HasPrefix(full, dir+string(filepath.Separator)) {
+		c.String(http.StatusNotFound, "Файл не найден")
+		return
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		c.String(http.StatusNotFound, "Файл не найден")
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || !st.Mode().IsRegular() {
+		c.String(http.StatusNotFound, "Файл не найден")
+		return
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	// у страниц пакета origin «null»: XHR/fetch к его же файлам — запрос с другого origin без cookie
+	c.Header("Access-Control-Allow-Origin", "*")
+	if ext := strings.ToLower(filepath.Ext(full)); ext != ".html" && ext != ".htm" {
+		c.Header("Cache-Control", "private, max-age=3600")
+		http.ServeContent(c.Writer, c.Request, st.Name(), st.ModTime(), f)
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil || user.ErasedAt != nil {
+		c.String(http.StatusForbidden, "Ссылка на пакет устарела — откройте блок заново")
+		return
+	}
+	a, err := loadScormAttempt(user.ID, blk.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка загрузки состояния пакета")
+		return
+	}
+	state, err := json.Marshal(scormRuntimeState(&user, p, a))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка подготовки данных пакета")
+		return
+	}
+	page, err := io.ReadAll(f)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка чтения файла")
+		return
+	}
+	// состояние в странице — своё у каждого пользователя и каждого открытия
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", scormInjectAPI(page, p.Version, string(state)))
+}
+
+// scormRuntimeRequest — тело, которое присылает JS-мост при Commit/Finish.
+type scormRuntimeRequest struct {
+	CMI    map[string]string `json:"cmi"`
+	Finish bool              `json:"finish"`
+}
+
+// scormRuntimeHandler сохраняет данные пакета. Тело — поле формы data (JSON),
+// чтобы работал и navigator.sendBeacon при закрытии страницы.
+func scormRuntimeHandler(c *gin.Context) {
+	blk, ok := loadScormBlock(c)
+	if !ok {
+		return
+	}
+	var req scormRuntimeRequest
+	if err := json.Unmarshal([]byte(c.PostForm("data")), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные SCORM"})
+		return
+	}
+	user := getCurrentUser(c)
+	a, err := scormCommit(user, blk, req.CMI, req.Finish)
+	if err != nil {
+		log.Printf("scorm commit (user %d, block %d): %v\n", user.ID, blk.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":         a.Status,
+		"success_status": a.SuccessStatus,
+		"score":          a.Score,
+		"completed":      a.Completed,
+	})
+}
@@ -0,0 +1,62 @@
+// routes_scorm_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ссылка на файлы пакета работает только для своего блока и до срока.
+func TestScormContentToken(t *testing.T) {
+	now := time.Now()
+	token := scormContentToken(7, 42, now)
+	parts := strings.Split(token, ".")
+
+	cases := []struct {
+		name    string
+		blockID uint
+		token   string
+		at      time.Time
+		ok      bool
+	}{
+		{"свой блок", 7, token, now, true},
+		{"до конца срока", 7, token, now.Add(scormContentTTL - time.Minute), true},
+		{"срок вышел", 7, token, now.Add(scormContentTTL + time.Minute), false},
+		{"другой блок", 8, token, now, false},
+		{"чужой пользователь", 7, "43." + parts[1] + "." + parts[2], now, false},
+		{"продлённый срок", 7, parts[0] + ".9999999999." + parts[2], now, false},
+		{"без подписи", 7, parts[0] + "." + parts[1], now, false},
+		{"мусор", 7, "x.y.z", now, false},
+	}
+	for _, tc := range cases {
+		userID, ok := scormContentUser(tc.blockID, tc.token, tc.at)
+		if ok != tc.ok || (ok && userID != 42) {
+			t.Errorf("%s: scormContentUser = %d, %v; want ok=%v", tc.name, userID, ok, tc.ok)
+		}
+	}
+}
+
+// API подставляется до скриптов пакета, состояние экранируется.
+func TestScormInjectAPI(t *testing.T) {
+	state := `{"cmi.core.student_name":"\"><script>alert(1)</script>"}`
+	cases := []struct {
+		page string
+		want string // начало результата
+	}{
+		{`<html><HEAD lang="ru"><script src="a.js"></script></HEAD></html>`, `<html><HEAD lang="ru"><script src="/static/js/scorm_api.js"`},
+		{`<script src="a.js"></script>`, `<script src="/static/js/scorm_api.js"`},
+	}
+	for _, tc := range cases {
+		got := string(scormInjectAPI([]byte(tc.page), "1.2", state))
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%q: получено %q", tc.page, got)
+		}
+		if strings.Contains(got, "<script>alert") {
+			t.Errorf("%q: состояние не экранировано: %q", tc.page, got)
+		}
+		if strings.Index(got, "scorm_api.js") > strings.Index(got, `src="a.js"`) {
+			t.Errorf("%q: скрипт пакета должен идти после API: %q", tc.page, got)
+		}
+	}
+}
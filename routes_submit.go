@@ -2,6 +2,9 @@
 package main
 
 import (
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -53,23 +56,42 @@ func submitAssignmentHandler(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Файл обязателен")
 		return
 	}
-	if err != nil {
-		c.String(http.StatusBadRequest, "Файл обязателен")
+
+	if _, err := saveSubmission(user, &block, file); errors.Is(err, errSubmissionClosed) {
+		c.String(http.StatusForbidden, "Срок сдачи задания прошёл")
+		return
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, "Ошибка сохранения отправки")
 		return
 	}
 
-	if err := os.MkdirAll(UploadsDir, 0o755); err != nil {
-		c.String(http.StatusInternalServerError, "Ошибка создания директории")
+	// редирект обратно на курс с якорем блока
+	// нужно найти курс по модулю
+	var module Module
+	if err := db.First(&module, block.ModuleID).Error; err != nil {
+		c.Redirect(http.StatusFound, "/courses")
 		return
 	}
 
+	c.Redirect(http.StatusFound, "/courses/"+strconv.Itoa(int(module.CourseID))+"#block-"+blockIDStr)
+}
+
+// saveSubmission кладёт файл в UploadsDir и создаёт запись Submission (форма плеера и API).
+// После срока сдачи задания с рецензированием — errSubmissionClosed.
+func saveSubmission(user *User, block *Block, file *multipart.FileHeader) (*Submission, error) {
+	if s := peerSettings(block); s != nil && peerPhase(s, time.Now()) != peerPhaseSubmission {
+		return nil, errSubmissionClosed
+	}
+	if err := os.MkdirAll(UploadsDir, 0o755); err != nil {
+		return nil, err
+	}
+
 	ext := filepath.Ext(file.Filename)
 	filename := strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + strconv.Itoa(int(user.ID)) + ext
 	fullPath := filepath.Join(UploadsDir, filename)
 
-	if err := c.SaveUploadedFile(file, fullPath); err != nil {
-		c.String(http.StatusInternalServerError, "Ошибка сохранения файла")
-		return
+	if err := saveMultipartFile(file, fullPath); err != nil {
+		return nil, err
 	}
 
 	sub := Submission{
@@ -82,17 +104,26 @@ func submitAssignmentHandler(c *gin.Context) {
 		Status:       "submitted",
 	}
 	if err := db.Create(&sub).Error; err != nil {
-		c.String(http.StatusInternalServerError, "Ошибка сохранения отправки")
-		return
+		os.Remove(fullPath)
+		return nil, err
 	}
+	emitSubmissionCreated(user, block, &sub)
+	return &sub, nil
+}
 
-	// редирект обратно на курс с якорем блока
-	// нужно найти курс по модулю
-	var module Module
-	if err := db.First(&module, block.ModuleID).Error; err != nil {
-		c.Redirect(http.StatusFound, "/courses")
-		return
+func saveMultipartFile(file *multipart.FileHeader, dst string) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
 	}
+	defer src.Close()
 
-	c.Redirect(http.StatusFound, "/courses/"+strconv.Itoa(int(module.CourseID))+"#block-"+blockIDStr)
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, src)
+	return err
 }
@@ -0,0 +1,263 @@
+// sanitize.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Контент авторов (payload блоков) проходит один конвейер:
+//   - при сохранении (форма блока, импорт архива, course-sync) — sanitizeBlockPayload:
+//     сырой HTML в Markdown очищается, адреса картинок/видео и ссылки проверяются;
+//   - при выводе — renderMarkdown и шаблонная функция safe: результат всегда через htmlPolicy.
+//
+// Allowlist — политика UGC bluemonday плюс то, что нужно Markdown (классы подсветки, id
+// заголовков, чекбоксы GFM), iframe с хостов HTML_IFRAME_HOSTS и элементы из HTML_ALLOW.
+
+var (
+	htmlPolicy = newHTMLPolicy()
+
+	mdClassRe   = regexp.MustCompile(`^[\w -]+$`)
+	mdIDRe      = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+	htmlAllowRe = regexp.MustCompile(`^([a-z][a-z0-9]*)(?:\[([a-z0-9 _-]*)\])?$`)
+
+	// что нельзя разрешить через HTML_ALLOW ни при каких настройках
+	htmlForbiddenElements = map[string]bool{
+		"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+		"embed": true, "applet": true, "form": true, "input": true, "button": true, "textarea": true,
+		"select": true, "base": true, "meta": true, "link": true, "svg": true, "math": true, "template": true,
+	}
+)
+
+const defaultIframeHosts = "www.youtube.com,www.youtube-nocookie.com,player.vimeo.com,rutube.ru"
+
+func newHTMLPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(mdClassRe).OnElements("a", "code", "pre", "span", "div")
+	p.AllowAttrs("id").Matching(mdIDRe).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	// встраивание видео: только https и только с перечисленных хостов
+	var hosts []string
+	for _, h := range strings.Split(envOr("HTML_IFRAME_HOSTS", defaultIframeHosts), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, regexp.QuoteMeta(h))
+		}
+	}
+	if len(hosts) > 0 {
+		src := regexp.MustCompile(`^https://(` + strings.Join(hosts, "|") + `)/`)
+		p.AllowAttrs("src").Matching(src).OnElements("iframe")
+		p.AllowAttrs("width", "height").Matching(regexp.MustCompile(`^\d{1,4}%?$`)).OnElements("iframe")
+		p.AllowAttrs("title").OnElements("iframe")
+		p.AllowAttrs("allowfullscreen").Matching(regexp.MustCompile(`^(|allowfullscreen|true)$`)).OnElements("iframe")
+		p.AllowAttrs("allow").Matching(regexp.MustCompile(`^[a-z0-9; -]*$`)).OnElements("iframe")
+	}
+
+	// HTML_ALLOW="mark,kbd,abbr[title],span[data-note lang]" — дополнительные элементы и атрибуты
+	for _, spec := range strings.Split(envOr("HTML_ALLOW", ""), ",") {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		if spec == "" {
+			continue
+		}
+		m := htmlAllowRe.FindStringSubmatch(spec)
+		if m == nil || htmlForbiddenElements[m[1]] {
+			log.Fatalf("HTML_ALLOW: %q нельзя разрешить", spec)
+		}
+		p.AllowElements(m[1])
+		for _, attr := range strings.Fields(m[2]) {
+			if strings.HasPrefix(attr, "on") || attr == "style" || attr == "srcdoc" || attr == "formaction" {
+				log.Fatalf("HTML_ALLOW: атрибут %s у %s нельзя разрешить", attr, m[1])
+			}
+			p.AllowAttrs(attr).OnElements(m[1])
+		}
+	}
+	return p
+}
+
+// sanitizeHTML — HTML после allowlist-политики.
+func sanitizeHTML(s string) string {
+	return htmlPolicy.Sanitize(s)
+}
+
+// safeContentURL — адрес картинки, видео или встраивания: путь на сайте или http(s).
+func safeContentURL(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+		return !strings.ContainsAny(s, "\\\x00")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// safeLinkURL — ссылка в тексте: относительная, http(s), mailto или tel.
+func safeLinkURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto", "tel":
+		return true
+	}
+	return false
+}
+
+// sanitizeMarkdownSource очищает сырой HTML внутри Markdown (остальной текст не трогает)
+// и возвращает ссылки с опасными схемами — их при сохранении не принимаем.
+func sanitizeMarkdownSource(src string) (string, []string) {
+	source := []byte(src)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	var segs []text.Segment
+	var bad []string
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch x := n.(type) {
+		case *ast.HTMLBlock:
+			for i := 0; i < x.Lines().Len(); i++ {
+				segs = append(segs, x.Lines().At(i))
+			}
+			if x.HasClosure() {
+				segs = append(segs, x.ClosureLine)
+			}
+		case *ast.RawHTML:
+			for i := 0; i < x.Segments.Len(); i++ {
+				segs = append(segs, x.Segments.At(i))
+			}
+		case *ast.Link:
+			if !safeLinkURL(string(x.Destination)) {
+				bad = append(bad, string(x.Destination))
+			}
+		case *ast.Image:
+			if !safeLinkURL(string(x.Destination)) {
+				bad = append(bad, string(x.Destination))
+			}
+		case *ast.AutoLink:
+			if u := string(x.URL(source)); !safeLinkURL(u) {
+				bad = append(bad, u)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(segs) == 0 {
+		return src, bad
+	}
+
+	// заменяем с конца, чтобы не сдвигать ещё не обработанные позиции
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Start > segs[j].Start })
+	out := source
+	for _, s := range segs {
+		orig := string(out[s.Start:s.Stop])
+		body := strings.TrimRight(orig, "\r\n")
+		clean := sanitizeHTML(body) + orig[len(body):]
+		if clean == orig {
+			continue
+		}
+		out = append(out[:s.Start:s.Start], append([]byte(clean), out[s.Stop:]...)...)
+	}
+	return string(out), bad
+}
+
+///////////////////////////////////////////////////////
+// PAYLOAD БЛОКОВ
+///////////////////////////////////////////////////////
+
+// sanitizeBlockPayload очищает payload блока. problems — что не прошло проверку:
+// адреса с недопустимой схемой (поле удаляется) и такие же ссылки в Markdown (остаются —
+// при выводе их вырежет htmlPolicy).
+func sanitizeBlockPayload(blockType string, raw datatypes.JSON) (datatypes.JSON, []string, error) {
+	// поля, которые автор заполняет свободно, объявляет тип блока (blocks.go): Markdown
+	// и адреса файлов/встраивания. Остальные строки выводятся шаблонами с экранированием.
+	k := blockKinds[blockType]
+	if len(raw) == 0 || k == nil || (len(k.markdown) == 0 && len(k.urls) == 0) {
+		return raw, nil, nil
+	}
+	var pm map[string]any
+	if err := json.Unmarshal(raw, &pm); err != nil {
+		return nil, nil, err
+	}
+	if pm == nil {
+		return raw, nil, nil
+	}
+
+	var problems []string
+	for _, f := range k.markdown {
+		if s, ok := pm[f].(string); ok {
+			clean, bad := sanitizeMarkdownSource(s)
+			pm[f] = clean
+			for _, u := range bad {
+				problems = append(problems, fmt.Sprintf("ссылка %q в тексте: допустимы http(s), mailto, tel и относительные", u))
+			}
+		}
+	}
+	for _, f := range k.urls {
+		if s, ok := pm[f].(string); ok && strings.TrimSpace(s) != "" && !safeContentURL(s) {
+			delete(pm, f)
+			problems = append(problems, fmt.Sprintf("%s: адрес %q — допустимы http(s) и пути на сайте", f, s))
+		}
+	}
+	out, err := json.Marshal(pm)
+	return datatypes.JSON(out), problems, err
+}
+
+// sanitizePayloadForSave — то же при сохранении автором: любая проблема — ошибка формы.
+func sanitizePayloadForSave(blockType string, raw datatypes.JSON) (datatypes.JSON, error) {
+	out, problems, err := sanitizeBlockPayload(blockType, raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return out, nil
+}
+
+// migrateSanitizePayloads прогоняет через конвейер payload, сохранённые до его появления.
+// updated_at не меняется — это не правка автора.
+func migrateSanitizePayloads(tx *gorm.DB) error {
+	changed := 0
+	var blocks []Block
+	err := tx.Select("id", "type", "payload").Order("id").FindInBatches(&blocks, 200, func(tx *gorm.DB, _ int) error {
+		for _, b := range blocks {
+			out, problems, err := sanitizeBlockPayload(b.Type, b.Payload)
+			if err != nil {
+				log.Printf("sanitize: блок #%d: некорректный payload: %v\n", b.ID, err)
+				continue
+			}
+			for _, p := range problems {
+				log.Printf("sanitize: блок #%d: %s\n", b.ID, p)
+			}
+			if sameJSON(b.Payload, out) {
+				continue
+			}
+			if err := tx.Model(&Block{}).Where("id = ?", b.ID).UpdateColumn("payload", out).Error; err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("sanitize: очищено payload блоков: %d\n", changed)
+	return nil
+}
@@ -0,0 +1,120 @@
+// sanitize_test.go
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// xssVectors — HTML и Markdown, после которых на странице не должно остаться исполняемого кода.
+var xssVectors = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+	`<img src=x onerror=alert(1)>`,
+	`<img src="javascript:alert(1)">`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<body onload=alert(1)>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="JaVaScRiPt:alert(1)">x</a>`,
+	`<a href="&#106;avascript:alert(1)">x</a>`,
+	`<a href="java&#x09;script:alert(1)">x</a>`,
+	`<a href=" javascript:alert(1)">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html,<script>alert(1)</script>">x</a>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<iframe src="https://evil.example/"></iframe>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<form action="javascript:alert(1)"><button>x</button></form>`,
+	`<button formaction="javascript:alert(1)">x</button>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<base href="javascript:alert(1)//">`,
+	`<link rel=stylesheet href="javascript:alert(1)">`,
+	`<details open ontoggle=alert(1)>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+	`<style>@import 'javascript:alert(1)';</style>`,
+	`"><img src=x onerror=alert(1)>`,
+	`[x](javascript:alert(1))`,
+	`[x](JAVASCRIPT:alert(1))`,
+	`[x](&#x6A;avascript:alert(1))`,
+	`[x](javascript&colon;alert(1))`,
+	`![x](javascript:alert(1))`,
+	`<javascript:alert(1)>`,
+	"[x][r]\n\n[r]: javascript:alert(1)",
+	"```\n</code></pre><script>alert(1)</script>\n```",
+	"# <img src=x onerror=alert(1)>",
+	"> <script>alert(1)</script>",
+	"- <svg onload=alert(1)>",
+	`$\text{<script>alert(1)</script>}$`,
+	`$$\operatorname{<img src=x onerror=alert(1)>}$$`,
+	"$$\n\\begin{matrix}<svg onload=alert(1)>\\end{matrix}\n$$",
+	"$x$ \uE000<script>alert(1)</script>\uE001",
+}
+
+// Каждый вектор — через все пути вывода: safe, Markdown при показе и Markdown, очищенный при сохранении.
+// С HTML_ALLOW — тоже: расширенный allowlist не должен открывать ни один вектор.
+func TestSanitizerBlocksXSSVectors(t *testing.T) {
+	for _, allow := range []string{"", "mark,kbd,abbr[title],span[lang]"} {
+		t.Setenv("HTML_ALLOW", allow)
+		saved := htmlPolicy
+		htmlPolicy = newHTMLPolicy()
+
+		for _, v := range xssVectors {
+			// сохранённый источник — Markdown (формулы в нём остаются TeX), проверяется его вывод
+			md, _ := sanitizeMarkdownSource(v)
+			outputs := []struct{ path, out string }{
+				{"sanitizeHTML", sanitizeHTML(v)},
+				{"renderMarkdown", string(renderMarkdown(v, "", nil))},
+				{"renderMarkdown(sanitizeMarkdownSource)", string(renderMarkdown(md, "", nil))},
+			}
+			for _, o := range outputs {
+				if reason := unsafeHTMLReason(o.out); reason != "" {
+					t.Errorf("HTML_ALLOW=%q, %s пропускает %q: %s\n%s", allow, o.path, v, reason, o.out)
+				}
+			}
+		}
+		htmlPolicy = saved
+	}
+}
+
+var dangerousSchemeRe = regexp.MustCompile(`^(javascript|vbscript|data|file):`)
+
+// unsafeHTMLReason разбирает HTML и ищет исполняемое: опасные элементы (script, SVG, выход из MathML),
+// обработчики событий, style/srcdoc и адреса с опасной схемой. Пустая строка — ничего не найдено.
+func unsafeHTMLReason(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "script", "style", "object", "embed", "form", "base", "meta", "link", "svg", "button",
+				// MathML формул выводим сами; через эти элементы выходят из MathML/SVG обратно в HTML
+				"mglyph", "malignmark", "annotation-xml", "foreignobject":
+				return "элемент " + t.Data
+			}
+			for _, a := range t.Attr {
+				key := strings.ToLower(a.Key)
+				if strings.HasPrefix(key, "on") || key == "style" || key == "srcdoc" || key == "formaction" {
+					return "атрибут " + key
+				}
+				val := strings.ToLower(strings.Join(strings.FieldsFunc(a.Val, func(r rune) bool { return r <= ' ' }), ""))
+				if dangerousSchemeRe.MatchString(val) {
+					return "адрес " + a.Val
+				}
+				if t.Data == "iframe" && key == "src" && !safeContentURL(a.Val) {
+					return "iframe " + a.Val
+				}
+			}
+		}
+	}
+}
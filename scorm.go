@@ -0,0 +1,671 @@
+// scorm.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm/clause"
+)
+
+// SCORM 1.2 и 2004: пакет (ZIP с imsmanifest.xml) распаковывается в SCORM_DIR/<id> и отдаётся
+// по подписанной ссылке /scorm/<block_id>/content/<токен>/ в песочнице (routes_scorm.go). Запускается первый SCO
+// организации по умолчанию; состояние (модель данных cmi) хранится в ScormAttempt.
+
+const (
+	scormV12  = "1.2"
+	scorm2004 = "2004"
+)
+
+func scormDir() string { return envOr("SCORM_DIR", "uploads/scorm") }
+
+var scormPackageIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// scormPackagePath — каталог распакованного пакета; "" для некорректного ID.
+func scormPackagePath(id string) string {
+	if !scormPackageIDRe.MatchString(id) {
+		return ""
+	}
+	return filepath.Join(scormDir(), id)
+}
+
+///////////////////////////////////////////////////////
+// imsmanifest.xml
+///////////////////////////////////////////////////////
+
+// Имена без пространств имён: encoding/xml сопоставляет их с adlcp:, imsss: и т. п.
+type scormManifest struct {
+	SchemaVersion string `xml:"metadata>schemaversion"`
+	Organizations struct {
+		Default string              `xml:"default,attr"`
+		List    []scormOrganization `xml:"organization"`
+	} `xml:"organizations"`
+	Resources struct {
+		Base string          `xml:"base,attr"`
+		List []scormResource `xml:"resource"`
+	} `xml:"resources"`
+}
+
+type scormOrganization struct {
+	Identifier string      `xml:"identifier,attr"`
+	Title      string      `xml:"title"`
+	Items      []scormItem `xml:"item"`
+}
+
+type scormItem struct {
+	IdentifierRef       string `xml:"identifierref,attr"`
+	Parameters          string `xml:"parameters,attr"`
+	Title               string `xml:"title"`
+	MasteryScore        string `xml:"masteryscore"` // 1.2
+	DataFromLMS12       string `xml:"datafromlms"`
+	DataFromLMS2004     string `xml:"dataFromLMS"`
+	CompletionThreshold string `xml:"completionThreshold"`
+	PrimaryObjective    struct {
+		SatisfiedByMeasure   bool   `xml:"satisfiedByMeasure,attr"`
+		MinNormalizedMeasure string `xml:"minNormalizedMeasure"`
+	} `xml:"sequencing>objectives>primaryObjective"`
+	Items []scormItem `xml:"item"`
+}
+
+type scormResource struct {
+	Identifier    string `xml:"identifier,attr"`
+	Href          string `xml:"href,attr"`
+	Base          string `xml:"base,attr"`
+	ScormType12   string `xml:"scormtype,attr"`
+	ScormType2004 string `xml:"scormType,attr"`
+}
+
+// scormPackageInfo — то, что из манифеста попадает в payload блока.
+type scormPackageInfo struct {
+	Version            string
+	Title              string
+	Launch             string // путь внутри пакета, может содержать ?параметры
+	MasteryScore       *float64
+	ScaledPassingScore *float64
+	CompletionThresh   *float64
+	LaunchData         string
+}
+
+func parseScormManifest(data []byte) (*scormPackageInfo, error) {
+	var m scormManifest
+	if err := xml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("imsmanifest.xml: %w", err)
+	}
+
+	info := &scormPackageInfo{Version: scormV12}
+	sv := strings.TrimSpace(m.SchemaVersion)
+	switch {
+	case sv == "1.2":
+	case strings.Contains(sv, "2004"), strings.Contains(sv, "1.3"), bytes.Contains(data, []byte("adlcp_v1p3")):
+		info.Version = scorm2004
+	}
+
+	if len(m.Organizations.List) == 0 {
+		return nil, errors.New("в манифесте нет организаций (organizations)")
+	}
+	org := m.Organizations.List[0]
+	for _, o := range m.Organizations.List {
+		if o.Identifier == m.Organizations.Default {
+			org = o
+		}
+	}
+	resources := map[string]scormResource{}
+	for _, r := range m.Resources.List {
+		resources[r.Identifier] = r
+	}
+
+	item, res := findLaunchItem(org.Items, resources)
+	if item == nil {
+		return nil, errors.New("в манифесте нет запускаемого ресурса (item с identifierref и href)")
+	}
+
+	launch := m.Resources.Base + res.Base + res.Href
+	if p := strings.TrimSpace(item.Parameters); p != "" {
+		switch {
+		case strings.HasPrefix(p, "#"):
+		case strings.Contains(launch, "?"):
+			p = "&" + strings.TrimPrefix(p, "?")
+		case !strings.HasPrefix(p, "?"):
+			p = "?" + p
+		}
+		launch += p
+	}
+	file, _, _ := strings.Cut(launch, "?")
+	file, _, _ = strings.Cut(file, "#")
+	clean := path.Clean("/" + file)
+	if strings.Contains(file, "://") || clean == "/" {
+		return nil, errors.New("недопустимый адрес запуска: " + launch)
+	}
+	info.Launch = strings.TrimPrefix(clean, "/") + launch[len(file):]
+
+	info.Title = strings.TrimSpace(org.Title)
+	if info.Title == "" {
+		info.Title = strings.TrimSpace(item.Title)
+	}
+	info.MasteryScore = parseOptFloat(item.MasteryScore)
+	info.CompletionThresh = parseOptFloat(item.CompletionThreshold)
+	if item.PrimaryObjective.SatisfiedByMeasure {
+		info.ScaledPassingScore = parseOptFloat(item.PrimaryObjective.MinNormalizedMeasure)
+	}
+	info.LaunchData = strings.TrimSpace(item.DataFromLMS12 + item.DataFromLMS2004)
+	return info, nil
+}
+
+// findLaunchItem — первый (в глубину) item, ссылающийся на ресурс с href.
+func findLaunchItem(items []scormItem, resources map[string]scormResource) (*scormItem, *scormResource) {
+	for i := range items {
+		if r, ok := resources[items[i].IdentifierRef]; ok && r.Href != "" {
+			return &items[i], &r
+		}
+		if it, r := findLaunchItem(items[i].Items, resources); it != nil {
+			return it, r
+		}
+	}
+	return nil, nil
+}
+
+func parseOptFloat(s string) *float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+///////////////////////////////////////////////////////
+// ЗАГРУЗКА ПАКЕТА
+///////////////////////////////////////////////////////
+
+// scormImport распаковывает загруженный ZIP и возвращает ID пакета и данные манифеста.
+// Пути проверяются (zip slip), размер ограничен SCORM_MAX_UNPACKED_MB (по умолчанию 1024).
+func scormImport(fh *multipart.FileHeader) (string, *scormPackageInfo, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, fh.Size)
+	if err != nil {
+		return "", nil, errors.New("файл не является ZIP-архивом")
+	}
+
+	// манифест в корне или в единственной папке верхнего уровня
+	root := ""
+	var manifest *zip.File
+	for _, zf := range zr.File {
+		name := strings.ReplaceAll(zf.Name, "\\", "/")
+		if path.Base(name) != "imsmanifest.xml" {
+			continue
+		}
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		if manifest == nil || len(dir) < len(root) {
+			manifest, root = zf, dir
+		}
+	}
+	if manifest == nil {
+		return "", nil, errors.New("в архиве нет imsmanifest.xml — это не SCORM-пакет")
+	}
+	rc, err := manifest.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, 8<<20))
+	rc.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := parseScormManifest(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := newScormPackageID()
+	if err != nil {
+		return "", nil, err
+	}
+	dest := scormPackagePath(id)
+
+	prefix := ""
+	if root != "" {
+		prefix = root + "/"
+	}
+	err = scormExtract(zr.File, prefix, dest)
+	if err == nil {
+		launchFile, _, _ := strings.Cut(info.Launch, "?")
+		launchFile, _, _ = strings.Cut(launchFile, "#")
+		if _, serr := os.Stat(filepath.Join(dest, filepath.FromSlash(launchFile))); serr != nil {
+			err = errors.New("в пакете нет файла запуска " + launchFile)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dest)
+		return "", nil, err
+	}
+	return id, info, nil
+}
+
+func newScormPackageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// scormExtract распаковывает файлы архива с путём, начинающимся с prefix, в каталог dest.
+// Пути очищаются от "../", ссылки и каталоги пропускаются, объём — не больше SCORM_MAX_UNPACKED_MB.
+func scormExtract(files []*zip.File, prefix, dest string) error {
+	limit := int64(envInt("SCORM_MAX_UNPACKED_MB", 1024)) << 20
+	var total int64
+	for _, zf := range files {
+		name := strings.ReplaceAll(zf.Name, "\\", "/")
+		if !strings.HasPrefix(name, prefix) || zf.FileInfo().IsDir() || !zf.Mode().IsRegular() {
+			continue
+		}
+		rel := path.Clean("/" + strings.TrimPrefix(name, prefix))
+		if rel == "/" {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		src, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			src.Close()
+			return err
+		}
+		n, err := io.Copy(out, io.LimitReader(src, limit-total+1))
+		src.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		total += n
+		if total > limit {
+			return fmt.Errorf("пакет больше %d МБ в распакованном виде", limit>>20)
+		}
+	}
+	return nil
+}
+
+// removeScormPackage удаляет распакованный пакет (при замене пакета или удалении блока).
+func removeScormPackage(id string) {
+	if dir := scormPackagePath(id); dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("scorm: удаление пакета %s: %v\n", id, err)
+		}
+	}
+}
+
+///////////////////////////////////////////////////////
+// МОДЕЛЬ ДАННЫХ cmi
+///////////////////////////////////////////////////////
+
+// scormBlockPayload — payload блока типа scorm (тип в реестре — scormBlock, blocks.go).
+type scormBlockPayload struct {
+	Title              string   `json:"title"`
+	Package            string   `json:"package" schema:"required"`
+	Version            string   `json:"version"`
+	Launch             string   `json:"launch"`
+	PackageTitle       string   `json:"package_title"`
+	OriginalName       string   `json:"original_name"`
+	PassScore          *float64 `json:"pass_score,omitempty" schema:"min=0,max=100"` // проходной балл %, задан в админке поверх манифеста
+	MasteryScore       *float64 `json:"mastery_score,omitempty"`                     // 1.2: проходной балл (raw)
+	ScaledPassingScore *float64 `json:"scaled_passing_score,omitempty"`              // 2004: 0..1
+	CompletionThresh   *float64 `json:"completion_threshold,omitempty"`              // 2004: 0..1
+	LaunchData         string   `json:"launch_data,omitempty"`
+}
+
+func scormPayload(blk *Block) scormBlockPayload {
+	var p scormBlockPayload
+	_ = json.Unmarshal(blk.Payload, &p)
+	if p.Version != scorm2004 {
+		p.Version = scormV12
+	}
+	if p.PassScore != nil {
+		if p.Version == scorm2004 {
+			v := *p.PassScore / 100
+			p.ScaledPassingScore = &v
+		} else {
+			p.MasteryScore = p.PassScore
+		}
+	}
+	return p
+}
+
+// Элементы, которые пакет не может записать: их значения выдаёт LMS.
+var scormReadOnly = map[string]map[string]bool{
+	scormV12: {
+		"cmi.core.student_id": true, "cmi.core.student_name": true, "cmi.core.credit": true,
+		"cmi.core.entry": true, "cmi.core.total_time": true, "cmi.core.lesson_mode": true,
+		"cmi.launch_data": true, "cmi.comments_from_lms": true,
+		"cmi.student_data.mastery_score": true, "cmi.student_data.max_time_allowed": true,
+		"cmi.student_data.time_limit_action": true,
+	},
+	scorm2004: {
+		"cmi._version": true, "cmi.learner_id": true, "cmi.learner_name": true, "cmi.credit": true,
+		"cmi.entry": true, "cmi.mode": true, "cmi.total_time": true, "cmi.launch_data": true,
+		"cmi.completion_threshold": true, "cmi.scaled_passing_score": true,
+		"cmi.max_time_allowed": true, "cmi.time_limit_action": true,
+	},
+}
+
+// Элементы только для записи: их не храним, а учитываем при сохранении (время сеанса, выход).
+var scormWriteOnly = map[string]map[string]bool{
+	scormV12:  {"cmi.core.exit": true, "cmi.core.session_time": true},
+	scorm2004: {"cmi.exit": true, "cmi.session_time": true},
+}
+
+const (
+	scormMaxKeys     = 10000
+	scormMaxValueLen = 64000
+)
+
+func scormStoredCMI(a *ScormAttempt) map[string]string {
+	cmi := map[string]string{}
+	if a != nil && len(a.CMI) > 0 {
+		_ = json.Unmarshal(a.CMI, &cmi)
+	}
+	return cmi
+}
+
+// scormRuntimeState — всё, что получает JS-мост при запуске: записанное пакетом + значения LMS.
+func scormRuntimeState(u *User, p scormBlockPayload, a *ScormAttempt) map[string]string {
+	cmi := scormStoredCMI(a)
+	name := u.FullName
+	if name == "" {
+		name = u.Email
+	}
+	entry := "ab-initio"
+	if a != nil {
+		entry = a.Entry
+	}
+	var total int64
+	if a != nil {
+		total = a.TotalTime
+	}
+
+	if p.Version == scorm2004 {
+		cmi["cmi._version"] = "1.0"
+		cmi["cmi.learner_id"] = strconv.Itoa(int(u.ID))
+		cmi["cmi.learner_name"] = name
+		cmi["cmi.credit"] = "credit"
+		cmi["cmi.mode"] = "normal"
+		cmi["cmi.entry"] = entry
+		cmi["cmi.total_time"] = formatISODuration(total)
+		cmi["cmi.launch_data"] = p.LaunchData
+		if p.ScaledPassingScore != nil {
+			cmi["cmi.scaled_passing_score"] = strconv.FormatFloat(*p.ScaledPassingScore, 'f', -1, 64)
+		}
+		if p.CompletionThresh != nil {
+			cmi["cmi.completion_threshold"] = strconv.FormatFloat(*p.CompletionThresh, 'f', -1, 64)
+		}
+		if cmi["cmi.completion_status"] == "" {
+			cmi["cmi.completion_status"] = "unknown"
+		}
+		if cmi["cmi.success_status"] == "" {
+			cmi["cmi.success_status"] = "unknown"
+		}
+		return cmi
+	}
+
+	cmi["cmi.core.student_id"] = strconv.Itoa(int(u.ID))
+	cmi["cmi.core.student_name"] = name
+	cmi["cmi.core.credit"] = "credit"
+	cmi["cmi.core.lesson_mode"] = "normal"
+	cmi["cmi.core.entry"] = entry
+	cmi["cmi.core.total_time"] = formatCMITimespan(total)
+	cmi["cmi.launch_data"] = p.LaunchData
+	if p.MasteryScore != nil {
+		cmi["cmi.student_data.mastery_score"] = strconv.FormatFloat(*p.MasteryScore, 'f', -1, 64)
+	}
+	if a != nil && a.Status != "" {
+		cmi["cmi.core.lesson_status"] = a.Status
+	} else {
+		cmi["cmi.core.lesson_status"] = "not attempted"
+	}
+	return cmi
+}
+
+// scormCommit сохраняет данные, присланные JS-мостом (Commit/Finish), и пересчитывает итог:
+// статус, балл в процентах, завершённость и общее время. finish — конец сеанса.
+func scormCommit(u *User, blk *Block, values map[string]string, finish bool) (*ScormAttempt, error) {
+	p := scormPayload(blk)
+
+	a := ScormAttempt{UserID: u.ID, BlockID: blk.ID, Entry: "ab-initio", CMI: datatypes.JSON("{}")}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&a).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("user_id = ? AND block_id = ?", u.ID, blk.ID).First(&a).Error; err != nil {
+		return nil, err
+	}
+	wasCompleted := a.Completed
+	prevScore := a.Score
+
+	cmi := scormStoredCMI(&a)
+	readOnly, writeOnly := scormReadOnly[p.Version], scormWriteOnly[p.Version]
+	for k, v := range values {
+		if !strings.HasPrefix(k, "cmi.") || readOnly[k] || writeOnly[k] ||
+			strings.Contains(k, "._count") || strings.Contains(k, "._children") ||
+			len(k) > 255 || len(v) > scormMaxValueLen {
+			continue
+		}
+		if _, exists := cmi[k]; !exists && len(cmi) >= scormMaxKeys {
+			continue
+		}
+		cmi[k] = v
+	}
+
+	if p.Version == scorm2004 {
+		scormDerive2004(&a, cmi, p)
+	} else {
+		scormDerive12(&a, cmi, p)
+	}
+
+	if finish {
+		a.Sessions++
+		var exit, session string
+		if p.Version == scorm2004 {
+			exit, session = values["cmi.exit"], values["cmi.session_time"]
+			a.TotalTime += parseISODuration(session)
+		} else {
+			exit, session = values["cmi.core.exit"], values["cmi.core.session_time"]
+			a.TotalTime += parseCMITimespan(session)
+		}
+		// suspend — продолжить с сохранённого места; иначе следующий сеанс начинается заново
+		if exit == "suspend" {
+			a.Entry = "resume"
+		} else {
+			a.Entry = ""
+		}
+	}
+
+	raw, err := json.Marshal(cmi)
+	if err != nil {
+		return nil, err
+	}
+	a.CMI = raw
+	if a.Completed && a.CompletedAt == nil {
+		now := time.Now()
+		a.CompletedAt = &now
+	}
+	if err := db.Save(&a).Error; err != nil {
+		return nil, err
+	}
+
+	if a.Completed && !wasCompleted {
+		checkCourseCompletion(u, blockCourseID(blk))
+	}
+	if (a.Completed && !wasCompleted) || !sameScore(prevScore, a.Score) {
+		ltiQueueScores(u, blk)
+	}
+	return &a, nil
+}
+
+// ScoreLabel — балл для шаблонов: "85%" или "" (пакет балл не сообщал).
+func (a ScormAttempt) ScoreLabel() string {
+	if a.Score == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*a.Score, 'f', 0, 64) + "%"
+}
+
+func sameScore(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func scormDerive12(a *ScormAttempt, cmi map[string]string, p scormBlockPayload) {
+	status := cmi["cmi.core.lesson_status"]
+	raw := parseOptFloat(cmi["cmi.core.score.raw"])
+	// есть проходной балл — итог определяет LMS (SCORM 1.2 RTE 3.4.4)
+	if p.MasteryScore != nil && raw != nil && status != "browsed" {
+		if *raw >= *p.MasteryScore {
+			status = "passed"
+		} else {
+			status = "failed"
+		}
+		cmi["cmi.core.lesson_status"] = status
+	}
+	if status == "" {
+		status = "incomplete"
+	}
+	a.Status = status
+	a.Completed = status == "completed" || status == "passed"
+	switch status {
+	case "passed", "failed":
+		a.SuccessStatus = status
+	default:
+		a.SuccessStatus = "unknown"
+	}
+	if raw != nil {
+		a.Score = scormPercent(*raw, parseOptFloat(cmi["cmi.core.score.min"]), parseOptFloat(cmi["cmi.core.score.max"]))
+	}
+}
+
+func scormDerive2004(a *ScormAttempt, cmi map[string]string, p scormBlockPayload) {
+	completion := cmi["cmi.completion_status"]
+	success := cmi["cmi.success_status"]
+	if pm := parseOptFloat(cmi["cmi.progress_measure"]); pm != nil && p.CompletionThresh != nil {
+		if *pm >= *p.CompletionThresh {
+			completion = "completed"
+		} else {
+			completion = "incomplete"
+		}
+	}
+	scaled := parseOptFloat(cmi["cmi.score.scaled"])
+	if scaled != nil && p.ScaledPassingScore != nil {
+		if *scaled >= *p.ScaledPassingScore {
+			success = "passed"
+		} else {
+			success = "failed"
+		}
+	}
+	if completion == "" {
+		completion = "incomplete"
+	}
+	if success == "" {
+		success = "unknown"
+	}
+	a.Status = completion
+	a.SuccessStatus = success
+	a.Completed = completion == "completed"
+
+	switch {
+	case scaled != nil:
+		v := math.Max(0, math.Min(100, *scaled*100))
+		a.Score = &v
+	default:
+		if raw := parseOptFloat(cmi["cmi.score.raw"]); raw != nil {
+			a.Score = scormPercent(*raw, parseOptFloat(cmi["cmi.score.min"]), parseOptFloat(cmi["cmi.score.max"]))
+		}
+	}
+}
+
+// scormPercent — балл в процентах; без max считаем, что raw уже 0..100.
+func scormPercent(raw float64, min, max *float64) *float64 {
+	v := raw
+	if max != nil && *max > 0 {
+		lo := 0.0
+		if min != nil && *min < *max {
+			lo = *min
+		}
+		v = (raw - lo) * 100 / (*max - lo)
+	}
+	v = math.Max(0, math.Min(100, v))
+	return &v
+}
+
+///////////////////////////////////////////////////////
+// ВРЕМЯ
+///////////////////////////////////////////////////////
+
+// parseCMITimespan — SCORM 1.2 CMITimespan "HHHH:MM:SS.SS" → секунды.
+func parseCMITimespan(s string) int64 {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil || h < 0 || m < 0 || sec < 0 {
+		return 0
+	}
+	return int64(h)*3600 + int64(m)*60 + int64(sec)
+}
+
+func formatCMITimespan(sec int64) string {
+	return fmt.Sprintf("%04d:%02d:%02d", sec/3600, sec%3600/60, sec%60)
+}
+
+var isoDurationRe = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration — SCORM 2004 timeinterval (ISO 8601, "PT1H2M3.5S") → секунды.
+// Годы и месяцы считаются как 365 и 30 дней.
+func parseISODuration(s string) int64 {
+	m := isoDurationRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0
+	}
+	num := func(i int) float64 {
+		v, _ := strconv.ParseFloat(m[i], 64)
+		return v
+	}
+	return int64(num(1)*365*86400 + num(2)*30*86400 + num(3)*86400 + num(4)*3600 + num(5)*60 + num(6))
+}
+
+func formatISODuration(sec int64) string {
+	return fmt.Sprintf("PT%dH%dM%dS", sec/3600, sec%3600/60, sec%60)
+}
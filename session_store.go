@@ -0,0 +1,366 @@
+// session_store.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+const sessionCookieName = "trainbrain_session"
+
+// sessionRotateKey — служебный флаг в сессии: при сохранении выдать новый ID (защита от фиксации сессии).
+const sessionRotateKey = "_rotate"
+
+type ctxKey string
+
+const clientIPCtxKey ctxKey = "client_ip"
+
+// sessionStoreIsServerSide — сессии лежат в Postgres (можно отзывать); false — старый cookie-store.
+var sessionStoreIsServerSide bool
+
+// pgSessionStore — gorilla/sessions Store, который держит данные в таблице user_sessions,
+// а в cookie кладёт только подписанный ID.
+type pgSessionStore struct {
+	Codecs   []securecookie.Codec
+	options  *gsessions.Options
+	idle     time.Duration
+	absolute time.Duration
+}
+
+func newPGSessionStore(secret []byte, idle, absolute time.Duration) *pgSessionStore {
+	return &pgSessionStore{
+		Codecs: securecookie.CodecsFromPairs(secret),
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   int(absolute / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		idle:     idle,
+		absolute: absolute,
+	}
+}
+
+func (s *pgSessionStore) Options(o sessions.Options) {
+	s.options = o.ToGorillaOptions()
+}
+
+func (s *pgSessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New загружает сессию по cookie. Битая подпись, отозванная или истёкшая сессия —
+// не ошибка, а просто новая пустая сессия.
+func (s *pgSessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	sess := gsessions.NewSession(s, name)
+	opts := *s.options
+	sess.Options = &opts
+	sess.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return sess, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.Codecs...); err != nil {
+		return sess, nil
+	}
+
+	var row UserSession
+	if err := db.First(&row, "id = ?", id).Error; err != nil {
+		return sess, nil
+	}
+
+	now := time.Now()
+	if now.After(row.ExpiresAt) || now.Sub(row.LastSeenAt) > s.idle {
+		db.Delete(&row)
+		return sess, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(row.Data)).Decode(&sess.Values); err != nil {
+		log.Printf("session %s: decode: %v\n", id, err)
+		return sess, nil
+	}
+	sess.ID = id
+	sess.IsNew = false
+
+	// last_seen обновляем не чаще раза в минуту, чтобы не писать в БД на каждый запрос
+	if now.Sub(row.LastSeenAt) > time.Minute {
+		db.Model(&UserSession{}).Where("id = ?", id).Updates(map[string]any{
+			"last_seen_at": now,
+			"ip":           requestIP(r),
+			"user_agent":   clampUA(r.UserAgent()),
+		})
+	}
+	return sess, nil
+}
+
+func (s *pgSessionStore) Save(r *http.Request, w http.ResponseWriter, sess *gsessions.Session) error {
+	// MaxAge < 0 — удалить сессию (выход)
+	if sess.Options.MaxAge < 0 {
+		if sess.ID != "" {
+			db.Delete(&UserSession{}, "id = ?", sess.ID)
+		}
+		http.SetCookie(w, gsessions.NewCookie(sess.Name(), "", sess.Options))
+		return nil
+	}
+
+	if _, ok := sess.Values[sessionRotateKey]; ok {
+		delete(sess.Values, sessionRotateKey)
+		if sess.ID != "" {
+			db.Delete(&UserSession{}, "id = ?", sess.ID)
+		}
+		sess.ID = ""
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sess.Values); err != nil {
+		return err
+	}
+
+	var userID *uint
+	if id, ok := sessionUint(sess.Values["user_id"]); ok {
+		userID = &id
+	}
+
+	now := time.Now()
+	if sess.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		row := UserSession{
+			ID:         id,
+			UserID:     userID,
+			Data:       buf.Bytes(),
+			IP:         requestIP(r),
+			UserAgent:  clampUA(r.UserAgent()),
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(s.absolute),
+		}
+		if err := db.Create(&row).Error; err != nil {
+			return err
+		}
+		sess.ID = id
+	} else {
+		res := db.Model(&UserSession{}).Where("id = ?", sess.ID).Updates(map[string]any{
+			"user_id":      userID,
+			"data":         buf.Bytes(),
+			"last_seen_at": now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// сессию отозвали, пока шёл запрос — не воскрешаем её
+			http.SetCookie(w, gsessions.NewCookie(sess.Name(), "", &gsessions.Options{Path: "/", MaxAge: -1}))
+			return nil
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(sess.Name(), sess.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(sess.Name(), encoded, sess.Options))
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
+
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPCtxKey).(string); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+func clampUA(ua string) string {
+	if len(ua) > 255 {
+		return ua[:255]
+	}
+	return ua
+}
+
+// clientIPMiddleware кладёт c.ClientIP() (X-Forwarded-For — только от TRUSTED_PROXIES) в контекст запроса,
+// чтобы store, у которого есть только *http.Request, видел реальный IP.
+func clientIPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPCtxKey, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// ---------- секрет и выбор хранилища ----------
+
+func isProduction() bool {
+	return envOr("APP_ENV", "development") == "production"
+}
+
+// sessionSecret: в production без нормального SESSION_SECRET не стартуем,
+// в разработке — генерируем случайный (сессии живут до перезапуска).
+func sessionSecret() []byte {
+	secret := os.Getenv("SESSION_SECRET")
+	weak := secret == "" || secret == "supersecretkey" || len(secret) < 32
+
+	if weak && isProduction() {
+		log.Fatalf("SESSION_SECRET не задан или слишком простой (нужно ≥32 символов) — в production запуск невозможен")
+	}
+	if secret == "" {
+		log.Println("SESSION_SECRET не задан — сгенерирован случайный, сессии не переживут перезапуск")
+		return securecookie.GenerateRandomKey(64)
+	}
+	if weak {
+		log.Println("ВНИМАНИЕ: SESSION_SECRET слишком простой, в production он будет отвергнут")
+	}
+	return []byte(secret)
+}
+
+func newSessionStore() sessions.Store {
+	secret := sessionSecret()
+
+	var store sessions.Store
+	maxAge := 30 * 24 * time.Hour // как у cookie-store по умолчанию
+	switch envOr("SESSION_STORE", "postgres") {
+	case "postgres":
+		idle := envDuration("SESSION_IDLE_TIMEOUT", 2*time.Hour)
+		absolute := envDuration("SESSION_ABSOLUTE_TIMEOUT", 24*time.Hour)
+		sessionStoreIsServerSide = true
+		go cleanupSessions(idle)
+		store, maxAge = newPGSessionStore(secret, idle, absolute), absolute
+	case "cookie":
+		store = cookie.NewStore(secret)
+	default:
+		log.Fatalf("SESSION_STORE: неизвестное значение %q (postgres|cookie)", os.Getenv("SESSION_STORE"))
+		return nil
+	}
+
+	// SESSION_SAMESITE=none — для курсов, открытых во фрейме LMS (LTI): иначе браузер
+	// не отправит cookie сессии из чужого сайта. Требует HTTPS (флаг Secure).
+	switch envOr("SESSION_SAMESITE", "lax") {
+	case "lax":
+	case "none":
+		store.Options(sessions.Options{
+			Path:     "/",
+			MaxAge:   int(maxAge / time.Second),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		})
+	default:
+		log.Fatalf("SESSION_SAMESITE: неизвестное значение %q (lax|none)", os.Getenv("SESSION_SAMESITE"))
+	}
+	return store
+}
+
+// cleanupSessions периодически удаляет истёкшие сессии.
+func cleanupSessions(idle time.Duration) {
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		res := db.Where("expires_at < ? OR last_seen_at < ?", now, now.Add(-idle)).Delete(&UserSession{})
+		if res.Error != nil {
+			log.Printf("session cleanup: %v\n", res.Error)
+		}
+	}
+}
+
+// ---------- операции над сессиями ----------
+
+// rotateSession — выдать текущей сессии новый ID при следующем Save (вход, смена прав).
+func rotateSession(c *gin.Context) {
+	if !sessionStoreIsServerSide {
+		return
+	}
+	sessions.Default(c).Set(sessionRotateKey, true)
+}
+
+// revokeUserSessions завершает все сессии пользователя, кроме exceptID (пусто — все).
+func revokeUserSessions(userID uint, exceptID string) (int64, error) {
+	q := db.Where("user_id = ?", userID)
+	if exceptID != "" {
+		q = q.Where("id <> ?", exceptID)
+	}
+	res := q.Delete(&UserSession{})
+	return res.RowsAffected, res.Error
+}
+
+// revokeUserSession завершает одну сессию пользователя.
+func revokeUserSession(userID uint, sessionID string) error {
+	res := db.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&UserSession{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("сессия не найдена")
+	}
+	return nil
+}
+
+// logoutSession — удалить текущую сессию целиком (и строку в БД, и cookie).
+func logoutSession(c *gin.Context) {
+	sess := sessions.Default(c)
+	sess.Clear()
+	sess.Options(sessions.Options{Path: "/", MaxAge: -1})
+	_ = sess.Save()
+}
+
+// deviceLabel — грубое «Chrome · Windows» из User-Agent для списка сессий.
+func deviceLabel(ua string) string {
+	browser := "Браузер"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case ua == "":
+		browser = "Неизвестно"
+	}
+
+	osName := ""
+	switch {
+	case strings.Contains(ua, "Windows"):
+		osName = "Windows"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		osName = "iOS"
+	case strings.Contains(ua, "Mac OS X"):
+		osName = "macOS"
+	case strings.Contains(ua, "Android"):
+		osName = "Android"
+	case strings.Contains(ua, "Linux"):
+		osName = "Linux"
+	}
+	if osName == "" {
+		return browser
+	}
+	return browser + " · " + osName
+}
@@ -0,0 +1,173 @@
+// scorm_api.js — Runtime API SCORM 1.2 (window.API) и 2004 (window.API_1484_11) внутри пакета.
+// Сервер подставляет этот скрипт в каждую HTML-страницу пакета (routes_scorm.go) с состоянием
+// пользователя в data-state. Пакет открыт в песочнице без allow-same-origin и окно плеера не видит,
+// поэтому изменённые значения, Commit и Finish уходят плееру через postMessage — на сервер их
+// отправляет он.
+(function () {
+  const script = document.currentScript;
+  const v2004 = script.dataset.version === '2004';
+  const data = JSON.parse(script.dataset.state || '{}');
+
+  let initialized = false, terminated = false, lastError = '0';
+
+  // у песочницы origin «null», у плеера — сайт; плеер принимает сообщения только от своего фрейма
+  function post(msg) {
+    if (window.parent !== window) window.parent.postMessage(msg, '*');
+  }
+
+  const readOnly12 = /^cmi\.(core\.(student_id|student_name|credit|entry|total_time|lesson_mode)|launch_data|comments_from_lms|student_data\.(mastery_score|max_time_allowed|time_limit_action))$/;
+  const writeOnly12 = /^cmi\.(core\.(exit|session_time)|interactions\.\d+\.(id|time|type|weighting|student_response|result|latency|objectives\.\d+\.id|correct_responses\.\d+\.pattern))$/;
+  const readOnly2004 = /^cmi\.(_version|learner_id|learner_name|credit|entry|mode|total_time|launch_data|completion_threshold|scaled_passing_score|max_time_allowed|time_limit_action)$/;
+  const writeOnly2004 = /^cmi\.(exit|session_time)$/;
+
+  const children = v2004 ? {
+    'cmi.score': 'scaled,raw,min,max',
+    'cmi.objectives': 'id,score,success_status,completion_status,progress_measure,description',
+    'cmi.interactions': 'id,type,objectives,timestamp,correct_responses,weighting,learner_response,result,latency,description',
+    'cmi.comments_from_learner': 'comment,location,timestamp',
+    'cmi.learner_preference': 'audio_level,language,delivery_speed,audio_captioning'
+  } : {
+    'cmi.core': 'student_id,student_name,lesson_location,credit,lesson_status,entry,score,total_time,lesson_mode,exit,session_time',
+    'cmi.core.score': 'raw,min,max',
+    'cmi.objectives': 'id,score,status',
+    'cmi.student_data': 'mastery_score,max_time_allowed,time_limit_action',
+    'cmi.student_preference': 'audio,language,speed,text',
+    'cmi.interactions': 'id,objectives,time,type,correct_responses,weighting,student_response,result,latency'
+  };
+
+  const errors = v2004 ? {
+    '0': 'No Error', '101': 'General Exception', '103': 'Already Initialized',
+    '104': 'Content Instance Terminated', '112': 'Termination Before Initialization',
+    '113': 'Termination After Termination', '122': 'Retrieve Data Before Initialization',
+    '123': 'Retrieve Data After Termination', '132': 'Store Data Before Initialization',
+    '133': 'Store Data After Termination', '142': 'Commit Before Initialization',
+    '143': 'Commit After Termination', '201': 'General Argument Error',
+    '301': 'General Get Failure', '351': 'General Set Failure', '391': 'General Commit Failure',
+    '401': 'Undefined Data Model Element', '403': 'Data Model Element Value Not Initialized',
+    '404': 'Data Model Element Is Read Only', '405': 'Data Model Element Is Write Only'
+  } : {
+    '0': 'No error', '101': 'General exception', '201': 'Invalid argument error',
+    '202': 'Element cannot have children', '203': 'Element not an array. Cannot have count.',
+    '301': 'Not initialized', '401': 'Not implemented error',
+    '402': 'Invalid set value, element is a keyword', '403': 'Element is read only',
+    '404': 'Element is write only', '405': 'Incorrect Data Type'
+  };
+
+  // _count для массивов: максимальный индекс среди сохранённых ключей + 1
+  function count(prefix) {
+    let n = 0;
+    const re = new RegExp('^' + prefix.replace(/\./g, '\\.') + '\\.(\\d+)\\.');
+    Object.keys(data).forEach(function (k) {
+      const m = k.match(re);
+      if (m) n = Math.max(n, Number(m[1]) + 1);
+    });
+    return String(n);
+  }
+
+  function getValue(name) {
+    if (!initialized || terminated) {
+      lastError = v2004 ? (terminated ? '123' : '122') : '301';
+      return '';
+    }
+    name = String(name || '');
+    if (name === '') { lastError = '201'; return ''; }
+    if (name.endsWith('._children')) {
+      const base = name.slice(0, -10);
+      if (children[base]) { lastError = '0'; return children[base]; }
+      lastError = v2004 ? '301' : '202'; return '';
+    }
+    if (name.endsWith('._count')) {
+      lastError = '0'; return count(name.slice(0, -7));
+    }
+    if (/\._version$/.test(name) && !v2004) { lastError = '0'; return '3.4'; }
+    if ((v2004 ? writeOnly2004 : writeOnly12).test(name)) {
+      lastError = v2004 ? '405' : '404'; return '';
+    }
+    if (!(name in data)) {
+      // 1.2: неизвестный, но допустимый элемент — пустая строка без ошибки
+      lastError = v2004 ? (name.startsWith('cmi.') ? '403' : '401') : (name.startsWith('cmi.') ? '0' : '401');
+      return '';
+    }
+    lastError = '0';
+    return data[name];
+  }
+
+  function setValue(name, value) {
+    if (!initialized || terminated) {
+      lastError = v2004 ? (terminated ? '133' : '132') : '301';
+      return 'false';
+    }
+    name = String(name || '');
+    value = value === undefined || value === null ? '' : String(value);
+    if (!name.startsWith('cmi.')) { lastError = v2004 ? '401' : '201'; return 'false'; }
+    if (/\._(children|count|version)$/.test(name)) {
+      lastError = v2004 ? '404' : '402'; return 'false';
+    }
+    if ((v2004 ? readOnly2004 : readOnly12).test(name)) {
+      lastError = v2004 ? '404' : '403'; return 'false';
+    }
+    data[name] = value;
+    post({ scorm: 'set', name: name, value: value });
+    lastError = '0';
+    return 'true';
+  }
+
+  function initialize(arg) {
+    if (String(arg || '') !== '') { lastError = '201'; return 'false'; }
+    if (terminated) { lastError = v2004 ? '104' : '101'; return 'false'; }
+    if (initialized) { lastError = v2004 ? '103' : '101'; return 'false'; }
+    initialized = true; lastError = '0';
+    post({ scorm: 'initialize' });
+    return 'true';
+  }
+
+  function commit(arg) {
+    if (String(arg || '') !== '') { lastError = '201'; return 'false'; }
+    if (!initialized || terminated) {
+      lastError = v2004 ? (terminated ? '143' : '142') : '301';
+      return 'false';
+    }
+    post({ scorm: 'commit' });
+    lastError = '0';
+    return 'true';
+  }
+
+  function terminate(arg) {
+    if (String(arg || '') !== '') { lastError = '201'; return 'false'; }
+    if (!initialized) { lastError = v2004 ? '112' : '301'; return 'false'; }
+    if (terminated) { lastError = v2004 ? '113' : '101'; return 'false'; }
+    post({ scorm: 'finish' });
+    terminated = true; lastError = '0';
+    return 'true';
+  }
+
+  function errorString(code) { return errors[String(code)] || ''; }
+  function diagnostic(code) { return errorString(code === '' || code === undefined ? lastError : code); }
+
+  window.API = {
+    LMSInitialize: initialize, LMSFinish: terminate,
+    LMSGetValue: getValue, LMSSetValue: setValue, LMSCommit: commit,
+    LMSGetLastError: function () { return lastError; },
+    LMSGetErrorString: errorString, LMSGetDiagnostic: diagnostic
+  };
+  window.API_1484_11 = {
+    Initialize: initialize, Terminate: terminate,
+    GetValue: getValue, SetValue: setValue, Commit: commit,
+    GetLastError: function () { return lastError; },
+    GetErrorString: errorString, GetDiagnostic: diagnostic
+  };
+
+  // вложенные фреймы пакета (frameset) передают сообщения плееру через эту страницу
+  window.addEventListener('message', function (e) {
+    if (e.source && e.source !== window.parent && e.data && typeof e.data.scorm === 'string') {
+      post(e.data);
+    }
+  });
+
+  // страницу закрыли, не вызвав Finish/Terminate — плеер сохранит то, что есть
+  window.addEventListener('pagehide', function () {
+    if (initialized && !terminated) {
+      terminate('');
+    }
+  });
+})();
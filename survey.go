@@ -0,0 +1,326 @@
+// survey.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Опросы и голосования (payload — blocks.go). Ответ не оценивается и в прогрессе не считается.
+// Ученик отвечает один раз: факт участия — SurveyParticipant, сам ответ — SurveyResponse.
+// У анонимного опроса ответ хранится без пользователя, с датой без времени и случайным ID,
+// так что по базе его не связать с участником. Итоги видит админка (/admin/blocks/:id/survey,
+// там же выгрузка CSV), ученик — после ответа, если у блока включено show_results.
+
+const surveyTextMaxLen = 5000
+
+var errSurveyAnswered = errors.New("на опрос уже есть ответ")
+
+func newSurveyResponseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// surveyOf — payload опроса или голосования в виде опроса; nil для блока другого типа.
+func surveyOf(blk *Block) *surveyPayload {
+	if p, ok := blk.Data.(surveyBlockPayload); ok {
+		return p.survey()
+	}
+	return nil
+}
+
+func isSurveyType(typ string) bool {
+	return typ == surveyBlock.Type || typ == pollBlock.Type
+}
+
+///////////////////////////////////////////////////////
+// ОТВЕТ
+///////////////////////////////////////////////////////
+
+// surveyAnswers собирает ответ из формы плеера: a_<id вопроса> — вариант, балл или текст,
+// у вопроса с несколькими вариантами — по полю на каждый отмеченный. Ответ сверяется с вопросами:
+// варианты — только из списка, балл — в пределах шкалы, обязательные вопросы — не пустые.
+func surveyAnswers(p *surveyPayload, form func(key string) []string) (map[string]any, error) {
+	answers := map[string]any{}
+	for i := range p.Questions {
+		q := &p.Questions[i]
+		var vals []string
+		for _, v := range form("a_" + q.ID) {
+			if v = strings.TrimSpace(v); v != "" {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) == 0 {
+			if q.Required {
+				return nil, fmt.Errorf("ответьте на вопрос «%s»", q.Text)
+			}
+			continue
+		}
+		switch q.Kind {
+		case "single":
+			if len(vals) > 1 || !slices.Contains(q.Options, vals[0]) {
+				return nil, fmt.Errorf("вопрос «%s»: выберите один из вариантов", q.Text)
+			}
+			answers[q.ID] = vals[0]
+		case "multi":
+			for _, v := range vals {
+				if !slices.Contains(q.Options, v) {
+					return nil, fmt.Errorf("вопрос «%s»: выберите варианты из списка", q.Text)
+				}
+			}
+			var picked []string
+			for _, o := range q.Options { // в порядке вариантов, без повторов
+				if slices.Contains(vals, o) {
+					picked = append(picked, o)
+				}
+			}
+			answers[q.ID] = picked
+		case "likert":
+			v, err := strconv.Atoi(vals[0])
+			if err != nil || len(vals) > 1 || !slices.Contains(q.Points(), v) {
+				return nil, fmt.Errorf("вопрос «%s»: оценка вне шкалы", q.Text)
+			}
+			answers[q.ID] = v
+		case "text":
+			text := strings.Join(vals, "\n")
+			if len([]rune(text)) > surveyTextMaxLen {
+				return nil, fmt.Errorf("вопрос «%s»: ответ длиннее %d символов", q.Text, surveyTextMaxLen)
+			}
+			answers[q.ID] = text
+		}
+	}
+	if len(answers) == 0 {
+		return nil, errors.New("ответ пустой")
+	}
+	return answers, nil
+}
+
+// saveSurveyResponse записывает участие и ответ в одной транзакции; второй ответ — errSurveyAnswered.
+func saveSurveyResponse(user *User, blk *Block, p *surveyPayload, answers map[string]any, now time.Time) error {
+	raw, err := json.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	id, err := newSurveyResponseID()
+	if err != nil {
+		return err
+	}
+	resp := SurveyResponse{ID: id, BlockID: blk.ID, Answers: datatypes.JSON(raw), CreatedAt: now}
+	if p.Anonymous {
+		resp.CreatedAt = startOfDay(now)
+	} else {
+		resp.UserID = &user.ID
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&SurveyParticipant{BlockID: blk.ID, UserID: user.ID})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSurveyAnswered
+		}
+		return tx.Create(&resp).Error
+	})
+}
+
+func surveyResponded(userID, blockID uint) bool {
+	return blockCompleted(&SurveyParticipant{}, "", userID, blockID)
+}
+
+///////////////////////////////////////////////////////
+// ИТОГИ
+///////////////////////////////////////////////////////
+
+type surveyOptionResult struct {
+	Label   string
+	Count   int
+	Percent float64 // от ответивших на вопрос
+}
+
+type surveyQuestionResult struct {
+	Question surveyQuestion
+	Answered int
+	Options  []surveyOptionResult // выбор — по вариантам, шкала — по баллам
+	Average  float64              // шкала
+	Texts    []string             // свободные ответы; ученику не показываются
+}
+
+type surveyResults struct {
+	Responses int
+	Questions []surveyQuestionResult
+}
+
+// surveyView — опрос глазами ученика (для плеера курса).
+type surveyView struct {
+	Form      *surveyPayload // вопросы; у голосования — один
+	Responded bool
+	Results   *surveyResults // итоги, если ученик ответил и у блока включено show_results
+}
+
+func loadSurveyView(blk *Block, user *User) error {
+	p := surveyOf(blk)
+	v := &surveyView{Form: p}
+	blk.Survey = v
+	if user == nil {
+		return nil
+	}
+	v.Responded = surveyResponded(user.ID, blk.ID)
+	if !v.Responded || !p.ShowResults {
+		return nil
+	}
+	responses, err := loadSurveyResponses(blk.ID)
+	if err != nil {
+		return err
+	}
+	v.Results = aggregateSurvey(p, responses, false)
+	return nil
+}
+
+// loadSurveyResponses — ответы по блоку: сначала новые, анонимные — в порядке случайных ID.
+func loadSurveyResponses(blockID uint) ([]SurveyResponse, error) {
+	var rows []SurveyResponse
+	err := db.Preload("User").Where("block_id = ?", blockID).Order("created_at desc, id").Find(&rows).Error
+	return rows, err
+}
+
+// decodeSurveyAnswers — ответ по id вопроса; список вариантов приходит из JSON как []any.
+func decodeSurveyAnswers(r *SurveyResponse) map[string]any {
+	m := map[string]any{}
+	if err := json.Unmarshal(r.Answers, &m); err != nil {
+		log.Printf("survey: ответ %s: %v\n", r.ID, err)
+	}
+	return m
+}
+
+// surveyAnswerStrings — ответ на вопрос строками: варианты, балл или текст.
+func surveyAnswerStrings(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case float64:
+		return []string{strconv.Itoa(int(x))}
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// aggregateSurvey считает итоги по вопросам. Варианты, которых уже нет в опросе (автор
+// их переименовал или удалил), в итоги не попадают. withTexts — со свободными ответами.
+func aggregateSurvey(p *surveyPayload, responses []SurveyResponse, withTexts bool) *surveyResults {
+	res := &surveyResults{Responses: len(responses)}
+	answers := make([]map[string]any, len(responses))
+	for i := range responses {
+		answers[i] = decodeSurveyAnswers(&responses[i])
+	}
+	for _, q := range p.Questions {
+		qr := surveyQuestionResult{Question: q}
+		labels := q.Options
+		if q.Kind == "likert" {
+			labels = nil
+			for _, pt := range q.Points() {
+				labels = append(labels, strconv.Itoa(pt))
+			}
+		}
+		counts := map[string]int{}
+		sum := 0
+		for _, a := range answers {
+			vals := surveyAnswerStrings(a[q.ID])
+			if len(vals) == 0 {
+				continue
+			}
+			qr.Answered++
+			if q.Kind == "text" {
+				if withTexts {
+					qr.Texts = append(qr.Texts, vals[0])
+				}
+				continue
+			}
+			for _, v := range vals {
+				counts[v]++
+				if n, err := strconv.Atoi(v); err == nil && q.Kind == "likert" {
+					sum += n
+				}
+			}
+		}
+		for _, l := range labels {
+			o := surveyOptionResult{Label: l, Count: counts[l]}
+			if qr.Answered > 0 {
+				o.Percent = float64(o.Count) * 100 / float64(qr.Answered)
+			}
+			qr.Options = append(qr.Options, o)
+		}
+		if q.Kind == "likert" && qr.Answered > 0 {
+			qr.Average = float64(sum) / float64(qr.Answered)
+		}
+		res.Questions = append(res.Questions, qr)
+	}
+	return res
+}
+
+///////////////////////////////////////////////////////
+// ФОРМА В ПЛЕЕРЕ
+///////////////////////////////////////////////////////
+
+func registerSurveyRoutes(r *gin.Engine) {
+	r.POST("/blocks/:block_id/survey", authRequired(), surveySubmitHandler)
+}
+
+func surveySubmitHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Некорректный ID блока")
+		return
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || !isSurveyType(blk.Type) {
+		c.String(http.StatusNotFound, "Опрос не найден")
+		return
+	}
+	decodeBlockData(blk)
+	target := "/courses/" + strconv.Itoa(int(blk.Module.CourseID)) + "#block-" + strconv.Itoa(int(blk.ID))
+
+	p := surveyOf(blk)
+	answers, err := surveyAnswers(p, c.PostFormArray)
+	if err != nil {
+		setFlash(c, "warning", "Ответ не сохранён: "+err.Error())
+		c.Redirect(http.StatusFound, target)
+		return
+	}
+	switch err := saveSurveyResponse(user, blk, p, answers, time.Now()); {
+	case errors.Is(err, errSurveyAnswered):
+		setFlash(c, "warning", "Вы уже ответили на этот опрос.")
+	case err != nil:
+		log.Printf("survey: ответ пользователя %d на блок %d: %v\n", user.ID, blk.ID, err)
+		c.String(http.StatusInternalServerError, "Ошибка сохранения ответа")
+		return
+	default:
+		setFlash(c, "success", "Спасибо, ответ сохранён.")
+	}
+	c.Redirect(http.StatusFound, target)
+}
@@ -0,0 +1,708 @@
+// texmath.go
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LaTeX → MathML на сервере, без CDN и JS: браузеры показывают MathML сами.
+// Поддерживается то, что встречается в учебных формулах: индексы и степени, \frac, \sqrt,
+// греческие буквы и операторы, функции (\sin, \lim…), \left…\right, \text, \mathbb/\mathbf/…,
+// акценты (\hat, \vec, \overline…) и окружения matrix/pmatrix/bmatrix/vmatrix/cases/aligned.
+// Неизвестная команда — ошибка с её именем: автор видит её в форме блока или вопроса.
+
+const (
+	texMaxLen   = 4000
+	texMaxDepth = 64
+)
+
+func texToMathML(tex string, display bool) (string, error) {
+	if utf8.RuneCountInString(tex) > texMaxLen {
+		return "", fmt.Errorf("формула длиннее %d символов", texMaxLen)
+	}
+	p := &texParser{s: []rune(tex), display: display}
+	body, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if !p.eof() {
+		switch {
+		case p.s[p.pos] == '}':
+			return "", fmt.Errorf("лишняя «}»")
+		case p.s[p.pos] == '&':
+			return "", fmt.Errorf("«&» вне окружения")
+		default:
+			return "", fmt.Errorf("\\%s без пары", p.peekCommand())
+		}
+	}
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return `<math display="` + mode + `"><semantics>` + texRow(body) +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(tex) + `</annotation></semantics></math>`, nil
+}
+
+type texParser struct {
+	s       []rune
+	pos     int
+	depth   int
+	display bool
+	variant string // \mathbb, \mathbf… для букв и цифр внутри аргумента
+}
+
+func (p *texParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *texParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+// peekCommand — имя команды в текущей позиции (после «\»): буквы или один символ.
+func (p *texParser) peekCommand() string {
+	i := p.pos + 1
+	if i >= len(p.s) {
+		return ""
+	}
+	if !isASCIILetter(p.s[i]) {
+		return string(p.s[i])
+	}
+	j := i
+	for j < len(p.s) && isASCIILetter(p.s[j]) {
+		j++
+	}
+	return string(p.s[i:j])
+}
+
+func (p *texParser) readCommand() string {
+	name := p.peekCommand()
+	p.pos += 1 + utf8.RuneCountInString(name)
+	return name
+}
+
+func isASCIILetter(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' }
+
+// parseList читает последовательность до «}», «&», «\\», \right, \end или конца — их не съедает.
+func (p *texParser) parseList() ([]string, error) {
+	var out []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return out, nil
+		}
+		switch c := p.s[p.pos]; c {
+		case '}', '&':
+			return out, nil
+		case '\\':
+			switch p.peekCommand() {
+			case "\\", "right", "end":
+				return out, nil
+			}
+		}
+		atom, limits, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if atom, err = p.parseScripts(atom, limits); err != nil {
+			return nil, err
+		}
+		if atom != "" {
+			out = append(out, atom)
+		}
+	}
+}
+
+// parseScripts — ^ и _ после основания (и штрихи f').
+func (p *texParser) parseScripts(base string, limits bool) (string, error) {
+	var sub, sup string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		c := p.s[p.pos]
+		if c == '\'' {
+			p.pos++
+			sup += "<mo>′</mo>"
+			continue
+		}
+		if c != '^' && c != '_' {
+			break
+		}
+		p.pos++
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", err
+		}
+		if c == '^' {
+			if sup != "" && !strings.HasPrefix(sup, "<mo>′") {
+				return "", fmt.Errorf("двойной верхний индекс")
+			}
+			sup += arg
+		} else {
+			if sub != "" {
+				return "", fmt.Errorf("двойной нижний индекс")
+			}
+			sub = arg
+		}
+	}
+	if sub == "" && sup == "" {
+		return base, nil
+	}
+	if base == "" {
+		base = "<mrow></mrow>"
+	}
+	if sup != "" {
+		sup = "<mrow>" + sup + "</mrow>"
+	}
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sup == "":
+		return "<" + under + ">" + base + sub + "</" + under + ">", nil
+	case sub == "":
+		return "<" + over + ">" + base + sup + "</" + over + ">", nil
+	}
+	return "<" + both + ">" + base + sub + sup + "</" + both + ">", nil
+}
+
+// parseArg — аргумент команды или индекса: {группа}, команда или один символ.
+func (p *texParser) parseArg() (string, error) {
+	p.skipSpace()
+	if p.eof() {
+		return "", fmt.Errorf("не хватает аргумента")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '{':
+		return p.parseGroup()
+	case c == '}' || c == '&' || c == '^' || c == '_':
+		return "", fmt.Errorf("не хватает аргумента перед «%c»", c)
+	case c >= '0' && c <= '9':
+		p.pos++
+		return "<mn>" + p.styled(c) + "</mn>", nil
+	}
+	atom, _, err := p.parseAtom()
+	return atom, err
+}
+
+func (p *texParser) parseGroup() (string, error) {
+	p.skipSpace()
+	if p.eof() || p.s[p.pos] != '{' {
+		return "", fmt.Errorf("ожидалась «{»")
+	}
+	p.pos++
+	if p.depth++; p.depth > texMaxDepth {
+		return "", fmt.Errorf("слишком глубокая вложенность")
+	}
+	list, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if p.eof() || p.s[p.pos] != '}' {
+		return "", fmt.Errorf("не закрыта «{»")
+	}
+	p.pos++
+	p.depth--
+	return texRow(list), nil
+}
+
+// readLiteral — содержимое {…} как текст (для \text, \operatorname, \begin).
+func (p *texParser) readLiteral() (string, error) {
+	p.skipSpace()
+	if p.eof() || p.s[p.pos] != '{' {
+		return "", fmt.Errorf("ожидалась «{»")
+	}
+	p.pos++
+	var b strings.Builder
+	level := 0
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\' && !p.eof() && strings.ContainsRune(`{}$%&_#\ `, p.s[p.pos]):
+			b.WriteRune(p.s[p.pos])
+			p.pos++
+			continue
+		case c == '{':
+			level++
+		case c == '}':
+			if level == 0 {
+				return b.String(), nil
+			}
+			level--
+		}
+		b.WriteRune(c)
+	}
+	return "", fmt.Errorf("не закрыта «{»")
+}
+
+func (p *texParser) parseAtom() (string, bool, error) {
+	c := p.s[p.pos]
+	switch {
+	case c == '{':
+		g, err := p.parseGroup()
+		return g, false, err
+	case c == '^' || c == '_':
+		return "", false, nil // индекс без основания: {}^2
+	case c == '\\':
+		return p.parseCommand()
+	case c >= '0' && c <= '9' || c == '.' && p.pos+1 < len(p.s) && p.s[p.pos+1] >= '0' && p.s[p.pos+1] <= '9':
+		start := p.pos
+		for !p.eof() && (p.s[p.pos] >= '0' && p.s[p.pos] <= '9' ||
+			p.s[p.pos] == '.' && p.pos+1 < len(p.s) && p.s[p.pos+1] >= '0' && p.s[p.pos+1] <= '9') {
+			p.pos++
+		}
+		var b strings.Builder
+		for _, r := range p.s[start:p.pos] {
+			b.WriteString(p.styled(r))
+		}
+		return "<mn>" + b.String() + "</mn>", false, nil
+	case c == '~':
+		p.pos++
+		return `<mspace width="0.25em"></mspace>`, false, nil
+	case c == '$' || c == '%' || c == '#':
+		return "", false, fmt.Errorf("символ «%c» в формуле нужно экранировать: \\%c", c, c)
+	}
+	p.pos++
+	if unicode.IsLetter(c) {
+		return p.ident(string(c)), false, nil
+	}
+	switch c {
+	case '(', ')', '[', ']', '|':
+		return `<mo stretchy="false">` + html.EscapeString(string(c)) + `</mo>`, false, nil
+	case '-':
+		return "<mo>−</mo>", false, nil
+	case '*':
+		return "<mo>∗</mo>", false, nil
+	case '\'':
+		return "<mo>′</mo>", false, nil
+	}
+	return "<mo>" + html.EscapeString(string(c)) + "</mo>", false, nil
+}
+
+// ident — <mi> с учётом \mathbb/\mathbf/…
+func (p *texParser) ident(s string) string {
+	if p.variant == "rm" {
+		return `<mi mathvariant="normal">` + html.EscapeString(s) + "</mi>"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteString(p.styled(r))
+	}
+	return "<mi>" + b.String() + "</mi>"
+}
+
+func (p *texParser) styled(r rune) string {
+	return html.EscapeString(string(texVariantRune(p.variant, r)))
+}
+
+func (p *texParser) parseCommand() (string, bool, error) {
+	name := p.readCommand()
+	if name == "" {
+		return "", false, fmt.Errorf("«\\» в конце формулы")
+	}
+	if s, ok := texGreek[name]; ok {
+		if unicode.IsUpper([]rune(s)[0]) {
+			return `<mi mathvariant="normal">` + s + "</mi>", false, nil
+		}
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texIdents[name]; ok {
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texOperators[name]; ok {
+		return "<mo>" + html.EscapeString(s) + "</mo>", false, nil
+	}
+	if op, ok := texBigOps[name]; ok {
+		if op.limits {
+			return `<mo movablelimits="true">` + op.sym + "</mo>", true, nil
+		}
+		return "<mo>" + op.sym + "</mo>", false, nil
+	}
+	if limits, ok := texFuncs[name]; ok {
+		if limits {
+			return `<mo movablelimits="true" form="prefix">` + name + "</mo>", true, nil
+		}
+		return "<mi>" + name + "</mi><mo>⁡</mo>", false, nil
+	}
+	if w, ok := texSpaces[name]; ok {
+		return `<mspace width="` + w + `"></mspace>`, false, nil
+	}
+	if v, ok := texVariants[name]; ok {
+		saved := p.variant
+		p.variant = v
+		arg, err := p.parseArg()
+		p.variant = saved
+		return arg, false, err
+	}
+	if a, ok := texAccents[name]; ok {
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		stretchy := "false"
+		if a.stretchy {
+			stretchy = "true"
+		}
+		tag, attr := "mover", "accent"
+		if a.under {
+			tag, attr = "munder", "accentunder"
+		}
+		return "<" + tag + ` ` + attr + `="true">` + arg + `<mo stretchy="` + stretchy + `">` + a.sym + "</mo></" + tag + ">", false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "binom":
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "binom" {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`, false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "sqrt":
+		p.skipSpace()
+		if !p.eof() && p.s[p.pos] == '[' {
+			p.pos++
+			idx, err := p.parseUntil(']')
+			if err != nil {
+				return "", false, err
+			}
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", false, err
+			}
+			return "<mroot>" + arg + idx + "</mroot>", false, nil
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "text", "textrm", "textit", "textbf", "mbox":
+		s, err := p.readLiteral()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(s) + "</mtext>", false, nil
+	case "operatorname":
+		s, err := p.readLiteral()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mi>" + html.EscapeString(s) + "</mi><mo>⁡</mo>", false, nil
+	case "left":
+		return p.parseLeftRight()
+	case "begin":
+		return p.parseEnv()
+	case "displaystyle", "textstyle", "limits", "nolimits":
+		return "", false, nil // на вывод MathML не влияют
+	case "\\":
+		return "", false, fmt.Errorf("перенос строки «\\\\» вне окружения")
+	case "right":
+		return "", false, fmt.Errorf("\\right без \\left")
+	case "end":
+		return "", false, fmt.Errorf("\\end без \\begin")
+	}
+	return "", false, fmt.Errorf("неизвестная команда \\%s", name)
+}
+
+// parseUntil — список до символа stop (для [n] у \sqrt).
+func (p *texParser) parseUntil(stop rune) (string, error) {
+	var out []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return "", fmt.Errorf("не закрыта «%c»", stop)
+		}
+		if p.s[p.pos] == stop {
+			p.pos++
+			return texRow(out), nil
+		}
+		atom, limits, err := p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+		if atom, err = p.parseScripts(atom, limits); err != nil {
+			return "", err
+		}
+		out = append(out, atom)
+	}
+}
+
+func (p *texParser) readDelim() (string, error) {
+	p.skipSpace()
+	if p.eof() {
+		return "", fmt.Errorf("не указана скобка после \\left/\\right")
+	}
+	c := p.s[p.pos]
+	if c == '\\' {
+		name := p.readCommand()
+		if s, ok := texDelims[name]; ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("\\%s не может быть скобкой", name)
+	}
+	p.pos++
+	switch c {
+	case '.':
+		return "", nil
+	case '(', ')', '[', ']', '|', '/', '<', '>':
+		return string(c), nil
+	}
+	return "", fmt.Errorf("«%c» не может быть скобкой", c)
+}
+
+func (p *texParser) parseLeftRight() (string, bool, error) {
+	open, err := p.readDelim()
+	if err != nil {
+		return "", false, err
+	}
+	if p.depth++; p.depth > texMaxDepth {
+		return "", false, fmt.Errorf("слишком глубокая вложенность")
+	}
+	list, err := p.parseList()
+	if err != nil {
+		return "", false, err
+	}
+	if p.eof() || p.s[p.pos] != '\\' || p.peekCommand() != "right" {
+		return "", false, fmt.Errorf("\\left без \\right")
+	}
+	p.readCommand()
+	closing, err := p.readDelim()
+	if err != nil {
+		return "", false, err
+	}
+	p.depth--
+	return "<mrow>" + texFence(open) + strings.Join(list, "") + texFence(closing) + "</mrow>", false, nil
+}
+
+func texFence(s string) string {
+	if s == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(s) + "</mo>"
+}
+
+// parseEnv — \begin{env} ячейки через &, строки через \\ … \end{env}.
+func (p *texParser) parseEnv() (string, bool, error) {
+	env, err := p.readLiteral()
+	if err != nil {
+		return "", false, err
+	}
+	fences, ok := texEnvs[env]
+	if !ok {
+		return "", false, fmt.Errorf("неизвестное окружение %s", env)
+	}
+	if p.depth++; p.depth > texMaxDepth {
+		return "", false, fmt.Errorf("слишком глубокая вложенность")
+	}
+	var rows [][]string
+	row := []string{}
+	for {
+		cell, err := p.parseList()
+		if err != nil {
+			return "", false, err
+		}
+		row = append(row, texRow(cell))
+		if p.eof() {
+			return "", false, fmt.Errorf("нет \\end{%s}", env)
+		}
+		if p.s[p.pos] == '&' {
+			p.pos++
+			continue
+		}
+		if p.s[p.pos] == '}' {
+			return "", false, fmt.Errorf("лишняя «}» в %s", env)
+		}
+		switch p.readCommand() {
+		case "\\":
+			rows = append(rows, row)
+			row = []string{}
+			continue
+		case "right":
+			return "", false, fmt.Errorf("\\right без \\left внутри %s", env)
+		}
+		// \end
+		end, err := p.readLiteral()
+		if err != nil {
+			return "", false, err
+		}
+		if end != env {
+			return "", false, fmt.Errorf("\\begin{%s} закрыт \\end{%s}", env, end)
+		}
+		if len(row) > 1 || row[0] != "<mrow></mrow>" {
+			rows = append(rows, row)
+		}
+		break
+	}
+	p.depth--
+
+	var b strings.Builder
+	b.WriteString("<mtable")
+	switch env {
+	case "cases":
+		b.WriteString(` columnalign="left left"`)
+	case "aligned", "align", "align*":
+		b.WriteString(` columnalign="right left" columnspacing="0"`)
+	}
+	b.WriteString(">")
+	for _, r := range rows {
+		b.WriteString("<mtr>")
+		for _, c := range r {
+			b.WriteString("<mtd>" + c + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return "<mrow>" + texFence(fences[0]) + b.String() + texFence(fences[1]) + "</mrow>", false, nil
+}
+
+func texRow(list []string) string {
+	if len(list) == 1 {
+		return list[0]
+	}
+	return "<mrow>" + strings.Join(list, "") + "</mrow>"
+}
+
+///////////////////////////////////////////////////////
+// ТАБЛИЦЫ КОМАНД
+///////////////////////////////////////////////////////
+
+var texGreek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+var texIdents = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅", "varnothing": "∅",
+	"Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "imath": "ı", "jmath": "ȷ", "wp": "℘",
+	"{": "{", "}": "}", "%": "%", "$": "$", "#": "#", "&": "&", "_": "_",
+}
+
+var texOperators = map[string]string{
+	"cdot": "⋅", "times": "×", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆", "circ": "∘",
+	"bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙",
+	"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "ne": "≠", "neq": "≠", "lt": "<", "gt": ">",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔", "Rightarrow": "⇒",
+	"Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦",
+	"uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨",
+	"perp": "⊥", "parallel": "∥", "mid": "∣", "angle": "∠", "triangle": "△", "degree": "°",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lbrace": "{", "rbrace": "}", "vert": "|", "Vert": "‖", "|": "‖", "prime": "′",
+	"colon": ":", "mod": "mod", "bmod": "mod",
+}
+
+var texBigOps = map[string]struct {
+	sym    string
+	limits bool
+}{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true}, "bigcup": {"⋃", true}, "bigcap": {"⋂", true},
+	"bigoplus": {"⨁", true}, "bigotimes": {"⨂", true},
+	"int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+}
+
+// функции; true — пределы под/над в выключной формуле (\lim_{x\to 0})
+var texFuncs = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "tg": false, "cot": false, "ctg": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "arctg": false, "sinh": false, "cosh": false, "tanh": false,
+	"log": false, "ln": false, "lg": false, "exp": false, "det": false, "dim": false, "ker": false, "deg": false,
+	"gcd": false, "arg": false, "Pr": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em", " ": "0.25em",
+	"!": "-0.1667em", "quad": "1em", "qquad": "2em",
+}
+
+var texVariants = map[string]string{
+	"mathrm": "rm", "mathbf": "bf", "boldsymbol": "bf", "mathit": "it", "mathbb": "bb",
+	"mathcal": "cal", "mathscr": "cal", "mathfrak": "frak", "mathsf": "sf", "mathtt": "tt",
+}
+
+var texAccents = map[string]struct {
+	sym      string
+	stretchy bool
+	under    bool
+}{
+	"hat": {"^", false, false}, "widehat": {"^", true, false}, "bar": {"¯", false, false},
+	"overline": {"¯", true, false}, "vec": {"→", false, false}, "overrightarrow": {"→", true, false},
+	"dot": {"˙", false, false}, "ddot": {"¨", false, false}, "tilde": {"~", false, false},
+	"widetilde": {"~", true, false}, "check": {"ˇ", false, false}, "breve": {"˘", false, false},
+	"acute": {"´", false, false}, "grave": {"`", false, false},
+	"overbrace": {"⏞", true, false}, "underbrace": {"⏟", true, true}, "underline": {"_", true, true},
+}
+
+var texDelims = map[string]string{
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "langle": "⟨", "rangle": "⟩", "|": "‖", "Vert": "‖",
+	"vert": "|", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+}
+
+var texEnvs = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+	"aligned": {"", ""}, "align": {"", ""}, "align*": {"", ""}, "gathered": {"", ""},
+}
+
+// texVariantRune — буква или цифра в начертании \mathbb, \mathbf… (Mathematical Alphanumeric Symbols).
+func texVariantRune(variant string, r rune) rune {
+	upper, lower, digit := rune(0), rune(0), rune(0)
+	var holes map[rune]rune
+	switch variant {
+	case "bf":
+		upper, lower, digit = 0x1D400, 0x1D41A, 0x1D7CE
+	case "it":
+		upper, lower = 0x1D434, 0x1D44E
+		holes = map[rune]rune{'h': 'ℎ'}
+	case "bb":
+		upper, lower, digit = 0x1D538, 0x1D552, 0x1D7D8
+		holes = map[rune]rune{'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'}
+	case "cal":
+		upper, lower = 0x1D49C, 0x1D4B6
+		holes = map[rune]rune{'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ',
+			'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'}
+	case "frak":
+		upper, lower = 0x1D504, 0x1D51E
+		holes = map[rune]rune{'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'}
+	case "sf":
+		upper, lower, digit = 0x1D5A0, 0x1D5BA, 0x1D7E2
+	case "tt":
+		upper, lower, digit = 0x1D670, 0x1D68A, 0x1D7F6
+	default:
+		return r
+	}
+	if h, ok := holes[r]; ok {
+		return h
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return upper + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return lower + r - 'a'
+	case r >= '0' && r <= '9' && digit != 0:
+		return digit + r - '0'
+	}
+	return r
+}
@@ -0,0 +1,166 @@
+// tools/lrs-stub/main.go
+//
+// Заглушка LRS для проверки xAPI-выгрузки TrainBrain без настоящего Learning Record Store.
+// Хранит заявления в памяти и печатает их в лог.
+//
+//	go run ./tools/lrs-stub              # слушает :8088, логин/пароль lrs/lrs
+//	XAPI_ENDPOINT=http://localhost:8088/xapi/ XAPI_USERNAME=lrs XAPI_PASSWORD=lrs ./server
+//
+// GET /xapi/statements отдаёт всё принятое, GET /xapi/about — версию.
+// LRS_FAIL_RATE=0.3 — доля запросов, на которые отвечаем 503 (проверка повторов).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type store struct {
+	mu         sync.Mutex
+	statements map[string]json.RawMessage
+	order      []string
+}
+
+func main() {
+	addr := envOr("LRS_ADDR", ":8088")
+	user := envOr("LRS_USER", "lrs")
+	pass := envOr("LRS_PASSWORD", "lrs")
+	failRate, _ := strconv.ParseFloat(os.Getenv("LRS_FAIL_RATE"), 64)
+
+	s := &store{statements: map[string]json.RawMessage{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/xapi/about", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"version": []string{"1.0.3"}})
+	})
+	mux.HandleFunc("/xapi/statements", func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Experience-API-Version") == "" {
+			http.Error(w, "X-Experience-API-Version header required", http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodGet && failRate > 0 && rand.Float64() < failRate {
+			http.Error(w, "simulated failure", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			list := make([]json.RawMessage, 0, len(s.order))
+			for _, id := range s.order {
+				list = append(list, s.statements[id])
+			}
+			s.mu.Unlock()
+			writeJSON(w, http.StatusOK, map[string]any{"statements": list})
+
+		case http.MethodPost:
+			var batch []json.RawMessage
+			body := readBody(r)
+			if len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '{' {
+				batch = []json.RawMessage{body}
+			} else if err := json.Unmarshal(body, &batch); err != nil {
+				http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			ids := make([]string, 0, len(batch))
+			for _, st := range batch {
+				id, status, msg := s.put("", st)
+				if status != http.StatusNoContent {
+					http.Error(w, msg, status)
+					return
+				}
+				ids = append(ids, id)
+			}
+			writeJSON(w, http.StatusOK, ids)
+
+		case http.MethodPut:
+			id := r.URL.Query().Get("statementId")
+			if id == "" {
+				http.Error(w, "statementId required", http.StatusBadRequest)
+				return
+			}
+			_, status, msg := s.put(id, readBody(r))
+			if status != http.StatusNoContent {
+				http.Error(w, msg, status)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	log.Printf("lrs-stub: http://localhost%s/xapi/ (пользователь %s)", addr, user)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// put проверяет обязательные поля и сохраняет заявление.
+// Повтор с тем же id и тем же телом — не ошибка; с другим телом — 409, как в настоящем LRS.
+func (s *store) put(id string, raw json.RawMessage) (string, int, string) {
+	var st struct {
+		ID    string `json:"id"`
+		Actor any    `json:"actor"`
+		Verb  struct {
+			ID string `json:"id"`
+		} `json:"verb"`
+		Object struct {
+			ID string `json:"id"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return "", http.StatusBadRequest, "invalid statement: " + err.Error()
+	}
+	if id == "" {
+		id = st.ID
+	}
+	if id == "" || (st.ID != "" && st.ID != id) {
+		return "", http.StatusBadRequest, "statement id missing or mismatched"
+	}
+	if st.Actor == nil || st.Verb.ID == "" || st.Object.ID == "" {
+		return "", http.StatusBadRequest, "actor, verb.id and object.id are required"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.statements[id]; ok {
+		if !bytes.Equal(prev, raw) {
+			return "", http.StatusConflict, "statement " + id + " already exists with different content"
+		}
+		return id, http.StatusNoContent, ""
+	}
+	s.statements[id] = raw
+	s.order = append(s.order, id)
+	log.Printf("statement %s: %s %s", id, st.Verb.ID, st.Object.ID)
+	return id, http.StatusNoContent, ""
+}
+
+func readBody(r *http.Request) []byte {
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(http.MaxBytesReader(nil, r.Body, 10<<20))
+	return buf.Bytes()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Experience-API-Version", "1.0.3")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,230 @@
+// video_captions.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Субтитры, главы и расшифровка видео-файлов (payload — blocks.go). Субтитры хранятся в WebVTT:
+// SRT переводится в VTT при загрузке (uploadKinds["subtitles"]) и при course-sync. Плеер подключает
+// дорожки тегом <track>, а под видео показывает расшифровку — реплики дорожек, разобранные на сервере;
+// щелчок по реплике или главе перематывает видео. Текст всех дорожек при сохранении блока попадает
+// в payload.transcript — по нему ищет поиск по курсам (course_search.go).
+
+const videoTrackMaxSize = 5 << 20 // субтитры больше — скорее всего не тот файл
+
+func init() {
+	// без этого /static отдаёт .vtt как text/plain, и Firefox не подключает дорожку
+	if err := mime.AddExtensionType(".vtt", "text/vtt"); err != nil {
+		log.Printf("video: тип .vtt: %v\n", err)
+	}
+}
+
+// vttCue — реплика субтитров: время, с, и текст без разметки.
+type vttCue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// videoTranscript — расшифровка одной дорожки для плеера.
+type videoTranscript struct {
+	Lang  string
+	Label string
+	Cues  []vttCue
+}
+
+var (
+	vttTagRe   = regexp.MustCompile(`<[^>]*>`)
+	srtCommaRe = regexp.MustCompile(`(\d{2}),(\d{3})`)
+	srtFontRe  = regexp.MustCompile(`(?i)</?font[^>]*>`)
+)
+
+func normalizeSubtitles(data []byte) string {
+	s := strings.TrimPrefix(string(data), "\ufeff")
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// parseVTT разбирает WebVTT: заголовок, затем блоки через пустую строку — реплики
+// (необязательный идентификатор, строка времени, текст) и NOTE/STYLE/REGION, которые пропускаются.
+func parseVTT(data []byte) ([]vttCue, error) {
+	blocks := strings.Split(normalizeSubtitles(data), "\n\n")
+	header, _, _ := strings.Cut(blocks[0], "\n")
+	if header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") {
+		return nil, errors.New("файл не начинается с WEBVTT")
+	}
+	var cues []vttCue
+	for _, b := range blocks[1:] {
+		lines := strings.Split(strings.Trim(b, "\n"), "\n")
+		first := strings.TrimSpace(lines[0])
+		if first == "" {
+			continue
+		}
+		if kw, _, _ := strings.Cut(first, " "); kw == "NOTE" || kw == "STYLE" || kw == "REGION" {
+			continue
+		}
+		if !strings.Contains(first, "-->") {
+			lines = lines[1:] // идентификатор реплики
+		}
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			return nil, fmt.Errorf("реплика %q: нет строки времени «00:01.000 --> 00:04.000»", first)
+		}
+		start, end, err := parseVTTTiming(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		text := html.UnescapeString(vttTagRe.ReplaceAllString(strings.Join(lines[1:], " "), ""))
+		if text = strings.Join(strings.Fields(text), " "); text != "" {
+			cues = append(cues, vttCue{Start: start, End: end, Text: text})
+		}
+	}
+	if len(cues) == 0 {
+		return nil, errors.New("в субтитрах нет ни одной реплики")
+	}
+	return cues, nil
+}
+
+// parseVTTTiming — «начало --> конец [настройки]».
+func parseVTTTiming(line string) (start, end float64, err error) {
+	from, rest, _ := strings.Cut(line, "-->")
+	to := strings.Fields(rest)
+	if len(to) == 0 {
+		return 0, 0, fmt.Errorf("строка времени %q: нет конца реплики", line)
+	}
+	if start, err = parseVTTTime(strings.TrimSpace(from)); err == nil {
+		end, err = parseVTTTime(to[0])
+	}
+	if err == nil && end < start {
+		err = fmt.Errorf("строка времени %q: конец раньше начала", line)
+	}
+	return start, end, err
+}
+
+// parseVTTTime — [чч:]мм:сс.ттт.
+func parseVTTTime(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("время %q: ожидается [чч:]мм:сс.ттт", s)
+	}
+	sec, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || sec < 0 || sec >= 60 {
+		return 0, fmt.Errorf("время %q: ожидается [чч:]мм:сс.ттт", s)
+	}
+	total := sec
+	for i, mul := len(parts)-2, 60.0; i >= 0; i, mul = i-1, mul*60 {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || (i == len(parts)-2 && len(parts) == 3 && n >= 60) {
+			return 0, fmt.Errorf("время %q: ожидается [чч:]мм:сс.ттт", s)
+		}
+		total += float64(n) * mul
+	}
+	return total, nil
+}
+
+// srtToVTT переводит SubRip в WebVTT: заголовок, запятая в долях секунды — точка. Номера реплик
+// остаются идентификаторами, теги <i>/<b>/<u> в VTT те же, <font> в VTT нет — он убирается.
+func srtToVTT(data []byte) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(strings.TrimSpace(normalizeSubtitles(data)), "\n") {
+		if strings.Contains(line, "-->") {
+			line = srtCommaRe.ReplaceAllString(line, "$1.$2")
+		}
+		b.WriteString(srtFontRe.ReplaceAllString(line, ""))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// prepareSubtitles — загрузка субтитров (uploadKind.Convert): UTF-8, SRT — в VTT, файл должен разбираться.
+func prepareSubtitles(data []byte, ext string) ([]byte, string, error) {
+	if len(data) > videoTrackMaxSize {
+		return nil, "", fmt.Errorf("субтитры больше %d МБ", videoTrackMaxSize>>20)
+	}
+	if !utf8.Valid(data) {
+		return nil, "", errors.New("субтитры не в UTF-8: пересохраните файл в этой кодировке")
+	}
+	if ext == ".srt" {
+		data = srtToVTT(data)
+	}
+	if _, err := parseVTT(data); err != nil {
+		return nil, "", err
+	}
+	return data, ".vtt", nil
+}
+
+// cuesText — текст реплик подряд (для поиска).
+func cuesText(cues []vttCue) string {
+	texts := make([]string, len(cues))
+	for i, c := range cues {
+		texts[i] = c.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+func readTrackCues(src string) ([]vttCue, error) {
+	path := contentFilePath(src)
+	if path == "" {
+		return nil, errors.New("субтитры не загружены на сайт")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseVTT(data)
+}
+
+// videoTranscriptText — текст всех дорожек для payload.transcript. Файлы читаются с диска:
+// адреса дорожек к этому времени проверены (videoPayload.validate).
+func videoTranscriptText(tracks []videoTrack) (string, error) {
+	var parts []string
+	for _, t := range tracks {
+		cues, err := readTrackCues(t.Src)
+		if err != nil {
+			return "", fmt.Errorf("субтитры «%s»: %w", t.Label, err)
+		}
+		parts = append(parts, cuesText(cues))
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// loadVideoTranscripts — расшифровки дорожек для плеера; дорожку, которая не читается, плеер
+// просто не покажет.
+func loadVideoTranscripts(blk *Block, tracks []videoTrack) []videoTranscript {
+	var out []videoTranscript
+	for _, t := range tracks {
+		cues, err := readTrackCues(t.Src)
+		if err != nil {
+			log.Printf("video: блок %d, субтитры %s: %v\n", blk.ID, t.Src, err)
+			continue
+		}
+		out = append(out, videoTranscript{Lang: t.Lang, Label: t.Label, Cues: cues})
+	}
+	return out
+}
+
+// parseClock — момент видео: секунды («90»), м:сс или ч:мм:сс.
+func parseClock(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ":") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("время %q: ожидается м:сс, ч:мм:сс или секунды", s)
+		}
+		return float64(n), nil
+	}
+	t, err := parseVTTTime(s)
+	if err != nil || t != float64(int(t)) {
+		return 0, fmt.Errorf("время %q: ожидается м:сс, ч:мм:сс или секунды", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,127 @@
+// video_captions_test.go
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// SRT → VTT: заголовок, запятая в долях секунды — точка (только в строке времени), без <font>,
+// BOM и переводы строк Windows/старого Mac не мешают.
+func TestSrtToVTT(t *testing.T) {
+	cases := []struct {
+		name string
+		srt  string
+		want string
+	}{
+		{"запятая в строке времени",
+			"1\n00:00:01,000 --> 00:00:04,500\nЦена 1,500 рублей\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:04.500\nЦена 1,500 рублей\n"},
+		{"<font> убирается, <i> остаётся",
+			"1\n00:00:01,000 --> 00:00:02,000\n<FONT color=\"#ff0000\">Привет</font>, <i>мир</i>\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nПривет, <i>мир</i>\n"},
+		{"BOM и CRLF",
+			"\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nРаз\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nДва\r\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nРаз\n\n2\n00:00:03.000 --> 00:00:04.000\nДва\n"},
+		{"только CR",
+			"1\r00:00:01,000 --> 00:00:02,000\rРаз\r",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nРаз\n"},
+	}
+	for _, tc := range cases {
+		if got := string(srtToVTT([]byte(tc.srt))); got != tc.want {
+			t.Errorf("%s:\nполучено  %q\nожидается %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseVTT(t *testing.T) {
+	cases := []struct {
+		name string
+		vtt  string
+		want []vttCue
+		err  string // часть текста ошибки; "" — без ошибки
+	}{
+		{"реплики, идентификатор, настройки, теги и сущности",
+			"WEBVTT - урок 1\n\nNOTE комментарий\n\nintro\n00:01.000 --> 00:04.500 align:start\n<v Лектор>Привет, <b>мир</b>\n\n" +
+				"00:00:05.000 --> 00:01:02.250\nВторая &amp; реплика\nстрока два\n",
+			[]vttCue{{1, 4.5, "Привет, мир"}, {5, 62.25, "Вторая & реплика строка два"}}, ""},
+		{"BOM и CRLF",
+			"\ufeffWEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nРаз\r\n",
+			[]vttCue{{1, 2, "Раз"}}, ""},
+		{"из SRT", string(srtToVTT([]byte("1\r\n01:00:00,000 --> 01:00:01,250\r\nРаз\r\n"))),
+			[]vttCue{{3600, 3601.25, "Раз"}}, ""},
+		{"пустая реплика пропускается",
+			"WEBVTT\n\n00:01.000 --> 00:02.000\n\n00:03.000 --> 00:04.000\nДва\n",
+			[]vttCue{{3, 4, "Два"}}, ""},
+		{"нет заголовка", "00:01.000 --> 00:02.000\nРаз\n", nil, "WEBVTT"},
+		{"WEBVTTX — не заголовок", "WEBVTTX\n\n00:01.000 --> 00:02.000\nРаз\n", nil, "WEBVTT"},
+		{"запятая, как в SRT", "WEBVTT\n\n00:00:01,000 --> 00:00:02,000\nРаз\n", nil, "ожидается"},
+		{"секунды за 59", "WEBVTT\n\n00:61.000 --> 01:02.000\nРаз\n", nil, "ожидается"},
+		{"минуты за 59 при часах", "WEBVTT\n\n00:60:00.000 --> 01:00:01.000\nРаз\n", nil, "ожидается"},
+		{"буквы во времени", "WEBVTT\n\n00:0a.000 --> 00:02.000\nРаз\n", nil, "ожидается"},
+		{"нет конца", "WEBVTT\n\n00:01.000 -->\nРаз\n", nil, "нет конца"},
+		{"конец раньше начала", "WEBVTT\n\n00:05.000 --> 00:02.000\nРаз\n", nil, "конец раньше начала"},
+		{"нет строки времени", "WEBVTT\n\nintro\nРаз\n", nil, "нет строки времени"},
+		{"ни одной реплики", "WEBVTT\n\nNOTE только комментарий\n", nil, "ни одной реплики"},
+	}
+	for _, tc := range cases {
+		got, err := parseVTT([]byte(tc.vtt))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: ошибка %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: ошибка %v, ожидается с %q", tc.name, err, tc.err)
+		case tc.err == "" && !reflect.DeepEqual(got, tc.want):
+			t.Errorf("%s: %v, ожидается %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPrepareSubtitles(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		ext  string
+		ok   bool
+	}{
+		{"SRT", "1\n00:00:01,000 --> 00:00:02,000\nРаз\n", ".srt", true},
+		{"VTT", "WEBVTT\n\n00:01.000 --> 00:02.000\nРаз\n", ".vtt", true},
+		{"SRT с расширением .vtt", "1\n00:00:01,000 --> 00:00:02,000\nРаз\n", ".vtt", false},
+		{"cp1251", "1\n00:00:01,000 --> 00:00:02,000\n\xcf\xf0\xe8\xe2\xe5\xf2\n", ".srt", false},
+		{"битое время", "1\n00:00:01,000 --> 00:00:0x,000\nРаз\n", ".srt", false},
+	}
+	for _, tc := range cases {
+		out, ext, err := prepareSubtitles([]byte(tc.data), tc.ext)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: ошибка %v, ожидается ok=%v", tc.name, err, tc.ok)
+			continue
+		}
+		if tc.ok && (ext != ".vtt" || !strings.HasPrefix(string(out), "WEBVTT")) {
+			t.Errorf("%s: %q, %q — ожидается WebVTT", tc.name, ext, out)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"90", 90, true},
+		{" 1:23 ", 83, true},
+		{"1:02:03", 3723, true},
+		{"1:2x", 0, false},
+		{"-3", 0, false},
+		{"1:23.5", 0, false},
+		{"1:60", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range cases {
+		got, err := parseClock(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseClock(%q) = %v, %v; ожидается %v, ok=%v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// video_watch.go
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Просмотр видео-файлов (блок video, mode file). Плеер шлёт POST /blocks/:id/watch при старте,
+// раз в videoHeartbeatEvery, на паузе, в конце и при уходе со страницы: отрезки, просмотренные
+// с прошлого раза, текущее место и длительность. Сервер объединяет отрезки с прежними (пересмотр
+// не считается дважды), запоминает место — с него плеер продолжит — и, если у блока задан порог
+// complete_percent, отмечает видео пройденным. Встроенные видео (iframe) не отслеживаются:
+// у чужого плеера нет событий.
+
+const (
+	videoHeartbeatEvery = 15 * time.Second
+	// за прошедшее с прошлого запроса время нельзя посмотреть больше, чем на videoMaxRate-кратной
+	// скорости, плюс videoSlack секунд на задержки сети
+	videoMaxRate  = 2.0
+	videoSlack    = 10.0
+	videoMaxSpans = 100 // отрезков в одном запросе
+
+	videoMaxDuration  = 24 * 3600.0
+	videoMergeGap     = 0.5 // с: соседние отрезки с зазором меньше — один отрезок
+	videoResumeTail   = 5.0 // с: остановился у самого конца — смотреть заново с начала
+	videoCurveBuckets = 50
+)
+
+// videoHeartbeat — тело запроса плеера.
+type videoHeartbeat struct {
+	Intervals [][2]float64 `json:"intervals"` // [от, до], с
+	Position  float64      `json:"position"`
+	Duration  float64      `json:"duration"`
+}
+
+// mergeSpans — отрезки по возрастанию без пересечений.
+func mergeSpans(spans [][2]float64) [][2]float64 {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var out [][2]float64
+	for _, s := range spans {
+		if n := len(out); n > 0 && s[0] <= out[n-1][1]+videoMergeGap {
+			out[n-1][1] = math.Max(out[n-1][1], s[1])
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func spansLength(spans [][2]float64) float64 {
+	total := 0.0
+	for _, s := range spans {
+		total += s[1] - s[0]
+	}
+	return total
+}
+
+func decodeVideoSpans(w *VideoWatch) [][2]float64 {
+	var spans [][2]float64
+	if len(w.Intervals) > 0 {
+		if err := json.Unmarshal(w.Intervals, &spans); err != nil {
+			log.Printf("video: просмотр %d: %v\n", w.ID, err)
+		}
+	}
+	return spans
+}
+
+// Percent — просмотренная доля видео, %.
+func (w *VideoWatch) Percent() float64 {
+	if w.Duration <= 0 {
+		return 0
+	}
+	return math.Min(w.Watched*100/w.Duration, 100)
+}
+
+// ResumeAt — с какого места продолжить; 0 — с начала (ещё не смотрел или досмотрел до конца).
+func (w *VideoWatch) ResumeAt() float64 {
+	if w.Position >= w.Duration-videoResumeTail {
+		return 0
+	}
+	return w.Position
+}
+
+// covers — момент t попадает в просмотренный отрезок.
+func covers(spans [][2]float64, t float64) bool {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i][1] >= t })
+	return i < len(spans) && spans[i][0] <= t
+}
+
+// saveVideoHeartbeat добавляет отрезки к просмотру; completed — порог просмотра достигнут этим запросом.
+// Строка просмотра блокируется на время запроса: параллельные вкладки не теряют отрезки друг друга.
+func saveVideoHeartbeat(user *User, blk *Block, p *videoPayload, hb *videoHeartbeat, now time.Time) (w VideoWatch, completed bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&VideoWatch{
+			UserID: user.ID, BlockID: blk.ID, Intervals: datatypes.JSON("[]"), CreatedAt: now, UpdatedAt: now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND block_id = ?", user.ID, blk.ID).First(&w).Error; err != nil {
+			return err
+		}
+
+		elapsed := now.Sub(w.UpdatedAt).Seconds()
+		if res.RowsAffected == 1 {
+			elapsed = videoHeartbeatEvery.Seconds()
+		}
+		var known float64
+		if err := tx.Model(&VideoWatch{}).Where("block_id = ?", blk.ID).
+			Select("coalesce(max(duration), 0)").Scan(&known).Error; err != nil {
+			return err
+		}
+		if completed, err = applyVideoHeartbeat(&w, p, hb, known, elapsed, now); err != nil {
+			return err
+		}
+		return tx.Save(&w).Error
+	})
+	return w, completed, err
+}
+
+// applyVideoHeartbeat — разбор heartbeat без БД: known — наибольшая длительность, сообщённая
+// зрителями блока, elapsed — секунд с прошлого запроса.
+func applyVideoHeartbeat(w *VideoWatch, p *videoPayload, hb *videoHeartbeat, known, elapsed float64, now time.Time) (completed bool, err error) {
+	// длительность сообщает клиент, поэтому она только растёт: не меньше прежней, чем у других
+	// зрителей и чем начало последней главы. Иначе «duration: 1» сразу давал бы 100%,
+	// а меньшая длительность в следующем запросе пересчитывала бы прежние отрезки.
+	duration := max(w.Duration, hb.Duration, known)
+	for _, ch := range p.Chapters {
+		duration = max(duration, ch.At)
+	}
+
+	budget := elapsed*videoMaxRate + videoSlack
+	spans := decodeVideoSpans(w)
+	for _, s := range hb.Intervals {
+		from, to := math.Max(s[0], 0), math.Min(s[1], duration)
+		if to <= from || budget <= 0 {
+			continue
+		}
+		to = math.Min(to, from+budget)
+		budget -= to - from
+		spans = append(spans, [2]float64{from, to})
+	}
+	spans = mergeSpans(spans)
+	raw, err := json.Marshal(spans)
+	if err != nil {
+		return false, err
+	}
+
+	w.Intervals = raw
+	w.Watched = spansLength(spans)
+	w.Duration = duration
+	w.Position = math.Min(math.Max(hb.Position, 0), duration)
+	w.UpdatedAt = now
+	if p.CompletePercent > 0 && w.CompletedAt == nil && w.Percent() >= float64(p.CompletePercent) {
+		w.CompletedAt = &now
+		completed = true
+	}
+	return completed, nil
+}
+
+///////////////////////////////////////////////////////
+// ОТЧЁТ
+///////////////////////////////////////////////////////
+
+type videoCurvePoint struct {
+	At      float64 // с от начала
+	Percent float64 // зрителей, посмотревших этот момент
+}
+
+type videoReport struct {
+	Duration  float64
+	Viewers   int     // посмотрели хоть что-то
+	Completed int     // достигли порога
+	AvgRatio  float64 // средняя просмотренная доля, %
+	Curve     []videoCurvePoint
+	Watches   []VideoWatch // по убыванию доли
+}
+
+// buildVideoReport — вовлечённость по видео: кривая удержания (доля зрителей, посмотревших каждую
+// из videoCurveBuckets точек ролика) и средняя просмотренная доля. Длительность — наибольшая из
+// сообщённых плеерами: если файл заменили, отрезки старой версии ложатся на начало кривой.
+func buildVideoReport(watches []VideoWatch) *videoReport {
+	r := &videoReport{}
+	spans := map[uint][][2]float64{}
+	for i := range watches {
+		w := &watches[i]
+		if w.Watched <= 0 {
+			continue
+		}
+		r.Watches = append(r.Watches, *w)
+		spans[w.ID] = decodeVideoSpans(w)
+		r.Duration = math.Max(r.Duration, w.Duration)
+		r.AvgRatio += w.Percent()
+		if w.CompletedAt != nil {
+			r.Completed++
+		}
+	}
+	r.Viewers = len(r.Watches)
+	if r.Viewers == 0 || r.Duration <= 0 {
+		return r
+	}
+	r.AvgRatio /= float64(r.Viewers)
+	sort.SliceStable(r.Watches, func(i, j int) bool { return r.Watches[i].Percent() > r.Watches[j].Percent() })
+
+	step := r.Duration / videoCurveBuckets
+	for b := 0; b < videoCurveBuckets; b++ {
+		t := (float64(b) + 0.5) * step
+		n := 0
+		for _, w := range r.Watches {
+			if covers(spans[w.ID], t) {
+				n++
+			}
+		}
+		r.Curve = append(r.Curve, videoCurvePoint{At: t, Percent: float64(n) * 100 / float64(r.Viewers)})
+	}
+	return r
+}
+
+///////////////////////////////////////////////////////
+// HEARTBEAT
+///////////////////////////////////////////////////////
+
+func registerVideoRoutes(r *gin.Engine) {
+	r.POST("/blocks/:block_id/watch", authRequired(), videoWatchHandler)
+}
+
+// videoWatchHandler принимает heartbeat плеера и отвечает просмотренной долей.
+func videoWatchHandler(c *gin.Context) {
+	user := getCurrentUser(c)
+	id, err := strconv.Atoi(c.Param("block_id"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	blk, err := loadBlockCourse(uint(id))
+	if err != nil || blk.Type != videoBlock.Type {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	p := videoOf(blk)
+	if p == nil || p.Mode != "file" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	var hb videoHeartbeat
+	if err := c.ShouldBindJSON(&hb); err != nil || hb.Duration <= 0 || hb.Duration > videoMaxDuration ||
+		len(hb.Intervals) > videoMaxSpans {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	w, completed, err := saveVideoHeartbeat(user, blk, p, &hb, time.Now())
+	if err != nil {
+		log.Printf("video: просмотр блока %d пользователем %d: %v\n", blk.ID, user.ID, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if completed {
+		logXAPIError("video completed", xapiVideoCompleted(user, &w))
+		checkCourseCompletion(user, blockCourseID(blk))
+	}
+	c.JSON(http.StatusOK, gin.H{"percent": math.Round(w.Percent()*10) / 10, "completed": w.CompletedAt != nil})
+}
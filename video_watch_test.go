@@ -0,0 +1,63 @@
+// video_watch_test.go
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Клиент не может подделать длительность: она только растёт, а прежние отрезки не пересчитываются.
+func TestApplyVideoHeartbeatDuration(t *testing.T) {
+	now := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	p := &videoPayload{Mode: "file", CompletePercent: 90}
+	cases := []struct {
+		name      string
+		watch     VideoWatch
+		hb        videoHeartbeat
+		known     float64 // наибольшая длительность у других зрителей
+		chapters  []videoChapter
+		duration  float64
+		watched   float64
+		completed bool
+	}{
+		{"обычный просмотр",
+			VideoWatch{Intervals: datatypes.JSON(`[[0,580]]`), Duration: 600},
+			videoHeartbeat{Intervals: [][2]float64{{580, 595}}, Position: 595, Duration: 600},
+			600, nil, 600, 595, true},
+		{"поддельная длительность после начала просмотра",
+			VideoWatch{Intervals: datatypes.JSON(`[[0,30]]`), Duration: 600},
+			videoHeartbeat{Intervals: [][2]float64{{0, 1}}, Position: 1, Duration: 1},
+			600, nil, 600, 30, false},
+		{"поддельная длительность в первом запросе: известна по другим зрителям",
+			VideoWatch{Intervals: datatypes.JSON(`[]`)},
+			videoHeartbeat{Intervals: [][2]float64{{0, 1}}, Position: 1, Duration: 1},
+			600, nil, 600, 1, false},
+		{"поддельная длительность в первом запросе: не меньше начала последней главы",
+			VideoWatch{Intervals: datatypes.JSON(`[]`)},
+			videoHeartbeat{Intervals: [][2]float64{{0, 1}}, Position: 1, Duration: 1},
+			0, []videoChapter{{At: 0, Title: "Начало"}, {At: 300, Title: "Итоги"}}, 300, 1, false},
+		{"длительность растёт вместе с файлом",
+			VideoWatch{Intervals: datatypes.JSON(`[[0,30]]`), Duration: 600},
+			videoHeartbeat{Intervals: [][2]float64{{30, 45}}, Position: 45, Duration: 900},
+			600, nil, 900, 45, false},
+	}
+	for _, tc := range cases {
+		w := tc.watch
+		pc := *p
+		pc.Chapters = tc.chapters
+		completed, err := applyVideoHeartbeat(&w, &pc, &tc.hb, tc.known, videoHeartbeatEvery.Seconds(), now)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if w.Duration != tc.duration || math.Abs(w.Watched-tc.watched) > 1e-9 || completed != tc.completed {
+			t.Errorf("%s: длительность %v, просмотрено %v, пройдено %v; ожидается %v, %v, %v",
+				tc.name, w.Duration, w.Watched, completed, tc.duration, tc.watched, tc.completed)
+		}
+		if w.Position > w.Duration {
+			t.Errorf("%s: место %v за концом видео %v", tc.name, w.Position, w.Duration)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+// webhooks.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// События, на которые можно подписаться
+const (
+	eventUserRegistered     = "user.registered"
+	eventQuizAttempted      = "quiz.attempted"
+	eventSubmissionCreated  = "submission.created"
+	eventSubmissionReviewed = "submission.reviewed"
+	eventCourseCompleted    = "course.completed"
+	eventPing               = "ping" // тестовая отправка из админки, подписка не нужна
+)
+
+type webhookEvent struct {
+	Name  string
+	Title string
+}
+
+var webhookEvents = []webhookEvent{
+	{eventUserRegistered, "Регистрация пользователя"},
+	{eventQuizAttempted, "Попытка теста"},
+	{eventSubmissionCreated, "Отправка задания"},
+	{eventSubmissionReviewed, "Проверка задания"},
+	{eventCourseCompleted, "Курс завершён"},
+}
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+
+	webhookSignatureHeader = "X-TrainBrain-Signature"
+	webhookResponseLimit   = 2048 // сколько байт ответа сохранять в журнал
+)
+
+// webhookConfig — WEBHOOK_POLL_INTERVAL, WEBHOOK_TIMEOUT, WEBHOOK_MAX_ATTEMPTS.
+type webhookConfig struct {
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+}
+
+var webhookCfg = webhookConfig{
+	PollInterval: 5 * time.Second,
+	Timeout:      10 * time.Second,
+	MaxAttempts:  8,
+}
+
+// webhookWake будит воркер сразу после нового события, не дожидаясь тика.
+var webhookWake = make(chan struct{}, 1)
+
+func (w Webhook) EventList() []string {
+	return strings.Fields(w.Events)
+}
+
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.EventList() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhook проверяет URL и список событий из формы.
+func validateWebhook(rawURL string, events []string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("укажите URL вида https://host/path")
+	}
+	known := map[string]bool{}
+	for _, e := range webhookEvents {
+		known[e.Name] = true
+	}
+	var clean []string
+	seen := map[string]bool{}
+	for _, e := range events {
+		if !known[e] {
+			return "", errors.New("неизвестное событие " + e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			clean = append(clean, e)
+		}
+	}
+	if len(clean) == 0 {
+		return "", errors.New("выберите хотя бы одно событие")
+	}
+	return strings.Join(clean, " "), nil
+}
+
+///////////////////////////////////////////////////////
+// OUTBOX
+///////////////////////////////////////////////////////
+
+// webhookEnvelope — тело запроса к получателю
+type webhookEnvelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// emitEvent ставит событие в очередь для всех активных подписок.
+// Ошибки только логируются: основное действие (сдача теста и т.п.) уже выполнено.
+func emitEvent(event string, data any) {
+	var hooks []Webhook
+	if err := db.Where("active").Find(&hooks).Error; err != nil {
+		log.Printf("webhooks: %s: %v\n", event, err)
+		return
+	}
+	var targets []Webhook
+	for _, h := range hooks {
+		if h.Subscribed(event) {
+			targets = append(targets, h)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	if err := enqueueEvent(targets, event, data); err != nil {
+		log.Printf("webhooks: %s: %v\n", event, err)
+	}
+}
+
+func enqueueEvent(targets []Webhook, event string, data any) error {
+	eventID, err := randomToken()
+	if err != nil {
+		return err
+	}
+	eventID = "evt_" + eventID[:24]
+
+	payload, err := json.Marshal(webhookEnvelope{
+		ID:        eventID,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rows := make([]WebhookDelivery, 0, len(targets))
+	for _, h := range targets {
+		rows = append(rows, WebhookDelivery{
+			WebhookID:     h.ID,
+			EventID:       eventID,
+			Event:         event,
+			Payload:       payload,
+			Status:        deliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	if err := db.Create(&rows).Error; err != nil {
+		return err
+	}
+	wakeWebhookWorker()
+	return nil
+}
+
+// redeliver создаёт новую доставку с тем же телом (и тем же id события).
+func redeliver(d *WebhookDelivery) (*WebhookDelivery, error) {
+	copyOf := d.ID
+	nd := WebhookDelivery{
+		WebhookID:     d.WebhookID,
+		EventID:       d.EventID,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		Status:        deliveryPending,
+		NextAttemptAt: time.Now(),
+		RedeliveryOf:  &copyOf,
+	}
+	if err := db.Create(&nd).Error; err != nil {
+		return nil, err
+	}
+	wakeWebhookWorker()
+	return &nd, nil
+}
+
+// sendTestEvent — ping в конкретную подписку, независимо от выбранных событий.
+func sendTestEvent(h Webhook) error {
+	return enqueueEvent([]Webhook{h}, eventPing, gin.H{"webhook_id": h.ID, "name": h.Name})
+}
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+///////////////////////////////////////////////////////
+// ВОРКЕР
+///////////////////////////////////////////////////////
+
+// startWebhookWorker запускает фоновую отправку. Несколько экземпляров приложения
+// не мешают друг другу: доставка захватывается через SELECT ... FOR UPDATE SKIP LOCKED.
+func startWebhookWorker() {
+	webhookCfg = webhookConfig{
+		PollInterval: envDuration("WEBHOOK_POLL_INTERVAL", webhookCfg.PollInterval),
+		Timeout:      envDuration("WEBHOOK_TIMEOUT", webhookCfg.Timeout),
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", webhookCfg.MaxAttempts),
+	}
+	client := &http.Client{Timeout: webhookCfg.Timeout}
+
+	go func() {
+		ticker := time.NewTicker(webhookCfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				n, err := processDueDeliveries(client, 20)
+				if err != nil {
+					log.Printf("webhooks: %v\n", err)
+				}
+				if n == 0 || err != nil {
+					break
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-webhookWake:
+			}
+		}
+	}()
+}
+
+// processDueDeliveries отправляет до limit доставок, у которых подошло время.
+func processDueDeliveries(client *http.Client, limit int) (int, error) {
+	var batch []WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", deliveryPending, time.Now()).
+			Order("next_attempt_at").Limit(limit).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(batch))
+		for _, d := range batch {
+			ids = append(ids, d.ID)
+		}
+		// «аренда»: если процесс упадёт посреди отправки, доставка вернётся в очередь
+		lease := time.Now().Add(webhookCfg.Timeout + time.Minute)
+		return tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	hooks := map[uint]*Webhook{}
+	for i := range batch {
+		d := &batch[i]
+		h, ok := hooks[d.WebhookID]
+		if !ok {
+			var w Webhook
+			if err := db.First(&w, d.WebhookID).Error; err != nil {
+				continue // подписку удалили — доставки ушли каскадом
+			}
+			h = &w
+			hooks[d.WebhookID] = h
+		}
+		deliverWebhook(client, h, d)
+	}
+	return len(batch), nil
+}
+
+// deliverWebhook — одна попытка; результат пишется в журнал, при неудаче — следующая попытка с backoff.
+func deliverWebhook(client *http.Client, h *Webhook, d *WebhookDelivery) {
+	now := time.Now()
+	attempt := d.Attempts + 1
+	updates := map[string]any{"attempts": attempt}
+
+	if !h.Active && d.Event != eventPing {
+		updates["status"] = deliveryFailed
+		updates["last_error"] = "подписка отключена"
+		updates["last_status_code"] = 0
+		saveDeliveryResult(d.ID, updates)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		updates["status"] = deliveryFailed
+		updates["last_error"] = err.Error()
+		saveDeliveryResult(d.ID, updates)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TrainBrain-Webhooks/1.0")
+	req.Header.Set("X-TrainBrain-Event", d.Event)
+	req.Header.Set("X-TrainBrain-Event-Id", d.EventID)
+	req.Header.Set("X-TrainBrain-Delivery", strconv.Itoa(int(d.ID)))
+	req.Header.Set(webhookSignatureHeader, signWebhook(h.Secret, now, d.Payload))
+
+	resp, err := client.Do(req)
+	updates["duration_ms"] = time.Since(now).Milliseconds()
+	if err == nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+		resp.Body.Close()
+		updates["last_status_code"] = resp.StatusCode
+		updates["response_body"] = strings.ToValidUTF8(string(body), "")
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			updates["status"] = deliveryDelivered
+			updates["delivered_at"] = time.Now()
+			updates["last_error"] = ""
+			saveDeliveryResult(d.ID, updates)
+			return
+		}
+		err = errors.New("HTTP " + strconv.Itoa(resp.StatusCode))
+	} else {
+		updates["last_status_code"] = 0
+		updates["response_body"] = ""
+	}
+
+	updates["last_error"] = err.Error()
+	if attempt >= webhookCfg.MaxAttempts {
+		updates["status"] = deliveryFailed
+		log.Printf("webhooks: доставка %d (%s → %s) не удалась после %d попыток: %v\n",
+			d.ID, d.Event, h.URL, attempt, err)
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempt))
+	}
+	saveDeliveryResult(d.ID, updates)
+}
+
+func saveDeliveryResult(id uint, updates map[string]any) {
+	if err := db.Model(&WebhookDelivery{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		log.Printf("webhooks: доставка %d: %v\n", id, err)
+	}
+}
+
+// retryBackoff (вебхуки, xAPI): 30с, 1м, 2м, 4м ... не больше 6ч, плюс до 20% случайного разброса.
+func retryBackoff(attempt int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempt && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// signWebhook — "t=<unix>,v1=<hex HMAC-SHA256(secret, "<unix>.<body>")>".
+// Получатель пересчитывает подпись и отбрасывает запросы со старым t (защита от повтора).
+func signWebhook(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+///////////////////////////////////////////////////////
+// ДАННЫЕ СОБЫТИЙ
+///////////////////////////////////////////////////////
+
+// emit* — единая точка публикации учебных событий: вебхуки и xAPI (xapi.go).
+
+// blockCourseID — курс, к которому относится блок (Module может быть не загружен).
+func blockCourseID(blk *Block) uint {
+	if blk.Module.CourseID != 0 {
+		return blk.Module.CourseID
+	}
+	var courseID uint
+	db.Model(&Module{}).Where("id = ?", blk.ModuleID).Select("course_id").Scan(&courseID)
+	return courseID
+}
+
+func emitUserRegistered(u *User) {
+	emitEvent(eventUserRegistered, gin.H{"user": toAPIUser(*u)})
+}
+
+func emitQuizAttempted(u *User, blk *Block, a *QuizAttempt) {
+	courseID := blockCourseID(blk)
+	emitEvent(eventQuizAttempted, gin.H{
+		"user":      toAPIUser(*u),
+		"course_id": courseID,
+		"attempt":   toAPIQuizAttempt(*a),
+	})
+	logXAPIError("quiz attempt", xapiQuizAttempt(u, a))
+	ltiQueueScores(u, blk)
+	if a.Passed {
+		checkCourseCompletion(u, courseID)
+	}
+}
+
+func emitSubmissionCreated(u *User, blk *Block, s *Submission) {
+	courseID := blockCourseID(blk)
+	emitEvent(eventSubmissionCreated, gin.H{
+		"user":       toAPIUser(*u),
+		"course_id":  courseID,
+		"submission": toAPISubmission(*s),
+	})
+	ltiQueueScores(u, blk)
+	checkCourseCompletion(u, courseID)
+}
+
+// emitSubmissionReviewed — после смены статуса/комментария; s.User должен быть загружен.
+func emitSubmissionReviewed(s *Submission, previousStatus string, reviewer *User) {
+	data := gin.H{
+		"user":            toAPIUser(s.User),
+		"submission":      toAPISubmission(*s),
+		"previous_status": previousStatus,
+	}
+	if reviewer != nil {
+		data["reviewer"] = gin.H{"id": reviewer.ID, "email": reviewer.Email}
+	}
+	emitEvent(eventSubmissionReviewed, data)
+	logXAPIError("submission review", xapiSubmissionReviewed(&s.User, s, time.Now()))
+}
+
+// checkCourseCompletion фиксирует завершение курса и один раз шлёт course.completed.
+func checkCourseCompletion(u *User, courseID uint) {
+	if courseID == 0 {
+		return
+	}
+	var course Course
+	if err := db.Preload("Modules.Blocks").First(&course, courseID).Error; err != nil {
+		return
+	}
+	p := courseProgress(u.ID, &course)
+	if p.Graded == 0 || p.Completed < p.Graded {
+		return
+	}
+
+	done := CourseCompletion{UserID: u.ID, CourseID: courseID, CompletedAt: time.Now()}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&done)
+	if res.Error != nil {
+		log.Printf("course completion %d/%d: %v\n", u.ID, courseID, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return // уже завершал раньше
+	}
+	emitEvent(eventCourseCompleted, gin.H{
+		"user":         toAPIUser(*u),
+		"course":       toAPICourse(course),
+		"progress":     p,
+		"completed_at": done.CompletedAt,
+	})
+	logXAPIError("course completion", xapiCourseCompleted(u, &course, done.CompletedAt))
+}
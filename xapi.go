@@ -0,0 +1,753 @@
+// xapi.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// xAPI (Tin Can): учебные события в виде заявлений «кто — что сделал — с чем»
+// отправляются в LRS (Learning Record Store). Без XAPI_ENDPOINT ничего не копится.
+
+const (
+	xapiPending = "pending"
+	xapiSent    = "sent"
+	xapiFailed  = "failed"
+)
+
+// xapiConfig — XAPI_ENDPOINT, XAPI_USERNAME, XAPI_PASSWORD, XAPI_VERSION, XAPI_ACTOR (mbox|account),
+// XAPI_LANG, XAPI_POLL_INTERVAL, XAPI_TIMEOUT, XAPI_BATCH_SIZE, XAPI_MAX_ATTEMPTS.
+type xapiConfig struct {
+	Endpoint     string // https://lrs.example.com/xapi/ — к нему добавляется statements
+	Username     string
+	Password     string
+	Version      string
+	Actor        string
+	Lang         string
+	ActivityBase string // префикс IRI активностей — адрес самого TrainBrain
+	PollInterval time.Duration
+	Timeout      time.Duration
+	BatchSize    int
+	MaxAttempts  int
+}
+
+var xapiCfg xapiConfig
+
+func loadXAPIConfig() xapiConfig {
+	endpoint := strings.TrimSpace(envOr("XAPI_ENDPOINT", ""))
+	if endpoint != "" && !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	actor := envOr("XAPI_ACTOR", "mbox")
+	if actor != "mbox" && actor != "account" {
+		log.Printf("XAPI_ACTOR: неизвестное значение %q, используется mbox\n", actor)
+		actor = "mbox"
+	}
+	return xapiConfig{
+		Endpoint:     endpoint,
+		Username:     envOr("XAPI_USERNAME", ""),
+		Password:     envOr("XAPI_PASSWORD", ""),
+		Version:      envOr("XAPI_VERSION", "1.0.3"),
+		Actor:        actor,
+		Lang:         envOr("XAPI_LANG", "ru-RU"),
+		ActivityBase: appBaseURL(),
+		PollInterval: envDuration("XAPI_POLL_INTERVAL", 10*time.Second),
+		Timeout:      envDuration("XAPI_TIMEOUT", 15*time.Second),
+		BatchSize:    envInt("XAPI_BATCH_SIZE", 50),
+		MaxAttempts:  envInt("XAPI_MAX_ATTEMPTS", 10),
+	}
+}
+
+func xapiEnabled() bool {
+	return xapiCfg.Endpoint != ""
+}
+
+///////////////////////////////////////////////////////
+// ФОРМАТ ЗАЯВЛЕНИЙ (xAPI 1.0.3)
+///////////////////////////////////////////////////////
+
+type xapiStatement struct {
+	ID        string       `json:"id"`
+	Actor     xapiActor    `json:"actor"`
+	Verb      xapiVerb     `json:"verb"`
+	Object    xapiActivity `json:"object"`
+	Result    *xapiResult  `json:"result,omitempty"`
+	Context   *xapiContext `json:"context,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+type xapiActor struct {
+	ObjectType string       `json:"objectType"`
+	Name       string       `json:"name,omitempty"`
+	Mbox       string       `json:"mbox,omitempty"`
+	Account    *xapiAccount `json:"account,omitempty"`
+}
+
+type xapiAccount struct {
+	HomePage string `json:"homePage"`
+	Name     string `json:"name"`
+}
+
+type xapiVerb struct {
+	ID      string            `json:"id"`
+	Display map[string]string `json:"display"`
+}
+
+type xapiActivity struct {
+	ObjectType string          `json:"objectType"`
+	ID         string          `json:"id"`
+	Definition *xapiDefinition `json:"definition,omitempty"`
+}
+
+type xapiDefinition struct {
+	Name                    map[string]string     `json:"name,omitempty"`
+	Type                    string                `json:"type,omitempty"`
+	InteractionType         string                `json:"interactionType,omitempty"`
+	Choices                 []xapiInteractionItem `json:"choices,omitempty"`
+	CorrectResponsesPattern []string              `json:"correctResponsesPattern,omitempty"`
+}
+
+type xapiInteractionItem struct {
+	ID          string            `json:"id"`
+	Description map[string]string `json:"description"`
+}
+
+type xapiResult struct {
+	Score      *xapiScore `json:"score,omitempty"`
+	Success    *bool      `json:"success,omitempty"`
+	Completion *bool      `json:"completion,omitempty"`
+	Response   string     `json:"response,omitempty"`
+}
+
+type xapiScore struct {
+	Scaled float64 `json:"scaled"`
+	Raw    float64 `json:"raw"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+type xapiContext struct {
+	Platform          string                 `json:"platform"`
+	Language          string                 `json:"language,omitempty"`
+	ContextActivities *xapiContextActivities `json:"contextActivities,omitempty"`
+}
+
+type xapiContextActivities struct {
+	Parent   []xapiActivity `json:"parent,omitempty"`
+	Grouping []xapiActivity `json:"grouping,omitempty"`
+}
+
+// Глаголы ADL
+var xapiVerbs = map[string]xapiVerb{
+	"launched":    {ID: "http://adlnet.gov/expapi/verbs/launched", Display: map[string]string{"en-US": "launched", "ru-RU": "открыл"}},
+	"experienced": {ID: "http://adlnet.gov/expapi/verbs/experienced", Display: map[string]string{"en-US": "experienced", "ru-RU": "просмотрел"}},
+	"answered":    {ID: "http://adlnet.gov/expapi/verbs/answered", Display: map[string]string{"en-US": "answered", "ru-RU": "ответил"}},
+	"passed":      {ID: "http://adlnet.gov/expapi/verbs/passed", Display: map[string]string{"en-US": "passed", "ru-RU": "сдал"}},
+	"failed":      {ID: "http://adlnet.gov/expapi/verbs/failed", Display: map[string]string{"en-US": "failed", "ru-RU": "не сдал"}},
+	"completed":   {ID: "http://adlnet.gov/expapi/verbs/completed", Display: map[string]string{"en-US": "completed", "ru-RU": "завершил"}},
+}
+
+const (
+	xapiTypeCourse      = "http://adlnet.gov/expapi/activities/course"
+	xapiTypeAssessment  = "http://adlnet.gov/expapi/activities/assessment"
+	xapiTypeLesson      = "http://adlnet.gov/expapi/activities/lesson"
+	xapiTypeMedia       = "http://adlnet.gov/expapi/activities/media"
+	xapiTypeInteraction = "http://adlnet.gov/expapi/activities/cmi.interaction"
+	xapiTypeAssignment  = "http://id.tincanapi.com/activitytype/school-assignment"
+	xapiTypeFile        = "http://adlnet.gov/expapi/activities/file"
+	xapiTypeLink        = "http://adlnet.gov/expapi/activities/link"
+	xapiTypeSurvey      = "http://id.tincanapi.com/activitytype/survey"
+)
+
+// xapiStatementID — UUID (формат v5) из ключа источника: одно событие — один ID.
+func xapiStatementID(sourceKey string) string {
+	sum := sha1.Sum([]byte(xapiCfg.ActivityBase + "|" + sourceKey))
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+func (cfg xapiConfig) lang(s string) map[string]string {
+	return map[string]string{cfg.Lang: s}
+}
+
+// xapiActorFor — mbox (email) или account (ID в TrainBrain, без персональных данных).
+func xapiActorFor(u *User) xapiActor {
+	if xapiCfg.Actor == "account" {
+		return xapiActor{
+			ObjectType: "Agent",
+			Account:    &xapiAccount{HomePage: xapiCfg.ActivityBase, Name: strconv.Itoa(int(u.ID))},
+		}
+	}
+	return xapiActor{ObjectType: "Agent", Name: u.FullName, Mbox: "mailto:" + u.Email}
+}
+
+func xapiCourseActivity(course *Course) xapiActivity {
+	return xapiActivity{
+		ObjectType: "Activity",
+		ID:         xapiCfg.ActivityBase + "/courses/" + strconv.Itoa(int(course.ID)),
+		Definition: &xapiDefinition{Name: xapiCfg.lang(course.Title), Type: xapiTypeCourse},
+	}
+}
+
+// xapiBlockActivity — блок как активность; IRI совпадает с якорем блока в плеере курса.
+func xapiBlockActivity(blk *Block, courseID uint) xapiActivity {
+	typ := xapiTypeLesson
+	if k := blockKinds[blk.Type]; k != nil {
+		typ = k.XAPIType
+	}
+	return xapiActivity{
+		ObjectType: "Activity",
+		ID:         xapiCfg.ActivityBase + "/courses/" + strconv.Itoa(int(courseID)) + "#block-" + strconv.Itoa(int(blk.ID)),
+		Definition: &xapiDefinition{Name: xapiCfg.lang(blockTitle(blk)), Type: typ},
+	}
+}
+
+func xapiQuestionActivity(q *QuizQuestion, quiz xapiActivity) xapiActivity {
+	def := &xapiDefinition{
+		Name:            xapiCfg.lang(q.Text),
+		Type:            xapiTypeInteraction,
+		InteractionType: "choice",
+	}
+	var correct []string
+	for _, opt := range q.Options {
+		id := strconv.Itoa(int(opt.ID))
+		def.Choices = append(def.Choices, xapiInteractionItem{ID: id, Description: xapiCfg.lang(opt.Text)})
+		if opt.IsCorrect {
+			correct = append(correct, id)
+		}
+	}
+	if len(correct) > 0 {
+		def.CorrectResponsesPattern = []string{strings.Join(correct, "[,]")}
+	}
+	return xapiActivity{
+		ObjectType: "Activity",
+		ID:         quiz.ID + "/question-" + strconv.Itoa(int(q.ID)),
+		Definition: def,
+	}
+}
+
+// blockTitle — заголовок блока из payload (или «Блок #N»).
+func blockTitle(blk *Block) string {
+	if t := payloadTitle(blk.Payload); t != "" {
+		return t
+	}
+	return "Блок #" + strconv.Itoa(int(blk.ID))
+}
+
+func xapiContextFor(parent []xapiActivity, grouping []xapiActivity) *xapiContext {
+	ctx := &xapiContext{Platform: "TrainBrain", Language: xapiCfg.Lang}
+	if len(parent) > 0 || len(grouping) > 0 {
+		ctx.ContextActivities = &xapiContextActivities{Parent: parent, Grouping: grouping}
+	}
+	return ctx
+}
+
+///////////////////////////////////////////////////////
+// ОЧЕРЕДЬ
+///////////////////////////////////////////////////////
+
+// queueXAPI кладёт заявление в очередь. Повтор с тем же sourceKey молча игнорируется.
+func queueXAPI(u *User, sourceKey, verb string, object xapiActivity, result *xapiResult, ctx *xapiContext, ts time.Time) error {
+	if !xapiEnabled() || u == nil || u.ErasedAt != nil || u.AuthSource == AuthSourceService {
+		return nil
+	}
+	v, ok := xapiVerbs[verb]
+	if !ok {
+		return errors.New("xapi: неизвестный глагол " + verb)
+	}
+
+	st := xapiStatement{
+		ID:        xapiStatementID(sourceKey),
+		Actor:     xapiActorFor(u),
+		Verb:      v,
+		Object:    object,
+		Result:    result,
+		Context:   ctx,
+		Timestamp: ts.UTC(),
+	}
+	body, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	row := XAPIStatement{
+		ID:            st.ID,
+		SourceKey:     sourceKey,
+		UserID:        u.ID,
+		Verb:          verb,
+		Statement:     body,
+		Status:        xapiPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return err
+	}
+	wakeXAPIWorker()
+	return nil
+}
+
+func logXAPIError(what string, err error) {
+	if err != nil {
+		log.Printf("xapi: %s: %v\n", what, err)
+	}
+}
+
+// loadBlockCourse — блок вместе с модулем и курсом (для контекста заявления).
+func loadBlockCourse(blockID uint) (*Block, error) {
+	var blk Block
+	if err := db.Preload("Module.Course").First(&blk, blockID).Error; err != nil {
+		return nil, err
+	}
+	return &blk, nil
+}
+
+///////////////////////////////////////////////////////
+// ГЕНЕРАЦИЯ ИЗ СОБЫТИЙ
+///////////////////////////////////////////////////////
+
+// xapiCourseLaunched — открытие курса; не чаще раза в 30 минут на пользователя и курс.
+func xapiCourseLaunched(u *User, course *Course) {
+	if !xapiEnabled() || u == nil {
+		return
+	}
+	now := time.Now()
+	key := fmt.Sprintf("launched:%d:%d:%d", u.ID, course.ID, now.Unix()/1800)
+	logXAPIError(key, queueXAPI(u, key, "launched", xapiCourseActivity(course), nil, xapiContextFor(nil, nil), now))
+}
+
+// xapiBlockExperienced — блок попал в зону видимости в плеере; раз в сутки на блок.
+func xapiBlockExperienced(u *User, blk *Block) {
+	if !xapiEnabled() {
+		return
+	}
+	now := time.Now()
+	key := fmt.Sprintf("experienced:%d:%d:%s", u.ID, blk.ID, now.Format("20060102"))
+	course := xapiCourseActivity(&blk.Module.Course)
+	logXAPIError(key, queueXAPI(u, key, "experienced", xapiBlockActivity(blk, blk.Module.CourseID), nil,
+		xapiContextFor([]xapiActivity{course}, nil), now))
+}
+
+// xapiQuizAttempt — answered по каждому вопросу и passed/failed по тесту.
+func xapiQuizAttempt(u *User, a *QuizAttempt) error {
+	if !xapiEnabled() {
+		return nil
+	}
+	blk, err := loadBlockCourse(a.BlockID)
+	if err != nil {
+		return err
+	}
+	course := xapiCourseActivity(&blk.Module.Course)
+	quiz := xapiBlockActivity(blk, blk.Module.CourseID)
+
+	var questions []QuizQuestion
+	if err := db.Preload("Options").Where("block_id = ?", blk.ID).Order("\"order\" asc").Find(&questions).Error; err != nil {
+		return err
+	}
+	answers := map[string]int{}
+	if len(a.Details) > 0 {
+		_ = json.Unmarshal(a.Details, &answers)
+	}
+
+	correct := 0
+	for i := range questions {
+		q := &questions[i]
+		optID, ok := answers[strconv.Itoa(int(q.ID))]
+		if !ok {
+			continue
+		}
+		success := false
+		for _, opt := range q.Options {
+			if int(opt.ID) == optID && opt.IsCorrect {
+				success = true
+				break
+			}
+		}
+		if success {
+			correct++
+		}
+		key := fmt.Sprintf("answered:%d:%d", a.ID, q.ID)
+		res := &xapiResult{Success: &success, Response: strconv.Itoa(optID)}
+		if err := queueXAPI(u, key, "answered", xapiQuestionActivity(q, quiz), res,
+			xapiContextFor([]xapiActivity{quiz}, []xapiActivity{course}), a.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	verb := "failed"
+	if a.Passed {
+		verb = "passed"
+	}
+	passed, completion := a.Passed, true
+	res := &xapiResult{
+		Score: &xapiScore{
+			Scaled: a.Score / 100,
+			Raw:    float64(correct),
+			Min:    0,
+			Max:    float64(len(questions)),
+		},
+		Success:    &passed,
+		Completion: &completion,
+	}
+	return queueXAPI(u, fmt.Sprintf("quiz:%d", a.ID), verb, quiz, res,
+		xapiContextFor([]xapiActivity{course}, nil), a.CreatedAt)
+}
+
+// xapiSubmissionReviewed — решение проверено: accepted → passed, rejected/needs-fix → failed.
+// Прочие статусы не являются итоговой оценкой и в LRS не уходят.
+func xapiSubmissionReviewed(u *User, s *Submission, ts time.Time) error {
+	if !xapiEnabled() {
+		return nil
+	}
+	var verb string
+	switch s.Status {
+	case "accepted":
+		verb = "passed"
+	case "rejected", "needs-fix":
+		verb = "failed"
+	default:
+		return nil
+	}
+	blk, err := loadBlockCourse(s.BlockID)
+	if err != nil {
+		return err
+	}
+	success := verb == "passed"
+	res := &xapiResult{Success: &success, Response: s.Comment}
+	return queueXAPI(u, fmt.Sprintf("review:%d:%s", s.ID, s.Status), verb,
+		xapiBlockActivity(blk, blk.Module.CourseID), res,
+		xapiContextFor([]xapiActivity{xapiCourseActivity(&blk.Module.Course)}, nil), ts)
+}
+
+// xapiVideoCompleted — просмотр видео достиг порога блока (payload.complete_percent).
+func xapiVideoCompleted(u *User, w *VideoWatch) error {
+	if !xapiEnabled() || w.CompletedAt == nil {
+		return nil
+	}
+	blk, err := loadBlockCourse(w.BlockID)
+	if err != nil {
+		return err
+	}
+	completion := true
+	return queueXAPI(u, fmt.Sprintf("video:%d:%d", w.UserID, w.BlockID), "completed",
+		xapiBlockActivity(blk, blk.Module.CourseID), &xapiResult{Completion: &completion},
+		xapiContextFor([]xapiActivity{xapiCourseActivity(&blk.Module.Course)}, nil), *w.CompletedAt)
+}
+
+func xapiCourseCompleted(u *User, course *Course, at time.Time) error {
+	completion := true
+	return queueXAPI(u, fmt.Sprintf("completed:%d:%d", u.ID, course.ID), "completed",
+		xapiCourseActivity(course), &xapiResult{Completion: &completion}, xapiContextFor(nil, nil), at)
+}
+
+///////////////////////////////////////////////////////
+// BACKFILL
+///////////////////////////////////////////////////////
+
+// xapiBackfillState — состояние последнего запуска (для страницы админки).
+type xapiBackfillState struct {
+	Running    bool
+	Since      time.Time
+	Processed  int
+	Err        string
+	FinishedAt *time.Time
+}
+
+var (
+	xapiBackfillMu   sync.Mutex
+	xapiBackfillLast xapiBackfillState
+)
+
+func xapiBackfillStatus() xapiBackfillState {
+	xapiBackfillMu.Lock()
+	defer xapiBackfillMu.Unlock()
+	return xapiBackfillLast
+}
+
+// startXAPIBackfill в фоне генерирует заявления для исторических данных начиная с since:
+// попытки тестов, проверенные задания, завершённые курсы. Уже отправленные — не дублируются.
+// Открытия курсов и просмотры блоков раньше не сохранялись, их восстановить нельзя.
+func startXAPIBackfill(since time.Time) bool {
+	xapiBackfillMu.Lock()
+	if xapiBackfillLast.Running {
+		xapiBackfillMu.Unlock()
+		return false
+	}
+	xapiBackfillLast = xapiBackfillState{Running: true, Since: since}
+	xapiBackfillMu.Unlock()
+
+	go func() {
+		n, err := xapiBackfill(since)
+		now := time.Now()
+		xapiBackfillMu.Lock()
+		xapiBackfillLast.Running = false
+		xapiBackfillLast.Processed = n
+		xapiBackfillLast.FinishedAt = &now
+		if err != nil {
+			xapiBackfillLast.Err = err.Error()
+		}
+		xapiBackfillMu.Unlock()
+		log.Printf("xapi backfill с %s: обработано %d записей, ошибка: %v\n", since.Format("2006-01-02"), n, err)
+	}()
+	return true
+}
+
+func xapiBackfill(since time.Time) (int, error) {
+	processed := 0
+
+	var attempts []QuizAttempt
+	err := db.Preload("User").Where("created_at >= ?", since).Order("id").
+		FindInBatches(&attempts, 200, func(tx *gorm.DB, batch int) error {
+			for i := range attempts {
+				if err := xapiQuizAttempt(&attempts[i].User, &attempts[i]); err != nil {
+					return err
+				}
+				processed++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return processed, err
+	}
+
+	// время проверки не хранится — берём время отправки решения
+	var subs []Submission
+	err = db.Preload("User").Where("created_at >= ? AND status IN ?", since, []string{"accepted", "rejected", "needs-fix"}).
+		Order("id").
+		FindInBatches(&subs, 200, func(tx *gorm.DB, batch int) error {
+			for i := range subs {
+				if err := xapiSubmissionReviewed(&subs[i].User, &subs[i], subs[i].CreatedAt); err != nil {
+					return err
+				}
+				processed++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return processed, err
+	}
+
+	var watches []VideoWatch
+	err = db.Preload("User").Where("completed_at >= ?", since).Order("id").
+		FindInBatches(&watches, 200, func(tx *gorm.DB, batch int) error {
+			for i := range watches {
+				if err := xapiVideoCompleted(&watches[i].User, &watches[i]); err != nil {
+					return err
+				}
+				processed++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return processed, err
+	}
+
+	var done []CourseCompletion
+	err = db.Preload("User").Preload("Course").Where("completed_at >= ?", since).Order("id").
+		FindInBatches(&done, 200, func(tx *gorm.DB, batch int) error {
+			for i := range done {
+				if err := xapiCourseCompleted(&done[i].User, &done[i].Course, done[i].CompletedAt); err != nil {
+					return err
+				}
+				processed++
+			}
+			return nil
+		}).Error
+	return processed, err
+}
+
+///////////////////////////////////////////////////////
+// ОТПРАВКА В LRS
+///////////////////////////////////////////////////////
+
+var xapiWake = make(chan struct{}, 1)
+
+func wakeXAPIWorker() {
+	select {
+	case xapiWake <- struct{}{}:
+	default:
+	}
+}
+
+// startXAPIWorker читает конфигурацию и, если задан XAPI_ENDPOINT, запускает отправку очереди.
+func startXAPIWorker() {
+	xapiCfg = loadXAPIConfig()
+	if !xapiEnabled() {
+		return
+	}
+	client := &http.Client{Timeout: xapiCfg.Timeout}
+	log.Printf("xapi: LRS %s\n", xapiCfg.Endpoint)
+
+	go func() {
+		ticker := time.NewTicker(xapiCfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				n, err := sendXAPIBatch(client)
+				if err != nil {
+					log.Printf("xapi: %v\n", err)
+				}
+				if n < xapiCfg.BatchSize || err != nil {
+					break
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-xapiWake:
+			}
+		}
+	}()
+}
+
+// sendXAPIBatch отправляет пачку заявлений одним POST /statements.
+// Если LRS отверг пачку целиком (400/409), заявления отправляются по одному через PUT,
+// чтобы одно плохое не блокировало остальные.
+func sendXAPIBatch(client *http.Client) (int, error) {
+	var batch []XAPIStatement
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", xapiPending, time.Now()).
+			Order("created_at, id").Limit(xapiCfg.BatchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]string, 0, len(batch))
+		for _, s := range batch {
+			ids = append(ids, s.ID)
+		}
+		lease := time.Now().Add(xapiCfg.Timeout + time.Minute)
+		return tx.Model(&XAPIStatement{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, s := range batch {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(s.Statement)
+	}
+	body.WriteByte(']')
+
+	status, respBody, err := xapiRequest(client, http.MethodPost, xapiCfg.Endpoint+"statements", body.Bytes())
+	switch {
+	case err == nil && status == http.StatusOK:
+		for _, s := range batch {
+			markXAPISent(s.ID)
+		}
+	case err == nil && (status == http.StatusBadRequest || status == http.StatusConflict):
+		for i := range batch {
+			sendXAPIOne(client, &batch[i])
+		}
+	default:
+		if err == nil {
+			err = fmt.Errorf("HTTP %d: %s", status, respBody)
+		}
+		for i := range batch {
+			markXAPIRetry(&batch[i], err)
+		}
+	}
+	return len(batch), nil
+}
+
+func sendXAPIOne(client *http.Client, s *XAPIStatement) {
+	u := xapiCfg.Endpoint + "statements?statementId=" + url.QueryEscape(s.ID)
+	status, respBody, err := xapiRequest(client, http.MethodPut, u, s.Statement)
+	switch {
+	case err != nil || status >= 500 || status == http.StatusTooManyRequests:
+		if err == nil {
+			err = fmt.Errorf("HTTP %d: %s", status, respBody)
+		}
+		markXAPIRetry(s, err)
+	case status == http.StatusNoContent:
+		markXAPISent(s.ID)
+	case status == http.StatusConflict:
+		// заявление с этим ID уже в LRS (ID детерминированы) — считаем доставленным
+		markXAPISent(s.ID)
+	default:
+		// 400/401/403: повтор не поможет, нужна правка данных или настроек
+		db.Model(&XAPIStatement{}).Where("id = ?", s.ID).Updates(map[string]any{
+			"status":     xapiFailed,
+			"attempts":   s.Attempts + 1,
+			"last_error": fmt.Sprintf("HTTP %d: %s", status, respBody),
+		})
+	}
+}
+
+func xapiRequest(client *http.Client, method, u string, body []byte) (int, string, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Experience-API-Version", xapiCfg.Version)
+	if xapiCfg.Username != "" {
+		req.SetBasicAuth(xapiCfg.Username, xapiCfg.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, strings.ToValidUTF8(strings.TrimSpace(string(respBody)), ""), nil
+}
+
+func markXAPISent(id string) {
+	now := time.Now()
+	if err := db.Model(&XAPIStatement{}).Where("id = ?", id).Updates(map[string]any{
+		"status":     xapiSent,
+		"sent_at":    &now,
+		"last_error": "",
+	}).Error; err != nil {
+		log.Printf("xapi: %s: %v\n", id, err)
+	}
+}
+
+func markXAPIRetry(s *XAPIStatement, cause error) {
+	attempt := s.Attempts + 1
+	updates := map[string]any{"attempts": attempt, "last_error": cause.Error()}
+	if attempt >= xapiCfg.MaxAttempts {
+		updates["status"] = xapiFailed
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempt))
+	}
+	if err := db.Model(&XAPIStatement{}).Where("id = ?", s.ID).Updates(updates).Error; err != nil {
+		log.Printf("xapi: %s: %v\n", s.ID, err)
+	}
+}
+
+// xapiAbout — GET {endpoint}about: проверка адреса, авторизации и версии LRS.
+func xapiAbout() (string, error) {
+	client := &http.Client{Timeout: xapiCfg.Timeout}
+	status, body, err := xapiRequest(client, http.MethodGet, xapiCfg.Endpoint+"about", nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d: %s", status, body)
+	}
+	return body, nil
+}